@@ -2,4 +2,7 @@ module atlas
 
 go 1.25
 
-require github.com/mattn/go-sqlite3 v1.14.17
+require (
+	github.com/mattn/go-sqlite3 v1.14.17
+	github.com/santhosh-tekuri/jsonschema/v5 v5.3.1
+)
@@ -0,0 +1,55 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// PortEvent is one port state transition recorded in port_history.
+type PortEvent struct {
+	IP            string
+	InterfaceName string
+	Port          int
+	Protocol      string
+	State         string
+	ObservedAt    time.Time
+}
+
+// PortHistory returns the port state timeline for ip on each interface it was
+// seen on, oldest first within an interface.
+func PortHistory(ip string) ([]PortEvent, error) {
+	return PortHistoryAt(dbPath, ip)
+}
+
+// PortHistoryAt is PortHistory against the database at path.
+func PortHistoryAt(path, ip string) ([]PortEvent, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open DB: %v", err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`
+        SELECT ip, COALESCE(interface_name, ''), port, protocol, state, CAST(observed_at AS TEXT)
+        FROM port_history
+        WHERE ip = ?
+        ORDER BY interface_name, observed_at, id
+    `, ip)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []PortEvent
+	for rows.Next() {
+		var ev PortEvent
+		var observed string
+		if err := rows.Scan(&ev.IP, &ev.InterfaceName, &ev.Port, &ev.Protocol, &ev.State, &observed); err != nil {
+			return nil, err
+		}
+		ev.ObservedAt, _ = time.ParseInLocation("2006-01-02 15:04:05", observed, time.UTC)
+		events = append(events, ev)
+	}
+	return events, rows.Err()
+}
@@ -0,0 +1,50 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestMaintainAtReclaimsDeletedRows(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "atlas.db")
+	if err := InitDBAt(path); err != nil {
+		t.Fatalf("InitDBAt: %v", err)
+	}
+	conn, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := conn.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2000; i++ {
+		if _, err := tx.Exec("INSERT INTO hosts (ip, name, interface_name) VALUES (?, hex(randomblob(512)), 'eth0')", fmt.Sprintf("10.%d.%d.1", i/250, i%250)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Exec("DELETE FROM hosts"); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	report, err := MaintainAt(path)
+	if err != nil {
+		t.Fatalf("MaintainAt: %v", err)
+	}
+	if report.SizeBefore == 0 || report.SizeAfter >= report.SizeBefore || report.Reclaimed() != report.SizeBefore-report.SizeAfter {
+		t.Fatalf("expected the deleted rows to be reclaimed: %+v", report)
+	}
+	if report.WALBusy || report.Duration <= 0 {
+		t.Fatalf("report %+v", report)
+	}
+
+	if _, err := MaintainAt(filepath.Join(t.TempDir(), "missing.db")); err == nil {
+		t.Fatal("expected an error for a missing database")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"fmt"
 	"os"
+	"path/filepath"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -11,14 +12,19 @@ import (
 const dbPath = "/config/db/atlas.db"
 
 func InitDB() error {
+	return InitDBAt(dbPath)
+}
+
+// InitDBAt creates or migrates the schema of the SQLite database at path.
+func InitDBAt(path string) error {
 	// Step 1: Make sure the directory exists
-	dbDir := "/config/db"
+	dbDir := filepath.Dir(path)
 	if err := os.MkdirAll(dbDir, 0755); err != nil {
 		return fmt.Errorf("failed to create DB dir: %v", err)
 	}
 
 	// Step 2: Open the SQLite database (it will be created if not exists)
-	db, err := sql.Open("sqlite3", dbPath)
+	db, err := sql.Open("sqlite3", path)
 	if err != nil {
 		return fmt.Errorf("failed to open DB: %v", err)
 	}
@@ -33,11 +39,16 @@ CREATE TABLE IF NOT EXISTS hosts (
     os_details TEXT,
     mac_address TEXT,
     open_ports TEXT,
+    open_ports_json TEXT,
     next_hop TEXT,
     network_name TEXT,
     interface_name TEXT,
+    first_seen DATETIME,
     last_seen DATETIME DEFAULT CURRENT_TIMESTAMP,
-    online_status TEXT DEFAULT 'online'
+    online_status TEXT DEFAULT 'online',
+    first_agent TEXT,
+    agent_id TEXT,
+    ipv6_addresses TEXT
 );
 
 CREATE TABLE IF NOT EXISTS docker_hosts (
@@ -63,6 +74,13 @@ CREATE TABLE IF NOT EXISTS external_networks (
 	last_seen DATETIME DEFAULT CURRENT_TIMESTAMP
 );
 
+CREATE TABLE IF NOT EXISTS external_ip_changes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    old_ip TEXT NOT NULL,
+    new_ip TEXT NOT NULL,
+    changed_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
 CREATE TABLE IF NOT EXISTS logs (
     id INTEGER PRIMARY KEY AUTOINCREMENT,
     log_type TEXT NOT NULL,
@@ -70,6 +88,27 @@ CREATE TABLE IF NOT EXISTS logs (
     timestamp DATETIME DEFAULT CURRENT_TIMESTAMP
 );
 
+CREATE TABLE IF NOT EXISTS port_history (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    ip TEXT NOT NULL,
+    interface_name TEXT,
+    port INTEGER NOT NULL,
+    protocol TEXT NOT NULL,
+    state TEXT NOT NULL,
+    observed_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS scan_runs (
+    run_id TEXT PRIMARY KEY,
+    scanner TEXT NOT NULL,
+    started_at DATETIME,
+    finished_at DATETIME,
+    host_count INTEGER DEFAULT 0,
+    partial INTEGER DEFAULT 0,
+    nmap_hosts_up INTEGER,
+    nmap_elapsed REAL
+);
+
 CREATE UNIQUE INDEX IF NOT EXISTS idx_hosts_ip_interface ON hosts(ip, interface_name);
 CREATE UNIQUE INDEX IF NOT EXISTS idx_external_networks_ip ON external_networks(public_ip);
 `
@@ -89,5 +128,74 @@ CREATE UNIQUE INDEX IF NOT EXISTS idx_external_networks_ip ON external_networks(
 	// Recreate unique index if missing (IF NOT EXISTS used above in schema creation, but older DBs may lack it)
 	_, _ = db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_hosts_ip_interface ON hosts(ip, interface_name);`)
 
+	// first_seen was added later; older rows have only ever been seen as far back as last_seen.
+	_, _ = db.Exec(`ALTER TABLE hosts ADD COLUMN first_seen DATETIME;`)
+	_, _ = db.Exec(`UPDATE hosts SET first_seen = last_seen WHERE first_seen IS NULL;`)
+
+	// open_ports_json holds the parsed ports as a JSON array next to the
+	// display summary; rows written before it was added stay NULL.
+	_, _ = db.Exec(`ALTER TABLE hosts ADD COLUMN open_ports_json TEXT;`)
+
+	// Agent provenance was added later; older rows stay NULL until re-scanned.
+	_, _ = db.Exec(`ALTER TABLE hosts ADD COLUMN first_agent TEXT;`)
+	_, _ = db.Exec(`ALTER TABLE hosts ADD COLUMN agent_id TEXT;`)
+
+	// IPv6 correlation was added later.
+	_, _ = db.Exec(`ALTER TABLE hosts ADD COLUMN ipv6_addresses TEXT;`)
+
+	// port_history was keyed on ip alone, mixing the timelines of one address
+	// on two interfaces. Older rows take the interface of a host with their
+	// ip, and the index is rebuilt once the column exists.
+	_, _ = db.Exec(`ALTER TABLE port_history ADD COLUMN interface_name TEXT;`)
+	_, _ = db.Exec(`UPDATE port_history SET interface_name = COALESCE(
+	    (SELECT interface_name FROM hosts WHERE hosts.ip = port_history.ip ORDER BY last_seen DESC LIMIT 1), 'unknown')
+	    WHERE interface_name IS NULL;`)
+	_, _ = db.Exec(`DROP INDEX IF EXISTS idx_port_history_ip;`)
+	if _, err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_port_history_host ON port_history(ip, interface_name, port, protocol);`); err != nil {
+		return fmt.Errorf("port_history index: %v", err)
+	}
+
+	// nmap run statistics were added to scan_runs later; older runs keep NULL.
+	_, _ = db.Exec(`ALTER TABLE scan_runs ADD COLUMN nmap_hosts_up INTEGER;`)
+	_, _ = db.Exec(`ALTER TABLE scan_runs ADD COLUMN nmap_elapsed REAL;`)
+
+	if err := migrateTimestampsToUTC(db); err != nil {
+		return fmt.Errorf("timestamp migration failed: %v", err)
+	}
+
 	return nil
 }
+
+// utcTimestampsVersion is the user_version from which the scanners' own
+// timestamps are stored in UTC.
+const utcTimestampsVersion = 1
+
+// migrateTimestampsToUTC converts the timestamps the scanners wrote as local
+// wall-clock time to UTC, once per database. Columns filled by SQLite's
+// CURRENT_TIMESTAMP default were UTC all along and are left alone.
+func migrateTimestampsToUTC(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return err
+	}
+	if version >= utcTimestampsVersion {
+		return nil
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, stmt := range []string{
+		`UPDATE hosts SET first_seen = datetime(first_seen, 'utc'), last_seen = datetime(last_seen, 'utc')`,
+		`UPDATE docker_hosts SET last_seen = datetime(last_seen, 'utc')`,
+		`UPDATE port_history SET observed_at = datetime(observed_at, 'utc')`,
+		`UPDATE scan_runs SET started_at = datetime(started_at, 'utc'), finished_at = datetime(finished_at, 'utc')`,
+		fmt.Sprintf(`PRAGMA user_version = %d`, utcTimestampsVersion),
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,76 @@
+package db
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+func TestInitDBAtMigratesPortHistoryInterface(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "atlas.db")
+	conn, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for _, stmt := range []string{
+		`CREATE TABLE hosts (id INTEGER PRIMARY KEY AUTOINCREMENT, ip TEXT, interface_name TEXT, last_seen DATETIME)`,
+		`CREATE TABLE port_history (id INTEGER PRIMARY KEY AUTOINCREMENT, ip TEXT NOT NULL, port INTEGER NOT NULL,
+		    protocol TEXT NOT NULL, state TEXT NOT NULL, observed_at DATETIME DEFAULT CURRENT_TIMESTAMP)`,
+		`CREATE INDEX idx_port_history_ip ON port_history(ip, port, protocol)`,
+		`INSERT INTO hosts (ip, interface_name, last_seen) VALUES ('10.0.0.5', 'eth0', '2024-05-01 09:00:00')`,
+		`INSERT INTO port_history (ip, port, protocol, state) VALUES ('10.0.0.5', 22, 'tcp', 'open'), ('10.0.0.9', 80, 'tcp', 'open')`,
+	} {
+		if _, err := conn.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := InitDBAt(path); err != nil {
+		t.Fatalf("InitDBAt: %v", err)
+	}
+	events, err := PortHistoryAt(path, "10.0.0.5")
+	if err != nil || len(events) != 1 || events[0].InterfaceName != "eth0" {
+		t.Fatalf("backfilled history %+v, %v", events, err)
+	}
+	if events, err := PortHistoryAt(path, "10.0.0.9"); err != nil || len(events) != 1 || events[0].InterfaceName != "unknown" {
+		t.Fatalf("history without a host %+v, %v", events, err)
+	}
+	var indexed string
+	if err := conn.QueryRow(`SELECT sql FROM sqlite_master WHERE name = 'idx_port_history_host'`).Scan(&indexed); err != nil {
+		t.Fatalf("new index missing: %v", err)
+	}
+	var stale int
+	if err := conn.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name = 'idx_port_history_ip'`).Scan(&stale); err != nil || stale != 0 {
+		t.Fatalf("old index left behind (%d, %v)", stale, err)
+	}
+}
+
+func TestInitDBAtConvertsTimestampsOnce(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "atlas.db")
+	if err := InitDBAt(path); err != nil {
+		t.Fatalf("InitDBAt: %v", err)
+	}
+	conn, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var version int
+	if err := conn.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil || version != utcTimestampsVersion {
+		t.Fatalf("user_version %d (%v)", version, err)
+	}
+
+	// Rows written after the migration are already UTC; rerunning initdb
+	// must not shift them again.
+	if _, err := conn.Exec(`INSERT INTO hosts (ip, interface_name, last_seen) VALUES ('10.0.0.5', 'eth0', '2024-05-01 07:00:00')`); err != nil {
+		t.Fatal(err)
+	}
+	if err := InitDBAt(path); err != nil {
+		t.Fatalf("InitDBAt again: %v", err)
+	}
+	var lastSeen string
+	if err := conn.QueryRow(`SELECT CAST(last_seen AS TEXT) FROM hosts`).Scan(&lastSeen); err != nil || lastSeen != "2024-05-01 07:00:00" {
+		t.Fatalf("last_seen %q (%v) changed on a second initdb", lastSeen, err)
+	}
+}
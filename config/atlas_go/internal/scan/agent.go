@@ -1,9 +1,15 @@
 package scan
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
+
+	"atlas/internal/utils"
 )
 
 // AgentConfig drives the remote agent loop.
@@ -13,6 +19,51 @@ type AgentConfig struct {
 	Once        bool
 	PrintJSON   bool
 	ScanCommand string
+	// DeepScan carries the nmap tuning used when ScanCommand is deepscan.
+	DeepScan DeepScanOptions
+	// Labels are added to every host's metadata (see --label).
+	Labels map[string]string
+	// EmitEmpty reports runs that found no hosts (see --emit-empty).
+	EmitEmpty bool
+	// MACSalt hashes payload MACs (see --hash-macs).
+	MACSalt string
+	// History archives every payload (see --output jsonl-gzip).
+	History HistoryOptions
+	// Redact blanks fields in the --json output (see --redact).
+	Redact []string
+	// PromTextfile receives each run's metrics (see --prom-textfile).
+	PromTextfile string
+	// BestEffort keeps a controller outage from failing a run whose payload
+	// the history archive kept (see --best-effort); the agent writes no
+	// SQLite rows. parseAgentConfig turns it on unless --fail-fast is set.
+	BestEffort bool
+	// BreakerFailures consecutive failed uploads open the controller circuit
+	// for BreakerCooldown (doubling while the controller stays down). Zero
+	// disables the breaker.
+	BreakerFailures int
+	BreakerCooldown time.Duration
+	// Incremental deep-scans only new or changed hosts after the first run;
+	// every FullEvery-th run (0 = never) is a complete scan again.
+	Incremental bool
+	FullEvery   int
+	// Window, when set, skips ticks that fall outside it and runs when it
+	// next opens instead (see --scan-window).
+	Window *ScanWindow
+	// WatchInterfaces rescans as soon as an interface subnet appears,
+	// disappears, or changes, once no further change has been seen for
+	// WatchDebounce (see --watch-interfaces).
+	WatchInterfaces bool
+	WatchDebounce   time.Duration
+	// CommandPoll, when positive, polls the controller's command queue this
+	// often and runs queued scan_now, scan_host, and update_config commands
+	// (see --command-poll).
+	CommandPoll time.Duration
+	// AlertWebhook receives an external_ip_changed event when a fast scan
+	// sees the site's public address change (see --alert-webhook).
+	AlertWebhook string
+	// ErrorBackoff shortens or stretches the interval after failed runs
+	// (see --error-backoff).
+	ErrorBackoff ErrorBackoff
 }
 
 // RunRemoteAgent executes the requested scan on a schedule and ships the
@@ -33,55 +84,262 @@ func RunRemoteAgent(cfg AgentConfig) error {
 	if cfg.ScanCommand == "" {
 		cfg.ScanCommand = "deepscan"
 	}
+	if cfg.DeepScan.Profile == "" {
+		profile, err := DeepScanProfile(DefaultProfile)
+		if err != nil {
+			return err
+		}
+		cfg.DeepScan = profile
+	}
+
+	// One client for the agent's lifetime so uploads reuse connections.
+	if cfg.Remote.HTTPClient == nil {
+		cfg.Remote.HTTPClient = NewHTTPClient(cfg.Interval + time.Minute)
+	}
+	defer cfg.Remote.HTTPClient.CloseIdleConnections()
+	if cfg.Remote.Breaker == nil {
+		cfg.Remote.Breaker = NewCircuitBreaker(cfg.BreakerFailures, cfg.BreakerCooldown)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	remoteOpts := RemotePayloadOptions{PrintJSON: cfg.PrintJSON, Config: cfg.Remote}
+	// Discover the controller layout once so every run reuses it.
+	cfg.Remote.Discover()
+	remoteOpts := RemotePayloadOptions{PrintJSON: cfg.PrintJSON, Labels: cfg.Labels, EmitEmpty: cfg.EmitEmpty, MACSalt: cfg.MACSalt, History: cfg.History, Redact: cfg.Redact, PromTextfile: cfg.PromTextfile, BestEffort: cfg.BestEffort, Config: cfg.Remote}
 
-	runID := fmt.Sprintf("agent-%d", time.Now().UnixNano())
-	fmt.Printf("[agent] run-id=%s controller=%s site=%s agent=%s interval=%s once=%v scan=%s\n",
-		runID, cfg.Remote.ControllerURL, cfg.Remote.SiteID, cfg.Remote.AgentID, cfg.Interval, cfg.Once, cfg.ScanCommand)
+	fmt.Printf("[agent] controller=%s site=%s agent=%s interval=%s once=%v scan=%s\n",
+		cfg.Remote.ControllerURL, cfg.Remote.SiteID, cfg.Remote.AgentID, cfg.Interval, cfg.Once, cfg.ScanCommand)
 	if cfg.PrintJSON {
 		fmt.Println("[agent] JSON output enabled; payloads will be written to stdout")
 	}
+	if cfg.Window != nil {
+		fmt.Printf("[agent] scans restricted to %s\n", cfg.Window)
+	}
+	// windowOpens fires when the scan window next opens after a skipped
+	// run, so an interval that never lines up with the window still scans.
+	var windowOpens <-chan time.Time
+	// outsideWindow logs and reports a tick the scan window rules out, and
+	// arms windowOpens for the window's next opening.
+	outsideWindow := func(label string, now time.Time) bool {
+		if cfg.Window.Contains(now) {
+			return false
+		}
+		next := cfg.Window.Next(now)
+		fmt.Printf("[agent] %s skipped: outside scan window %s; scanning when it opens at %s\n", label, cfg.Window, next.Format(time.RFC3339))
+		if windowOpens == nil {
+			windowOpens = time.After(time.Until(next))
+		}
+		return true
+	}
 
-	runOnce := func() error {
+	var cache *IncrementalCache
+	if cfg.Incremental {
+		cache = NewIncrementalCache()
+	}
+	// The agent writes no SQLite rows, so fast scans compare the public
+	// address with the one its previous run saw.
+	externalIPs := newExternalIPTracker(cfg.History.Path)
+	runs := 0
+	// runScan scans targets, or everything when targets is empty, and
+	// returns the run ID it used.
+	runScan := func(targets []string) (string, error) {
+		// Each run gets its own ID so the controller can group its hosts.
+		runOpts := remoteOpts
+		runOpts.RunID = newRunID(time.Now())
+		fmt.Printf("[agent] run-id=%s\n", runOpts.RunID)
 		switch cfg.ScanCommand {
 		case "fastscan":
-			return FastScan(FastScanOptions{
-				SkipDB: true,
-				Remote: remoteOpts,
+			if len(targets) > 0 {
+				return "", fmt.Errorf("fastscan cannot scan single targets")
+			}
+			return runOpts.RunID, FastScan(FastScanOptions{
+				SkipDB:          true,
+				Remote:          runOpts,
+				IncludeReserved: cfg.DeepScan.IncludeReserved,
+				AllowPublic:     cfg.DeepScan.AllowPublic,
+				Context:         ctx,
+				AlertWebhook:    cfg.AlertWebhook,
+				externalIPs:     externalIPs,
 			})
 		case "deepscan":
-			return DeepScan(DeepScanOptions{
-				SkipDB: true,
-				Remote: remoteOpts,
-			})
+			deepOpts := cfg.DeepScan
+			deepOpts.SkipDB = true
+			deepOpts.Remote = runOpts
+			// A shutdown signal stops the running scan instead of waiting
+			// for nmap to finish.
+			deepOpts.Context = ctx
+			if len(targets) > 0 {
+				// A targeted run is not one of the incremental schedule's runs.
+				deepOpts.Targets = targets
+				return runOpts.RunID, DeepScan(deepOpts)
+			}
+			runs++
+			if cache != nil {
+				full := runs == 1 || (cfg.FullEvery > 0 && (runs-1)%cfg.FullEvery == 0)
+				cache.BeginRun(full)
+				deepOpts.Incremental = cache
+				fmt.Printf("[agent] incremental run %d (full=%v)\n", runs, full)
+			}
+			return runOpts.RunID, DeepScan(deepOpts)
 		default:
-			return fmt.Errorf("remote agent does not support %s", cfg.ScanCommand)
+			return "", fmt.Errorf("remote agent does not support %s", cfg.ScanCommand)
 		}
 	}
+	runOnce := func() error {
+		_, err := runScan(nil)
+		return err
+	}
 
 	start := time.Now()
-	if err := runOnce(); err != nil {
-		fmt.Printf("[agent] initial %s failed after %s: %v\n", cfg.ScanCommand, time.Since(start), err)
-		return err
+	var initialErr error
+	// Outside the window, the first scan runs when the window opens.
+	if !outsideWindow("initial "+cfg.ScanCommand, start) {
+		if initialErr = runOnce(); initialErr != nil {
+			fmt.Printf("[agent] initial %s failed after %s: %v\n", cfg.ScanCommand, time.Since(start), initialErr)
+			if cfg.Once || isFatalScanError(initialErr) {
+				return initialErr
+			}
+		} else {
+			fmt.Printf("[agent] initial %s completed in %s\n", cfg.ScanCommand, time.Since(start))
+		}
 	}
-	fmt.Printf("[agent] initial %s completed in %s\n", cfg.ScanCommand, time.Since(start))
 	if cfg.Once {
 		return nil
 	}
 
+	var ifChanges <-chan string
+	if cfg.WatchInterfaces {
+		changes, err := watchInterfaces(ctx, cfg.DeepScan.MinPrefixLen, cfg.DeepScan.PrimaryInterfaces, cfg.WatchDebounce)
+		if err != nil {
+			fmt.Printf(utils.Deco("⚠️ [agent] cannot watch interfaces (%v); rescanning on the interval only\n"), err)
+		} else {
+			fmt.Printf("[agent] watching interfaces; changes trigger a rescan after %s of quiet\n", cfg.WatchDebounce)
+			ifChanges = changes
+		}
+	}
+
 	ticker := time.NewTicker(cfg.Interval)
 	defer ticker.Stop()
+	// failures counts consecutive failed runs for cfg.ErrorBackoff.
+	failures := 0
+	// afterRun moves the next scheduled run according to the outcome of the
+	// one that just finished.
+	afterRun := func(err error) {
+		prev := failures
+		if err != nil {
+			failures++
+		} else {
+			failures = 0
+		}
+		if !cfg.ErrorBackoff.enabled() || (failures == 0 && prev == 0) {
+			return
+		}
+		next := cfg.ErrorBackoff.next(cfg.Interval, failures)
+		ticker.Reset(next)
+		at := time.Now().Add(next).Format(time.RFC3339)
+		if failures == 0 {
+			fmt.Printf("[agent] run succeeded after %d failures; back to the %s interval, next run at %s\n", prev, cfg.Interval, at)
+		} else {
+			fmt.Printf("[agent] %d consecutive failed runs; next run in %s at %s (--error-backoff)\n", failures, next, at)
+		}
+	}
+	afterRun(initialErr)
+
+	var polls <-chan time.Time
+	// unacked holds results whose ack failed, so a command the controller
+	// returns again is acknowledged without running it twice.
+	unacked := map[string]commandAck{}
+	if cfg.CommandPoll > 0 {
+		poller := time.NewTicker(cfg.CommandPoll)
+		defer poller.Stop()
+		polls = poller.C
+		fmt.Printf("[agent] polling controller commands every %s\n", cfg.CommandPoll)
+	}
+	execute := func(cmd AgentCommand) commandAck {
+		fmt.Printf("[agent] controller command %s: %s %s\n", cmd.ID, cmd.Type, cmd.Target)
+		switch cmd.Type {
+		case CommandScanNow:
+			// An explicit request overrides the scan window.
+			return ackFor(runScan(nil))
+		case CommandScanHost:
+			if cmd.Target == "" {
+				return ackFor("", fmt.Errorf("scan_host without a target"))
+			}
+			return ackFor(runScan([]string{cmd.Target}))
+		case CommandUpdateConfig:
+			changed, err := cmd.Config.apply(&cfg.Interval, &remoteOpts.Labels)
+			if err != nil {
+				return ackFor("", err)
+			}
+			ticker.Reset(cfg.ErrorBackoff.next(cfg.Interval, failures))
+			fmt.Printf("[agent] configuration updated: %s\n", changed)
+			return ackFor("", nil)
+		default:
+			return ackFor("", fmt.Errorf("unknown command %q", cmd.Type))
+		}
+	}
+	pollCommands := func() {
+		commands, err := cfg.Remote.FetchCommands()
+		if err != nil {
+			fmt.Printf(utils.Deco("⚠️ [agent] command poll failed: %v\n"), err)
+			return
+		}
+		for _, cmd := range commands {
+			ack, done := unacked[cmd.ID]
+			if !done {
+				ack = execute(cmd)
+				if ack.Error != "" {
+					fmt.Printf(utils.Deco("⚠️ [agent] command %s failed: %s\n"), cmd.ID, ack.Error)
+				}
+			}
+			if err := cfg.Remote.AckCommand(cmd.ID, ack); err != nil {
+				fmt.Printf(utils.Deco("⚠️ [agent] could not acknowledge command %s: %v\n"), cmd.ID, err)
+				unacked[cmd.ID] = ack
+				continue
+			}
+			delete(unacked, cmd.ID)
+		}
+	}
+
 	iteration := 1
-	for tick := range ticker.C {
+	for {
+		var tick time.Time
+		trigger := "scheduled"
+		select {
+		case <-ctx.Done():
+			fmt.Println("[agent] shutting down")
+			return nil
+		case tick = <-ticker.C:
+		case tick = <-windowOpens:
+			windowOpens = nil
+			trigger = "scan window opened"
+			// The next scheduled run counts from this one.
+			ticker.Reset(cfg.Interval)
+		case reason := <-ifChanges:
+			tick = time.Now()
+			trigger = "interface change: " + reason
+			// The next scheduled run counts from this one.
+			ticker.Reset(cfg.Interval)
+		case <-polls:
+			pollCommands()
+			continue
+		}
 		iteration++
 		start := time.Now()
-		fmt.Printf("[agent] run %d starting at %s\n", iteration, tick.Format(time.RFC3339))
-		if err := runOnce(); err != nil {
-			fmt.Printf("[agent] run %d failed after %s: %v\n", iteration, time.Since(start), err)
+		if outsideWindow(fmt.Sprintf("run %d (%s)", iteration, trigger), start) {
 			continue
 		}
-		fmt.Printf("[agent] run %d finished in %s\n", iteration, time.Since(start))
+		fmt.Printf("[agent] run %d starting at %s (%s)\n", iteration, tick.Format(time.RFC3339), trigger)
+		err := runOnce()
+		if err != nil {
+			fmt.Printf("[agent] run %d failed after %s (circuit %s): %v\n", iteration, time.Since(start), cfg.Remote.Breaker.State(), err)
+			if isFatalScanError(err) {
+				return err
+			}
+		} else {
+			fmt.Printf("[agent] run %d finished in %s\n", iteration, time.Since(start))
+		}
+		afterRun(err)
 	}
-	return nil
 }
@@ -0,0 +1,115 @@
+package scan
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// AlertEvent is the JSON body posted to an alert webhook (see
+// --alert-webhook).
+type AlertEvent struct {
+	Event   string         `json:"event"`
+	SiteID  string         `json:"site_id,omitempty"`
+	AgentID string         `json:"agent_id,omitempty"`
+	At      time.Time      `json:"at"`
+	Details map[string]any `json:"details,omitempty"`
+}
+
+// postAlert sends event to url with rc's HTTP client. The controller's token
+// and headers are not sent: the webhook is usually another service. Callers
+// log the error; an alert never fails a scan.
+func postAlert(rc RemoteConfig, url string, event AlertEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	client := rc.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", rc.userAgent())
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("alert webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+// externalIPChangedEvent is the alert for a change recordExternalIP or
+// externalIPTracker saw.
+func externalIPChangedEvent(rc RemoteConfig, ext externalIP, at time.Time) AlertEvent {
+	return AlertEvent{
+		Event:   "external_ip_changed",
+		SiteID:  rc.SiteID,
+		AgentID: rc.AgentID,
+		At:      at.UTC(),
+		Details: map[string]any{"previous_ip": ext.Previous, "current_ip": ext.Current},
+	}
+}
+
+// externalIPTracker stands in for external_ip_changes when a fast scan
+// writes no SQLite rows. It remembers the last address across an agent's
+// runs and starts from the newest one in the history archive, so a
+// one-shot --skip-db scan with --output jsonl-gzip also sees changes.
+type externalIPTracker struct {
+	mu       sync.Mutex
+	history  string
+	loaded   bool
+	last     string
+	previous string
+}
+
+func newExternalIPTracker(historyPath string) *externalIPTracker {
+	return &externalIPTracker{history: historyPath}
+}
+
+// observe records ip like recordExternalIP does and returns it with the
+// address it replaced.
+func (t *externalIPTracker) observe(ip string) externalIP {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.loaded {
+		t.loaded = true
+		t.last, t.previous = lastArchivedExternalIP(t.history)
+	}
+	if t.last != "" && t.last != ip {
+		t.previous = t.last
+		t.last = ip
+		return externalIP{Current: ip, Previous: t.previous, Changed: true}
+	}
+	t.last = ip
+	return externalIP{Current: ip, Previous: t.previous}
+}
+
+// lastArchivedExternalIP returns the external_ip and previous_external_ip
+// metadata of the newest archived run that has them. A truncated final
+// record still leaves the earlier ones readable.
+func lastArchivedExternalIP(path string) (current, previous string) {
+	if path == "" {
+		return "", ""
+	}
+	records, _ := readHistory(path)
+	for i := len(records) - 1; i >= 0; i-- {
+		meta := records[i].Metadata
+		if ip, _ := meta["external_ip"].(string); ip != "" {
+			previous, _ = meta["previous_external_ip"].(string)
+			return ip, previous
+		}
+	}
+	return "", ""
+}
@@ -0,0 +1,73 @@
+package scan
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestExternalIPTrackerStartsFromHistory(t *testing.T) {
+	archive := HistoryOptions{Path: filepath.Join(t.TempDir(), "history.jsonl.gz")}
+	p := RemotePayload{Metadata: map[string]any{"external_ip": "198.51.100.1"}}
+	if err := appendHistory(archive, p, time.Now()); err != nil {
+		t.Fatalf("appendHistory: %v", err)
+	}
+
+	tracker := newExternalIPTracker(archive.Path)
+	ext := tracker.observe("198.51.100.1")
+	if ext.Changed || ext.Previous != "" {
+		t.Fatalf("same address as the archive reported a change: %+v", ext)
+	}
+	ext = tracker.observe("203.0.113.7")
+	if !ext.Changed || ext.Previous != "198.51.100.1" || ext.Current != "203.0.113.7" {
+		t.Fatalf("change not detected: %+v", ext)
+	}
+	ext = tracker.observe("203.0.113.7")
+	if ext.Changed || ext.Previous != "198.51.100.1" {
+		t.Fatalf("repeat run should keep previous without a change: %+v", ext)
+	}
+
+	// A fresh tracker without an archive has nothing to compare against.
+	if ext := newExternalIPTracker("").observe("203.0.113.7"); ext.Changed {
+		t.Fatalf("first observation reported a change: %+v", ext)
+	}
+}
+
+func TestPostAlertSendsExternalIPChange(t *testing.T) {
+	var got AlertEvent
+	var auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decode: %v", err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	rc := RemoteConfig{SiteID: "site-1", AgentID: "agent-1", Token: "secret"}
+	ext := externalIP{Current: "203.0.113.7", Previous: "198.51.100.1", Changed: true}
+	if err := postAlert(rc, srv.URL, externalIPChangedEvent(rc, ext, time.Now())); err != nil {
+		t.Fatalf("postAlert: %v", err)
+	}
+	if got.Event != "external_ip_changed" || got.SiteID != "site-1" || got.AgentID != "agent-1" {
+		t.Fatalf("event = %+v", got)
+	}
+	if got.Details["previous_ip"] != "198.51.100.1" || got.Details["current_ip"] != "203.0.113.7" {
+		t.Fatalf("details = %v", got.Details)
+	}
+	if auth != "" {
+		t.Fatalf("controller token leaked to the webhook: %q", auth)
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer failing.Close()
+	if err := postAlert(rc, failing.URL, AlertEvent{Event: "test"}); err == nil {
+		t.Fatal("expected an error for a 502 answer")
+	}
+}
@@ -0,0 +1,65 @@
+package scan
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// ErrorBackoff reschedules the agent after failed runs (--error-backoff).
+// Each consecutive failure multiplies the interval by Factor, so a factor
+// below 1 retries sooner (a transient outage is caught quickly) and one
+// above 1 backs off (a broken network is not hammered). The result is
+// clamped to [Min, Max], with Min capped at the interval; a successful run
+// restores the normal interval.
+// A zero Factor disables it.
+type ErrorBackoff struct {
+	Factor float64
+	Min    time.Duration
+	Max    time.Duration
+}
+
+// Validate reports settings that cannot produce a schedule.
+func (b ErrorBackoff) Validate() error {
+	switch {
+	case b.Factor < 0:
+		return fmt.Errorf("--error-backoff must not be negative")
+	case b.Min < 0 || b.Max < 0:
+		return fmt.Errorf("--error-backoff-min and --error-backoff-max must not be negative")
+	case b.Max > 0 && b.Min > b.Max:
+		return fmt.Errorf("--error-backoff-min (%s) exceeds --error-backoff-max (%s)", b.Min, b.Max)
+	}
+	return nil
+}
+
+// enabled reports whether failures change the schedule at all.
+func (b ErrorBackoff) enabled() bool {
+	return b.Factor > 0 && b.Factor != 1
+}
+
+// next returns the delay before the run after failures consecutive failed
+// runs; with no failures, or when disabled, it is interval.
+func (b ErrorBackoff) next(interval time.Duration, failures int) time.Duration {
+	if failures <= 0 || !b.enabled() {
+		return interval
+	}
+	d := time.Duration(float64(interval) * math.Pow(b.Factor, float64(failures)))
+	// Pow overflows to +Inf (or a negative Duration) long before a large
+	// failure count could matter; treat that as "as long as allowed".
+	if d <= 0 && b.Factor > 1 {
+		d = math.MaxInt64
+	}
+	if b.Max > 0 && d > b.Max {
+		d = b.Max
+	}
+	// Min never stretches a short interval: a factor below 1 is meant to
+	// retry sooner, not later than the schedule would anyway.
+	if floor := min(b.Min, interval); d < floor {
+		d = floor
+	}
+	// A ticker cannot run at zero.
+	if d <= 0 {
+		d = time.Second
+	}
+	return d
+}
@@ -0,0 +1,40 @@
+package scan
+
+import (
+	"testing"
+	"time"
+)
+
+func TestErrorBackoff(t *testing.T) {
+	interval := 15 * time.Minute
+	sooner := ErrorBackoff{Factor: 0.5, Min: 2 * time.Minute, Max: time.Hour}
+	for failures, want := range []time.Duration{15 * time.Minute, 7*time.Minute + 30*time.Second, 225 * time.Second, 2 * time.Minute, 2 * time.Minute} {
+		if got := sooner.next(interval, failures); got != want {
+			t.Errorf("factor 0.5 after %d failures: got %s, want %s", failures, got, want)
+		}
+	}
+	later := ErrorBackoff{Factor: 2, Max: time.Hour}
+	for failures, want := range []time.Duration{15 * time.Minute, 30 * time.Minute, time.Hour, time.Hour} {
+		if got := later.next(interval, failures); got != want {
+			t.Errorf("factor 2 after %d failures: got %s, want %s", failures, got, want)
+		}
+	}
+	if got := later.next(interval, 5000); got != time.Hour {
+		t.Errorf("overflow should clamp to the max, got %s", got)
+	}
+	if got := (ErrorBackoff{}).next(interval, 3); got != interval {
+		t.Errorf("disabled backoff changed the interval to %s", got)
+	}
+	if err := (ErrorBackoff{Factor: 2, Min: time.Hour, Max: time.Minute}).Validate(); err == nil {
+		t.Error("min above max should be rejected")
+	}
+	if err := (ErrorBackoff{Factor: -1}).Validate(); err == nil {
+		t.Error("a negative factor should be rejected")
+	}
+	// With a 30s interval the 1m default minimum would make a "sooner"
+	// retry later than the normal schedule.
+	short := ErrorBackoff{Factor: 0.5, Min: time.Minute, Max: 6 * time.Hour}
+	if got := short.next(30*time.Second, 2); got != 30*time.Second {
+		t.Errorf("min should be capped at the interval, got %s", got)
+	}
+}
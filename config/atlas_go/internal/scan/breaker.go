@@ -0,0 +1,123 @@
+package scan
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned instead of posting while the controller circuit
+// breaker is open. It matches ErrRemoteIngest via errors.Is.
+var ErrCircuitOpen = fmt.Errorf("%w: controller circuit open", ErrRemoteIngest)
+
+// maxBreakerCooldown caps the exponential cooldown growth.
+const maxBreakerCooldown = 6 * time.Hour
+
+// CircuitBreaker stops an agent from hammering an unreachable controller.
+// After Threshold consecutive failed uploads (transport errors or 5xx) it
+// opens for Cooldown; the next upload after that is a half-open probe. A failed probe reopens the circuit
+// for twice as long (up to maxBreakerCooldown), a successful one closes it.
+type CircuitBreaker struct {
+	Threshold int
+	Cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	wait      time.Duration
+	now       func() time.Time
+}
+
+// NewCircuitBreaker returns a closed breaker, or nil when threshold is not
+// positive. A nil breaker never blocks.
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	if threshold <= 0 {
+		return nil
+	}
+	if cooldown <= 0 {
+		cooldown = time.Minute
+	}
+	return &CircuitBreaker{Threshold: threshold, Cooldown: cooldown, now: time.Now}
+}
+
+// State reports "closed", "open", or "half-open".
+func (b *CircuitBreaker) State() string {
+	if b == nil {
+		return "closed"
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.stateLocked()
+}
+
+func (b *CircuitBreaker) stateLocked() string {
+	switch {
+	case b.failures < b.Threshold:
+		return "closed"
+	case b.now().Before(b.openUntil):
+		return "open"
+	default:
+		return "half-open"
+	}
+}
+
+// allow returns ErrCircuitOpen while the cooldown is running.
+func (b *CircuitBreaker) allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.stateLocked() {
+	case "open":
+		return fmt.Errorf("%w after %d failures; skipping upload until %s",
+			ErrCircuitOpen, b.failures, b.openUntil.Format(time.RFC3339))
+	case "half-open":
+		fmt.Printf("[remote] circuit half-open; probing controller after %d failures\n", b.failures)
+	}
+	return nil
+}
+
+// isControllerOutage reports whether err means the controller could not take
+// the upload at all: a transport error or a 5xx answer. A 4xx answer is a
+// rejected payload from a working controller.
+func isControllerOutage(err error) bool {
+	if err == nil || !errors.Is(err, ErrRemoteIngest) {
+		return false
+	}
+	var ingest *RemoteIngestError
+	if errors.As(err, &ingest) {
+		return ingest.StatusCode >= 500
+	}
+	return true
+}
+
+// record updates the breaker with the outcome of one upload. Only outages
+// (see isControllerOutage) count as failures; a 4xx comes from a controller
+// that is up, so it leaves the breaker as it is.
+func (b *CircuitBreaker) record(err error) {
+	if b == nil || errors.Is(err, ErrCircuitOpen) || (err != nil && !isControllerOutage(err)) {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil {
+		if b.failures >= b.Threshold {
+			fmt.Println("[remote] controller reachable again; circuit closed")
+		}
+		b.failures, b.wait, b.openUntil = 0, 0, time.Time{}
+		return
+	}
+	b.failures++
+	if b.failures < b.Threshold {
+		return
+	}
+	if b.wait == 0 {
+		b.wait = b.Cooldown
+	} else {
+		b.wait = min(2*b.wait, maxBreakerCooldown)
+	}
+	b.openUntil = b.now().Add(b.wait)
+	fmt.Printf("[remote] circuit open after %d consecutive failures; skipping uploads for %s\n", b.failures, b.wait)
+}
@@ -0,0 +1,81 @@
+package scan
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBreakerIgnoresClientErrors(t *testing.T) {
+	status := http.StatusBadRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+
+	breaker := NewCircuitBreaker(2, time.Minute)
+	opts := RemotePayloadOptions{Config: RemoteConfig{ControllerURL: srv.URL, SiteID: "lab", AgentID: "edge01", Breaker: breaker}}
+	for i := 0; i < 3; i++ {
+		if err := opts.emit(samplePayload()); err == nil {
+			t.Fatal("expected the 400 to fail the emit")
+		}
+	}
+	if breaker.State() != "closed" || breaker.failures != 0 {
+		t.Fatalf("4xx answers opened the circuit: state=%s failures=%d", breaker.State(), breaker.failures)
+	}
+
+	status = http.StatusServiceUnavailable
+	_ = opts.emit(samplePayload())
+	_ = opts.emit(samplePayload())
+	if breaker.State() != "open" {
+		t.Fatalf("state after two 503s = %s", breaker.State())
+	}
+}
+
+func TestCircuitBreakerSkipsUploadsWhileOpen(t *testing.T) {
+	calls := 0
+	status := http.StatusBadGateway
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.NotFound(w, r)
+			return
+		}
+		calls++
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	breaker := NewCircuitBreaker(2, time.Minute)
+	breaker.now = func() time.Time { return now }
+	opts := RemotePayloadOptions{Config: RemoteConfig{ControllerURL: srv.URL, SiteID: "lab", AgentID: "edge01", Breaker: breaker}}
+	send := func() error { return opts.emit(samplePayload()) }
+
+	_ = send()
+	_ = send()
+	if breaker.State() != "open" {
+		t.Fatalf("state after two failures = %s", breaker.State())
+	}
+	if err := send(); !errors.Is(err, ErrCircuitOpen) || !errors.Is(err, ErrRemoteIngest) || calls != 2 {
+		t.Fatalf("open circuit should skip the POST: err=%v calls=%d", err, calls)
+	}
+
+	// The half-open probe fails, so the cooldown doubles.
+	now = now.Add(time.Minute)
+	_ = send()
+	if calls != 3 || breaker.State() != "open" || breaker.wait != 2*time.Minute {
+		t.Fatalf("failed probe: calls=%d state=%s wait=%s", calls, breaker.State(), breaker.wait)
+	}
+
+	now = now.Add(2 * time.Minute)
+	status = http.StatusAccepted
+	if err := send(); err != nil || breaker.State() != "closed" {
+		t.Fatalf("successful probe: err=%v state=%s", err, breaker.State())
+	}
+}
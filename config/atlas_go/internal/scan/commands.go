@@ -0,0 +1,152 @@
+package scan
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Commands a controller can queue for an agent (see --command-poll).
+const (
+	// CommandScanNow runs a full scan right away.
+	CommandScanNow = "scan_now"
+	// CommandScanHost deep-scans only Target (an IP, CIDR, or hostname).
+	CommandScanHost = "scan_host"
+	// CommandUpdateConfig applies Config to the running agent.
+	CommandUpdateConfig = "update_config"
+)
+
+const (
+	commandsPath   = "sites/{site}/agents/{agent}/commands"
+	commandAckPath = commandsPath + "/{id}/ack"
+)
+
+// AgentCommand is one command queued by the controller.
+type AgentCommand struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	// Target is the address a scan_host command scans. "scan_host <ip>" in
+	// Type is accepted as well.
+	Target string `json:"target,omitempty"`
+	// Config carries the settings an update_config command changes.
+	Config *CommandConfig `json:"config,omitempty"`
+}
+
+// CommandConfig is the part of the agent configuration a controller may
+// change at runtime. Changes last until the agent restarts.
+type CommandConfig struct {
+	// Interval replaces the scan interval, e.g. "30m".
+	Interval string `json:"interval,omitempty"`
+	// Labels are merged into --label; an empty value removes a label.
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// commandAck reports a command's outcome back to the controller.
+type commandAck struct {
+	Status string `json:"status"` // "done" or "failed"
+	Error  string `json:"error,omitempty"`
+	RunID  string `json:"run_id,omitempty"`
+}
+
+func ackFor(runID string, err error) commandAck {
+	if err != nil {
+		return commandAck{Status: "failed", Error: err.Error(), RunID: runID}
+	}
+	return commandAck{Status: "done", RunID: runID}
+}
+
+// normalize splits a "scan_host <ip>" type into Type and Target.
+func (c AgentCommand) normalize() AgentCommand {
+	if kind, target, ok := strings.Cut(strings.TrimSpace(c.Type), " "); ok && c.Target == "" {
+		c.Type, c.Target = kind, strings.TrimSpace(target)
+	}
+	return c
+}
+
+// FetchCommands returns the commands the controller has queued for this
+// agent, following pagination.
+func (rc RemoteConfig) FetchCommands() ([]AgentCommand, error) {
+	items, err := rc.GetPaginated(commandsPath)
+	if err != nil {
+		return nil, err
+	}
+	commands := make([]AgentCommand, 0, len(items))
+	for _, raw := range items {
+		var c AgentCommand
+		if err := json.Unmarshal(raw, &c); err != nil {
+			return nil, fmt.Errorf("%w: command %s: %v", ErrRemoteFetch, raw, err)
+		}
+		if c.ID == "" {
+			return nil, fmt.Errorf("%w: command without an id: %s", ErrRemoteFetch, raw)
+		}
+		commands = append(commands, c.normalize())
+	}
+	return commands, nil
+}
+
+// AckCommand marks command id as processed so the controller stops
+// returning it.
+func (rc RemoteConfig) AckCommand(id string, ack commandAck) error {
+	ackURL, err := rc.apiURL(strings.ReplaceAll(commandAckPath, "{id}", url.PathEscape(id)))
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(ack)
+	if err != nil {
+		return err
+	}
+	client := rc.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 60 * time.Second}
+	}
+	_, _, err = rc.do(client, http.MethodPost, ackURL, body)
+	return err
+}
+
+// apply validates cc and applies it to interval and labels, returning what
+// changed for the log.
+func (cc *CommandConfig) apply(interval *time.Duration, labels *map[string]string) (string, error) {
+	if cc == nil {
+		return "", fmt.Errorf("update_config without a config")
+	}
+	var next time.Duration
+	if cc.Interval != "" {
+		d, err := time.ParseDuration(cc.Interval)
+		if err != nil || d <= 0 {
+			return "", fmt.Errorf("update_config: invalid interval %q", cc.Interval)
+		}
+		next = d
+	}
+	for key, value := range cc.Labels {
+		if _, _, err := ParseLabel(key + "=" + value); err != nil {
+			return "", fmt.Errorf("update_config: %w", err)
+		}
+	}
+	var changed []string
+	if next > 0 {
+		*interval = next
+		changed = append(changed, "interval="+next.String())
+	}
+	if len(cc.Labels) > 0 {
+		merged := make(map[string]string, len(*labels)+len(cc.Labels))
+		for k, v := range *labels {
+			merged[k] = v
+		}
+		for k, v := range cc.Labels {
+			if v == "" {
+				delete(merged, k)
+			} else {
+				merged[k] = v
+			}
+		}
+		*labels = merged
+		changed = append(changed, fmt.Sprintf("%d labels", len(cc.Labels)))
+	}
+	if len(changed) == 0 {
+		return "", fmt.Errorf("update_config: nothing to change")
+	}
+	return strings.Join(changed, ", "), nil
+}
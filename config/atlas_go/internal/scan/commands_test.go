@@ -0,0 +1,64 @@
+package scan
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFetchAndAckCommands(t *testing.T) {
+	acks := map[string]commandAck{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/sites/site-1/agents/agent-1/commands":
+			io.WriteString(w, `[{"id":"c1","type":"scan_host 10.0.0.5"},{"id":"c/2","type":"update_config","config":{"interval":"30m","labels":{"room":"b2","old":""}}}]`)
+		case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/ack"):
+			var ack commandAck
+			if err := json.NewDecoder(r.Body).Decode(&ack); err != nil {
+				t.Errorf("ack body: %v", err)
+			}
+			acks[strings.TrimSuffix(strings.TrimPrefix(r.URL.EscapedPath(), "/sites/site-1/agents/agent-1/commands/"), "/ack")] = ack
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	rc := RemoteConfig{ControllerURL: srv.URL, SiteID: "site-1", AgentID: "agent-1"}
+	commands, err := rc.FetchCommands()
+	if err != nil {
+		t.Fatalf("FetchCommands: %v", err)
+	}
+	if len(commands) != 2 || commands[0].Type != CommandScanHost || commands[0].Target != "10.0.0.5" {
+		t.Fatalf("unexpected commands %+v", commands)
+	}
+
+	interval := 15 * time.Minute
+	labels := map[string]string{"old": "x", "site_code": "hq"}
+	if _, err := commands[1].Config.apply(&interval, &labels); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if interval != 30*time.Minute || !reflect.DeepEqual(labels, map[string]string{"room": "b2", "site_code": "hq"}) {
+		t.Fatalf("config not applied: %s %v", interval, labels)
+	}
+	if _, err := (&CommandConfig{Labels: map[string]string{"run_id": "x"}}).apply(&interval, &labels); err == nil {
+		t.Fatal("update_config replaced a reserved metadata key")
+	}
+
+	if err := rc.AckCommand("c1", ackFor("run-1", nil)); err != nil {
+		t.Fatalf("AckCommand: %v", err)
+	}
+	if err := rc.AckCommand("c/2", ackFor("", errors.New("boom"))); err != nil {
+		t.Fatalf("AckCommand: %v", err)
+	}
+	want := map[string]commandAck{"c1": {Status: "done", RunID: "run-1"}, "c%2F2": {Status: "failed", Error: "boom"}}
+	if !reflect.DeepEqual(acks, want) {
+		t.Fatalf("acks %+v, want %+v", acks, want)
+	}
+}
@@ -0,0 +1,182 @@
+package scan
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"atlas/internal/utils"
+)
+
+// CompareOptions selects the two snapshots the compare command diffs.
+type CompareOptions struct {
+	// Before and After are payload files written by --json/--output-dir, or
+	// JSONL archives (plain or --output jsonl-gzip), whose last run is used.
+	Before string
+	After  string
+	JSON   bool
+}
+
+// OSChange is a host whose reported OS differs between two snapshots.
+type OSChange struct {
+	IP   string `json:"ip"`
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// SnapshotDiff is the structured difference between two payloads.
+type SnapshotDiff struct {
+	Added       []string     `json:"added"`
+	Removed     []string     `json:"removed"`
+	PortChanges []PortChange `json:"port_changes"`
+	OSChanges   []OSChange   `json:"os_changes"`
+}
+
+// Compare loads two snapshots and writes their diff to w as a table, or as
+// JSON with opts.JSON. It touches neither the network nor the database.
+func Compare(w io.Writer, opts CompareOptions) error {
+	before, err := loadSnapshot(opts.Before)
+	if err != nil {
+		return err
+	}
+	after, err := loadSnapshot(opts.After)
+	if err != nil {
+		return err
+	}
+	diff := diffSnapshots(before, after)
+	if opts.JSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(diff)
+	}
+	return writeSnapshotDiff(w, diff)
+}
+
+// loadSnapshot reads the last payload in path. A gzip archive goes through
+// readHistory; anything else is decoded as one or more concatenated JSON
+// payloads, which covers both a single payload file and plain JSONL.
+func loadSnapshot(path string) (RemotePayload, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return RemotePayload{}, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	if magic, _ := r.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		records, err := readHistory(path)
+		if len(records) == 0 {
+			if err == nil {
+				err = errors.New("archive holds no runs")
+			}
+			return RemotePayload{}, fmt.Errorf("%s: %w", path, err)
+		}
+		if err != nil {
+			// stderr, so --json output stays parseable.
+			fmt.Fprintf(os.Stderr, "[compare] %v; using the last complete run\n", err)
+		}
+		return records[len(records)-1].RemotePayload, nil
+	}
+	var last RemotePayload
+	n := 0
+	dec := json.NewDecoder(r)
+	for {
+		var p RemotePayload
+		if err := dec.Decode(&p); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return RemotePayload{}, fmt.Errorf("%s: record %d: %w", path, n+1, err)
+		}
+		last = p
+		n++
+	}
+	if n == 0 {
+		return RemotePayload{}, fmt.Errorf("%s: no payload found", path)
+	}
+	return last, nil
+}
+
+// diffSnapshots compares hosts by IP. Port changes use the same rules as the
+// live port history: a host whose after snapshot has no parsed ports is not
+// treated as having closed everything.
+func diffSnapshots(before, after RemotePayload) SnapshotDiff {
+	diff := SnapshotDiff{Added: []string{}, Removed: []string{}, PortChanges: []PortChange{}, OSChanges: []OSChange{}}
+	old := make(map[string]RemoteHostPayload, len(before.Hosts))
+	for _, h := range before.Hosts {
+		old[h.IP] = h
+	}
+	seen := map[string]bool{}
+	for _, h := range after.Hosts {
+		seen[h.IP] = true
+		prev, ok := old[h.IP]
+		if !ok {
+			diff.Added = append(diff.Added, h.IP)
+			continue
+		}
+		if len(h.Ports) > 0 {
+			states := make(map[portKey]string, len(prev.Ports))
+			for _, p := range prev.Ports {
+				states[portKey{p.Port, p.Protocol}] = p.State
+			}
+			diff.PortChanges = append(diff.PortChanges, diffPortStates(h.IP, states, h.Ports)...)
+		}
+		if knownOS(prev.OS) && knownOS(h.OS) && prev.OS != h.OS {
+			diff.OSChanges = append(diff.OSChanges, OSChange{IP: h.IP, From: prev.OS, To: h.OS})
+		}
+	}
+	for _, h := range before.Hosts {
+		if !seen[h.IP] {
+			diff.Removed = append(diff.Removed, h.IP)
+		}
+	}
+	byIP := func(ips []string) func(i, j int) bool {
+		return func(i, j int) bool { return utils.CompareIP(ips[i], ips[j]) < 0 }
+	}
+	sort.Slice(diff.Added, byIP(diff.Added))
+	sort.Slice(diff.Removed, byIP(diff.Removed))
+	sort.SliceStable(diff.PortChanges, func(i, j int) bool {
+		a, b := diff.PortChanges[i], diff.PortChanges[j]
+		if a.IP != b.IP {
+			return utils.CompareIP(a.IP, b.IP) < 0
+		}
+		if a.Port != b.Port {
+			return a.Port < b.Port
+		}
+		return a.Protocol < b.Protocol
+	})
+	sort.Slice(diff.OSChanges, func(i, j int) bool { return utils.CompareIP(diff.OSChanges[i].IP, diff.OSChanges[j].IP) < 0 })
+	return diff
+}
+
+func knownOS(name string) bool {
+	return name != "" && name != "Unknown"
+}
+
+func writeSnapshotDiff(w io.Writer, diff SnapshotDiff) error {
+	if len(diff.Added)+len(diff.Removed)+len(diff.PortChanges)+len(diff.OSChanges) == 0 {
+		_, err := fmt.Fprintln(w, "No differences.")
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CHANGE\tIP\tDETAIL")
+	for _, ip := range diff.Added {
+		fmt.Fprintf(tw, "added\t%s\t-\n", ip)
+	}
+	for _, ip := range diff.Removed {
+		fmt.Fprintf(tw, "removed\t%s\t-\n", ip)
+	}
+	for _, c := range diff.PortChanges {
+		fmt.Fprintf(tw, "port\t%s\t%s\n", c.IP, strings.TrimPrefix(c.String(), c.IP+": "))
+	}
+	for _, c := range diff.OSChanges {
+		fmt.Fprintf(tw, "os\t%s\t%s -> %s\n", c.IP, c.From, c.To)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,63 @@
+package scan
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCompareSnapshots(t *testing.T) {
+	dir := t.TempDir()
+	before := samplePayload()
+	before.Hosts = []RemoteHostPayload{
+		{IP: "192.168.1.10", OS: "Linux", Ports: []RemotePort{{Port: 22, Protocol: "tcp", State: "open"}, {Port: 80, Protocol: "tcp", State: "open"}}},
+		{IP: "192.168.1.20", OS: "Windows"},
+	}
+	after := samplePayload()
+	after.Hosts = []RemoteHostPayload{
+		{IP: "192.168.1.10", OS: "FreeBSD", Ports: []RemotePort{{Port: 22, Protocol: "tcp", State: "filtered"}, {Port: 443, Protocol: "tcp", State: "open"}}},
+		{IP: "192.168.1.30"},
+	}
+	// before is a two-run gzip archive; only its last run counts.
+	archive := HistoryOptions{Path: filepath.Join(dir, "before.jsonl.gz")}
+	for _, p := range []RemotePayload{after, before} {
+		if err := appendHistory(archive, p, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b, err := json.Marshal(after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	afterPath := filepath.Join(dir, "after.json")
+	if err := os.WriteFile(afterPath, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := Compare(&out, CompareOptions{Before: archive.Path, After: afterPath, JSON: true}); err != nil {
+		t.Fatalf("Compare: %v", err)
+	}
+	var diff SnapshotDiff
+	if err := json.Unmarshal(out.Bytes(), &diff); err != nil {
+		t.Fatalf("decode %s: %v", out.String(), err)
+	}
+	wantPorts := []PortChange{
+		{IP: "192.168.1.10", Port: 22, Protocol: "tcp", From: "open", To: "filtered"},
+		{IP: "192.168.1.10", Port: 80, Protocol: "tcp", From: "open", To: "closed"},
+		{IP: "192.168.1.10", Port: 443, Protocol: "tcp", To: "open"},
+	}
+	if !reflect.DeepEqual(diff.Added, []string{"192.168.1.30"}) || !reflect.DeepEqual(diff.Removed, []string{"192.168.1.20"}) ||
+		!reflect.DeepEqual(diff.PortChanges, wantPorts) || !reflect.DeepEqual(diff.OSChanges, []OSChange{{IP: "192.168.1.10", From: "Linux", To: "FreeBSD"}}) {
+		t.Fatalf("unexpected diff %+v", diff)
+	}
+
+	out.Reset()
+	if err := Compare(&out, CompareOptions{Before: afterPath, After: afterPath}); err != nil || out.String() != "No differences.\n" {
+		t.Fatalf("self-compare = %q, %v", out.String(), err)
+	}
+}
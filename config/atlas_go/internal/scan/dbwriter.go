@@ -0,0 +1,135 @@
+package scan
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+)
+
+// dbWriterBatch caps how many queued operations share one transaction.
+const dbWriterBatch = 64
+
+// dbWriter owns every write a scan makes to its database. Scan goroutines
+// send operations over a channel and wait for the result; the writer runs
+// whatever has queued up in one transaction, so concurrent hosts never
+// compete for SQLite's write lock (no SQLITE_BUSY) and a busy scan pays one
+// fsync per batch instead of one per host. Each operation runs under its own
+// savepoint, so a failing one is undone without rolling back the rest of its
+// batch. Operations apply in the order they were sent.
+type dbWriter struct {
+	db   *sql.DB
+	ops  chan dbOp
+	done chan struct{}
+	// log receives what host upserts report (kept names, port changes);
+	// nil means stdout. DeepScan points it at its progress log.
+	log io.Writer
+}
+
+type dbOp struct {
+	apply  func(tx *sql.Tx) error
+	result chan error
+}
+
+// newDBWriter starts the writer goroutine for db. Close it before closing db.
+func newDBWriter(db *sql.DB) *dbWriter {
+	w := &dbWriter{db: db, ops: make(chan dbOp, dbWriterBatch), done: make(chan struct{})}
+	go w.run()
+	return w
+}
+
+// do runs apply on the writer goroutine and returns its error once the
+// transaction holding it has committed.
+func (w *dbWriter) do(apply func(tx *sql.Tx) error) error {
+	op := dbOp{apply: apply, result: make(chan error, 1)}
+	w.ops <- op
+	return <-op.result
+}
+
+// Close waits for queued operations to commit and stops the writer.
+func (w *dbWriter) Close() {
+	close(w.ops)
+	<-w.done
+}
+
+func (w *dbWriter) run() {
+	defer close(w.done)
+	for op := range w.ops {
+		batch := []dbOp{op}
+		// Take whatever else is already queued, without waiting for more.
+	drain:
+		for len(batch) < dbWriterBatch {
+			select {
+			case next, ok := <-w.ops:
+				if !ok {
+					break drain
+				}
+				batch = append(batch, next)
+			default:
+				break drain
+			}
+		}
+		w.commit(batch)
+	}
+}
+
+func (w *dbWriter) commit(batch []dbOp) {
+	errs := make([]error, len(batch))
+	reply := func() {
+		for i, op := range batch {
+			op.result <- errs[i]
+		}
+	}
+	defer reply()
+	tx, err := w.db.Begin()
+	if err != nil {
+		for i := range errs {
+			errs[i] = fmt.Errorf("%w: %w", ErrDatabase, err)
+		}
+		return
+	}
+	for i, op := range batch {
+		if _, err := tx.Exec("SAVEPOINT op"); err != nil {
+			errs[i] = fmt.Errorf("%w: %w", ErrDatabase, err)
+			continue
+		}
+		if errs[i] = op.run(tx); errs[i] != nil {
+			_, _ = tx.Exec("ROLLBACK TO op")
+		}
+		_, _ = tx.Exec("RELEASE op")
+	}
+	if err := tx.Commit(); err != nil {
+		for i := range errs {
+			if errs[i] == nil {
+				errs[i] = fmt.Errorf("%w: %w", ErrDatabase, err)
+			}
+		}
+	}
+}
+
+// run applies op, returning a panic as op's error so that one bad
+// operation neither stops the writer nor leaves the other senders waiting.
+func (op dbOp) run(tx *sql.Tx) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: operation panicked: %v", ErrDatabase, r)
+		}
+	}()
+	return op.apply(tx)
+}
+
+// upsertHost is upsertHost through the writer.
+func (w *dbWriter) upsertHost(record *HostRecord, policy NamePolicy, agentID string) error {
+	out := w.log
+	if out == nil {
+		out = os.Stdout
+	}
+	return w.do(func(tx *sql.Tx) error {
+		return upsertHostWith(tx, upsertQuery(tx), record, policy, agentID, out)
+	})
+}
+
+// upsertPresence is upsertPresence through the writer.
+func (w *dbWriter) upsertPresence(record HostRecord, agentID string) error {
+	return w.do(func(tx *sql.Tx) error { return upsertPresence(tx, record, agentID) })
+}
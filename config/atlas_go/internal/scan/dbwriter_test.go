@@ -0,0 +1,129 @@
+package scan
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+func TestDBWriterLogsToItsWriter(t *testing.T) {
+	conn := openTestDB(t)
+	writer := newDBWriter(conn)
+	var log bytes.Buffer
+	writer.log = &log
+
+	record := HostRecord{IP: "192.168.1.12", InterfaceName: "eth0", Hostname: "nas.lan"}
+	if err := writer.upsertHost(&record, NamePreferExisting, ""); err != nil {
+		t.Fatal(err)
+	}
+	record.Hostname = "renamed.lan"
+	if err := writer.upsertHost(&record, NamePreferExisting, ""); err != nil {
+		t.Fatal(err)
+	}
+	writer.Close()
+	if !strings.Contains(log.String(), `Host 192.168.1.12 keeps its name "nas.lan"`) {
+		t.Fatalf("kept-name note not written to the writer's log: %q", log.String())
+	}
+}
+
+func TestDBWriterSerializesConcurrentWrites(t *testing.T) {
+	conn := openTestDB(t)
+	writer := newDBWriter(conn)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 40)
+	for i := 0; i < 40; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			record := HostRecord{IP: fmt.Sprintf("10.0.0.%d", i+1), InterfaceName: "eth0", OnlineStatus: "online",
+				Ports: []RemotePort{{Port: 22, Protocol: "tcp", State: "open"}}}
+			if err := writer.upsertHost(&record, NamePreferNew, ""); err != nil {
+				errs <- err
+			} else if record.FirstSeen.IsZero() {
+				errs <- fmt.Errorf("%s: first_seen not read back", record.IP)
+			}
+		}(i)
+	}
+	// A failing operation is rolled back on its own.
+	failed := writer.do(func(tx *sql.Tx) error {
+		if err := upsertPresence(tx, HostRecord{IP: "10.0.9.9", InterfaceName: "eth0", LastSeen: time.Now(), OnlineStatus: "online"}, ""); err != nil {
+			return err
+		}
+		return fmt.Errorf("boom")
+	})
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	if failed == nil || failed.Error() != "boom" {
+		t.Fatalf("expected the operation's own error, got %v", failed)
+	}
+
+	// Operations apply in send order: the last write wins.
+	for _, status := range []string{"offline", "online", "offline"} {
+		if err := writer.do(func(tx *sql.Tx) error {
+			_, err := tx.Exec("UPDATE hosts SET online_status = ? WHERE ip = '10.0.0.1'", status)
+			return err
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writer.Close()
+
+	var hosts, rolledBack int
+	if err := conn.QueryRow("SELECT COUNT(*) FROM hosts WHERE ip LIKE '10.0.0.%'").Scan(&hosts); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.QueryRow("SELECT COUNT(*) FROM hosts WHERE ip = '10.0.9.9'").Scan(&rolledBack); err != nil {
+		t.Fatal(err)
+	}
+	if hosts != 40 || rolledBack != 0 {
+		t.Fatalf("expected 40 hosts and no rolled-back row, got %d and %d", hosts, rolledBack)
+	}
+	var status string
+	if err := conn.QueryRow("SELECT online_status FROM hosts WHERE ip = '10.0.0.1'").Scan(&status); err != nil || status != "offline" {
+		t.Fatalf("expected the last write to win, got %q (%v)", status, err)
+	}
+}
+
+func TestDBWriterSurvivesPanickingOp(t *testing.T) {
+	conn := openTestDB(t)
+	writer := newDBWriter(conn)
+	defer writer.Close()
+
+	err := writer.do(func(tx *sql.Tx) error {
+		if _, err := tx.Exec("INSERT INTO hosts (ip, interface_name) VALUES ('10.0.9.1', 'eth0')"); err != nil {
+			return err
+		}
+		panic("boom")
+	})
+	if !errors.Is(err, ErrDatabase) || !strings.Contains(err.Error(), "panicked: boom") {
+		t.Fatalf("expected the panic as the op's error, got %v", err)
+	}
+	record := HostRecord{IP: "10.0.9.2", InterfaceName: "eth0"}
+	if err := writer.upsertHost(&record, NamePreferNew, ""); err != nil {
+		t.Fatalf("writer stopped after a panic: %v", err)
+	}
+	var n int
+	if err := conn.QueryRow("SELECT COUNT(*) FROM hosts WHERE ip = '10.0.9.1'").Scan(&n); err != nil || n != 0 {
+		t.Fatalf("panicking op was not rolled back: %d rows (%v)", n, err)
+	}
+}
+
+func TestDatabaseErrorsKeepTheirCause(t *testing.T) {
+	_, err := openScanDB(filepath.Join(t.TempDir(), "missing", "atlas.db"))
+	var cause sqlite3.Error
+	if !errors.Is(err, ErrDatabase) || !errors.As(err, &cause) {
+		t.Fatalf("want ErrDatabase wrapping the sqlite error, got %v", err)
+	}
+}
@@ -2,13 +2,16 @@ package scan
 
 import (
 	"bufio"
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"io"
-	"net"
 	"os"
-	"os/exec"
+	"path/filepath"
 	"regexp"
+	"runtime/debug"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -21,59 +24,148 @@ import (
 // Use top ports for faster scans while still providing useful coverage.
 const topTcpPorts = "200"
 
+// logDir holds progress logs and per-host nmap output. Tests point it at a
+// temporary directory.
+var logDir = "/config/logs"
+
+// dbPath is the SQLite database shared with the FastAPI backend.
+var dbPath = "/config/db/atlas.db"
+
 // const udpPortArg = "-" // UDP scan commented
 
 type HostInfo struct {
 	IP            string
 	Name          string
 	InterfaceName string
+	// TargetHostname is the --target name this address was resolved from.
+	TargetHostname string
 }
 
-// DeepScanOptions controls how deep scans persist and emit data.
+// DeepScanOptions controls how deep scans persist and emit data. Use
+// DeepScanProfile to obtain a populated set of nmap settings.
 type DeepScanOptions struct {
-	SkipDB bool
-	Remote RemotePayloadOptions
+	SkipDB  bool
+	Remote  RemotePayloadOptions
+	Profile string
+	TCP     TCPScanOptions
+	// OutputDir, when set, bundles each run's per-host nmap output, payload,
+	// and summary into a timestamped subdirectory instead of logDir.
+	OutputDir string
+	// MinPrefixLen rejects interface subnets broader than this many bits.
+	MinPrefixLen int
+	// PrimaryInterfaces picks which interface a shared subnet is scanned
+	// and reported under, most preferred first.
+	PrimaryInterfaces []string
+	// NameOrder lists hostname resolvers to try in order; nil uses
+	// DefaultNameOrder.
+	NameOrder []string
+	// NameOrderSet marks NameOrder as chosen with --name-order, so a
+	// --dns-server nmap cannot query does not reorder it (see dnsNameOrder).
+	NameOrderSet bool
+	// NamePolicy decides whether a stored hostname yields to a new one.
+	NamePolicy NamePolicy
+	// DNSServer (host:port, see ParseDNSServer) answers reverse DNS, hostname
+	// target lookups, and nmap's discovery names instead of the system
+	// resolver.
+	DNSServer string
+	// Discovery selects where live hosts come from (see DiscoveryNmap).
+	Discovery string
+	// DiscoveryPing selects how live hosts are found (see DiscoveryPingICMP).
+	DiscoveryPing string
+	// Targets, when set, are scanned instead of discovering every interface
+	// subnet. Each may be an IP, a CIDR, or a hostname.
+	Targets []string
+	// Sample deep-scans only a random subset of discovered hosts; the rest
+	// are recorded as present without a port scan.
+	Sample SampleOptions
+	// Count scans each host this many times (at most MaxScanCount) and
+	// merges the results, for lossy networks where one pass misses ports.
+	Count int
+	// RescanEmpty repeats a host's TCP scan once with -Pn when the first
+	// pass parsed no ports.
+	RescanEmpty bool
+	// BindInterface scans each interface's subnet out of that interface and
+	// from its address (nmap -e / -S) for multi-homed hosts.
+	BindInterface bool
+	// AllowPublic permits targets and discovered hosts outside private
+	// address space; by default they are skipped.
+	AllowPublic bool
+	// IncludeReserved keeps link-local, multicast, and subnet network and
+	// broadcast addresses that discovery would otherwise drop.
+	IncludeReserved bool
+	// MaxDuration caps the whole run; hosts not finished by then are
+	// skipped and the run is reported as partial. Zero means no limit.
+	MaxDuration time.Duration
+	// OfflineTTL keeps hosts that were not re-seen online until they have
+	// been missing this long. Zero marks them offline after every scan.
+	OfflineTTL time.Duration
+	// Replay, when set, names a captured greppable nmap log whose hosts are
+	// fed through the scan pipeline instead of probing the network.
+	Replay string
+	// Incremental, when set, skips the port scan of hosts whose last scan
+	// is cached and whose MAC is unchanged (agent --incremental).
+	Incremental *IncrementalCache
+	// SSHFingerprint records the SSH host key fingerprint of hosts with port
+	// 22 open as ssh_host_key_sha256 metadata.
+	SSHFingerprint bool
+	// SkipSelf records the agent's own host (tagged is_self) as present
+	// without port-scanning it.
+	SkipSelf bool
+	// Liveness is the --liveness policy that turns ping, port, and
+	// neighbor-cache signals into OnlineStatus (see LivenessAny).
+	Liveness string
+	// MaxRateHosts caps how many host scans start per second, independent
+	// of nmap's packet rate. Zero means no limit.
+	MaxRateHosts float64
+	// IPv6 adds IPv6 neighbor discovery on each swept interface. Devices
+	// whose MAC matches an IPv4 host get ipv6_addresses metadata; the rest
+	// are reported as ipv6_only hosts without a port scan.
+	IPv6 bool
+	// IncludeRaw attaches each host's grepable nmap output (capped) and its
+	// log path as nmap_raw and nmap_raw_path metadata.
+	IncludeRaw bool
+	// OnlyServices, when set, drops hosts none of whose parsed ports runs
+	// one of these nmap service names before they are persisted or emitted.
+	OnlyServices []string
+	// VLANs are tagged sub-interfaces created for the run (and removed after
+	// it) so their subnets are scanned; hosts on any VLAN interface get
+	// vlan_id and vlan_parent metadata.
+	VLANs []VLANSpec
+	// StreamIngest posts hosts to the controller in batches of StreamEvery
+	// as they finish, and at least every StreamInterval, instead of once at
+	// the end of the run.
+	StreamIngest   bool
+	StreamEvery    int
+	StreamInterval time.Duration
+	// SummaryJSON, when set, writes the run summary (counts, durations, top
+	// ports, subnet stats, new and removed hosts) to this file.
+	SummaryJSON string
+	// RequirePrivileged fails the run when nmap would lack raw sockets,
+	// instead of disabling OS detection, SYN scans, and traceroute.
+	RequirePrivileged bool
+	// ProbePort marks a probesweep run (see ProbeSweepOptions): only this
+	// TCP port was scanned, so hosts are stored as presence only and keep
+	// the ports and OS their last deep scan recorded.
+	ProbePort int
+	// OSHints maps canonical IPs to an operator-supplied OS used when nmap
+	// reports none (--os-hint, --os-hints-file); such hosts get
+	// os_source=manual metadata.
+	OSHints map[string]string
+	// Context, when set, cancels the run like an expired MaxDuration, e.g.
+	// on the agent's shutdown signal. nil means context.Background().
+	Context context.Context
 }
 
-// Try NetBIOS (nbtscan) for hostname resolution
-func getNetBIOSName(ip string) string {
-	out, err := exec.Command("nbtscan", ip).Output()
+// discoverLiveHosts runs an nmap ping sweep of subnet. extraArgs are inserted
+// before the target (e.g. "-n" to skip reverse DNS). stats is the sweep's
+// "Nmap done" summary, zero if nmap printed none.
+func discoverLiveHosts(ctx context.Context, subnet string, extraArgs ...string) (hosts []HostInfo, stats nmapStats, err error) {
+	args := append(append([]string{"-sn"}, extraArgs...), subnet)
+	out, err := utils.RunCommand(ctx, "nmap", args...)
 	if err != nil {
-		return ""
-	}
-	lines := strings.Split(string(out), "\n")
-	for _, line := range lines {
-		fields := strings.Fields(line)
-		// nbtscan output format: IP Name <other info>
-		if len(fields) >= 2 && fields[0] == ip {
-			return fields[1]
-		}
-	}
-	return ""
-}
-
-// Returns best available host name using nmap, reverse DNS, NetBIOS
-func bestHostName(ip string, nmapName string) string {
-	if nmapName != "" && nmapName != "NoName" {
-		return nmapName
-	}
-	name := getHostName(ip)
-	if name != "" && name != "NoName" {
-		return name
-	}
-	name = getNetBIOSName(ip)
-	if name != "" {
-		return name
+		return nil, nmapStats{}, wrapNmapError(err)
 	}
-	return "NoName"
-}
-
-func discoverLiveHosts(subnet string) ([]HostInfo, error) {
-	out, err := exec.Command("nmap", "-sn", subnet).Output()
-	if err != nil {
-		return nil, err
-	}
-	var hosts []HostInfo
+	stats, _ = parseNmapDone(string(out))
 	for _, line := range strings.Split(string(out), "\n") {
 		if strings.HasPrefix(line, "Nmap scan report for") {
 			fields := strings.Fields(line)
@@ -87,62 +179,159 @@ func discoverLiveHosts(subnet string) ([]HostInfo, error) {
 			}
 		}
 	}
-	return hosts, nil
+	return hosts, stats, nil
 }
 
-// Parse nmap port string to human-readable form (show only open/filtered)
-func parseNmapPorts(s string) PortDetails {
-	parts := strings.Split(s, ",")
-	var readable []string
+// parseNmapPorts parses the Ports: column of nmap grepable output. Each entry
+// is port/state/protocol/owner/service/rpc_info/version/; only the first
+// three fields are required. Entries with a non-numeric port or fewer than
+// three fields are dropped, and so are closed ports unless allStates is set.
+func parseNmapPorts(s string, allStates bool) PortDetails {
 	var ports []RemotePort
-	for _, p := range parts {
-		p = strings.TrimSpace(p)
+	for _, p := range splitNmapPortEntries(s) {
 		fields := strings.Split(p, "/")
-		if len(fields) < 5 {
+		if len(fields) < 3 {
+			continue
+		}
+		portStr := strings.TrimSpace(fields[0])
+		portNum, err := strconv.Atoi(portStr)
+		if err != nil {
 			continue
 		}
 		state := strings.ToLower(fields[1])
 		proto := fields[2]
-		service := fields[4]
-		portStr := fields[0]
+		var service, version string
+		if len(fields) > 4 {
+			service = fields[4]
+		}
+		if len(fields) > 6 {
+			// The version column is last; rejoin any slashes it contained.
+			version = strings.TrimSpace(strings.TrimSuffix(strings.Join(fields[6:], "/"), "/"))
+		}
 
 		isInteresting := strings.Contains(state, "open") || state == "filtered" || state == "unfiltered"
-		if isInteresting {
-			part := fmt.Sprintf("%s/%s", portStr, proto)
-			if service != "" {
-				part = fmt.Sprintf("%s (%s)", part, service)
-			}
-			readable = append(readable, part)
-			portNum, _ := strconv.Atoi(portStr)
-			ports = append(ports, RemotePort{Port: portNum, Protocol: proto, Service: service, State: state})
+		if isInteresting || allStates {
+			ports = append(ports, RemotePort{Port: portNum, Protocol: proto, Service: service, State: state, Version: version})
+		}
+	}
+	return PortDetails{Summary: summarizePorts(ports), Ports: ports}
+}
+
+// summarizePorts renders ports as the "22/tcp (ssh), 80/tcp" summary stored
+// in SQLite, or "Unknown" when there are none.
+func summarizePorts(ports []RemotePort) string {
+	if len(ports) == 0 {
+		return "Unknown"
+	}
+	readable := make([]string, 0, len(ports))
+	for _, p := range ports {
+		part := fmt.Sprintf("%d/%s", p.Port, p.Protocol)
+		if p.Service != "" {
+			part = fmt.Sprintf("%s (%s)", part, p.Service)
 		}
+		readable = append(readable, part)
 	}
-	summary := "Unknown"
-	if len(readable) > 0 {
-		summary = strings.Join(readable, ", ")
+	return strings.Join(readable, ", ")
+}
+
+// splitNmapPortEntries splits the Ports: column on the ", " separators between
+// entries. Version strings may themselves contain commas, so a segment that
+// does not start with a port number is glued back onto the previous entry
+// when that entry has reached its version column, and dropped otherwise.
+func splitNmapPortEntries(s string) []string {
+	var entries []string
+	for _, seg := range strings.Split(s, ",") {
+		trimmed := strings.TrimSpace(seg)
+		if port, _, ok := strings.Cut(trimmed, "/"); ok && port != "" && strings.Trim(port, "0123456789") == "" {
+			entries = append(entries, trimmed)
+			continue
+		}
+		if n := len(entries); n > 0 && strings.Count(entries[n-1], "/") >= 6 {
+			entries[n-1] += "," + seg
+		}
 	}
-	return PortDetails{Summary: summary, Ports: ports}
+	return entries
+}
+
+// nmapPrivilegeMessages are printed by nmap when a requested feature needs
+// raw sockets.
+var nmapPrivilegeMessages = []string{
+	"requires root privileges",
+	"Operation not permitted",
+	"Failed to open device",
 }
 
-func scanAllTcp(ip string, logProgress io.Writer) (PortDetails, string) {
-	logFile := fmt.Sprintf("/config/logs/nmap_tcp_%s.log", strings.ReplaceAll(ip, ".", "_"))
-	// Force host up status with -Pn so port scans proceed even when ICMP is filtered.
-	// Limit to the most common ports and speed up the scan with -T4 to avoid long runtimes.
-	nmapArgs := []string{"-O", "-Pn", "--top-ports", topTcpPorts, "-T4", ip, "-oG", logFile}
+// nmapNeedsPrivileges reports whether a failed nmap run blames missing
+// privileges. nmap prints the reason on stderr, which the runner returns in
+// err.
+func nmapNeedsPrivileges(err error) bool {
+	for _, msg := range nmapPrivilegeMessages {
+		if strings.Contains(err.Error(), msg) {
+			return true
+		}
+	}
+	return false
+}
+
+// tcpScanResult is everything scanAllTcp extracts from one nmap run.
+type tcpScanResult struct {
+	Ports PortDetails
+	OS    string
+	// Hops lists traceroute hop addresses when TCPScanOptions.Traceroute is set.
+	Hops []string
+	// Vendor is the MAC vendor nmap printed, when it could see the MAC.
+	Vendor string
+	// Nmap is the "# Nmap done" summary from the grepable output.
+	Nmap nmapStats
+	// Raw is the grepable output as read (capped at maxRawOutput) and
+	// RawPath the log file it came from, for --include-raw.
+	Raw     string
+	RawPath string
+}
+
+// maxRawOutput caps the nmap_raw metadata --include-raw attaches to a host.
+const maxRawOutput = 16 << 10
+
+// capRawOutput trims raw to maxRawOutput bytes, noting how much was cut.
+func capRawOutput(raw string) string {
+	if len(raw) <= maxRawOutput {
+		return raw
+	}
+	return raw[:maxRawOutput] + fmt.Sprintf("\n... [%d more bytes truncated]\n", len(raw)-maxRawOutput)
+}
+
+func scanAllTcp(ctx context.Context, ip string, tcp TCPScanOptions, outDir string, logProgress io.Writer) tcpScanResult {
+	logFile := filepath.Join(outDir, fmt.Sprintf("nmap_tcp_%s.log", strings.ReplaceAll(ip, ".", "_")))
+	// The standard profile forces host up status with -Pn so port scans proceed even when
+	// ICMP is filtered, and limits to the most common ports with -T4 to avoid long runtimes.
+	nmapArgs := tcp.nmapArgs(ip, logFile)
 	start := time.Now()
-	cmd := exec.Command("nmap", nmapArgs...)
-	cmd.Stdout = logProgress
-	cmd.Stderr = logProgress
-	if err := cmd.Run(); err != nil {
-		fmt.Fprintf(logProgress, "[nmap] command failed for %s: %v\n", ip, err)
-		return PortDetails{Summary: "Unknown"}, "Unknown"
+	// A failed run is still parsed, so never let it pick up an older file.
+	_ = os.Remove(logFile)
+	out, runErr := utils.RunCommand(ctx, "nmap", nmapArgs...)
+	logProgress.Write(out)
+	if runErr != nil {
+		if ctx.Err() != nil {
+			fmt.Fprintf(logProgress, "[nmap] scan of %s cancelled: %v\n", ip, ctx.Err())
+			return tcpScanResult{Ports: PortDetails{Summary: "Unknown"}, OS: "Unknown"}
+		}
+		// nmap often writes the greppable file before exiting non-zero,
+		// e.g. when only OS detection lacked privileges.
+		fmt.Fprintf(logProgress, "[nmap] command failed for %s: %v; parsing any partial output\n", ip, runErr)
+		if nmapNeedsPrivileges(runErr) {
+			fmt.Fprintf(logProgress, utils.Deco("⚠️ nmap lacked privileges scanning %s; run atlas as root or grant nmap CAP_NET_RAW, or use --no-os-detect\n"), ip)
+		}
+	} else {
+		fmt.Fprintf(logProgress, "TCP scan for %s finished in %s\n", ip, time.Since(start))
+	}
+	var hops []string
+	if tcp.Traceroute {
+		hops = parseTraceroute(string(out))
 	}
-	elapsed := time.Since(start)
-	fmt.Fprintf(logProgress, "TCP scan for %s finished in %s\n", ip, elapsed)
 
 	file, err := os.Open(logFile)
 	if err != nil {
-		return PortDetails{Summary: "Unknown"}, "Unknown"
+		return tcpScanResult{Ports: PortDetails{Summary: "Unknown"}, OS: "Unknown"}
 	}
 	defer file.Close()
 
@@ -155,18 +344,26 @@ func scanAllTcp(ip string, logProgress io.Writer) (PortDetails, string) {
 
 	scanner := bufio.NewScanner(file)
 	var sampleLines []string
+	var stats nmapStats
+	var raw strings.Builder
 	for scanner.Scan() {
 		line := scanner.Text()
+		raw.WriteString(line)
+		raw.WriteByte('\n')
 		if len(sampleLines) < 5 {
 			sampleLines = append(sampleLines, line)
 		}
+		if s, ok := parseNmapDone(line); ok {
+			stats = s
+			continue
+		}
 		if m := rePorts.FindStringSubmatch(line); m != nil {
 			portField := strings.TrimSpace(m[1])
 			if idx := strings.Index(portField, "Ignored State:"); idx != -1 {
 				portField = strings.TrimSpace(portField[:idx])
 			}
 			rawPortField = portField
-			ports = parseNmapPorts(portField)
+			ports = parseNmapPorts(portField, tcp.AllPortStates)
 		}
 		if m := reOS.FindStringSubmatch(line); m != nil {
 			rawOs := m[1]
@@ -189,7 +386,11 @@ func scanAllTcp(ip string, logProgress io.Writer) (PortDetails, string) {
 	} else {
 		fmt.Fprintf(logProgress, "[nmap] parsed %d ports for %s; summary=%s\n", len(ports.Ports), ip, ports.Summary)
 	}
-	return ports, osInfo
+	if runErr != nil && len(ports.Ports) == 0 && osInfo == "" {
+		return tcpScanResult{Ports: PortDetails{Summary: "Unknown"}, OS: "Unknown"}
+	}
+	return tcpScanResult{Ports: ports, OS: osInfo, Hops: hops, Vendor: parseMACVendor(string(out)), Nmap: stats,
+		Raw: capRawOutput(raw.String()), RawPath: logFile}
 }
 
 // func scanAllUdp(ip string, logProgress *os.File) string {
@@ -219,63 +420,186 @@ func scanAllTcp(ip string, logProgress io.Writer) (PortDetails, string) {
 // 	return ports
 // }
 
-func getHostName(ip string) string {
-	names, err := net.LookupAddr(ip)
-	if err != nil || len(names) == 0 {
-		return "NoName"
-	}
-	return strings.TrimSuffix(names[0], ".")
-}
-
-func getMacAddress(ip string) string {
-	file, err := os.Open("/proc/net/arp")
+// openScanDB opens the SQLite database and checks that it is reachable;
+// sql.Open alone defers every error to the first query.
+func openScanDB(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", path)
 	if err != nil {
-		return "Unknown"
+		return nil, fmt.Errorf("%w: %w", ErrDatabase, err)
 	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-	scanner.Scan() // Skip header
-	for scanner.Scan() {
-		fields := strings.Fields(scanner.Text())
-		if len(fields) >= 4 && fields[0] == ip {
-			return fields[3]
-		}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%w: %w", ErrDatabase, err)
 	}
-	return "Unknown"
+	return db, nil
 }
 
 func DeepScan(opts DeepScanOptions) error {
-	if err := os.MkdirAll("/config/logs", 0o755); err != nil {
-		fmt.Printf("⚠️ Unable to create log directory /config/logs: %v\n", err)
+	if opts.Replay != "" {
+		return replayDeepScan(opts)
 	}
+	if err := os.MkdirAll(logDir, 0o755); err != nil {
+		fmt.Printf(utils.Deco("⚠️ Unable to create log directory %s: %v\n"), logDir, err)
+	}
+	removeVLANs, err := setupVLANs(opts.VLANs, func(format string, args ...any) {
+		fmt.Printf(format+"\n", args...)
+	})
+	if err != nil {
+		return err
+	}
+	defer removeVLANs()
 	// Get all network interfaces
 	interfaces, err := utils.GetAllInterfaces()
 	if err != nil {
-		fmt.Printf("⚠️ Could not auto-detect interfaces: %v, using fallback\n", err)
+		fmt.Printf(utils.Deco("⚠️ Could not auto-detect interfaces: %v, using fallback\n"), err)
 		// Fallback to default subnet if auto-detection fails
 		interfaces = []utils.InterfaceInfo{{Name: "unknown", Subnet: "192.168.2.0/24", IP: ""}}
 	}
+	// Taken before sanitizing so addresses on skipped interfaces still count.
+	selfIPs := selfAddresses(interfaces)
 
 	startTime := time.Now()
-	logFile := "/config/logs/deep_scan_progress.log"
-	lf, _ := os.Create(logFile)
-	defer lf.Close()
-	logProgress := io.MultiWriter(lf, os.Stdout)
+	runID := opts.Remote.runID(startTime)
+	// parent ends when the caller gives up on the run, e.g. on the agent's
+	// shutdown signal; ctx adds the --max-duration budget. Sweeps and host
+	// scans still running when either ends are killed and left out of the
+	// results.
+	parent := opts.Context
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx := parent
+	if opts.MaxDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.MaxDuration)
+		defer cancel()
+	}
+	logFile := filepath.Join(logDir, "deep_scan_progress.log")
+	var progressOut io.Writer = os.Stdout
+	if lf, err := os.Create(logFile); err == nil {
+		defer lf.Close()
+		progressOut = io.MultiWriter(lf, os.Stdout)
+	}
+	// Host goroutines all log here; serialize writes so lines never interleave.
+	logProgress := newLockedWriter(progressOut)
+
+	interfaces = sanitizeInterfaces(interfaces, opts.MinPrefixLen, opts.PrimaryInterfaces, func(format string, args ...any) {
+		fmt.Fprintf(logProgress, format+"\n", args...)
+	})
+	if len(interfaces) == 0 && len(opts.Targets) == 0 {
+		return fmt.Errorf("%w: no interface subnet passed validation", ErrNoInterfaces)
+	}
+	vlans := vlanInterfaces(interfaces)
+
+	scanner := "deepscan"
+	if opts.ProbePort > 0 {
+		scanner = "probesweep"
+	}
+	fmt.Fprintf(logProgress, "[%s] scanner version=%s (agent build) run-id=%s starting at %s on %d interfaces (profile=%s)\n", scanner, ScannerVersion, runID, startTime.UTC().Format(time.RFC3339), len(interfaces), opts.Profile)
+	dns := newDNSResolver(opts.DNSServer)
+	var dnsArgs []string
+	if opts.DNSServer != "" {
+		fmt.Fprintf(logProgress, "Resolving reverse DNS and target hostnames through %s\n", opts.DNSServer)
+		if dnsArgs = nmapDNSArgs(opts.DNSServer); dnsArgs == nil {
+			opts.NameOrder = dnsNameOrder(opts.NameOrder, opts.NameOrderSet, opts.DNSServer, logProgress)
+		}
+	}
+
+	tcp, capabilities, err := adjustForPrivileges(opts.TCP, currentPrivileges(), opts.RequirePrivileged, logProgress)
+	if err != nil {
+		return err
+	}
+	opts.TCP = tcp
 
-	fmt.Fprintf(logProgress, "[deepscan] scanner version=%s (agent build) starting at %s on %d interfaces\n", ScannerVersion, startTime.UTC().Format(time.RFC3339), len(interfaces))
+	hostLogDir := logDir
+	var runDir string
+	if opts.OutputDir != "" {
+		runDir = filepath.Join(opts.OutputDir, startTime.UTC().Format("20060102T150405Z"))
+		if err := os.MkdirAll(runDir, 0o755); err != nil {
+			return fmt.Errorf("create output dir %s: %w", runDir, err)
+		}
+		hostLogDir = runDir
+		if opts.Remote.JSONOutPath == "" {
+			opts.Remote.JSONOutPath = filepath.Join(runDir, "payload.json")
+		}
+		fmt.Fprintf(logProgress, "[deepscan] writing run artifacts to %s\n", runDir)
+	}
 
 	var hostInfos []HostInfo
+	// discoveredOn lists interfaces whose sweep succeeded; only their hosts
+	// can be judged offline afterwards.
+	var discoveredOn []string
+	// nmapRuns totals the "Nmap done" summaries of every sweep and port scan.
+	var nmapRuns nmapRunTotals
+
+	if _, err := discoveryPingArgs(opts.DiscoveryPing); err != nil {
+		return err
+	}
+
+	discoverLogf := func(format string, args ...any) {
+		fmt.Fprintf(logProgress, format+"\n", args...)
+	}
+	var bindings map[string]utils.InterfaceInfo
+	if opts.BindInterface {
+		bindings = interfaceBindings(interfaces, isPrivileged(), discoverLogf)
+	}
+	sweep := interfaces
+	if len(opts.Targets) > 0 {
+		// Explicit targets replace interface discovery. Nothing is marked
+		// offline afterwards because only part of each subnet was looked at.
+		sweep = nil
+		targets := opts.Targets
+		if !opts.AllowPublic {
+			targets = targets[:0:0]
+			for _, target := range opts.Targets {
+				if publicTarget(target) {
+					fmt.Fprintf(logProgress, utils.Deco("⚠️ Skipping target %s: outside private address space (use --allow-public to scan it)\n"), target)
+					continue
+				}
+				targets = append(targets, target)
+			}
+		}
+		var errs []error
+		hostInfos, errs = resolveTargets(targets, interfaces, dns, func(subnet string) ([]HostInfo, error) {
+			hosts, stats, err := discoverHosts(ctx, subnet, opts.Discovery, opts.DiscoveryPing, discoverLogf, dnsArgs...)
+			nmapRuns.Discovery.add(stats)
+			if err == nil && !opts.IncludeReserved {
+				hosts = dropReservedHosts(hosts, subnet, discoverLogf)
+			}
+			return hosts, err
+		})
+		for _, err := range errs {
+			fmt.Fprintf(logProgress, utils.Deco("⚠️ Skipping %v\n"), err)
+			if errors.Is(err, ErrNmapNotFound) {
+				return err
+			}
+		}
+		// CIDR sweeps were filtered against their own range above. An IP or
+		// a resolved hostname has no subnet, so only link-local, multicast,
+		// and similar addresses are dropped; hostnames are only checked for
+		// public addresses once resolved.
+		hostInfos = filterDiscovered(hostInfos, "", opts.IncludeReserved, opts.AllowPublic, discoverLogf)
+	}
 
 	// Discover live hosts on all interfaces
-	for _, iface := range interfaces {
+	for _, iface := range sweep {
 		fmt.Fprintf(logProgress, "Discovering live hosts on %s (interface: %s)...\n", iface.Subnet, iface.Name)
-		hosts, err := discoverLiveHosts(iface.Subnet)
+		sweepArgs := dnsArgs
+		if b, ok := bindings[iface.Name]; ok {
+			sweepArgs = append(sourceArgs(b.Name, b.IP), dnsArgs...)
+		}
+		hosts, sweepStats, err := discoverHosts(ctx, iface.Subnet, opts.Discovery, opts.DiscoveryPing, discoverLogf, sweepArgs...)
+		nmapRuns.Discovery.add(sweepStats)
 		if err != nil {
 			fmt.Fprintf(logProgress, "Failed to discover hosts on %s: %v\n", iface.Subnet, err)
+			if errors.Is(err, ErrNmapNotFound) {
+				return err
+			}
 			continue
 		}
 		fmt.Fprintf(logProgress, "Discovered %d hosts on %s\n", len(hosts), iface.Subnet)
+		hosts = filterDiscovered(hosts, iface.Subnet, opts.IncludeReserved, opts.AllowPublic, discoverLogf)
+		discoveredOn = append(discoveredOn, iface.Name)
 		// Add interface name to each host
 		for _, host := range hosts {
 			host.InterfaceName = iface.Name
@@ -283,41 +607,241 @@ func DeepScan(opts DeepScanOptions) error {
 		}
 	}
 
+	var v6Neighbors []ipv6Neighbor
+	if opts.IPv6 {
+		if len(sweep) == 0 {
+			fmt.Fprintln(logProgress, "IPv6 neighbor discovery skipped: --target replaces interface discovery")
+		}
+		v6Neighbors = discoverIPv6Neighbors(ctx, sweep, discoverLogf)
+	}
+	v6 := newIPv6Index(v6Neighbors)
+	neighbors := readNeighborTable()
+	fmt.Fprintf(logProgress, "Total discovered: %d hosts in %s\n", len(hostInfos), time.Since(startTime))
+	if nmapRuns.Discovery.Runs > 0 && nmapRuns.Discovery.HostsUp != len(hostInfos) {
+		fmt.Fprintf(logProgress, "nmap reported %d hosts up across %d sweeps; %d kept after filtering\n", nmapRuns.Discovery.HostsUp, nmapRuns.Discovery.Runs, len(hostInfos))
+	}
+
+	runMeta := map[string]any{"run_id": runID, "interfaces": utils.DescribeInterfaces(interfaces), "scan_capabilities": capabilities}
+	if opts.ProbePort > 0 {
+		runMeta["probe_port"] = opts.ProbePort
+	}
+	nmapNames := make(map[string]string, len(hostInfos))
+	for _, h := range hostInfos {
+		nmapNames[h.IP] = h.Name
+	}
+	names := newNameResolvers(opts.NameOrder, nmapNames, dns)
+	var unsampled []HostInfo
+	if opts.Sample.enabled() {
+		discovered := len(hostInfos)
+		var seed int64
+		hostInfos, unsampled, seed = sampleHosts(hostInfos, opts.Sample)
+		sampledIPs := make([]string, len(hostInfos))
+		for i, h := range hostInfos {
+			sampledIPs[i] = h.IP
+		}
+		fmt.Fprintf(logProgress, "[deepscan] sampling %d/%d hosts (seed=%d): %s\n", len(hostInfos), discovered, seed, strings.Join(sampledIPs, ", "))
+		runMeta["sample"] = map[string]any{"discovered": discovered, "scanned": len(hostInfos), "seed": seed}
+	}
 	total := len(hostInfos)
-	fmt.Fprintf(logProgress, "Total discovered: %d hosts in %s\n", total, time.Since(startTime))
 
+	gatewayIP, err := getDefaultGateway()
+	if err != nil {
+		fmt.Fprintf(logProgress, utils.Deco("⚠️ Could not determine gateway: %v\n"), err)
+		gatewayIP = ""
+	}
+
+	// A broken local DB only aborts the run when it is the sole sink;
+	// remote-emitting agents keep scanning and report without persisting.
 	var db *sql.DB
 	if !opts.SkipDB {
-		dbPath := "/config/db/atlas.db"
-		db, err = sql.Open("sqlite3", dbPath)
+		db, err = openScanDB(dbPath)
 		if err != nil {
-			fmt.Fprintf(logProgress, "Failed to open DB: %v\n", err)
-			return err
+			if !opts.Remote.shouldEmit() {
+				fmt.Fprintf(logProgress, "Failed to open DB: %v\n", err)
+				return err
+			}
+			fmt.Fprintf(logProgress, utils.Deco("⚠️ Failed to open DB, continuing without local persistence: %v\n"), err)
 		}
+	}
+	// All writes go through writer; host goroutines never touch db directly.
+	var writer *dbWriter
+	if db != nil {
 		defer db.Close()
+		writer = newDBWriter(db)
+		writer.log = logProgress
+		defer writer.Close()
 
-		// Mark all hosts as offline before scanning
-		if _, err := db.Exec("UPDATE hosts SET online_status = 'offline'"); err != nil {
-			fmt.Fprintf(logProgress, "Failed to mark hosts as offline: %v\n", err)
-		}
+		_ = writer.do(func(tx *sql.Tx) error {
+			for _, host := range unsampled {
+				seen := HostRecord{IP: host.IP, Hostname: host.Name, NetworkName: "LAN", InterfaceName: host.InterfaceName, LastSeen: time.Now(), OnlineStatus: "online"}
+				if err := upsertPresence(tx, seen, opts.Remote.Config.AgentID); err != nil {
+					fmt.Fprintf(logProgress, utils.Deco("❌ %v\n"), err)
+				}
+			}
+			return nil
+		})
 	}
 
 	var wg sync.WaitGroup
 	var remoteBatch []HostRecord
 	var batchMu sync.Mutex
+	skipped, reused, errored, filtered := 0, 0, 0, 0
+	skip := func() {
+		batchMu.Lock()
+		skipped++
+		batchMu.Unlock()
+	}
+	// keep applies --only-service; a dropped host still counts as discovered.
+	keep := func(record HostRecord) bool {
+		if len(opts.OnlyServices) == 0 || offersService(record, opts.OnlyServices) {
+			return true
+		}
+		fmt.Fprintf(logProgress, "Host %s offers none of %s; dropped by --only-service\n", record.IP, strings.Join(opts.OnlyServices, ","))
+		batchMu.Lock()
+		filtered++
+		batchMu.Unlock()
+		return false
+	}
+	var stream *hostStream
+	if opts.StreamIngest {
+		stream = newHostStream(opts.Remote, runID, opts.StreamEvery, opts.StreamInterval, func(format string, args ...any) {
+			fmt.Fprintf(logProgress, format+"\n", args...)
+		})
+	}
+	limiter := newHostRateLimiter(opts.MaxRateHosts)
+	if limiter != nil {
+		fmt.Fprintf(logProgress, "Starting at most %g host scans per second (--max-rate-hosts)\n", opts.MaxRateHosts)
+	}
 	for idx, host := range hostInfos {
+		// Paced here, in host order; once the budget expires the goroutine
+		// sees ctx done and counts the host as skipped.
+		_ = limiter.wait(ctx)
 		wg.Add(1)
 		go func(idx int, host HostInfo) {
 			defer wg.Done()
+			// A bug tripped by one host's data must not take down a long
+			// scan: report that host as errored and let the rest finish.
+			defer func() {
+				r := recover()
+				if r == nil {
+					return
+				}
+				fmt.Fprintf(logProgress, utils.Deco("❌ Host %s: scan panicked: %v\n%s\n"), host.IP, r, debug.Stack())
+				record := HostRecord{
+					IP:            host.IP,
+					Hostname:      host.Name,
+					InterfaceName: host.InterfaceName,
+					NetworkName:   "LAN",
+					LastSeen:      time.Now(),
+					OnlineStatus:  "online",
+					Metadata:      map[string]any{"scanner": scanner, "run_id": runID, "scan_error": fmt.Sprint(r)},
+				}
+				batchMu.Lock()
+				remoteBatch = append(remoteBatch, record)
+				stream.add(record)
+				errored++
+				batchMu.Unlock()
+			}()
+			if ctx.Err() != nil {
+				skip()
+				return
+			}
 			hostStart := time.Now()
 			ip := host.IP
-			// Use bestHostName for all fallback methods
-			name := bestHostName(ip, host.Name)
+			name := bestHostName(ctx, ip, names)
+			isSelf := selfIPs[ip]
+			if isSelf && opts.SkipSelf {
+				fmt.Fprintf(logProgress, "Host %d/%d: %s is this agent; skipping its port scan (--skip-self)\n", idx+1, total, ip)
+				record := HostRecord{
+					IP:            ip,
+					Hostname:      name,
+					InterfaceName: host.InterfaceName,
+					NetworkName:   "LAN",
+					LastSeen:      time.Now(),
+					OnlineStatus:  "online",
+					Metadata:      map[string]any{"scanner": scanner, "run_id": runID, "is_self": true},
+				}
+				tagVLAN(&record, vlans)
+				applyOSHint(&record, opts.OSHints)
+				if !keep(record) {
+					return
+				}
+				if db != nil {
+					if err := writer.upsertPresence(record, opts.Remote.Config.AgentID); err != nil {
+						fmt.Fprintf(logProgress, utils.Deco("❌ %v\n"), err)
+					}
+				}
+				batchMu.Lock()
+				remoteBatch = append(remoteBatch, record)
+				stream.add(record)
+				batchMu.Unlock()
+				return
+			}
+			if isSelf {
+				fmt.Fprintf(logProgress, utils.Deco("⚠️ Host %s is this agent; its own listening services will be scanned (pass --skip-self to skip it)\n"), ip)
+			}
+			if opts.Incremental != nil {
+				if prev, ok := opts.Incremental.lookup(ip, getMacAddress(ip)); ok {
+					fmt.Fprintf(logProgress, "Host %d/%d: %s unchanged (MAC %s); reusing the scan from %s\n", idx+1, total, ip, prev.MAC, prev.LastSeen.Format(time.RFC3339))
+					signals := gatherLiveness(ip, utils.PingHost(ip), prev.Ports, neighbors)
+					record := reuseCachedHost(prev, name, signals.status(opts.Liveness), runID)
+					record.Metadata["liveness_signals"] = signals.names()
+					applyOSHint(&record, opts.OSHints)
+					v6.claim(&record)
+					if !keep(record) {
+						return
+					}
+					if db != nil {
+						if err := writer.upsertHost(&record, opts.NamePolicy, opts.Remote.Config.AgentID); err != nil {
+							fmt.Fprintf(logProgress, utils.Deco("❌ Update failed for %s on interface %s: %v\n"), ip, host.InterfaceName, err)
+						}
+					}
+					batchMu.Lock()
+					remoteBatch = append(remoteBatch, record)
+					stream.add(record)
+					reused++
+					batchMu.Unlock()
+					return
+				}
+			}
 			fmt.Fprintf(logProgress, "Scanning host %d/%d: %s\n", idx+1, total, ip)
 
-			tcpPorts, osInfo := scanAllTcp(ip, logProgress)
+			tcp := opts.TCP
+			if b, ok := bindings[host.InterfaceName]; ok {
+				tcp.Interface, tcp.SourceIP = b.Name, b.IP
+			}
+			scanOnce := func(t TCPScanOptions) tcpScanResult {
+				r := scanAllTcp(ctx, ip, t, hostLogDir, logProgress)
+				batchMu.Lock()
+				nmapRuns.Scans.add(r.Nmap)
+				batchMu.Unlock()
+				return r
+			}
+			rescanned := false
+			result, status, observations, ok := repeatHostScan(ctx, opts.Count, func() tcpScanResult {
+				if !opts.RescanEmpty {
+					return scanOnce(tcp)
+				}
+				r, again := rescanIfEmpty(ctx, tcp, scanOnce, func(format string, args ...any) {
+					fmt.Fprintf(logProgress, "Host %s: "+format+"\n", append([]any{ip}, args...)...)
+				})
+				rescanned = rescanned || again
+				return r
+			}, func() string {
+				return utils.PingHost(ip)
+			})
+			if !ok {
+				fmt.Fprintf(logProgress, "Host %s cut off by the scan budget\n", ip)
+				skip()
+				return
+			}
+			tcpPorts, osInfo := result.Ports, result.OS
+			signals := gatherLiveness(ip, status, tcpPorts.Ports, neighbors)
+			status = signals.status(opts.Liveness)
+			if !opts.TCP.OSDetect {
+				osInfo = "Unknown"
+			}
 			mac := getMacAddress(ip)
-			status := utils.PingHost(ip)
 			elapsed := time.Since(startTime)
 			hostsLeft := total - (idx + 1)
 			estLeft := time.Duration(0)
@@ -334,30 +858,238 @@ func DeepScan(opts DeepScanOptions) error {
 				MAC:           mac,
 				PortSummary:   tcpPorts.Summary,
 				Ports:         tcpPorts.Ports,
+				NextHop:       gatewayIP,
 				InterfaceName: host.InterfaceName,
 				NetworkName:   "LAN",
 				LastSeen:      time.Now(),
 				OnlineStatus:  status,
 				Metadata: map[string]any{
-					"scanner": "deepscan",
+					"scanner":          scanner,
+					"run_id":           runID,
+					"liveness_signals": signals.names(),
 				},
 			}
+			if gatewayIP != "" {
+				record.Metadata["gateway_ip"] = gatewayIP
+			}
+			for k, v := range observations {
+				record.Metadata[k] = v
+			}
+			if rescanned {
+				record.Metadata["rescanned_empty"] = true
+			}
+			if isSelf {
+				record.Metadata["is_self"] = true
+			}
+			if opts.IncludeRaw && result.RawPath != "" {
+				record.Metadata["nmap_raw"] = result.Raw
+				record.Metadata["nmap_raw_path"] = result.RawPath
+			}
+			if host.TargetHostname != "" {
+				record.Hostname = host.TargetHostname
+				record.Metadata["target_hostname"] = host.TargetHostname
+			}
+			tagGateway(&record, gatewayIP, result.Vendor)
+			tagVLAN(&record, vlans)
+			applyHops(&record, result.Hops)
+			applyDeviceType(&record, result.Vendor)
+			applyOSHint(&record, opts.OSHints)
+			if opts.SSHFingerprint && hasOpenPort(record, 22) {
+				if fp := sshHostKeyFingerprint(ctx, ip); fp != "" {
+					record.Metadata["ssh_host_key_sha256"] = fp
+				} else {
+					fmt.Fprintf(logProgress, "Host %s: no SSH host key within %s; skipping fingerprint\n", ip, sshKeyscanTimeout)
+				}
+			}
+			if opts.ProbePort > 0 {
+				record.Metadata["probe_port"] = opts.ProbePort
+			}
+			v6.claim(&record)
+			if !keep(record) {
+				return
+			}
 			if db != nil {
-				if err := upsertHost(db, record); err != nil {
-					fmt.Fprintf(logProgress, "❌ Update failed for %s on interface %s: %v\n", ip, host.InterfaceName, err)
+				var err error
+				if opts.ProbePort > 0 {
+					err = writer.upsertPresence(record, opts.Remote.Config.AgentID)
+				} else {
+					err = writer.upsertHost(&record, opts.NamePolicy, opts.Remote.Config.AgentID)
+				}
+				if err != nil {
+					fmt.Fprintf(logProgress, utils.Deco("❌ Update failed for %s on interface %s: %v\n"), ip, host.InterfaceName, err)
 				}
 			}
+			opts.Incremental.store(record)
 			batchMu.Lock()
 			remoteBatch = append(remoteBatch, record)
+			stream.add(record)
 			batchMu.Unlock()
 			fmt.Fprintf(logProgress, "Host %s scanned in %s\n", ip, time.Since(hostStart))
 		}(idx, host)
 	}
 	wg.Wait()
+	for _, record := range v6.unclaimed(runID) {
+		signals := gatherLiveness(record.IP, utils.PingHost(pingTarget(record.IP, record.InterfaceName)), nil, neighbors)
+		record.OnlineStatus = signals.status(opts.Liveness)
+		record.Metadata["liveness_signals"] = signals.names()
+		tagVLAN(&record, vlans)
+		applyOSHint(&record, opts.OSHints)
+		if !keep(record) {
+			continue
+		}
+		if db != nil {
+			if err := writer.upsertHost(&record, opts.NamePolicy, opts.Remote.Config.AgentID); err != nil {
+				fmt.Fprintf(logProgress, utils.Deco("❌ Update failed for %s on interface %s: %v\n"), record.IP, record.InterfaceName, err)
+			}
+		}
+		remoteBatch = append(remoteBatch, record)
+		stream.add(record)
+	}
+	sortHosts(remoteBatch)
+	remoteBatch, collapsed := collapseSameHost(remoteBatch)
+	for _, record := range collapsed {
+		fmt.Fprintf(logProgress, "Host %s was scanned more than once (on %s); reporting it once with the union of its ports\n", record.IP, strings.Join(record.Metadata["seen_interfaces"].([]string), ", "))
+		stream.add(record)
+	}
+	nmapRuns.apply(runMeta)
+	if n := flagMACConflicts(remoteBatch, func(format string, args ...any) {
+		fmt.Fprintf(logProgress, format+"\n", args...)
+	}); n > 0 {
+		runMeta["mac_conflicts"] = n
+		// Conflicts only show once every host is in, so the hosts involved
+		// may already have been streamed; send them again.
+		for _, record := range remoteBatch {
+			if record.Metadata["mac_conflict"] == true {
+				stream.add(record)
+			}
+		}
+	}
+	if opts.Incremental != nil {
+		fmt.Fprintf(logProgress, "[deepscan] incremental: %d hosts reused, %d scanned\n", reused, len(remoteBatch)-reused)
+		runMeta["incremental"] = map[string]any{"reused": reused, "scanned": len(remoteBatch) - reused}
+	}
+
+	interrupted := parent.Err() != nil
+	partial := skipped > 0 || interrupted
+	if partial {
+		if interrupted {
+			fmt.Fprintf(logProgress, utils.Deco("⚠️ Scan interrupted; %d of %d hosts skipped, reporting the %d that finished\n"), skipped, total, len(remoteBatch))
+			runMeta["interrupted"] = true
+		} else {
+			fmt.Fprintf(logProgress, utils.Deco("⚠️ Scan budget of %s exceeded; %d of %d hosts skipped, reporting the %d that finished\n"), opts.MaxDuration, skipped, total, len(remoteBatch))
+		}
+		runMeta["partial"] = true
+		runMeta["skipped_hosts"] = skipped
+	}
+	if len(opts.OnlyServices) > 0 {
+		fmt.Fprintf(logProgress, "--only-service %s kept %d of %d hosts\n", strings.Join(opts.OnlyServices, ","), len(remoteBatch), len(remoteBatch)+filtered)
+		runMeta["only_service"] = map[string]any{"services": opts.OnlyServices, "dropped": filtered}
+	}
+	if errored > 0 {
+		fmt.Fprintf(logProgress, utils.Deco("⚠️ %d hosts failed with an internal error; see scan_error in their metadata\n"), errored)
+		runMeta["errored_hosts"] = errored
+	}
+
+	// A partial run says nothing about the hosts it skipped, so leave their
+	// online state alone. The same goes for hosts --only-service dropped,
+	// which were seen but not re-recorded.
+	var removed []string
+	if db != nil && !partial && len(opts.OnlyServices) == 0 {
+		cutoff := startTime.Truncate(time.Second).Add(-opts.OfflineTTL)
+		var ips []string
+		err := writer.do(func(tx *sql.Tx) (err error) {
+			ips, err = markStaleOffline(tx, discoveredOn, cutoff)
+			return err
+		})
+		if err != nil {
+			fmt.Fprintf(logProgress, "Failed to mark hosts as offline: %v\n", err)
+		} else {
+			if len(ips) > 0 {
+				fmt.Fprintf(logProgress, "Marked %d hosts offline (not seen since %s)\n", len(ips), cutoff.Format(time.RFC3339))
+			}
+			removed = append([]string{}, ips...)
+			sort.Slice(removed, func(i, j int) bool { return utils.CompareIP(removed[i], removed[j]) < 0 })
+		}
+	}
+
+	if db != nil {
+		run := scanRun{ID: runID, Scanner: scanner, StartedAt: startTime, FinishedAt: time.Now(), HostCount: len(remoteBatch), Partial: partial, Nmap: nmapRuns}
+		if err := writer.do(func(tx *sql.Tx) error { return recordScanRun(tx, run) }); err != nil {
+			fmt.Fprintf(logProgress, "Failed to record scan run: %v\n", err)
+		}
+	}
 
 	fmt.Fprintf(logProgress, "Deep scan complete in %s\n", time.Since(startTime))
-	if err := emitHosts(remoteBatch, opts.Remote); err != nil {
+	stats := computeSubnetStats(interfaces, remoteBatch)
+	for _, s := range stats {
+		fmt.Fprintln(logProgress, s)
+	}
+	groups := groupHosts(interfaces, remoteBatch)
+	for _, g := range groups {
+		fmt.Fprintln(logProgress, g)
+	}
+	health := computeHealthScore(remoteBatch, skipped)
+	fmt.Fprintf(logProgress, "Health score: %.1f (reachable %.2f, no risky ports %.2f, OS known %.2f, complete %.2f)\n",
+		health.Score, health.Reachable, health.NoRiskyPorts, health.OSKnown, health.Completeness)
+	if runDir != "" || opts.SummaryJSON != "" {
+		summary := newRunSummary(remoteBatch, skipped, partial, startTime, time.Now(), nmapRuns)
+		summary.Scanner = scanner
+		summary.RunID = runID
+		summary.Version = ScannerVersion
+		summary.Profile = opts.Profile
+		summary.SubnetStats = stats
+		summary.Groups = groups
+		summary.Health = &health
+		summary.RemovedHosts = removed
+		if db != nil {
+			// first_seen is stored with second precision.
+			since := startTime.Truncate(time.Second)
+			summary.NewHosts = []string{}
+			for _, h := range remoteBatch {
+				if !h.FirstSeen.IsZero() && !h.FirstSeen.Before(since) {
+					summary.NewHosts = append(summary.NewHosts, h.IP)
+				}
+			}
+		}
+		var paths []string
+		if runDir != "" {
+			paths = append(paths, filepath.Join(runDir, "summary.json"))
+		}
+		if opts.SummaryJSON != "" {
+			paths = append(paths, opts.SummaryJSON)
+		}
+		for _, path := range paths {
+			if err := summary.write(path); err != nil {
+				fmt.Fprintf(logProgress, utils.Deco("⚠️ Failed to write run summary to %s: %v\n"), path, err)
+			}
+		}
+	}
+	runMeta["subnet_stats"] = stats
+	runMeta["groups"] = groups
+	runMeta["health_score"] = health
+	// The results only count as kept if the DB opened; under --skip-db only
+	// the history archive can keep them (see emit).
+	persisted := db != nil
+	if stream != nil {
+		// The controller already has most hosts; the other sinks still get
+		// the complete payload.
+		local := opts.Remote
+		local.Config = RemoteConfig{}
+		localErr := emitHosts(remoteBatch, runMeta, local)
+		if err := opts.Remote.tolerate(stream.finish(runMeta, opts.Remote.EmitEmpty), persisted); err != nil {
+			return err
+		}
+		if localErr != nil {
+			return localErr
+		}
+	} else if err := opts.Remote.tolerate(emitHosts(remoteBatch, runMeta, opts.Remote), persisted); err != nil {
 		return err
 	}
+	if interrupted {
+		return fmt.Errorf("%w: interrupted, %d of %d hosts skipped", ErrPartialScan, skipped, total)
+	}
+	if partial {
+		return fmt.Errorf("%w: budget of %s exceeded, %d of %d hosts skipped", ErrPartialScan, opts.MaxDuration, skipped, total)
+	}
 	return nil
 }
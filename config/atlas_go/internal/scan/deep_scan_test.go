@@ -1,9 +1,22 @@
 package scan
 
-import "testing"
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"atlas/internal/utils"
+)
 
 func TestParseNmapPortsIncludesOpenVariants(t *testing.T) {
-	details := parseNmapPorts("53/open|filtered/tcp//domain///, 123/open/udp//ntp///, 161/filtered/udp//snmp///")
+	details := parseNmapPorts("53/open|filtered/tcp//domain///, 123/open/udp//ntp///, 161/filtered/udp//snmp///", false)
 	if len(details.Ports) != 3 {
 		t.Fatalf("expected 3 ports, got %d", len(details.Ports))
 	}
@@ -18,11 +31,253 @@ func TestParseNmapPortsIncludesOpenVariants(t *testing.T) {
 }
 
 func TestParseNmapPortsIncludesUnfiltered(t *testing.T) {
-	details := parseNmapPorts("80/unfiltered/tcp//http///, 443/closed/tcp//https///")
+	details := parseNmapPorts("80/unfiltered/tcp//http///, 443/closed/tcp//https///", false)
 	if len(details.Ports) != 1 {
 		t.Fatalf("expected 1 port, got %d", len(details.Ports))
 	}
 	if details.Ports[0].Port != 80 || details.Ports[0].State != "unfiltered" {
 		t.Fatalf("unexpected port entry: %+v", details.Ports[0])
 	}
+	if all := parseNmapPorts("80/unfiltered/tcp//http///, 443/closed/tcp//https///", true); len(all.Ports) != 2 || all.Ports[1].State != "closed" {
+		t.Fatalf("allStates dropped the closed port: %+v", all.Ports)
+	}
+}
+
+func TestParseNmapPortsKeepsVersion(t *testing.T) {
+	details := parseNmapPorts("22/open/tcp//ssh//OpenSSH 8.9p1 Ubuntu 3ubuntu0.1 (Ubuntu Linux; protocol 2.0)/, "+
+		"80/open/tcp//http//Apache httpd 2.4.41 ((Ubuntu)), mod_ssl/2.4/, 443/open/tcp//https///", false)
+	want := []RemotePort{
+		{Port: 22, Protocol: "tcp", Service: "ssh", State: "open", Version: "OpenSSH 8.9p1 Ubuntu 3ubuntu0.1 (Ubuntu Linux; protocol 2.0)"},
+		{Port: 80, Protocol: "tcp", Service: "http", State: "open", Version: "Apache httpd 2.4.41 ((Ubuntu)), mod_ssl/2.4"},
+		{Port: 443, Protocol: "tcp", Service: "https", State: "open"},
+	}
+	if len(details.Ports) != len(want) {
+		t.Fatalf("expected %d ports, got %+v", len(want), details.Ports)
+	}
+	for i := range want {
+		if details.Ports[i] != want[i] {
+			t.Errorf("port %d: want %+v got %+v", i, want[i], details.Ports[i])
+		}
+	}
+}
+
+func TestParseNmapPortsShortAndMalformedEntries(t *testing.T) {
+	details := parseNmapPorts("8080/open/tcp, 22/open, x/open/tcp//ssh///, /open/tcp//, 53/open/udp//domain", false)
+	if len(details.Ports) != 2 {
+		t.Fatalf("expected 2 ports, got %+v", details.Ports)
+	}
+	if details.Ports[0] != (RemotePort{Port: 8080, Protocol: "tcp", State: "open"}) {
+		t.Errorf("unexpected short entry %+v", details.Ports[0])
+	}
+	if details.Ports[1] != (RemotePort{Port: 53, Protocol: "udp", Service: "domain", State: "open"}) {
+		t.Errorf("unexpected entry without trailing fields %+v", details.Ports[1])
+	}
+	if details.Summary != "8080/tcp, 53/udp (domain)" {
+		t.Errorf("unexpected summary %q", details.Summary)
+	}
+}
+
+// fakeRunner stands in for utils.ExecRunner. The handler receives the command
+// name and args and returns canned output.
+type fakeRunner struct {
+	handler func(name string, args []string) ([]byte, error)
+}
+
+func (f fakeRunner) Run(_ context.Context, name string, args ...string) ([]byte, error) {
+	return f.handler(name, args)
+}
+
+// useFakeRunner installs handler as the process-wide runner and redirects
+// per-host logs to a temp directory for the duration of the test.
+func useFakeRunner(t *testing.T, handler func(name string, args []string) ([]byte, error)) {
+	t.Helper()
+	prevRunner, prevLogDir := utils.Runner, logDir
+	utils.Runner = fakeRunner{handler: handler}
+	logDir = t.TempDir()
+	t.Cleanup(func() {
+		utils.Runner = prevRunner
+		logDir = prevLogDir
+	})
+}
+
+// writeGrepable emulates nmap's -oG flag by writing content to the path that
+// follows it in args.
+func writeGrepable(t *testing.T, args []string, content string) {
+	t.Helper()
+	for i, a := range args {
+		if a == "-oG" && i+1 < len(args) {
+			if err := os.WriteFile(args[i+1], []byte(content), 0o644); err != nil {
+				t.Fatalf("write fixture: %v", err)
+			}
+			return
+		}
+	}
+	t.Fatalf("nmap args missing -oG: %v", args)
+}
+
+const grepableFixture = "# Nmap 7.94 scan initiated as: nmap -O -Pn --top-ports 200 -T4 192.168.1.10\n" +
+	"Host: 192.168.1.10 (nas.lan)\tStatus: Up\n" +
+	"Host: 192.168.1.10 (nas.lan)\tPorts: 22/open/tcp//ssh///, 80/open/tcp//http///, 443/filtered/tcp//https///\tIgnored State: closed (197)\tOS: Linux 5.4 - 5.15\tSeq Index: 260\n" +
+	"# Nmap done at Mon Jan  1 00:00:05 2024 -- 1 IP address (1 host up) scanned in 5.00 seconds\n"
+
+func TestScanAllTcpParsesGrepableOutput(t *testing.T) {
+	useFakeRunner(t, func(name string, args []string) ([]byte, error) {
+		if name != "nmap" {
+			t.Fatalf("unexpected command %s", name)
+		}
+		writeGrepable(t, args, grepableFixture)
+		return []byte("Nmap done\n"), nil
+	})
+
+	result := scanAllTcp(context.Background(), "192.168.1.10", scanProfiles["standard"], logDir, io.Discard)
+	ports, osInfo := result.Ports, result.OS
+	if len(ports.Ports) != 3 {
+		t.Fatalf("expected 3 ports, got %+v", ports.Ports)
+	}
+	if ports.Summary != "22/tcp (ssh), 80/tcp (http), 443/tcp (https)" {
+		t.Errorf("unexpected summary %q", ports.Summary)
+	}
+	if osInfo != "Linux 5.4 - 5.15" {
+		t.Errorf("unexpected OS %q", osInfo)
+	}
+	if want := (nmapStats{Runs: 1, Addresses: 1, HostsUp: 1, Elapsed: 5}); result.Nmap != want {
+		t.Errorf("nmap stats: want %+v got %+v", want, result.Nmap)
+	}
+	if result.Raw != grepableFixture || !strings.HasSuffix(result.RawPath, "nmap_tcp_192_168_1_10.log") {
+		t.Errorf("raw output not kept: %q from %q", result.Raw, result.RawPath)
+	}
+	if capped := capRawOutput(strings.Repeat("x", maxRawOutput+10)); !strings.HasSuffix(capped, "[10 more bytes truncated]\n") {
+		t.Errorf("oversized raw output not capped: ...%q", capped[len(capped)-40:])
+	}
+}
+
+func TestScanAllTcpCommandFailure(t *testing.T) {
+	useFakeRunner(t, func(name string, args []string) ([]byte, error) {
+		return nil, errors.New("exit status 1")
+	})
+
+	result := scanAllTcp(context.Background(), "192.168.1.10", scanProfiles["standard"], logDir, io.Discard)
+	if result.Ports.Summary != "Unknown" || result.OS != "Unknown" {
+		t.Fatalf("expected Unknown results, got %q / %q", result.Ports.Summary, result.OS)
+	}
+}
+
+func TestScanAllTcpParsesPartialOutputOnFailure(t *testing.T) {
+	useFakeRunner(t, func(name string, args []string) ([]byte, error) {
+		writeGrepable(t, args, grepableFixture)
+		return nil, &utils.CommandError{Name: "nmap", Err: errors.New("exit status 1"), Stderr: "TCP/IP fingerprinting (for OS scan) requires root privileges."}
+	})
+
+	var log strings.Builder
+	result := scanAllTcp(context.Background(), "192.168.1.10", scanProfiles["standard"], logDir, &log)
+	if len(result.Ports.Ports) != 3 {
+		t.Fatalf("expected partial output to be parsed, got %+v", result.Ports)
+	}
+	if !strings.Contains(log.String(), "lacked privileges") {
+		t.Fatalf("expected a privilege hint in the log, got %q", log.String())
+	}
+}
+
+func TestDeepScanOnlyServiceDropsOtherHosts(t *testing.T) {
+	useFakeRunner(t, func(name string, args []string) ([]byte, error) {
+		return nil, fmt.Errorf("%s ran outside the replay", name)
+	})
+	capture := filepath.Join(t.TempDir(), "capture.gnmap")
+	if err := os.WriteFile(capture, []byte(grepableFixture), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		services string
+		want     int
+	}{{"ftp,smtp", 0}, {"SMB, ssh", 1}} {
+		opts := DeepScanOptions{SkipDB: true, Replay: capture, TCP: scanProfiles["standard"], OnlyServices: ParseServiceList(tc.services)}
+		opts.Remote.JSONOutPath = filepath.Join(t.TempDir(), "payload.json")
+		opts.Remote.EmitEmpty = true
+		if err := DeepScan(opts); err != nil {
+			t.Fatalf("DeepScan: %v", err)
+		}
+		b, err := os.ReadFile(opts.Remote.JSONOutPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var payload RemotePayload
+		if err := json.Unmarshal(b, &payload); err != nil {
+			t.Fatal(err)
+		}
+		if len(payload.Hosts) != tc.want {
+			t.Errorf("--only-service %s: want %d hosts, got %+v", tc.services, tc.want, payload.Hosts)
+		}
+		filter, _ := payload.Metadata["only_service"].(map[string]any)
+		if filter["dropped"] != float64(1-tc.want) {
+			t.Errorf("--only-service %s: metadata %v", tc.services, payload.Metadata["only_service"])
+		}
+	}
+}
+
+func TestDeepScanStopsWhenContextIsCancelled(t *testing.T) {
+	useFakeRunner(t, func(name string, args []string) ([]byte, error) {
+		return nil, fmt.Errorf("%s ran outside the replay", name)
+	})
+	capture := filepath.Join(t.TempDir(), "capture.gnmap")
+	if err := os.WriteFile(capture, []byte(grepableFixture), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	opts := DeepScanOptions{SkipDB: true, Replay: capture, TCP: scanProfiles["standard"], Context: ctx}
+	opts.Remote.JSONOutPath = filepath.Join(t.TempDir(), "payload.json")
+	opts.Remote.EmitEmpty = true
+	err := DeepScan(opts)
+	if !errors.Is(err, ErrPartialScan) || !strings.Contains(err.Error(), "interrupted") {
+		t.Fatalf("expected an interrupted partial scan, got %v", err)
+	}
+	b, err := os.ReadFile(opts.Remote.JSONOutPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var payload RemotePayload
+	if err := json.Unmarshal(b, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if len(payload.Hosts) != 0 || payload.Metadata["interrupted"] != true || payload.Metadata["skipped_hosts"] != float64(1) {
+		t.Fatalf("unexpected payload %s", b)
+	}
+}
+
+func TestDiscoverLiveHostsParsesPingScan(t *testing.T) {
+	useFakeRunner(t, func(name string, args []string) ([]byte, error) {
+		return []byte("Starting Nmap 7.94\n" +
+			"Nmap scan report for router.lan (192.168.1.1)\n" +
+			"Host is up (0.0010s latency).\n" +
+			"Nmap scan report for 192.168.1.20\n" +
+			"Host is up (0.0020s latency).\n" +
+			"Nmap done: 256 IP addresses (2 hosts up) scanned in 2.10 seconds\n"), nil
+	})
+
+	hosts, stats, err := discoverLiveHosts(context.Background(), "192.168.1.0/24")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := (nmapStats{Runs: 1, Addresses: 256, HostsUp: 2, Elapsed: 2.10}); stats != want {
+		t.Errorf("stats: want %+v got %+v", want, stats)
+	}
+	want := []HostInfo{{IP: "192.168.1.1", Name: "router.lan"}, {IP: "192.168.1.20", Name: "NoName"}}
+	if len(hosts) != len(want) {
+		t.Fatalf("expected %d hosts, got %+v", len(want), hosts)
+	}
+	for i := range want {
+		if hosts[i] != want[i] {
+			t.Errorf("host %d: want %+v got %+v", i, want[i], hosts[i])
+		}
+	}
+}
+
+func TestDiscoverLiveHostsMissingNmap(t *testing.T) {
+	useFakeRunner(t, func(name string, args []string) ([]byte, error) {
+		return nil, exec.ErrNotFound
+	})
+
+	if _, _, err := discoverLiveHosts(context.Background(), "192.168.1.0/24"); !errors.Is(err, ErrNmapNotFound) {
+		t.Fatalf("expected ErrNmapNotFound, got %v", err)
+	}
 }
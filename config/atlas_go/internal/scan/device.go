@@ -0,0 +1,159 @@
+package scan
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Device types written to Metadata["device_type"].
+const (
+	DeviceRouter  = "router"
+	DevicePrinter = "printer"
+	DevicePhone   = "phone"
+	DeviceIoT     = "iot"
+	DeviceVirtual = "virtual"
+)
+
+var deviceTypes = []string{DeviceRouter, DevicePrinter, DevicePhone, DeviceIoT, DeviceVirtual}
+
+// ouiVendors covers prefixes whose vendor alone says a lot about the device,
+// for when nmap did not report a vendor (unprivileged runs, routed hosts).
+var ouiVendors = map[string]string{
+	"00:50:56": "VMware",
+	"00:0C:29": "VMware",
+	"00:05:69": "VMware",
+	"08:00:27": "PCS Systemtechnik (VirtualBox)",
+	"52:54:00": "QEMU",
+	"00:15:5D": "Microsoft (Hyper-V)",
+	"00:16:3E": "Xen",
+	"00:1C:42": "Parallels",
+	"B8:27:EB": "Raspberry Pi Foundation",
+	"DC:A6:32": "Raspberry Pi Trading",
+	"24:0A:C4": "Espressif",
+	"30:AE:A4": "Espressif",
+	"00:0E:58": "Sonos",
+	"00:17:88": "Philips Lighting",
+	"18:B4:30": "Nest Labs",
+	"24:A4:3C": "Ubiquiti Networks",
+	"F0:9F:C2": "Ubiquiti Networks",
+	"4C:5E:0C": "Routerboard.com (MikroTik)",
+	"00:80:77": "Brother Industries",
+	"00:00:48": "Seiko Epson",
+	"00:00:AA": "Xerox",
+}
+
+// reMACVendor matches nmap's "MAC Address: AA:BB:CC:DD:EE:FF (Vendor)" line.
+var reMACVendor = regexp.MustCompile(`MAC Address: [0-9A-Fa-f:]{17} \(([^)]+)\)`)
+
+// parseMACVendor returns the vendor nmap printed for the host, if any.
+func parseMACVendor(out string) string {
+	m := reMACVendor.FindStringSubmatch(out)
+	if m == nil || m[1] == "Unknown" {
+		return ""
+	}
+	return m[1]
+}
+
+// macVendor prefers the vendor nmap reported and falls back to ouiVendors.
+func macVendor(mac, nmapVendor string) string {
+	if nmapVendor != "" {
+		return nmapVendor
+	}
+	if len(mac) < 8 {
+		return ""
+	}
+	return ouiVendors[strings.ToUpper(mac[:8])]
+}
+
+// deviceRule maps a vendor and/or open port to a device type. A rule with
+// both vendors and ports needs a match on each.
+type deviceRule struct {
+	deviceType string
+	vendors    []string
+	ports      []int
+}
+
+// deviceRules are checked in order; the first match wins.
+var deviceRules = []deviceRule{
+	{DeviceVirtual, []string{"vmware", "virtualbox", "qemu", "hyper-v", "xen", "parallels"}, nil},
+	{DevicePrinter, nil, []int{9100}},
+	{DevicePrinter, []string{"hp", "hewlett", "canon", "ricoh", "kyocera", "lexmark"}, []int{515, 631}},
+	{DevicePrinter, []string{"brother", "epson", "xerox"}, nil},
+	{DeviceRouter, []string{"ubiquiti", "mikrotik", "routerboard", "netgear", "tp-link", "juniper", "cisco", "aruba"}, nil},
+	{DeviceIoT, []string{"espressif", "raspberry pi", "sonos", "philips", "nest", "tuya", "roku", "amazon"}, nil},
+	{DevicePhone, []string{"apple"}, []int{62078}},
+	{DevicePhone, []string{"samsung", "xiaomi", "oneplus", "huawei", "google"}, nil},
+}
+
+func (r deviceRule) matches(vendor string, open map[int]bool) bool {
+	if len(r.vendors) > 0 && !containsAny(vendor, r.vendors) {
+		return false
+	}
+	if len(r.ports) > 0 {
+		for _, p := range r.ports {
+			if open[p] {
+				return true
+			}
+		}
+		return false
+	}
+	return len(r.vendors) > 0
+}
+
+func containsAny(s string, words []string) bool {
+	for _, w := range words {
+		if strings.Contains(s, w) {
+			return true
+		}
+	}
+	return false
+}
+
+// classifyDevice guesses a coarse device type from the MAC vendor and open
+// ports, or returns "" when nothing matches. It is a heuristic: a device type
+// among the host's tags always wins, and the default gateway is a router.
+func classifyDevice(vendor string, ports []RemotePort, tags []string) string {
+	for _, tag := range tags {
+		for _, t := range deviceTypes {
+			if tag == t {
+				return t
+			}
+		}
+	}
+	open := make(map[int]bool, len(ports))
+	for _, p := range ports {
+		if p.Protocol == "tcp" && p.State == "open" {
+			open[p.Port] = true
+		}
+	}
+	vendor = strings.ToLower(vendor)
+	for _, r := range deviceRules {
+		if r.matches(vendor, open) {
+			return r.deviceType
+		}
+	}
+	for _, tag := range tags {
+		if tag == "gateway" {
+			return DeviceRouter
+		}
+	}
+	return ""
+}
+
+// applyDeviceType records the host's vendor and inferred device type.
+func applyDeviceType(record *HostRecord, nmapVendor string) {
+	vendor := macVendor(record.MAC, nmapVendor)
+	deviceType := classifyDevice(vendor, record.Ports, record.Tags)
+	if vendor == "" && deviceType == "" {
+		return
+	}
+	if record.Metadata == nil {
+		record.Metadata = map[string]any{}
+	}
+	if vendor != "" {
+		record.Metadata["mac_vendor"] = vendor
+	}
+	if deviceType != "" {
+		record.Metadata["device_type"] = deviceType
+	}
+}
@@ -0,0 +1,46 @@
+package scan
+
+import "testing"
+
+func TestClassifyDevice(t *testing.T) {
+	tcp := func(ports ...int) []RemotePort {
+		var out []RemotePort
+		for _, p := range ports {
+			out = append(out, RemotePort{Port: p, Protocol: "tcp", State: "open"})
+		}
+		return out
+	}
+	cases := []struct {
+		name   string
+		vendor string
+		ports  []RemotePort
+		tags   []string
+		want   string
+	}{
+		{"hp jetdirect", "Hewlett Packard", tcp(80, 9100), nil, DevicePrinter},
+		{"hp ipp", "HP", tcp(631), nil, DevicePrinter},
+		{"hp laptop", "HP", tcp(22), nil, ""},
+		{"vmware guest", "VMware", tcp(22, 9100), nil, DeviceVirtual},
+		{"ubiquiti ap", "Ubiquiti Networks", tcp(22), nil, DeviceRouter},
+		{"iphone", "Apple", tcp(62078), nil, DevicePhone},
+		{"mac", "Apple", tcp(22), nil, ""},
+		{"esp32", "Espressif", nil, nil, DeviceIoT},
+		{"unknown gateway", "", tcp(53), []string{"gateway"}, DeviceRouter},
+		{"manual tag wins", "VMware", nil, []string{"printer"}, DevicePrinter},
+	}
+	for _, c := range cases {
+		if got := classifyDevice(c.vendor, c.ports, c.tags); got != c.want {
+			t.Errorf("%s: classifyDevice = %q, want %q", c.name, got, c.want)
+		}
+	}
+
+	out := "Nmap scan report for 192.168.1.20\nMAC Address: 00:0C:29:AA:BB:CC (VMware)\n"
+	if v := parseMACVendor(out); v != "VMware" {
+		t.Fatalf("parseMACVendor = %q", v)
+	}
+	record := HostRecord{MAC: "b8:27:eb:01:02:03"}
+	applyDeviceType(&record, "")
+	if record.Metadata["mac_vendor"] != "Raspberry Pi Foundation" || record.Metadata["device_type"] != DeviceIoT {
+		t.Fatalf("unexpected metadata %v", record.Metadata)
+	}
+}
@@ -0,0 +1,77 @@
+package scan
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"atlas/internal/utils"
+)
+
+// Discovery ping modes accepted by --discovery-ping.
+//
+//   - icmp (default): nmap's standard -sn host discovery. Fast, but hosts
+//     that drop ICMP and the default probes are reported down and never
+//     reach the port scan.
+//   - tcp-syn: -sn -PS22,80,443. Also finds ICMP-filtered hosts that expose
+//     common TCP services; slightly slower and noisier.
+//   - none: -sn -Pn. Treats every address in the CIDR as up so every one is
+//     port scanned. Most thorough, but much slower on sparse subnets.
+const (
+	DiscoveryPingICMP   = "icmp"
+	DiscoveryPingTCPSyn = "tcp-syn"
+	DiscoveryPingNone   = "none"
+)
+
+// discoveryPingArgs returns the extra nmap arguments for a discovery mode.
+func discoveryPingArgs(mode string) ([]string, error) {
+	switch mode {
+	case "", DiscoveryPingICMP:
+		return nil, nil
+	case DiscoveryPingTCPSyn:
+		return []string{"-PS22,80,443"}, nil
+	case DiscoveryPingNone:
+		return []string{"-Pn"}, nil
+	default:
+		return nil, fmt.Errorf("unknown discovery ping mode %q (valid: icmp, tcp-syn, none)", mode)
+	}
+}
+
+// escalationProbeArgs are the TCP discovery probes tried when the configured
+// sweep finds nothing. They cover the services most commonly left reachable
+// on networks that filter ICMP (SSH, web, SMB, RDP).
+var escalationProbeArgs = []string{"-PS21,22,23,25,80,135,139,443,445,3389,8080", "-PA80,443"}
+
+// discoverWithEscalation runs the discovery sweep for mode and, if it finds
+// no hosts, retries once with broader TCP probes before reporting the subnet
+// empty. extraArgs (e.g. "-n") apply to both sweeps, and stats sums both.
+func discoverWithEscalation(ctx context.Context, subnet, mode string, logf func(format string, args ...any), extraArgs ...string) ([]HostInfo, nmapStats, error) {
+	args, err := discoveryPingArgs(mode)
+	if err != nil {
+		return nil, nmapStats{}, err
+	}
+	hosts, stats, err := discoverLiveHosts(ctx, subnet, append(args, extraArgs...)...)
+	if err != nil || len(hosts) > 0 || mode == DiscoveryPingNone {
+		return hosts, stats, err
+	}
+
+	logf(utils.Deco("⚠️ No hosts answered %s discovery on %s; escalating to TCP discovery (%s)"), discoveryModeName(mode), subnet, strings.Join(escalationProbeArgs, " "))
+	hosts, escalated, err := discoverLiveHosts(ctx, subnet, append(append([]string{}, escalationProbeArgs...), extraArgs...)...)
+	stats.add(escalated)
+	if err != nil {
+		return nil, stats, err
+	}
+	if len(hosts) == 0 {
+		logf(utils.Deco("⚠️ No hosts found on %s even with TCP discovery. The subnet may be empty, or a firewall drops all probes; try --discovery-ping none to port scan every address."), subnet)
+	} else {
+		logf("TCP discovery found %d hosts on %s that the %s sweep missed", len(hosts), subnet, discoveryModeName(mode))
+	}
+	return hosts, stats, nil
+}
+
+func discoveryModeName(mode string) string {
+	if mode == "" {
+		return DiscoveryPingICMP
+	}
+	return mode
+}
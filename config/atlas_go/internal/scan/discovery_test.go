@@ -0,0 +1,32 @@
+package scan
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestDiscoveryEscalatesToTCPWhenEmpty(t *testing.T) {
+	var calls [][]string
+	useFakeRunner(t, func(name string, args []string) ([]byte, error) {
+		calls = append(calls, args)
+		if strings.HasPrefix(args[1], "-PS") {
+			return []byte("Nmap scan report for 10.1.0.5\nHost is up.\n"), nil
+		}
+		return []byte("Nmap done: 256 IP addresses (0 hosts up)\n"), nil
+	})
+
+	var logged []string
+	hosts, _, err := discoverWithEscalation(context.Background(), "10.1.0.0/24", DiscoveryPingICMP, func(format string, args ...any) {
+		logged = append(logged, format)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(calls) != 2 || len(hosts) != 1 || hosts[0].IP != "10.1.0.5" {
+		t.Fatalf("expected escalated sweep to find 10.1.0.5, got %+v after %v", hosts, calls)
+	}
+	if len(logged) == 0 || !strings.Contains(logged[0], "escalating") {
+		t.Errorf("escalation was not logged: %v", logged)
+	}
+}
@@ -1,15 +1,16 @@
 package scan
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
-	"os/exec"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	"atlas/internal/utils"
 	_ "github.com/mattn/go-sqlite3"
 )
 
@@ -32,7 +33,7 @@ type DockerContainer struct {
 }
 
 func runCmd(cmd string, args ...string) ([]byte, error) {
-	return exec.Command(cmd, args...).CombinedOutput()
+	return utils.RunCommand(context.Background(), cmd, args...)
 }
 
 func getDockerContainers() ([]string, error) {
@@ -238,9 +239,9 @@ func isDockerInternalGateway(gateway string) bool {
 }
 
 func updateDockerDB(containers []DockerContainer) error {
-	db, err := sql.Open("sqlite3", "/config/db/atlas.db")
+	db, err := sql.Open("sqlite3", dbPath)
 	if err != nil {
-		return err
+		return fmt.Errorf("%w: %w", ErrDatabase, err)
 	}
 	defer db.Close()
 
@@ -265,7 +266,7 @@ func updateDockerDB(containers []DockerContainer) error {
                 next_hop=excluded.next_hop,
                 last_seen=excluded.last_seen,
                 online_status=excluded.online_status
-        `, c.ID, c.IP, c.Name, c.OS, c.MAC, c.Ports, c.NextHop, c.NetName, time.Now().Format("2006-01-02 15:04:05"), onlineStatus)
+        `, c.ID, c.IP, c.Name, c.OS, c.MAC, c.Ports, c.NextHop, c.NetName, dbTime(time.Now()), onlineStatus)
 		if err != nil {
 			fmt.Printf("Insert/update failed for %s: %v\n", c.ID, err)
 		}
@@ -283,9 +284,11 @@ func updateDockerDB(containers []DockerContainer) error {
 }
 
 func DockerScan(opts DockerScanOptions) error {
+	start := time.Now()
+	runID := opts.Remote.runID(start)
 	ids, err := getDockerContainers()
 	if err != nil {
-		return err
+		return fmt.Errorf("%w: %v", ErrDockerUnavailable, err)
 	}
 
 	var allContainers []DockerContainer
@@ -301,7 +304,13 @@ func DockerScan(opts DockerScanOptions) error {
 	if err := updateDockerDB(allContainers); err != nil {
 		return err
 	}
-	return emitHosts(containersToHostRecords(allContainers), opts.Remote)
+	hosts := containersToHostRecords(allContainers)
+	tagRunID(hosts, runID)
+	run := scanRun{ID: runID, Scanner: "dockerscan", StartedAt: start, FinishedAt: time.Now(), HostCount: len(hosts)}
+	if err := saveScanRun(dbPath, run); err != nil {
+		fmt.Printf(utils.Deco("⚠️ Failed to record scan run: %v\n"), err)
+	}
+	return opts.Remote.tolerate(emitHosts(hosts, map[string]any{"run_id": runID}, opts.Remote), true)
 }
 
 func containersToHostRecords(containers []DockerContainer) []HostRecord {
@@ -0,0 +1,207 @@
+package scan
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"time"
+
+	"atlas/internal/utils"
+)
+
+// doctorCheck is one line of the `atlas doctor` report.
+type doctorCheck struct {
+	Name string
+	// Level is "ok", "warn", or "fail"; only failures make Doctor return
+	// an error.
+	Level  string
+	Detail string
+	Hint   string
+}
+
+// doctorTools lists the external binaries scanners shell out to; required
+// ones fail the report, the rest only degrade name resolution or scanners.
+var doctorTools = []struct {
+	name     string
+	required bool
+	hint     string
+}{
+	{"nmap", true, "install nmap (apt install nmap / apk add nmap); every scanner depends on it"},
+	{"ip", false, "install iproute2 for gateway and IPv6 neighbor detection"},
+	{"nbtscan", false, "install nbtscan for NetBIOS names, or pass --no-netbios"},
+	{"avahi-resolve-address", false, "install avahi-utils for mDNS names, or pass --no-mdns"},
+	{"ssh-keyscan", false, "install openssh-client for --ssh-fingerprint"},
+	{"docker", false, "only needed for dockerscan"},
+}
+
+// Doctor checks that the local environment can run scans and prints a
+// pass/fail report to w. rc is probed only when a controller is configured.
+func Doctor(w io.Writer, rc RemoteConfig) error {
+	var checks []doctorCheck
+	for _, tool := range doctorTools {
+		bin := utils.CommandPath(tool.name)
+		path, err := exec.LookPath(bin)
+		missing := "not found in PATH"
+		if bin != tool.name {
+			missing = bin + " is not executable"
+		}
+		switch {
+		case err == nil:
+			checks = append(checks, doctorCheck{Name: tool.name, Level: "ok", Detail: path})
+		case tool.required:
+			checks = append(checks, doctorCheck{Name: tool.name, Level: "fail", Detail: missing, Hint: tool.hint})
+		default:
+			checks = append(checks, doctorCheck{Name: tool.name, Level: "warn", Detail: missing, Hint: tool.hint})
+		}
+	}
+	switch p := currentPrivileges(); {
+	case p.RawSockets && p.Root:
+		checks = append(checks, doctorCheck{Name: "privileges", Level: "ok", Detail: "running as root"})
+	case p.RawSockets:
+		checks = append(checks, doctorCheck{Name: "privileges", Level: "ok", Detail: "CAP_NET_RAW without root; nmap runs with --privileged"})
+	case p.Root:
+		checks = append(checks, doctorCheck{Name: "privileges", Level: "warn", Detail: "root without CAP_NET_RAW; OS detection, SYN scans, and traceroute are disabled",
+			Hint: "start the container with --cap-add NET_RAW --cap-add NET_ADMIN"})
+	default:
+		checks = append(checks, doctorCheck{Name: "privileges", Level: "warn", Detail: "not root; OS detection, SYN scans, and traceroute are disabled",
+			Hint: "run as root, grant atlas ambient CAP_NET_RAW/CAP_NET_ADMIN, or setcap nmap and set NMAP_PRIVILEGED=1"})
+	}
+	checks = append(checks, checkInterfaces(), checkLogDir(), checkDB(dbPath))
+	if rc.ControllerURL != "" {
+		checks = append(checks, checkController(rc))
+	}
+
+	failed := 0
+	for _, c := range checks {
+		icon := utils.Deco("✅")
+		switch c.Level {
+		case "warn":
+			icon = utils.Deco("⚠️")
+		case "fail":
+			icon = utils.Deco("❌")
+			failed++
+		}
+		fmt.Fprintf(w, "%s %-22s %s\n", icon, c.Name, c.Detail)
+		if c.Hint != "" && c.Level != "ok" {
+			fmt.Fprintf(w, utils.Deco("   ↳ %s\n"), c.Hint)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d check(s) failed", failed)
+	}
+	return nil
+}
+
+func checkInterfaces() doctorCheck {
+	c := doctorCheck{Name: "interfaces"}
+	interfaces, err := utils.GetAllInterfaces()
+	if err != nil {
+		c.Level, c.Detail, c.Hint = "fail", err.Error(), "run with host networking (docker run --network host) so LAN interfaces are visible"
+		return c
+	}
+	usable := sanitizeInterfaces(interfaces, utils.DefaultMinPrefixLen, nil, func(string, ...any) {})
+	if len(usable) == 0 {
+		c.Level, c.Detail, c.Hint = "fail", fmt.Sprintf("%d detected, none scannable", len(interfaces)), "check --min-prefix and that the host has an IPv4 LAN address"
+		return c
+	}
+	c.Level = "ok"
+	for i, iface := range usable {
+		if i > 0 {
+			c.Detail += ", "
+		}
+		c.Detail += iface.Name + " " + iface.Subnet
+	}
+	return c
+}
+
+func checkLogDir() doctorCheck {
+	c := doctorCheck{Name: "log dir", Detail: logDir}
+	if err := os.MkdirAll(logDir, 0o755); err == nil {
+		if f, err := os.CreateTemp(logDir, ".doctor-*"); err == nil {
+			f.Close()
+			os.Remove(f.Name())
+			c.Level = "ok"
+			return c
+		}
+	}
+	c.Level, c.Hint = "fail", "mount a writable volume at "+logDir
+	c.Detail += " is not writable"
+	return c
+}
+
+// checkDB opens the database and creates a table inside a rolled-back
+// transaction to prove it is writable without changing it. A missing file
+// is reported rather than opened, which would create it.
+func checkDB(path string) doctorCheck {
+	c := doctorCheck{Name: "database", Detail: path}
+	fail := func(err error) doctorCheck {
+		c.Level, c.Hint = "fail", "run `atlas initdb` and make sure the DB directory is writable, or use --skip-db"
+		c.Detail = fmt.Sprintf("%s: %v", path, err)
+		return c
+	}
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		c.Level, c.Detail, c.Hint = "warn", path+": missing", "run `atlas initdb`, or use --skip-db"
+		return c
+	} else if err != nil {
+		return fail(err)
+	}
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return fail(err)
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		return fail(err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("CREATE TABLE atlas_doctor_probe (id INTEGER)"); err != nil {
+		return fail(err)
+	}
+	var hosts int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM hosts").Scan(&hosts); err != nil {
+		c.Level, c.Detail, c.Hint = "warn", path+": writable but hosts table missing", "run `atlas initdb`"
+		return c
+	}
+	c.Level = "ok"
+	c.Detail = fmt.Sprintf("%s (writable, %d hosts)", path, hosts)
+	return c
+}
+
+// checkController sends a HEAD to the controller URL; any response below
+// 500 means it is reachable, even if the bare URL is not a valid endpoint.
+func checkController(rc RemoteConfig) doctorCheck {
+	c := doctorCheck{Name: "controller", Detail: rc.ControllerURL}
+	client := &http.Client{Timeout: 10 * time.Second}
+	if rc.HTTPClient != nil {
+		client = rc.HTTPClient
+	}
+	req, err := http.NewRequest(http.MethodHead, rc.ControllerURL, nil)
+	if err != nil {
+		c.Level, c.Detail = "fail", err.Error()
+		return c
+	}
+	rc.applyHeaders(req)
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		c.Level, c.Hint = "fail", "check --remote, DNS, proxies, and TLS certificates from this host"
+		c.Detail = fmt.Sprintf("%s: %v", rc.ControllerURL, err)
+		return c
+	}
+	resp.Body.Close()
+	c.Detail = fmt.Sprintf("%s (%s in %s)", rc.ControllerURL, resp.Status, time.Since(start).Round(time.Millisecond))
+	if resp.StatusCode >= 500 {
+		c.Level, c.Hint = "fail", "the controller is reachable but unhealthy; check its logs"
+		return c
+	}
+	c.Level = "ok"
+	if !rc.Enabled() {
+		c.Level, c.Hint = "warn", "--site and --agent are also required to ingest"
+	}
+	return c
+}
@@ -0,0 +1,24 @@
+package scan
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckDBReportsMissingFileWithoutCreatingIt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "atlas.db")
+	c := checkDB(path)
+	if c.Level != "warn" || !strings.HasSuffix(c.Detail, ": missing") {
+		t.Fatalf("missing database: %+v", c)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("doctor created %s (stat err %v)", path, err)
+	}
+
+	openTestDB(t)
+	if c := checkDB(dbPath); c.Level != "ok" || !strings.Contains(c.Detail, "0 hosts") {
+		t.Fatalf("initialized database: %+v", c)
+	}
+}
@@ -3,9 +3,14 @@ package scan
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"os"
 	"strings"
 	"time"
+
+	"atlas/internal/utils"
 )
 
 // ScannerVersion is embedded into remote payloads so the controller can
@@ -19,6 +24,8 @@ type RemotePort struct {
 	Protocol string `json:"protocol"`
 	Service  string `json:"service,omitempty"`
 	State    string `json:"state,omitempty"`
+	// Version is nmap's service version string (-sV), when detected.
+	Version string `json:"version,omitempty"`
 }
 
 // RemoteHostPayload matches the /ingest payload contract.
@@ -38,6 +45,7 @@ type RemoteHostPayload struct {
 type RemotePayload struct {
 	SiteName     string              `json:"site_name,omitempty"`
 	AgentVersion string              `json:"agent_version,omitempty"`
+	Metadata     map[string]any      `json:"metadata,omitempty"`
 	Hosts        []RemoteHostPayload `json:"hosts"`
 }
 
@@ -58,7 +66,10 @@ type HostRecord struct {
 	Note          string
 	Metadata      map[string]any
 	LastSeen      time.Time
-	OnlineStatus  string
+	// FirstSeen is read back from SQLite after persisting; zero when the
+	// record was not written to the local DB.
+	FirstSeen    time.Time
+	OnlineStatus string
 }
 
 // PortDetails bundles the slice of remote ports with the string summary we
@@ -68,7 +79,12 @@ type PortDetails struct {
 	Ports   []RemotePort
 }
 
-func (h HostRecord) metadataForPayload() map[string]any {
+// metadataForPayload merges the host's metadata with its record fields.
+// agentID names the agent emitting the payload, so the controller can tell
+// which agent reported the host. The first agent to report a host is not
+// sent: runs without SQLite, the agent's included, cannot know it, so the
+// controller derives it from the first agent_id it receives.
+func (h HostRecord) metadataForPayload(agentID string) map[string]any {
 	meta := map[string]any{}
 	if h.Metadata != nil {
 		for k, v := range h.Metadata {
@@ -84,6 +100,13 @@ func (h HostRecord) metadataForPayload() map[string]any {
 	if h.NextHop != "" {
 		meta["next_hop"] = h.NextHop
 	}
+	if !h.FirstSeen.IsZero() {
+		meta["first_seen"] = h.FirstSeen.UTC().Format(time.RFC3339)
+	}
+	if agentID != "" {
+		meta["agent_id"] = agentID
+	}
+	meta["fingerprint"] = hostFingerprint(h)
 	return meta
 }
 
@@ -112,9 +135,37 @@ func (h HostRecord) PortsSummary() string {
 	return strings.Join(readable, ", ")
 }
 
+// portsJSON returns the parsed ports as the JSON array stored in
+// open_ports_json, for json_each queries. It is nil (NULL) when the scan
+// parsed no ports, matching the "Unknown" summary.
+func (h HostRecord) portsJSON() (any, error) {
+	if len(h.Ports) == 0 {
+		return nil, nil
+	}
+	b, err := json.Marshal(h.Ports)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// ipv6JSON encodes the host's ipv6_addresses metadata for the
+// ipv6_addresses column, or returns nil when it has none.
+func (h HostRecord) ipv6JSON() (any, error) {
+	addrs, ok := h.Metadata["ipv6_addresses"]
+	if !ok {
+		return nil, nil
+	}
+	b, err := json.Marshal(addrs)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
 // ToRemoteHostPayload converts the HostRecord into the JSON-friendly shape the
-// FastAPI controller expects.
-func (h HostRecord) ToRemoteHostPayload() RemoteHostPayload {
+// FastAPI controller expects, as reported by agentID.
+func (h HostRecord) ToRemoteHostPayload(agentID string) RemoteHostPayload {
 	lastSeen := ""
 	if !h.LastSeen.IsZero() {
 		lastSeen = h.LastSeen.UTC().Format(time.RFC3339)
@@ -130,7 +181,7 @@ func (h HostRecord) ToRemoteHostPayload() RemoteHostPayload {
 		Metadata: nil,
 		Ports:    h.Ports,
 	}
-	meta := h.metadataForPayload()
+	meta := h.metadataForPayload(agentID)
 	if len(meta) > 0 {
 		payload.Metadata = meta
 	}
@@ -143,15 +194,16 @@ func (h HostRecord) ToRemoteHostPayload() RemoteHostPayload {
 	return payload
 }
 
-// BuildRemotePayload wraps host payloads with site metadata.
-func BuildRemotePayload(siteName, agentVersion string, hosts []HostRecord) RemotePayload {
+// BuildRemotePayload wraps host payloads with site metadata; agentID is
+// stamped on every host (see metadataForPayload).
+func BuildRemotePayload(siteName, agentVersion, agentID string, hosts []HostRecord) RemotePayload {
 	payload := RemotePayload{
 		SiteName:     siteName,
 		AgentVersion: agentVersion,
 	}
 	payload.Hosts = make([]RemoteHostPayload, 0, len(hosts))
 	for _, host := range hosts {
-		payload.Hosts = append(payload.Hosts, host.ToRemoteHostPayload())
+		payload.Hosts = append(payload.Hosts, host.ToRemoteHostPayload(agentID))
 	}
 	return payload
 }
@@ -160,37 +212,157 @@ func BuildRemotePayload(siteName, agentVersion string, hosts []HostRecord) Remot
 // to the configured controller endpoint.
 type RemotePayloadOptions struct {
 	PrintJSON bool
-	Config    RemoteConfig
+	// JSONOutPath, when set, also writes the indented payload to this file.
+	JSONOutPath string
+	// SchemaPath, when set, validates the payload against this JSON Schema
+	// before it is printed or posted; violations abort the emit.
+	SchemaPath string
+	// NmapXMLPath, when set, also writes the hosts as an nmap -oX style
+	// document for tools that import nmap XML.
+	NmapXMLPath string
+	// PromTextfile, when set (--prom-textfile), replaces this file with the
+	// run's metrics for node_exporter's textfile collector after each scan.
+	PromTextfile string
+	// Table prints the hosts as an aligned text table; Wide disables
+	// truncation of the Ports column.
+	Table bool
+	Wide  bool
+	// Labels (--label key=value) are added to every host's metadata.
+	Labels map[string]string
+	// EmitEmpty sends a payload with an empty hosts array when a scan finds
+	// nothing, so the controller can tell an empty site from a dead agent.
+	EmitEmpty bool
+	// RunID overrides the generated run ID (see newRunID) that tags the
+	// payload, every host, and the scan_runs row.
+	RunID string
+	// MACSalt, when set (--hash-macs), replaces every MAC in the payload with
+	// hashMAC(mac, MACSalt).
+	MACSalt string
+	// History, when its Path is set (--output jsonl-gzip), appends every
+	// emitted payload to a gzip JSONL archive.
+	History HistoryOptions
+	// Redact (--redact) lists fields blanked in the --json and JSONOutPath
+	// output only; see redactPayload.
+	Redact []string
+	// BestEffort (--best-effort) logs a failed controller delivery instead of
+	// failing the run, provided local persistence worked; see tolerate.
+	BestEffort bool
+	Config     RemoteConfig
 }
 
 func (o RemotePayloadOptions) shouldEmit() bool {
-	return o.PrintJSON || o.Config.Enabled()
+	return o.PrintJSON || o.JSONOutPath != "" || o.SchemaPath != "" || o.NmapXMLPath != "" || o.PromTextfile != "" || o.Table || o.History.Path != "" || o.Config.Enabled()
 }
 
 func (o RemotePayloadOptions) emit(payload RemotePayload) error {
 	if !o.shouldEmit() {
 		return nil
 	}
-	if o.PrintJSON {
-		b, err := json.MarshalIndent(payload, "", "  ")
+	if o.SchemaPath != "" {
+		if err := validatePayloadSchema(payload, o.SchemaPath); err != nil {
+			return err
+		}
+		fmt.Printf("[remote] payload validated against %s\n", o.SchemaPath)
+	}
+	if o.PrintJSON || o.JSONOutPath != "" {
+		b, err := json.MarshalIndent(redactPayload(payload, o.Redact), "", "  ")
 		if err != nil {
 			return err
 		}
-		fmt.Println(string(b))
+		if o.PrintJSON {
+			fmt.Println(string(b))
+		}
+		if o.JSONOutPath != "" {
+			if err := os.WriteFile(o.JSONOutPath, append(b, '\n'), 0o644); err != nil {
+				return fmt.Errorf("write payload to %s: %w", o.JSONOutPath, err)
+			}
+		}
+	}
+	archived := false
+	if o.History.Path != "" {
+		// The archive is a side channel; a full disk must not stop the upload.
+		if err := appendHistory(o.History, payload, time.Now()); err != nil {
+			fmt.Printf(utils.Deco("⚠️ Failed to archive payload to %s: %v\n"), o.History.Path, err)
+		} else {
+			fmt.Printf("[history] run archived to %s\n", o.History.Path)
+			archived = true
+		}
 	}
 	if o.Config.Enabled() {
-		return o.Config.PostPayload(payload)
+		err := o.post(payload)
+		if err != nil && archived {
+			return &keptLocallyError{Err: err, Path: o.History.Path}
+		}
+		return err
+	}
+	return nil
+}
+
+// post delivers payload to the controller through the circuit breaker.
+func (o RemotePayloadOptions) post(payload RemotePayload) error {
+	if err := o.Config.Breaker.allow(); err != nil {
+		return err
 	}
+	o.Config.Discover()
+	err := o.Config.postBatched(payload)
+	o.Config.Breaker.record(err)
+	return err
+}
+
+// tolerate applies the --fail-fast / --best-effort policy to an emit error.
+// Under BestEffort a failed controller delivery (ErrRemoteIngest, which
+// includes an open circuit) is logged and dropped when the run was kept
+// locally: persisted reports that SQLite stored it, and emit marks errors
+// whose payload the history archive holds. Every other error is returned
+// as is.
+func (o RemotePayloadOptions) tolerate(err error, persisted bool) error {
+	if err == nil || !o.BestEffort || !errors.Is(err, ErrRemoteIngest) {
+		return err
+	}
+	where := "the local database"
+	var kept *keptLocallyError
+	switch {
+	case errors.As(err, &kept):
+		where = kept.Path
+	case !persisted:
+		return err
+	}
+	fmt.Printf(utils.Deco("⚠️ Controller ingest failed; results were kept in %s (--best-effort): %v\n"), where, err)
 	return nil
 }
 
-func emitHosts(hosts []HostRecord, opts RemotePayloadOptions) error {
+// emitHosts builds the ingest payload for hosts and emits it. meta is attached
+// as run-level payload metadata (e.g. subnet_stats) and may be nil.
+func emitHosts(hosts []HostRecord, meta map[string]any, opts RemotePayloadOptions) error {
 	if !opts.shouldEmit() {
 		return nil
 	}
+	if opts.PromTextfile != "" {
+		// Written even for an empty run so the collector drops stale hosts;
+		// like the history archive, a failure does not stop the upload.
+		if err := writePromTextfile(opts.PromTextfile, hosts, meta, time.Now()); err != nil {
+			fmt.Printf(utils.Deco("⚠️ Failed to write metrics to %s: %v\n"), opts.PromTextfile, err)
+		}
+	}
 	if len(hosts) == 0 {
-		fmt.Println("⚠️ No hosts discovered; skipping remote payload")
-		return nil
+		if !opts.EmitEmpty {
+			fmt.Println(utils.Deco("⚠️ No hosts discovered; skipping remote payload"))
+			return nil
+		}
+		fmt.Println("[remote] no hosts discovered; emitting empty inventory")
+		meta = emptyRunMetadata(meta, opts.Config)
+	}
+	sortHosts(hosts)
+	applyLabels(hosts, opts.Labels)
+	if opts.Table {
+		if err := writeHostTable(os.Stdout, hosts, opts.Wide); err != nil {
+			return err
+		}
+	}
+	if opts.NmapXMLPath != "" {
+		if err := writeNmapXML(opts.NmapXMLPath, hosts); err != nil {
+			return err
+		}
 	}
 	var withoutPorts, withPorts int
 	for _, h := range hosts {
@@ -208,6 +380,12 @@ func emitHosts(hosts []HostRecord, opts RemotePayloadOptions) error {
 			}
 		}
 	}
+	return opts.emit(hostsPayload(hosts, meta, opts))
+}
+
+// hostsPayload wraps hosts and run metadata into the ingest payload for the
+// configured site, hashing MACs when --hash-macs is set.
+func hostsPayload(hosts []HostRecord, meta map[string]any, opts RemotePayloadOptions) RemotePayload {
 	siteName := opts.Config.SiteName
 	if siteName == "" {
 		siteName = opts.Config.SiteID
@@ -216,40 +394,162 @@ func emitHosts(hosts []HostRecord, opts RemotePayloadOptions) error {
 	if agentVersion == "" {
 		agentVersion = ScannerVersion
 	}
-	payload := BuildRemotePayload(siteName, agentVersion, hosts)
-	return opts.emit(payload)
+	payload := BuildRemotePayload(siteName, agentVersion, opts.Config.AgentID, hosts)
+	if len(meta) > 0 {
+		payload.Metadata = meta
+	}
+	if opts.MACSalt != "" {
+		hashPayloadMACs(&payload, opts.MACSalt)
+	}
+	return payload
+}
+
+// emptyRunMetadata copies meta and marks it as an intentionally empty
+// inventory, naming the site and agent so the payload stands on its own.
+func emptyRunMetadata(meta map[string]any, rc RemoteConfig) map[string]any {
+	out := map[string]any{"empty": true}
+	if rc.SiteID != "" {
+		out["site_id"] = rc.SiteID
+	}
+	if rc.AgentID != "" {
+		out["agent_id"] = rc.AgentID
+	}
+	for k, v := range meta {
+		out[k] = v
+	}
+	return out
 }
 
 // SaveHostsToDB persists the provided records into the local SQLite database.
+// Hosts on the same interfaces that are not part of hosts are marked offline
+// afterwards.
 func SaveHostsToDB(dbPath string, hosts []HostRecord) error {
+	return saveHostsToDB(dbPath, hosts, 0, NamePreferNew, "")
+}
+
+// saveHostsToDB is SaveHostsToDB with an offline TTL: hosts missing from
+// the batch stay online until they have not been seen for offlineTTL.
+func saveHostsToDB(dbPath string, hosts []HostRecord, offlineTTL time.Duration, policy NamePolicy, agentID string) error {
 	if len(hosts) == 0 {
 		return nil
 	}
 	db, err := sql.Open("sqlite3", dbPath)
 	if err != nil {
-		return err
+		return fmt.Errorf("%w: %w", ErrDatabase, err)
 	}
 	defer db.Close()
+	writer := newDBWriter(db)
+	defer writer.Close()
+	return writer.do(func(tx *sql.Tx) error { return saveHosts(tx, hosts, offlineTTL, policy, agentID) })
+}
 
-	interfaces := map[string]struct{}{}
-	for _, host := range hosts {
-		if host.InterfaceName != "" {
-			interfaces[host.InterfaceName] = struct{}{}
+// saveHosts upserts hosts and marks the ones missing from the same
+// interfaces offline. It runs as one writer operation: one transaction and
+// one prepared statement for the whole batch, so a crash mid-batch leaves
+// the previous inventory intact instead of a half-updated one.
+func saveHosts(tx *sql.Tx, hosts []HostRecord, offlineTTL time.Duration, policy NamePolicy, agentID string) error {
+	stmt, err := tx.Prepare(upsertHostSQL)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrDatabase, err)
+	}
+	defer stmt.Close()
+
+	seen := map[string]bool{}
+	var interfaces []string
+	cutoff := time.Now()
+	for i := range hosts {
+		if err := upsertHostWith(tx, stmt.QueryRow, &hosts[i], policy, agentID, os.Stdout); err != nil {
+			return fmt.Errorf("%w: upsert %s: %w", ErrDatabase, hosts[i].IP, err)
+		}
+		if name := hosts[i].InterfaceName; name != "" && !seen[name] {
+			seen[name] = true
+			interfaces = append(interfaces, name)
+		}
+		if t := hosts[i].LastSeen; !t.IsZero() && t.Before(cutoff) {
+			cutoff = t
 		}
 	}
-	for name := range interfaces {
-		_, _ = db.Exec("UPDATE hosts SET online_status = 'offline' WHERE interface_name = ?", name)
+	// last_seen is stored with second precision.
+	cutoff = cutoff.Truncate(time.Second).Add(-offlineTTL)
+	_, err = markStaleOffline(tx, interfaces, cutoff)
+	return err
+}
+
+// dbTimeLayout is how timestamps are written to SQLite, always in UTC like
+// SQLite's own CURRENT_TIMESTAMP (see dbTime).
+const dbTimeLayout = "2006-01-02 15:04:05"
+
+// dbTime formats t for SQLite. Storing UTC keeps string comparisons such as
+// markStaleOffline's cutoff correct across zone and DST changes.
+func dbTime(t time.Time) string {
+	return t.UTC().Format(dbTimeLayout)
+}
+
+// parseDBTime parses a timestamp read back from SQLite, either as written by
+// dbTime or as the RFC 3339 UTC value go-sqlite3 decodes DATETIME columns to.
+func parseDBTime(s string) time.Time {
+	if t, err := time.ParseInLocation(dbTimeLayout, s, time.UTC); err == nil {
+		return t
+	}
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t
 	}
+	return time.Time{}
+}
 
-	for _, host := range hosts {
-		if err := upsertHost(db, host); err != nil {
-			return err
-		}
+// sqlExecutor is satisfied by both *sql.DB and *sql.Tx.
+type sqlExecutor interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+const upsertHostSQL = `
+        INSERT INTO hosts (
+            ip, name, os_details, mac_address, open_ports, open_ports_json, next_hop,
+            network_name, interface_name, first_seen, last_seen, online_status,
+            first_agent, agent_id, ipv6_addresses
+        ) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, NULLIF(?, ''), NULLIF(?, ''), ?)
+        ON CONFLICT(ip, interface_name) DO UPDATE SET
+            name=CASE
+                WHEN COALESCE(hosts.name, '') IN ('', 'NoName') THEN excluded.name
+                WHEN excluded.name IN ('', 'NoName') OR ? = 'prefer-existing' THEN hosts.name
+                ELSE excluded.name
+            END,
+            os_details=excluded.os_details,
+            mac_address=excluded.mac_address,
+            open_ports=excluded.open_ports,
+            open_ports_json=excluded.open_ports_json,
+            next_hop=excluded.next_hop,
+            last_seen=excluded.last_seen,
+            online_status=excluded.online_status,
+            first_agent=COALESCE(hosts.first_agent, excluded.first_agent),
+            agent_id=excluded.agent_id,
+            ipv6_addresses=excluded.ipv6_addresses
+        RETURNING first_seen, name
+    `
+
+// upsertHost writes host keyed on (ip, interface_name). first_seen is set on
+// the initial insert only and is copied back into host.FirstSeen. The stored
+// name is decided by policy (see NamePolicy) and copied back into
+// host.Hostname, so the payload reports the name that was kept. agentID is
+// stored as the host's agent_id, and as first_agent until one is set. Kept
+// names and port changes are logged to stdout.
+func upsertHost(db sqlExecutor, record *HostRecord, policy NamePolicy, agentID string) error {
+	return upsertHostWith(db, upsertQuery(db), record, policy, agentID, os.Stdout)
+}
+
+// upsertQuery runs upsertHostSQL on db.
+func upsertQuery(db sqlExecutor) func(args ...any) *sql.Row {
+	return func(args ...any) *sql.Row {
+		return db.QueryRow(upsertHostSQL, args...)
 	}
-	return nil
 }
 
-func upsertHost(db *sql.DB, host HostRecord) error {
+// upsertHostWith is upsertHost with the upsert run through queryRow, e.g. a
+// prepared statement reused across a batch, and its log lines written to out.
+func upsertHostWith(db sqlExecutor, queryRow func(args ...any) *sql.Row, record *HostRecord, policy NamePolicy, agentID string, out io.Writer) error {
+	host := *record
 	if host.NetworkName == "" {
 		host.NetworkName = "LAN"
 	}
@@ -260,20 +560,35 @@ func upsertHost(db *sql.DB, host HostRecord) error {
 		host.OnlineStatus = "online"
 	}
 	openPorts := host.PortsSummary()
-	_, err := db.Exec(`
-        INSERT INTO hosts (
-            ip, name, os_details, mac_address, open_ports, next_hop,
-            network_name, interface_name, last_seen, online_status
-        ) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-        ON CONFLICT(ip, interface_name) DO UPDATE SET
-            name=excluded.name,
-            os_details=excluded.os_details,
-            mac_address=excluded.mac_address,
-            open_ports=excluded.open_ports,
-            next_hop=excluded.next_hop,
-            last_seen=excluded.last_seen,
-            online_status=excluded.online_status
-    `, host.IP, host.Hostname, host.OS, host.MAC, openPorts, host.NextHop,
-		host.NetworkName, host.InterfaceName, host.LastSeen.Format("2006-01-02 15:04:05"), host.OnlineStatus)
-	return err
+	portsJSON, err := host.portsJSON()
+	if err != nil {
+		return err
+	}
+	ipv6JSON, err := host.ipv6JSON()
+	if err != nil {
+		return err
+	}
+	lastSeen := dbTime(host.LastSeen)
+	var firstSeen, name sql.NullString
+	err = queryRow(host.IP, host.Hostname, host.OS, host.MAC, openPorts, portsJSON, host.NextHop,
+		host.NetworkName, host.InterfaceName, lastSeen, lastSeen, host.OnlineStatus,
+		agentID, agentID, ipv6JSON, string(policy)).Scan(&firstSeen, &name)
+	if err != nil {
+		return err
+	}
+	if firstSeen.Valid {
+		record.FirstSeen = parseDBTime(firstSeen.String)
+	}
+	if name.Valid && name.String != host.Hostname {
+		fmt.Fprintf(out, "Host %s keeps its name %q (scan reported %q, --name-policy %s)\n", host.IP, name.String, host.Hostname, policy.orDefault())
+		record.Hostname = name.String
+	}
+	changes, err := recordPortChanges(db, host)
+	if err != nil {
+		return fmt.Errorf("record port history: %w", err)
+	}
+	for _, c := range changes {
+		fmt.Fprintf(out, utils.Deco("🔔 Port change %s\n"), c)
+	}
+	return nil
 }
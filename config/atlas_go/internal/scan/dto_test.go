@@ -0,0 +1,491 @@
+package scan
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"atlas/internal/db"
+)
+
+// openTestDB creates a migrated SQLite database in a temp dir and points the
+// package-level dbPath at it.
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "atlas.db")
+	if err := db.InitDBAt(path); err != nil {
+		t.Fatalf("InitDBAt: %v", err)
+	}
+	prev := dbPath
+	dbPath = path
+	conn, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		dbPath = prev
+	})
+	return conn
+}
+
+func TestUpsertHostKeepsFirstSeen(t *testing.T) {
+	conn := openTestDB(t)
+	first := time.Date(2024, 1, 1, 10, 0, 0, 0, time.Local)
+	record := HostRecord{IP: "192.168.1.10", InterfaceName: "eth0", LastSeen: first}
+	if err := upsertHost(conn, &record, NamePreferNew, ""); err != nil {
+		t.Fatalf("first upsert: %v", err)
+	}
+	if !record.FirstSeen.Equal(first) {
+		t.Fatalf("expected first_seen %s, got %s", first, record.FirstSeen)
+	}
+
+	later := HostRecord{IP: "192.168.1.10", InterfaceName: "eth0", LastSeen: first.Add(48 * time.Hour)}
+	if err := upsertHost(conn, &later, NamePreferNew, ""); err != nil {
+		t.Fatalf("second upsert: %v", err)
+	}
+	if !later.FirstSeen.Equal(first) {
+		t.Fatalf("first_seen changed on update: %s", later.FirstSeen)
+	}
+	if got := later.ToRemoteHostPayload("").Metadata["first_seen"]; got != first.UTC().Format(time.RFC3339) {
+		t.Fatalf("unexpected first_seen metadata %v", got)
+	}
+}
+
+func TestUpsertHostNamePolicy(t *testing.T) {
+	conn := openTestDB(t)
+	steps := []struct {
+		policy       NamePolicy
+		scanned      string
+		want         string
+		wantReported string
+	}{
+		{NamePreferNew, "NoName", "NoName", "NoName"},
+		{NamePreferNew, "server.lan", "server.lan", "server.lan"}, // a first real name replaces NoName
+		{NamePreferNew, "NoName", "server.lan", "server.lan"},     // and never yields to NoName
+		{NamePreferNew, "", "server.lan", "server.lan"},
+		{NamePreferNew, "fileserver.lan", "fileserver.lan", "fileserver.lan"}, // a rename
+		{NamePreferExisting, "nas.lan", "fileserver.lan", "fileserver.lan"},
+		{NamePreferExisting, "NoName", "fileserver.lan", "fileserver.lan"},
+	}
+	for i, step := range steps {
+		record := HostRecord{IP: "192.168.1.10", InterfaceName: "eth0", Hostname: step.scanned}
+		if err := upsertHost(conn, &record, step.policy, ""); err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+		var stored string
+		if err := conn.QueryRow("SELECT name FROM hosts WHERE ip = ?", record.IP).Scan(&stored); err != nil {
+			t.Fatal(err)
+		}
+		if stored != step.want || record.Hostname != step.wantReported {
+			t.Fatalf("step %d (%s, scanned %q): stored %q, reported %q; want %q", i, step.policy, step.scanned, stored, record.Hostname, step.want)
+		}
+	}
+
+	// prefer-existing still fills in a host that never had a real name.
+	record := HostRecord{IP: "192.168.1.11", InterfaceName: "eth0", Hostname: "NoName"}
+	_ = upsertHost(conn, &record, NamePreferExisting, "")
+	record.Hostname = "printer.lan"
+	if err := upsertHost(conn, &record, NamePreferExisting, ""); err != nil || record.Hostname != "printer.lan" {
+		t.Fatalf("prefer-existing should accept a first real name: %q %v", record.Hostname, err)
+	}
+
+	if _, err := ParseNamePolicy("newest"); err == nil {
+		t.Fatal("expected an error for an unknown policy")
+	}
+	if p, err := ParseNamePolicy(""); err != nil || p != NamePreferNew {
+		t.Fatalf("default policy = %q, %v", p, err)
+	}
+}
+
+func TestUpsertHostRecordsPortChanges(t *testing.T) {
+	conn := openTestDB(t)
+	host := HostRecord{IP: "192.168.1.10", InterfaceName: "eth0", Ports: []RemotePort{
+		{Port: 22, Protocol: "tcp", State: "open"},
+		{Port: 80, Protocol: "tcp", State: "open"},
+	}}
+	if err := upsertHost(conn, &host, NamePreferNew, ""); err != nil {
+		t.Fatal(err)
+	}
+	host.Ports = []RemotePort{{Port: 22, Protocol: "tcp", State: "filtered"}}
+	if err := upsertHost(conn, &host, NamePreferNew, ""); err != nil {
+		t.Fatal(err)
+	}
+	// An unchanged rescan records nothing new.
+	if err := upsertHost(conn, &host, NamePreferNew, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := db.PortHistoryAt(dbPath, host.IP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, ev := range events {
+		got = append(got, fmt.Sprintf("%d/%s=%s", ev.Port, ev.Protocol, ev.State))
+	}
+	want := "22/tcp=open,80/tcp=open,22/tcp=filtered,80/tcp=closed"
+	if strings.Join(got, ",") != want {
+		t.Fatalf("unexpected history %v, want %s", got, want)
+	}
+
+	// The same address on another interface is another host with its own
+	// timeline.
+	other := HostRecord{IP: host.IP, InterfaceName: "wlan0", Ports: []RemotePort{{Port: 80, Protocol: "tcp", State: "open"}}}
+	if err := upsertHost(conn, &other, NamePreferNew, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := upsertHost(conn, &host, NamePreferNew, ""); err != nil {
+		t.Fatal(err)
+	}
+	events, err = db.PortHistoryAt(dbPath, host.IP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = nil
+	for _, ev := range events {
+		got = append(got, fmt.Sprintf("%s:%d/%s=%s", ev.InterfaceName, ev.Port, ev.Protocol, ev.State))
+	}
+	want = "eth0:22/tcp=open,eth0:80/tcp=open,eth0:22/tcp=filtered,eth0:80/tcp=closed,wlan0:80/tcp=open"
+	if strings.Join(got, ",") != want {
+		t.Fatalf("unexpected per-interface history %v, want %s", got, want)
+	}
+}
+
+func TestSaveHostsMarksMissingOfflineAfterTTL(t *testing.T) {
+	conn := openTestDB(t)
+	now := time.Now()
+	old := []HostRecord{
+		{IP: "10.0.0.1", InterfaceName: "eth0", LastSeen: now.Add(-2 * time.Hour), OnlineStatus: "online"},
+		{IP: "10.0.0.2", InterfaceName: "eth0", LastSeen: now.Add(-10 * time.Minute), OnlineStatus: "online"},
+	}
+	for i := range old {
+		if err := upsertHost(conn, &old[i], NamePreferNew, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	seen := []HostRecord{{IP: "10.0.0.3", InterfaceName: "eth0", LastSeen: now, OnlineStatus: "online"}}
+	if err := saveHostsToDB(dbPath, seen, time.Hour, NamePreferNew, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	status := map[string]string{}
+	rows, err := conn.Query("SELECT ip, online_status FROM hosts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var ip, s string
+		if err := rows.Scan(&ip, &s); err != nil {
+			t.Fatal(err)
+		}
+		status[ip] = s
+	}
+	if status["10.0.0.1"] != "offline" || status["10.0.0.2"] != "online" || status["10.0.0.3"] != "online" {
+		t.Fatalf("unexpected statuses %v", status)
+	}
+}
+
+func TestRunIDsSortAndAreRecorded(t *testing.T) {
+	conn := openTestDB(t)
+	start := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+	first, second := newRunID(start), newRunID(start.Add(time.Millisecond))
+	if !strings.HasPrefix(first, "20240501T090000.000Z-") || first >= second {
+		t.Fatalf("run IDs should sort by start time: %s, %s", first, second)
+	}
+
+	hosts := []HostRecord{{IP: "10.0.0.1"}, {IP: "10.0.0.2", Metadata: map[string]any{"scanner": "fastscan"}}}
+	tagRunID(hosts, first)
+	for _, h := range hosts {
+		if h.Metadata["run_id"] != first {
+			t.Fatalf("host %s not tagged: %v", h.IP, h.Metadata)
+		}
+	}
+
+	run := scanRun{ID: first, Scanner: "deepscan", StartedAt: start, FinishedAt: start.Add(time.Minute), HostCount: 2, Partial: true,
+		Nmap: nmapRunTotals{Discovery: nmapStats{Runs: 1, HostsUp: 3, Elapsed: 2.5}, Scans: nmapStats{Runs: 2, HostsUp: 2, Elapsed: 10.25}}}
+	if err := recordScanRun(conn, run); err != nil {
+		t.Fatalf("recordScanRun: %v", err)
+	}
+	var scanner string
+	var count, hostsUp int
+	var partial bool
+	var elapsed float64
+	if err := conn.QueryRow("SELECT scanner, host_count, partial, nmap_hosts_up, nmap_elapsed FROM scan_runs WHERE run_id = ?", first).Scan(&scanner, &count, &partial, &hostsUp, &elapsed); err != nil {
+		t.Fatal(err)
+	}
+	if scanner != "deepscan" || count != 2 || !partial {
+		t.Fatalf("unexpected scan_runs row: %s %d %v", scanner, count, partial)
+	}
+	if hostsUp != 3 || elapsed != 12.75 {
+		t.Fatalf("unexpected nmap stats: %d hosts up, %.2fs", hostsUp, elapsed)
+	}
+}
+
+func benchmarkHosts(n int) []HostRecord {
+	hosts := make([]HostRecord, n)
+	for i := range hosts {
+		hosts[i] = HostRecord{IP: fmt.Sprintf("10.0.%d.%d", i/256, i%256), InterfaceName: "eth0", OS: "Linux", PortSummary: "22/tcp (ssh)"}
+	}
+	return hosts
+}
+
+// BenchmarkSaveHostsToDB measures the transactional prepared-statement batch.
+func BenchmarkSaveHostsToDB(b *testing.B) {
+	path := filepath.Join(b.TempDir(), "atlas.db")
+	if err := db.InitDBAt(path); err != nil {
+		b.Fatal(err)
+	}
+	hosts := benchmarkHosts(500)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := SaveHostsToDB(path, hosts); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkUpsertHostPerExec is the previous path: one autocommitted upsert
+// per host.
+func BenchmarkUpsertHostPerExec(b *testing.B) {
+	path := filepath.Join(b.TempDir(), "atlas.db")
+	if err := db.InitDBAt(path); err != nil {
+		b.Fatal(err)
+	}
+	conn, err := sql.Open("sqlite3", path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer conn.Close()
+	hosts := benchmarkHosts(500)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := range hosts {
+			if err := upsertHost(conn, &hosts[j], NamePreferNew, ""); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestRecordExternalIPLogsChanges(t *testing.T) {
+	conn := openTestDB(t)
+	steps := []struct {
+		ip       string
+		previous string
+		changed  bool
+	}{
+		{"203.0.113.5", "", false},
+		{"203.0.113.5", "", false},
+		{"198.51.100.7", "203.0.113.5", true},
+		{"198.51.100.7", "203.0.113.5", false},
+		{"203.0.113.5", "198.51.100.7", true},
+	}
+	for i, s := range steps {
+		ext, err := recordExternalIP(conn, s.ip)
+		if err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+		if ext != (externalIP{Current: s.ip, Previous: s.previous, Changed: s.changed}) {
+			t.Errorf("step %d: got %+v", i, ext)
+		}
+	}
+	var changes int
+	if err := conn.QueryRow("SELECT COUNT(*) FROM external_ip_changes").Scan(&changes); err != nil {
+		t.Fatal(err)
+	}
+	if changes != 2 {
+		t.Fatalf("expected 2 change rows, got %d", changes)
+	}
+
+	meta := map[string]any{}
+	externalIP{Current: "198.51.100.7", Previous: "203.0.113.5", Changed: true}.addTo(meta)
+	if meta["external_ip"] != "198.51.100.7" || meta["previous_external_ip"] != "203.0.113.5" || meta["external_ip_changed"] != true {
+		t.Fatalf("metadata %v", meta)
+	}
+	meta = map[string]any{}
+	externalIP{}.addTo(meta)
+	if len(meta) != 0 {
+		t.Fatalf("unknown external IP added metadata %v", meta)
+	}
+}
+
+func TestUpsertHostRecordsAgentProvenance(t *testing.T) {
+	conn := openTestDB(t)
+	record := HostRecord{IP: "10.0.0.8", Hostname: "nas", InterfaceName: "eth0"}
+	if err := upsertHost(conn, &record, NamePreferNew, ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, agent := range []string{"agent-a", "agent-b"} {
+		if err := upsertHost(conn, &record, NamePreferNew, agent); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var first, last string
+	if err := conn.QueryRow("SELECT first_agent, agent_id FROM hosts WHERE ip = ?", record.IP).Scan(&first, &last); err != nil {
+		t.Fatal(err)
+	}
+	if first != "agent-a" || last != "agent-b" {
+		t.Fatalf("stored first_agent=%q agent_id=%q", first, last)
+	}
+
+	// Presence upserts stamp the agent too.
+	seen := HostRecord{IP: "10.0.0.9", InterfaceName: "eth0", LastSeen: time.Now(), OnlineStatus: "online"}
+	for _, agent := range []string{"agent-c", "agent-d"} {
+		if err := upsertPresence(conn, seen, agent); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := conn.QueryRow("SELECT first_agent, agent_id FROM hosts WHERE ip = ?", seen.IP).Scan(&first, &last); err != nil {
+		t.Fatal(err)
+	}
+	if first != "agent-c" || last != "agent-d" {
+		t.Fatalf("presence stored first_agent=%q agent_id=%q", first, last)
+	}
+
+	// The controller derives the first agent; payloads carry agent_id alone.
+	meta := hostsPayload([]HostRecord{record}, nil, RemotePayloadOptions{Config: RemoteConfig{SiteID: "lab", AgentID: "agent-b"}}).Hosts[0].Metadata
+	if _, ok := meta["first_agent"]; ok || meta["agent_id"] != "agent-b" {
+		t.Fatalf("payload metadata %v", meta)
+	}
+	if _, _, err := ParseLabel("agent_id=spoofed"); err == nil {
+		t.Fatal("--label may not override agent_id")
+	}
+}
+
+func TestUpsertHostStoresIPv6Addresses(t *testing.T) {
+	conn := openTestDB(t)
+	dual := HostRecord{IP: "10.0.0.9", MAC: "aa:bb:cc:00:00:09", InterfaceName: "eth0",
+		Metadata: map[string]any{"ipv6_addresses": []string{"fe80::9"}}}
+	if err := upsertHost(conn, &dual, NamePreferNew, ""); err != nil {
+		t.Fatal(err)
+	}
+	var addrs sql.NullString
+	if err := conn.QueryRow("SELECT ipv6_addresses FROM hosts WHERE ip = ?", dual.IP).Scan(&addrs); err != nil {
+		t.Fatal(err)
+	}
+	if addrs.String != `["fe80::9"]` {
+		t.Fatalf("stored ipv6_addresses=%v", addrs)
+	}
+
+	// The next scan without IPv6 neighbors clears it.
+	dual.Metadata = nil
+	if err := upsertHost(conn, &dual, NamePreferNew, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.QueryRow("SELECT ipv6_addresses FROM hosts WHERE ip = ?", dual.IP).Scan(&addrs); err != nil {
+		t.Fatal(err)
+	}
+	if addrs.Valid {
+		t.Fatalf("stale ipv6_addresses=%v", addrs)
+	}
+}
+
+func TestEmitHostsEmptyInventory(t *testing.T) {
+	var posted []RemotePayload
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			var p RemotePayload
+			if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+				t.Errorf("decode body: %v", err)
+			}
+			posted = append(posted, p)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	opts := RemotePayloadOptions{Config: RemoteConfig{ControllerURL: srv.URL, SiteID: "lab", AgentID: "edge01"}}
+	if err := emitHosts(nil, nil, opts); err != nil {
+		t.Fatalf("emitHosts: %v", err)
+	}
+	if len(posted) != 0 {
+		t.Fatalf("empty scan was posted without --emit-empty: %+v", posted)
+	}
+
+	opts.EmitEmpty = true
+	if err := emitHosts(nil, map[string]any{"run": "x"}, opts); err != nil {
+		t.Fatalf("emitHosts: %v", err)
+	}
+	if len(posted) != 1 {
+		t.Fatalf("expected one empty payload, got %d", len(posted))
+	}
+	p := posted[0]
+	if p.Hosts == nil || len(p.Hosts) != 0 {
+		t.Errorf("hosts should be an empty array, got %#v", p.Hosts)
+	}
+	if p.Metadata["empty"] != true || p.Metadata["site_id"] != "lab" || p.Metadata["agent_id"] != "edge01" || p.Metadata["run"] != "x" {
+		t.Errorf("unexpected metadata %v", p.Metadata)
+	}
+}
+
+func TestRemoteFailurePolicy(t *testing.T) {
+	useFakeRunner(t, func(name string, args []string) ([]byte, error) {
+		return nil, errors.New(name + " ran outside the replay")
+	})
+	conn := openTestDB(t)
+	capture := filepath.Join(t.TempDir(), "capture.gnmap")
+	if err := os.WriteFile(capture, []byte(grepableFixture), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(srv.Close)
+
+	run := func(bestEffort, skipDB bool, history string) error {
+		opts := DeepScanOptions{Replay: capture, TCP: scanProfiles["standard"], SkipDB: skipDB}
+		opts.Remote = RemotePayloadOptions{BestEffort: bestEffort, History: HistoryOptions{Path: history}, Config: RemoteConfig{ControllerURL: srv.URL, SiteID: "lab", AgentID: "edge01"}}
+		return DeepScan(opts)
+	}
+	if err := run(false, false, ""); !errors.Is(err, ErrRemoteIngest) {
+		t.Fatalf("fail-fast should return the ingest error, got %v", err)
+	}
+	if err := run(true, false, ""); err != nil {
+		t.Fatalf("best-effort should succeed once the hosts are saved, got %v", err)
+	}
+	// Under --skip-db nothing is kept unless the history archive holds it.
+	if err := run(true, true, ""); !errors.Is(err, ErrRemoteIngest) {
+		t.Fatalf("best-effort without local persistence should return the ingest error, got %v", err)
+	}
+	archive := filepath.Join(t.TempDir(), "history.jsonl.gz")
+	if err := run(true, true, archive); err != nil {
+		t.Fatalf("best-effort should succeed once the run is archived, got %v", err)
+	}
+	if records, err := readHistory(archive); err != nil || len(records) != 1 {
+		t.Fatalf("archive holds %d runs (err=%v), want 1", len(records), err)
+	}
+	var n int
+	if err := conn.QueryRow("SELECT COUNT(*) FROM hosts WHERE ip = ?", "192.168.1.10").Scan(&n); err != nil || n != 1 {
+		t.Fatalf("replayed host not persisted: n=%d err=%v", n, err)
+	}
+
+	// Only ingest failures are tolerated, and only when local persistence worked.
+	opts := RemotePayloadOptions{BestEffort: true}
+	ingest := &RemoteIngestError{StatusCode: 503, Status: "503 Service Unavailable"}
+	if err := opts.tolerate(ingest, false); !errors.Is(err, ErrRemoteIngest) {
+		t.Fatalf("unsaved results must keep the ingest error, got %v", err)
+	}
+	if err := opts.tolerate(ErrCircuitOpen, true); err != nil {
+		t.Fatalf("open circuit should be tolerated, got %v", err)
+	}
+	if err := opts.tolerate(&keptLocallyError{Err: ingest, Path: "history.jsonl.gz"}, false); err != nil {
+		t.Fatalf("an archived run should be tolerated, got %v", err)
+	}
+	if err := opts.tolerate(ErrDatabase, true); !errors.Is(err, ErrDatabase) {
+		t.Fatalf("non-ingest errors must not be tolerated, got %v", err)
+	}
+}
@@ -0,0 +1,81 @@
+package scan
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Sentinel errors returned (wrapped) by the scan entry points so callers such
+// as the agent loop can branch on the failure class with errors.Is.
+var (
+	// ErrNmapNotFound means the nmap binary could not be located or executed.
+	ErrNmapNotFound = errors.New("nmap not found")
+	// ErrNoInterfaces means no scannable network interface was detected.
+	ErrNoInterfaces = errors.New("no usable network interfaces")
+	// ErrDatabase wraps failures opening or writing the local SQLite database.
+	ErrDatabase = errors.New("database error")
+	// ErrDockerUnavailable means the docker CLI or daemon could not be reached.
+	ErrDockerUnavailable = errors.New("docker unavailable")
+	// ErrRemoteIngest wraps failures delivering a payload to the controller.
+	ErrRemoteIngest = errors.New("remote ingest failed")
+	// ErrRemoteFetch wraps failures reading from the controller API.
+	ErrRemoteFetch = errors.New("remote fetch failed")
+	// ErrPartialScan means results were saved and emitted, but the scan
+	// budget (--max-duration) expired before every host was scanned.
+	ErrPartialScan = errors.New("scan incomplete")
+	// ErrUnprivileged means --require-privileged was set but nmap would not
+	// get raw sockets.
+	ErrUnprivileged = errors.New("insufficient privileges")
+)
+
+// RemoteIngestError describes a controller response that rejected a payload.
+// It matches ErrRemoteIngest via errors.Is.
+type RemoteIngestError struct {
+	StatusCode int
+	Status     string
+	Body       string
+	Elapsed    time.Duration
+}
+
+func (e *RemoteIngestError) Error() string {
+	return fmt.Sprintf("remote ingest failed in %s: %s - %s", e.Elapsed, e.Status, strings.TrimSpace(e.Body))
+}
+
+// Is lets errors.Is(err, ErrRemoteIngest) match HTTP-level ingest failures.
+func (e *RemoteIngestError) Is(target error) bool {
+	return target == ErrRemoteIngest
+}
+
+// wrapNmapError annotates an nmap invocation error with ErrNmapNotFound when
+// the binary is missing so callers can tell it apart from a failed run.
+func wrapNmapError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, exec.ErrNotFound) {
+		return fmt.Errorf("%w: %v", ErrNmapNotFound, err)
+	}
+	return err
+}
+
+// isFatalScanError reports whether retrying the scan later cannot succeed
+// without operator intervention (missing binaries, no interfaces, missing
+// privileges).
+func isFatalScanError(err error) bool {
+	return errors.Is(err, ErrNmapNotFound) || errors.Is(err, ErrNoInterfaces) || errors.Is(err, ErrUnprivileged)
+}
+
+// keptLocallyError wraps a failed controller delivery whose payload was
+// already written to Path (the history archive), so the run is not lost
+// even though the controller never saw it.
+type keptLocallyError struct {
+	Err  error
+	Path string
+}
+
+func (e *keptLocallyError) Error() string { return e.Err.Error() }
+
+func (e *keptLocallyError) Unwrap() error { return e.Err }
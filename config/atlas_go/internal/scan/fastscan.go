@@ -1,10 +1,12 @@
 package scan
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"os"
-	"os/exec"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -15,11 +17,35 @@ import (
 type FastScanOptions struct {
 	SkipDB bool
 	Remote RemotePayloadOptions
+	// MinPrefixLen rejects interface subnets broader than this many bits.
+	MinPrefixLen int
+	// PrimaryInterfaces picks which interface a shared subnet is scanned
+	// and reported under, most preferred first.
+	PrimaryInterfaces []string
+	// OfflineTTL keeps hosts that were not re-seen online until they have
+	// been missing this long.
+	OfflineTTL time.Duration
+	// NamePolicy decides whether a stored hostname yields to a new one.
+	NamePolicy NamePolicy
+	// IncludeReserved keeps addresses dropReservedHosts would filter.
+	IncludeReserved bool
+	// AllowPublic keeps addresses dropPublicHosts would filter.
+	AllowPublic bool
+	// Context, when set, cancels the run's sweeps, e.g. on the agent's
+	// shutdown signal. nil means context.Background().
+	Context context.Context
+	// AlertWebhook, when set, is posted an external_ip_changed event when
+	// the site's public address changes (see --alert-webhook).
+	AlertWebhook string
+	// externalIPs tracks the public address when SkipDB leaves no
+	// external_ip_changes table to compare against. The agent shares one
+	// across its runs; nil starts from the history archive.
+	externalIPs *externalIPTracker
 }
 
 // POINT 1: Get the default gateway IP (internal)
 func getDefaultGateway() (string, error) {
-	out, err := exec.Command("ip", "route").Output()
+	out, err := utils.RunCommand(context.Background(), "ip", "route")
 	if err != nil {
 		return "", err
 	}
@@ -36,10 +62,43 @@ func getDefaultGateway() (string, error) {
 	return "", fmt.Errorf("no default gateway found")
 }
 
-func runNmap(subnet string) (map[string]string, error) {
-	out, err := exec.Command("nmap", "-sn", subnet).Output()
+// tagGateway marks record as the site's default gateway when its IP matches
+// gatewayIP, tagging it and making sure its MAC is captured. The MAC's vendor,
+// preferring nmapVendor when the scan reported one, is recorded as
+// gateway_vendor.
+func tagGateway(record *HostRecord, gatewayIP, nmapVendor string) {
+	if gatewayIP == "" || record.IP != gatewayIP {
+		return
+	}
+	hasTag := false
+	for _, tag := range record.Tags {
+		if tag == "gateway" {
+			hasTag = true
+			break
+		}
+	}
+	if !hasTag {
+		record.Tags = append(record.Tags, "gateway")
+	}
+	if record.Metadata == nil {
+		record.Metadata = map[string]any{}
+	}
+	record.Metadata["is_gateway"] = true
+	if record.MAC == "" || record.MAC == "Unknown" {
+		record.MAC = getMacAddress(record.IP)
+	}
+	if record.MAC != "Unknown" {
+		record.Metadata["gateway_mac"] = record.MAC
+		if vendor := macVendor(record.MAC, nmapVendor); vendor != "" {
+			record.Metadata["gateway_vendor"] = vendor
+		}
+	}
+}
+
+func runNmap(ctx context.Context, subnet string) (map[string]string, error) {
+	out, err := utils.RunCommand(ctx, "nmap", "-sn", subnet)
 	if err != nil {
-		return nil, err
+		return nil, wrapNmapError(err)
 	}
 
 	hosts := make(map[string]string)
@@ -60,7 +119,9 @@ func runNmap(subnet string) (map[string]string, error) {
 	return hosts, nil
 }
 
-func updateExternalIPInDB(dbPath string) {
+// lookupExternalIP asks public echo services for this site's public
+// address, returning "" when none answers.
+func lookupExternalIP() string {
 	urls := []string{
 		"https://ifconfig.me",
 		"https://api.ipify.org",
@@ -68,74 +129,213 @@ func updateExternalIPInDB(dbPath string) {
 
 	var ip string
 	for _, url := range urls {
-		out, err := exec.Command("curl", "-s", url).Output()
+		out, err := utils.RunCommand(context.Background(), "curl", "-s", url)
 		if err == nil && len(out) > 0 {
 			ip = strings.TrimSpace(string(out))
 			break
 		}
 	}
 
-	if ip == "" {
-		fmt.Println("⚠️ Could not determine external IP")
+	return ip
+}
+
+// externalIP is the site's public address as recorded by a fast scan.
+// Previous is the address it replaced, from the latest external_ip_changes
+// row; Changed is set when this run saw the change.
+type externalIP struct {
+	Current  string
+	Previous string
+	Changed  bool
+}
+
+// addTo sets the external_ip, previous_external_ip, and external_ip_changed
+// run metadata.
+func (e externalIP) addTo(meta map[string]any) {
+	if e.Current == "" {
 		return
 	}
+	meta["external_ip"] = e.Current
+	if e.Previous != "" {
+		meta["previous_external_ip"] = e.Previous
+	}
+	if e.Changed {
+		meta["external_ip_changed"] = true
+	}
+}
 
-	db, err := sql.Open("sqlite3", dbPath)
+// recordExternalIP adds ip to external_networks or refreshes its last_seen.
+// When the last recorded address differs, the change is logged to
+// external_ip_changes first. The last address is the newest change's, which
+// stays right when several fall in the same second; databases without any
+// changes yet fall back to the most recently seen external_networks row.
+func recordExternalIP(db sqlExecutor, ip string) (externalIP, error) {
+	ext := externalIP{Current: ip}
+	var last string
+	err := db.QueryRow(`
+        SELECT COALESCE(
+            (SELECT new_ip FROM external_ip_changes ORDER BY id DESC LIMIT 1),
+            (SELECT public_ip FROM external_networks ORDER BY last_seen DESC, id DESC LIMIT 1),
+            ''
+        )
+    `).Scan(&last)
 	if err != nil {
-		fmt.Println("❌ Failed to open DB:", err)
-		return
+		return ext, fmt.Errorf("%w: %w", ErrDatabase, err)
 	}
-	defer db.Close()
-
-	_, _ = db.Exec(`
+	if last != "" && last != ip {
+		if _, err := db.Exec(`
+            INSERT INTO external_ip_changes (old_ip, new_ip)
+            VALUES (?, ?)
+        `, last, ip); err != nil {
+			return ext, fmt.Errorf("%w: %w", ErrDatabase, err)
+		}
+		ext.Changed = true
+	}
+	if _, err := db.Exec(`
         INSERT OR IGNORE INTO external_networks (public_ip)
         VALUES (?)
-    `, ip)
-
-	_, _ = db.Exec(`
+    `, ip); err != nil {
+		return ext, fmt.Errorf("%w: %w", ErrDatabase, err)
+	}
+	if _, err := db.Exec(`
         UPDATE external_networks
         SET last_seen = CURRENT_TIMESTAMP
         WHERE public_ip = ?
-    `, ip)
+    `, ip); err != nil {
+		return ext, fmt.Errorf("%w: %w", ErrDatabase, err)
+	}
+	err = db.QueryRow(`
+        SELECT old_ip FROM external_ip_changes
+        WHERE new_ip = ?
+        ORDER BY id DESC
+        LIMIT 1
+    `, ip).Scan(&ext.Previous)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return ext, fmt.Errorf("%w: %w", ErrDatabase, err)
+	}
+	return ext, nil
+}
 
-	fmt.Println("🌐 External IP recorded:", ip)
+// log reports the recorded address, warning when it changed.
+func (e externalIP) log() {
+	if e.Changed {
+		fmt.Printf(utils.Deco("⚠️ External IP changed from %s to %s\n"), e.Previous, e.Current)
+	} else {
+		fmt.Println(utils.Deco("🌐 External IP recorded:"), e.Current)
+	}
+}
+
+// trackExternalIP looks up the public address for a run that writes no
+// SQLite rows and compares it with the last one tracker saw.
+func trackExternalIP(tracker *externalIPTracker) externalIP {
+	ip := lookupExternalIP()
+	if ip == "" {
+		fmt.Println(utils.Deco("⚠️ Could not determine external IP"))
+		return externalIP{}
+	}
+	ext := tracker.observe(ip)
+	ext.log()
+	return ext
+}
+
+// saveFastScan persists a fast scan's hosts (reported by agentID), the site's
+// external IP, and the run through one writer, in that order. It returns the
+// external IP as recorded, zero when it could not be determined or stored.
+func saveFastScan(path string, hosts []HostRecord, offlineTTL time.Duration, policy NamePolicy, agentID string, run scanRun) (externalIP, error) {
+	db, err := openScanDB(path)
+	if err != nil {
+		return externalIP{}, err
+	}
+	defer db.Close()
+	writer := newDBWriter(db)
+	defer writer.Close()
+
+	if len(hosts) > 0 {
+		if err := writer.do(func(tx *sql.Tx) error { return saveHosts(tx, hosts, offlineTTL, policy, agentID) }); err != nil {
+			return externalIP{}, err
+		}
+	}
+	var ext externalIP
+	if ip := lookupExternalIP(); ip == "" {
+		fmt.Println(utils.Deco("⚠️ Could not determine external IP"))
+	} else if err := writer.do(func(tx *sql.Tx) (err error) { ext, err = recordExternalIP(tx, ip); return err }); err != nil {
+		fmt.Printf(utils.Deco("⚠️ Failed to record external IP: %v\n"), err)
+		ext = externalIP{}
+	} else {
+		ext.log()
+	}
+	if err := writer.do(func(tx *sql.Tx) error { return recordScanRun(tx, run) }); err != nil {
+		fmt.Printf(utils.Deco("⚠️ Failed to record scan run: %v\n"), err)
+	}
+	return ext, nil
 }
 
 func FastScan(opts FastScanOptions) error {
-	logFile := "/config/logs/fast_scan_progress.log"
+	logFile := filepath.Join(logDir, "fast_scan_progress.log")
 	lf, _ := os.Create(logFile)
 	var (
-		hosts []HostRecord
-		err   error
+		hosts  []HostRecord
+		stats  []SubnetStats
+		ifaces []utils.InterfaceDetails
+		err    error
 	)
 	start := time.Now()
 	if lf != nil {
 		defer lf.Close()
-		fmt.Fprintf(lf, "🚀 Fast scan started at %s\n", start.Format(time.RFC3339))
-		hosts, err = fastScanCore(lf)
+		fmt.Fprintf(lf, utils.Deco("🚀 Fast scan started at %s\n"), start.Format(time.RFC3339))
+		hosts, stats, ifaces, err = fastScanCore(lf, opts)
 		fmt.Fprintf(lf, "Fast scan complete in %s\n", time.Since(start))
 	} else {
-		hosts, err = fastScanCore(nil)
+		hosts, stats, ifaces, err = fastScanCore(nil, opts)
 	}
 	if err != nil {
 		return err
 	}
+	runID := opts.Remote.runID(start)
+	tagRunID(hosts, runID)
+	// Fast scan hosts carry their subnet metadata, so groups need no interfaces.
+	meta := map[string]any{"run_id": runID, "subnet_stats": stats, "groups": groupHosts(nil, hosts), "interfaces": ifaces}
+	if n := flagMACConflicts(hosts, func(format string, args ...any) { fmt.Printf(format+"\n", args...) }); n > 0 {
+		meta["mac_conflicts"] = n
+	}
 
+	// Only a DB write (or the history archive, see emit) keeps the run when
+	// the controller cannot be reached.
+	persisted := !opts.SkipDB
+	var ext externalIP
 	if !opts.SkipDB {
-		if err := SaveHostsToDB("/config/db/atlas.db", hosts); err != nil {
-			return err
+		run := scanRun{ID: runID, Scanner: "fastscan", StartedAt: start, FinishedAt: time.Now(), HostCount: len(hosts)}
+		ext, err = saveFastScan(dbPath, hosts, opts.OfflineTTL, opts.NamePolicy, opts.Remote.Config.AgentID, run)
+		if err != nil {
+			if !opts.Remote.shouldEmit() {
+				return err
+			}
+			fmt.Printf(utils.Deco("⚠️ Failed to save hosts to DB, continuing with remote emit: %v\n"), err)
+			persisted = false
+		}
+	} else {
+		tracker := opts.externalIPs
+		if tracker == nil {
+			tracker = newExternalIPTracker(opts.Remote.History.Path)
+		}
+		ext = trackExternalIP(tracker)
+	}
+	ext.addTo(meta)
+	if ext.Changed && opts.AlertWebhook != "" {
+		if err := postAlert(opts.Remote.Config, opts.AlertWebhook, externalIPChangedEvent(opts.Remote.Config, ext, time.Now())); err != nil {
+			fmt.Printf(utils.Deco("⚠️ External IP change alert failed: %v\n"), err)
+		} else {
+			fmt.Println(utils.Deco("🔔 External IP change sent to the alert webhook"))
 		}
-		updateExternalIPInDB("/config/db/atlas.db")
 	}
 
-	if err := emitHosts(hosts, opts.Remote); err != nil {
+	if err := opts.Remote.tolerate(emitHosts(hosts, meta, opts.Remote), persisted); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func fastScanCore(lf *os.File) ([]HostRecord, error) {
+func fastScanCore(lf *os.File, opts FastScanOptions) ([]HostRecord, []SubnetStats, []utils.InterfaceDetails, error) {
 	logf := func(format string, args ...any) {
 		msg := fmt.Sprintf(format, args...)
 		fmt.Println(msg)
@@ -144,30 +344,46 @@ func fastScanCore(lf *os.File) ([]HostRecord, error) {
 		}
 	}
 
+	ctx := opts.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
 	interfaces, err := utils.GetAllInterfaces()
 	if err != nil {
-		return nil, fmt.Errorf("failed to detect network interfaces: %v", err)
+		return nil, nil, nil, fmt.Errorf("%w: failed to detect network interfaces: %v", ErrNoInterfaces, err)
+	}
+	interfaces = sanitizeInterfaces(interfaces, opts.MinPrefixLen, opts.PrimaryInterfaces, logf)
+	if len(interfaces) == 0 {
+		return nil, nil, nil, fmt.Errorf("%w: no interface subnet passed validation", ErrNoInterfaces)
 	}
 
 	gatewayIP, err := getDefaultGateway()
 	if err != nil {
-		logf("⚠️ Could not determine gateway: %v", err)
+		logf(utils.Deco("⚠️ Could not determine gateway: %v"), err)
 		gatewayIP = ""
 	}
 
+	selfIPs := selfAddresses(interfaces)
 	var discovered []HostRecord
 	for _, iface := range interfaces {
 		logf("Discovering live hosts on %s (interface: %s)...", iface.Subnet, iface.Name)
-		hosts, err := runNmap(iface.Subnet)
+		hosts, err := runNmap(ctx, iface.Subnet)
 		if err != nil {
-			logf("⚠️ Failed to scan subnet %s on interface %s: %v", iface.Subnet, iface.Name, err)
+			logf(utils.Deco("⚠️ Failed to scan subnet %s on interface %s: %v"), iface.Subnet, iface.Name, err)
+			if errors.Is(err, ErrNmapNotFound) {
+				return nil, nil, nil, err
+			}
 			continue
 		}
 		logf("Discovered %d hosts on %s", len(hosts), iface.Subnet)
+		infos := make([]HostInfo, 0, len(hosts))
 		for ip, name := range hosts {
+			infos = append(infos, HostInfo{IP: ip, Name: name})
+		}
+		for _, host := range filterDiscovered(infos, iface.Subnet, opts.IncludeReserved, opts.AllowPublic, logf) {
 			record := HostRecord{
-				IP:            ip,
-				Hostname:      name,
+				IP:            host.IP,
+				Hostname:      host.Name,
 				OS:            "Unknown",
 				MAC:           "Unknown",
 				PortSummary:   "Unknown",
@@ -175,7 +391,6 @@ func fastScanCore(lf *os.File) ([]HostRecord, error) {
 				NetworkName:   "LAN",
 				InterfaceName: iface.Name,
 				LastSeen:      time.Now(),
-				OnlineStatus:  "online",
 				Metadata: map[string]any{
 					"scanner": "fastscan",
 					"subnet":  iface.Subnet,
@@ -184,10 +399,26 @@ func fastScanCore(lf *os.File) ([]HostRecord, error) {
 			if gatewayIP != "" {
 				record.Metadata["gateway_ip"] = gatewayIP
 			}
+			tagGateway(&record, gatewayIP, "")
+			tagSelf(&record, selfIPs)
 			discovered = append(discovered, record)
 		}
 	}
 
+	// Every swept host answered nmap's ping; the shared liveness policy adds
+	// the neighbor signal and records both.
+	neighbors := readNeighborTable()
+	for i := range discovered {
+		signals := gatherLiveness(discovered[i].IP, "online", nil, neighbors)
+		discovered[i].OnlineStatus = signals.status(LivenessAny)
+		discovered[i].Metadata["liveness_signals"] = signals.names()
+	}
+
 	logf("Total hosts discovered: %d", len(discovered))
-	return discovered, nil
+	sortHosts(discovered)
+	stats := computeSubnetStats(interfaces, discovered)
+	for _, s := range stats {
+		logf("%s", s)
+	}
+	return discovered, stats, utils.DescribeInterfaces(interfaces), nil
 }
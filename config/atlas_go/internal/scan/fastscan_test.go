@@ -0,0 +1,30 @@
+package scan
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTagGateway(t *testing.T) {
+	other := HostRecord{IP: "192.168.1.20", MAC: "00:0C:29:12:34:56"}
+	tagGateway(&other, "192.168.1.1", "")
+	if other.Tags != nil || other.Metadata != nil {
+		t.Fatalf("a host that is not the gateway was tagged: %+v", other)
+	}
+
+	gw := HostRecord{IP: "192.168.1.1", MAC: "00:0C:29:12:34:56", Tags: []string{"gateway"}}
+	tagGateway(&gw, "192.168.1.1", "")
+	if !reflect.DeepEqual(gw.Tags, []string{"gateway"}) || gw.Metadata["is_gateway"] != true {
+		t.Fatalf("gateway not tagged once: %+v", gw)
+	}
+	if gw.Metadata["gateway_mac"] != "00:0C:29:12:34:56" || gw.Metadata["gateway_vendor"] != "VMware" {
+		t.Fatalf("gateway MAC and vendor from the OUI table: %v", gw.Metadata)
+	}
+
+	// The vendor nmap printed wins over the OUI table.
+	gw = HostRecord{IP: "192.168.1.1", MAC: "00:0C:29:12:34:56"}
+	tagGateway(&gw, "192.168.1.1", "Ubiquiti Networks")
+	if gw.Metadata["gateway_vendor"] != "Ubiquiti Networks" {
+		t.Fatalf("nmap vendor ignored: %v", gw.Metadata)
+	}
+}
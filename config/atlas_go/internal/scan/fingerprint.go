@@ -0,0 +1,33 @@
+package scan
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// hostFingerprint hashes the parts of a host a controller cares about when
+// deciding whether it changed: IP, MAC, OS, and the port list. Ports are
+// sorted first, and MAC case is ignored, so scan order and formatting do not
+// change the result. Payloads sent with --hash-macs carry a fingerprint over
+// the hashed MAC instead (see hashPayloadMACs), so it cannot be used to test
+// guesses at the raw one.
+func hostFingerprint(h HostRecord) string {
+	return fingerprint(h.IP, h.MAC, h.OS, h.Ports)
+}
+
+func fingerprint(ip, mac, os string, hostPorts []RemotePort) string {
+	ports := make([]string, 0, len(hostPorts))
+	for _, p := range hostPorts {
+		ports = append(ports, fmt.Sprintf("%d/%s/%s/%s/%s", p.Port, strings.ToLower(p.Protocol), p.State, p.Service, p.Version))
+	}
+	sort.Strings(ports)
+	sum := sha256.New()
+	for _, part := range []string{ip, strings.ToLower(mac), os, strings.Join(ports, ",")} {
+		sum.Write([]byte(part))
+		sum.Write([]byte{0})
+	}
+	return hex.EncodeToString(sum.Sum(nil)[:16])
+}
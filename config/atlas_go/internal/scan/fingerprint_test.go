@@ -0,0 +1,37 @@
+package scan
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHostFingerprint(t *testing.T) {
+	base := HostRecord{IP: "10.0.0.5", MAC: "AA:BB:CC:DD:EE:FF", OS: "Linux", Ports: []RemotePort{
+		{Port: 22, Protocol: "tcp", State: "open", Service: "ssh"},
+		{Port: 443, Protocol: "tcp", State: "open", Service: "https"},
+	}}
+	reordered := base
+	reordered.Ports = []RemotePort{base.Ports[1], base.Ports[0]}
+	reordered.MAC = "aa:bb:cc:dd:ee:ff"
+	reordered.Hostname = "renamed"
+	reordered.LastSeen = time.Now()
+	if hostFingerprint(base) != hostFingerprint(reordered) {
+		t.Fatal("port order, MAC case, hostname, or last_seen changed the fingerprint")
+	}
+
+	changed := base
+	changed.Ports = append([]RemotePort{}, base.Ports...)
+	changed.Ports[1].State = "filtered"
+	if hostFingerprint(base) == hostFingerprint(changed) {
+		t.Fatal("a port state change should change the fingerprint")
+	}
+	moved := base
+	moved.IP = "10.0.0.6"
+	if hostFingerprint(base) == hostFingerprint(moved) {
+		t.Fatal("an IP change should change the fingerprint")
+	}
+
+	if got := base.ToRemoteHostPayload("").Metadata["fingerprint"]; got != hostFingerprint(base) {
+		t.Fatalf("payload fingerprint %v", got)
+	}
+}
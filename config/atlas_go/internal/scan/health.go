@@ -0,0 +1,81 @@
+package scan
+
+import "math"
+
+// riskyPorts are services that should rarely be reachable on a LAN: cleartext
+// logins, remote desktops, and file or database shares.
+var riskyPorts = map[int]string{
+	21:   "ftp",
+	23:   "telnet",
+	139:  "netbios-ssn",
+	445:  "microsoft-ds",
+	1433: "ms-sql",
+	3306: "mysql",
+	3389: "rdp",
+	5900: "vnc",
+	6379: "redis",
+}
+
+// Health score weights; they sum to 1.
+const (
+	healthWeightReachable    = 0.3
+	healthWeightRisk         = 0.3
+	healthWeightOSKnown      = 0.2
+	healthWeightCompleteness = 0.2
+)
+
+// HealthScore condenses one scan into a 0-100 trend line. Each component is a
+// fraction where 1 is healthy.
+type HealthScore struct {
+	Score float64 `json:"score"`
+	// Reachable is the share of hosts that answered (online_status online).
+	Reachable float64 `json:"reachable"`
+	// NoRiskyPorts is the share of hosts with no open riskyPorts.
+	NoRiskyPorts float64 `json:"no_risky_ports"`
+	// OSKnown is the share of hosts whose OS was identified.
+	OSKnown float64 `json:"os_known"`
+	// Completeness is the share of discovered hosts that were scanned
+	// before --max-duration expired.
+	Completeness float64 `json:"completeness"`
+	RiskyHosts   int     `json:"risky_hosts"`
+}
+
+// computeHealthScore scores hosts from one scan; skipped is the number of
+// discovered hosts that were never scanned. With no hosts, only completeness
+// can lower the score.
+func computeHealthScore(hosts []HostRecord, skipped int) HealthScore {
+	h := HealthScore{Reachable: 1, NoRiskyPorts: 1, OSKnown: 1, Completeness: 1}
+	if total := len(hosts) + skipped; total > 0 {
+		h.Completeness = float64(len(hosts)) / float64(total)
+	}
+	if n := float64(len(hosts)); n > 0 {
+		var online, osKnown int
+		for _, host := range hosts {
+			if host.OnlineStatus != "offline" {
+				online++
+			}
+			if host.OS != "" && host.OS != "Unknown" {
+				osKnown++
+			}
+			if hasRiskyPort(host) {
+				h.RiskyHosts++
+			}
+		}
+		h.Reachable = float64(online) / n
+		h.OSKnown = float64(osKnown) / n
+		h.NoRiskyPorts = float64(len(hosts)-h.RiskyHosts) / n
+	}
+	score := healthWeightReachable*h.Reachable + healthWeightRisk*h.NoRiskyPorts +
+		healthWeightOSKnown*h.OSKnown + healthWeightCompleteness*h.Completeness
+	h.Score = math.Round(score*1000) / 10
+	return h
+}
+
+func hasRiskyPort(h HostRecord) bool {
+	for _, p := range h.Ports {
+		if _, risky := riskyPorts[p.Port]; risky && p.State == "open" {
+			return true
+		}
+	}
+	return false
+}
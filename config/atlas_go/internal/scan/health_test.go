@@ -0,0 +1,21 @@
+package scan
+
+import "testing"
+
+func TestComputeHealthScore(t *testing.T) {
+	if h := computeHealthScore(nil, 0); h.Score != 100 {
+		t.Fatalf("empty scan scored %v", h.Score)
+	}
+
+	hosts := []HostRecord{
+		{IP: "10.0.0.1", OS: "Linux", OnlineStatus: "online", Ports: []RemotePort{{Port: 22, State: "open"}}},
+		{IP: "10.0.0.2", OS: "Unknown", OnlineStatus: "online", Ports: []RemotePort{{Port: 23, State: "open"}}},
+		{IP: "10.0.0.3", OS: "Windows", OnlineStatus: "offline", Ports: []RemotePort{{Port: 3389, State: "filtered"}}},
+		{IP: "10.0.0.4", OS: "", OnlineStatus: "online"},
+	}
+	h := computeHealthScore(hosts, 1)
+	want := HealthScore{Score: 71, Reachable: 0.75, NoRiskyPorts: 0.75, OSKnown: 0.5, Completeness: 0.8, RiskyHosts: 1}
+	if h != want {
+		t.Fatalf("computeHealthScore = %+v, want %+v", h, want)
+	}
+}
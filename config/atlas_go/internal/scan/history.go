@@ -0,0 +1,141 @@
+package scan
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// OutputJSONLGzip is the --output value that archives every emitted payload.
+const OutputJSONLGzip = "jsonl-gzip"
+
+// DefaultHistoryPath is where --output jsonl-gzip appends runs by default.
+const DefaultHistoryPath = "/config/history/atlas-history.jsonl.gz"
+
+// HistoryOptions configures the append-only run archive.
+type HistoryOptions struct {
+	// Path is the active archive file; empty disables archiving.
+	Path string
+	// MaxBytes rotates the archive once it grows past this size. The file
+	// is also rotated when the UTC date changes. Zero disables size-based
+	// rotation.
+	MaxBytes int64
+}
+
+// historyRecord is one line of the archive: the payload as emitted plus the
+// time it was archived.
+type historyRecord struct {
+	ArchivedAt time.Time `json:"archived_at"`
+	RemotePayload
+}
+
+// ValidateOutput checks an --output value.
+func ValidateOutput(output string) error {
+	if output != "" && output != OutputJSONLGzip {
+		return fmt.Errorf("unknown output %q (valid: %s)", output, OutputJSONLGzip)
+	}
+	return nil
+}
+
+// appendHistory adds payload to the archive as its own gzip member holding a
+// single JSON line. The member is built in memory and written with one
+// append followed by fsync, so a crash can only leave a truncated final
+// member, which readHistory reports without losing earlier runs.
+func appendHistory(opts HistoryOptions, payload RemotePayload, now time.Time) error {
+	if err := os.MkdirAll(filepath.Dir(opts.Path), 0o755); err != nil {
+		return err
+	}
+	if err := rotateHistory(opts, now); err != nil {
+		return fmt.Errorf("rotate %s: %w", opts.Path, err)
+	}
+	line, err := json.Marshal(historyRecord{ArchivedAt: now.UTC(), RemotePayload: payload})
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(opts.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// rotateHistory renames the archive to <name>-<timestamp>.jsonl.gz when it
+// exceeds MaxBytes or was last written on an earlier UTC day. The timestamp
+// has nanosecond precision, and a -N suffix keeps a rotation from replacing
+// an earlier one on filesystems with coarser modification times.
+func rotateHistory(opts HistoryOptions, now time.Time) error {
+	info, err := os.Stat(opts.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	tooBig := opts.MaxBytes > 0 && info.Size() >= opts.MaxBytes
+	newDay := info.ModTime().UTC().Format("20060102") != now.UTC().Format("20060102")
+	if !tooBig && !newDay {
+		return nil
+	}
+	base := strings.TrimSuffix(opts.Path, ".jsonl.gz")
+	stamp := info.ModTime().UTC().Format("20060102T150405.000000000Z")
+	rotated := fmt.Sprintf("%s-%s.jsonl.gz", base, stamp)
+	for n := 1; ; n++ {
+		if _, err := os.Stat(rotated); errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		rotated = fmt.Sprintf("%s-%s-%d.jsonl.gz", base, stamp, n)
+	}
+	return os.Rename(opts.Path, rotated)
+}
+
+// readHistory returns every run archived in path, oldest first. A truncated
+// final member (an interrupted write) yields the runs before it together
+// with an error.
+func readHistory(path string) ([]historyRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(bufio.NewReader(f))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	var records []historyRecord
+	dec := json.NewDecoder(zr)
+	for {
+		var rec historyRecord
+		if err := dec.Decode(&rec); err != nil {
+			if errors.Is(err, io.EOF) {
+				return records, nil
+			}
+			return records, fmt.Errorf("%s: record %d: %w", path, len(records)+1, err)
+		}
+		records = append(records, rec)
+	}
+}
@@ -0,0 +1,72 @@
+package scan
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRotateHistoryKeepsEveryArchive(t *testing.T) {
+	dir := t.TempDir()
+	opts := HistoryOptions{Path: filepath.Join(dir, "atlas-history.jsonl.gz"), MaxBytes: 1}
+	mtime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	// Three rotations within one mtime tick, as on a filesystem that only
+	// keeps whole seconds.
+	for i := 0; i < 3; i++ {
+		if err := os.WriteFile(opts.Path, []byte{byte(i)}, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(opts.Path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+		if err := rotateHistory(opts, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rotated, _ := filepath.Glob(filepath.Join(dir, "atlas-history-*.jsonl.gz"))
+	if len(rotated) != 3 {
+		t.Fatalf("rotations replaced each other: %v", rotated)
+	}
+	if want := filepath.Join(dir, "atlas-history-20240501T120000.000000000Z.jsonl.gz"); rotated[2] != want {
+		t.Fatalf("rotated names %v, want the first one as %s", rotated, want)
+	}
+}
+
+func TestHistoryArchiveAppendsAndRotates(t *testing.T) {
+	dir := t.TempDir()
+	opts := HistoryOptions{Path: filepath.Join(dir, "atlas-history.jsonl.gz")}
+	now := time.Now()
+	first, second := samplePayload(), samplePayload()
+	first.Metadata = map[string]any{"run_id": "run-1"}
+	second.Metadata = map[string]any{"run_id": "run-2"}
+	for _, p := range []RemotePayload{first, second} {
+		if err := appendHistory(opts, p, now); err != nil {
+			t.Fatalf("appendHistory: %v", err)
+		}
+	}
+	records, err := readHistory(opts.Path)
+	if err != nil || len(records) != 2 || records[1].Metadata["run_id"] != "run-2" || records[0].Hosts[0].IP != "192.168.1.10" {
+		t.Fatalf("readHistory = %+v, %v", records, err)
+	}
+
+	// A torn final write keeps the runs before it.
+	f, err := os.OpenFile(opts.Path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0x1f, 0x8b, 0x08})
+	f.Close()
+	if records, err := readHistory(opts.Path); err == nil || len(records) != 2 {
+		t.Fatalf("truncated archive: %d records, err=%v", len(records), err)
+	}
+
+	opts.MaxBytes = 1
+	if err := appendHistory(opts, first, now); err != nil {
+		t.Fatal(err)
+	}
+	rotated, _ := filepath.Glob(filepath.Join(dir, "atlas-history-*.jsonl.gz"))
+	if records, err := readHistory(opts.Path); err != nil || len(records) != 1 || len(rotated) != 1 {
+		t.Fatalf("after rotation: %d records, %v rotated, err=%v", len(records), rotated, err)
+	}
+}
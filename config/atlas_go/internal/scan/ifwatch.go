@@ -0,0 +1,86 @@
+package scan
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"atlas/internal/utils"
+)
+
+// watchInterfaces reports topology changes that matter to the scanner: an
+// interface subnet that passes --min-prefix/--primary-interface appeared,
+// disappeared, or changed. Kernel link and address events are debounced, so
+// a VPN that flaps while connecting yields one report once the host has been
+// quiet for debounce. At most one report is pending at a time; changes that
+// arrive while the agent is scanning are picked up by that pending rescan.
+func watchInterfaces(ctx context.Context, minPrefix int, primary []string, debounce time.Duration) (<-chan string, error) {
+	events, err := linkEvents(ctx)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := func() map[string]string {
+		interfaces, err := utils.GetAllInterfaces()
+		if err != nil {
+			return nil
+		}
+		subnets := map[string]string{}
+		for _, iface := range sanitizeInterfaces(interfaces, minPrefix, primary, func(string, ...any) {}) {
+			subnets[iface.Name] = iface.Subnet
+		}
+		return subnets
+	}
+	changes := make(chan string, 1)
+	go func() {
+		current := snapshot()
+		var quiet <-chan time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case _, ok := <-events:
+				if !ok {
+					return
+				}
+				quiet = time.After(debounce)
+			case <-quiet:
+				quiet = nil
+				next := snapshot()
+				if next == nil {
+					continue
+				}
+				if reason := describeInterfaceChange(current, next); reason != "" {
+					current = next
+					select {
+					case changes <- reason:
+					default:
+					}
+				}
+			}
+		}
+	}()
+	return changes, nil
+}
+
+// describeInterfaceChange summarizes how the interface subnets in after
+// differ from before, or returns "" when they are the same.
+func describeInterfaceChange(before, after map[string]string) string {
+	var parts []string
+	for name, subnet := range after {
+		switch old, ok := before[name]; {
+		case !ok:
+			parts = append(parts, fmt.Sprintf("%s up (%s)", name, subnet))
+		case old != subnet:
+			parts = append(parts, fmt.Sprintf("%s moved %s -> %s", name, old, subnet))
+		}
+	}
+	for name, subnet := range before {
+		if _, ok := after[name]; !ok {
+			parts = append(parts, fmt.Sprintf("%s down (%s)", name, subnet))
+		}
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ", ")
+}
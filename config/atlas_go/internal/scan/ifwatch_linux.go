@@ -0,0 +1,77 @@
+package scan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"syscall"
+)
+
+// rtnetlink multicast groups from <linux/rtnetlink.h>; package syscall does
+// not export them.
+const (
+	rtmgrpLink       = 0x1
+	rtmgrpIPv4IfAddr = 0x10
+	rtmgrpIPv6IfAddr = 0x100
+)
+
+// linkEvents subscribes to rtnetlink link and address notifications and
+// signals on the returned channel for each batch received. The channel is
+// closed when ctx ends or the socket fails.
+func linkEvents(ctx context.Context) (<-chan struct{}, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return nil, fmt.Errorf("netlink socket: %w", err)
+	}
+	sa := &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: rtmgrpLink | rtmgrpIPv4IfAddr | rtmgrpIPv6IfAddr}
+	if err := syscall.Bind(fd, sa); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("netlink bind: %w", err)
+	}
+	// A receive timeout lets the reader notice ctx ending; closing the fd
+	// from another goroutine does not wake a blocked recvfrom.
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &syscall.Timeval{Sec: 1}); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("netlink timeout: %w", err)
+	}
+	events := make(chan struct{}, 1)
+	go func() {
+		defer close(events)
+		defer syscall.Close(fd)
+		buf := make([]byte, 1<<16)
+		for ctx.Err() == nil {
+			n, _, err := syscall.Recvfrom(fd, buf, 0)
+			if err != nil {
+				if errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EINTR) {
+					continue
+				}
+				// ENOBUFS means events were dropped; the interfaces are
+				// re-read after the debounce anyway, so treat it as one.
+				if !errors.Is(err, syscall.ENOBUFS) {
+					return
+				}
+			} else if !isLinkEvent(buf[:n]) {
+				continue
+			}
+			select {
+			case events <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return events, nil
+}
+
+func isLinkEvent(b []byte) bool {
+	msgs, err := syscall.ParseNetlinkMessage(b)
+	if err != nil {
+		return true
+	}
+	for _, m := range msgs {
+		switch m.Header.Type {
+		case syscall.RTM_NEWLINK, syscall.RTM_DELLINK, syscall.RTM_NEWADDR, syscall.RTM_DELADDR:
+			return true
+		}
+	}
+	return false
+}
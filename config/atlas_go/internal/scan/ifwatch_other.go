@@ -0,0 +1,13 @@
+//go:build !linux
+
+package scan
+
+import (
+	"context"
+	"errors"
+)
+
+// linkEvents needs rtnetlink, which only Linux has.
+func linkEvents(ctx context.Context) (<-chan struct{}, error) {
+	return nil, errors.New("interface watching is only supported on Linux")
+}
@@ -0,0 +1,15 @@
+package scan
+
+import "testing"
+
+func TestDescribeInterfaceChange(t *testing.T) {
+	before := map[string]string{"eth0": "10.0.0.0/24", "wg0": "10.8.0.0/24"}
+	if got := describeInterfaceChange(before, map[string]string{"wg0": "10.8.0.0/24", "eth0": "10.0.0.0/24"}); got != "" {
+		t.Errorf("unchanged interfaces reported %q", got)
+	}
+	after := map[string]string{"eth0": "10.0.1.0/24", "tun0": "10.9.0.0/24"}
+	want := "eth0 moved 10.0.0.0/24 -> 10.0.1.0/24, tun0 up (10.9.0.0/24), wg0 down (10.8.0.0/24)"
+	if got := describeInterfaceChange(before, after); got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,70 @@
+package scan
+
+import (
+	"sync"
+	"time"
+)
+
+// IncrementalCache remembers each host's last full scan so later agent runs
+// only port scan hosts that are new or whose MAC changed (--incremental).
+// Reused hosts are still pinged and reported with their cached ports.
+type IncrementalCache struct {
+	mu    sync.Mutex
+	hosts map[string]HostRecord
+	full  bool
+}
+
+// NewIncrementalCache returns an empty cache; the first run is always full.
+func NewIncrementalCache() *IncrementalCache {
+	return &IncrementalCache{hosts: map[string]HostRecord{}}
+}
+
+// BeginRun starts a run. A full run scans every host and refreshes the cache.
+func (c *IncrementalCache) BeginRun(full bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.full = full
+}
+
+// lookup returns the cached record for ip when this is not a full run and
+// the host still answers with the MAC it had when it was last scanned. A nil
+// cache never hits.
+func (c *IncrementalCache) lookup(ip, mac string) (HostRecord, bool) {
+	if c == nil {
+		return HostRecord{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	prev, ok := c.hosts[ip]
+	if c.full || !ok || prev.MAC != mac {
+		return HostRecord{}, false
+	}
+	return prev, true
+}
+
+// store records a freshly scanned host.
+func (c *IncrementalCache) store(record HostRecord) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.hosts[record.IP] = record
+}
+
+// reuseCachedHost turns a cached scan into this run's record: the ports, OS,
+// and MAC are kept, while presence, hostname, and run metadata are current.
+// last_full_scan tells the controller how old the port data is.
+func reuseCachedHost(prev HostRecord, hostname, status, runID string) HostRecord {
+	record := prev
+	record.Hostname = hostname
+	record.OnlineStatus = status
+	record.LastSeen = time.Now()
+	record.Metadata = make(map[string]any, len(prev.Metadata)+1)
+	for k, v := range prev.Metadata {
+		record.Metadata[k] = v
+	}
+	record.Metadata["run_id"] = runID
+	record.Metadata["last_full_scan"] = prev.LastSeen.UTC().Format(time.RFC3339)
+	return record
+}
@@ -0,0 +1,49 @@
+package scan
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIncrementalDeepScanReusesUnchangedHosts(t *testing.T) {
+	useFakeRunner(t, func(name string, args []string) ([]byte, error) {
+		return nil, fmt.Errorf("%s ran outside the replay", name)
+	})
+	capture := filepath.Join(t.TempDir(), "capture.gnmap")
+	if err := os.WriteFile(capture, []byte(grepableFixture), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cache := NewIncrementalCache()
+	opts := DeepScanOptions{SkipDB: true, Replay: capture, TCP: scanProfiles["standard"], Incremental: cache}
+	opts.Remote.JSONOutPath = filepath.Join(t.TempDir(), "payload.json")
+	run := func(full bool) RemoteHostPayload {
+		t.Helper()
+		cache.BeginRun(full)
+		if err := DeepScan(opts); err != nil {
+			t.Fatalf("DeepScan: %v", err)
+		}
+		b, err := os.ReadFile(opts.Remote.JSONOutPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var payload RemotePayload
+		if err := json.Unmarshal(b, &payload); err != nil || len(payload.Hosts) != 1 {
+			t.Fatalf("payload %s: %v", b, err)
+		}
+		return payload.Hosts[0]
+	}
+
+	if h := run(true); h.Metadata["last_full_scan"] != nil {
+		t.Fatalf("first run reused a scan: %v", h.Metadata)
+	}
+	h := run(false)
+	if h.Metadata["last_full_scan"] == nil || len(h.Ports) != 3 {
+		t.Fatalf("unchanged host was not reused: %+v", h)
+	}
+	if h := run(true); h.Metadata["last_full_scan"] != nil {
+		t.Fatalf("full run reused a scan: %v", h.Metadata)
+	}
+}
@@ -0,0 +1,151 @@
+package scan
+
+import (
+	"net"
+	"sort"
+
+	"atlas/internal/utils"
+)
+
+// sanitizeInterfaces drops interfaces whose subnet is malformed or broader
+// than minPrefix so bad interface data never turns into an enormous nmap run,
+// then collapses duplicate subnets (see collapseDuplicateSubnets).
+// minPrefix <= 0 selects utils.DefaultMinPrefixLen.
+func sanitizeInterfaces(interfaces []utils.InterfaceInfo, minPrefix int, primary []string, logf func(format string, args ...any)) []utils.InterfaceInfo {
+	if minPrefix <= 0 {
+		minPrefix = utils.DefaultMinPrefixLen
+	}
+	valid := make([]utils.InterfaceInfo, 0, len(interfaces))
+	for _, iface := range interfaces {
+		subnet, err := utils.ValidateScanSubnet(iface.Subnet, minPrefix)
+		if err != nil {
+			logf(utils.Deco("⚠️ Skipping interface %s: %v"), iface.Name, err)
+			continue
+		}
+		iface.Subnet = subnet
+		valid = append(valid, iface)
+	}
+	return collapseDuplicateSubnets(valid, primary, logf)
+}
+
+// collapseDuplicateSubnets keeps one interface per subnet so bonded or
+// bridged setups do not discover and scan the same hosts twice under two
+// names. When two interfaces share a subnet the one listed first in primary
+// wins, otherwise the first one detected. When one subnet is nested inside
+// another, an interface in primary also wins, even over a broader subnet;
+// between other interfaces the nested subnet is dropped because the broader
+// sweep covers it.
+func collapseDuplicateSubnets(interfaces []utils.InterfaceInfo, primary []string, logf func(format string, args ...any)) []utils.InterfaceInfo {
+	rank := func(name string) int {
+		for i, p := range primary {
+			if p == name {
+				return i
+			}
+		}
+		return len(primary)
+	}
+	type candidate struct {
+		iface utils.InterfaceInfo
+		net   *net.IPNet
+		ones  int
+		index int
+	}
+	candidates := make([]candidate, 0, len(interfaces))
+	for i, iface := range interfaces {
+		_, ipNet, err := net.ParseCIDR(iface.Subnet)
+		if err != nil {
+			continue
+		}
+		ones, _ := ipNet.Mask.Size()
+		candidates = append(candidates, candidate{iface: iface, net: ipNet, ones: ones, index: i})
+	}
+	// By preference, then broadest subnets first, so the survivor of each
+	// group is decided before its duplicates are seen.
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if ri, rj := rank(candidates[i].iface.Name), rank(candidates[j].iface.Name); ri != rj {
+			return ri < rj
+		}
+		return candidates[i].ones < candidates[j].ones
+	})
+	var kept []candidate
+	for _, c := range candidates {
+		duplicate := false
+		for _, k := range kept {
+			if len(k.net.IP) != len(c.net.IP) {
+				continue
+			}
+			if k.net.Contains(c.net.IP) {
+				logf("Subnet %s on %s is already covered by %s on %s; scanning it once", c.iface.Subnet, c.iface.Name, k.iface.Subnet, k.iface.Name)
+				duplicate = true
+				break
+			}
+			if c.net.Contains(k.net.IP) {
+				logf("Subnet %s on %s contains %s on preferred interface %s; scanning only %s", c.iface.Subnet, c.iface.Name, k.iface.Subnet, k.iface.Name, k.iface.Subnet)
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			kept = append(kept, c)
+		}
+	}
+	sort.Slice(kept, func(i, j int) bool { return kept[i].index < kept[j].index })
+	result := make([]utils.InterfaceInfo, len(kept))
+	for i, k := range kept {
+		result[i] = k.iface
+	}
+	return result
+}
+
+// sourceArgs returns the nmap flags that send probes out of iface from
+// sourceIP. Either may be empty.
+func sourceArgs(iface, sourceIP string) []string {
+	var args []string
+	if iface != "" {
+		args = append(args, "-e", iface)
+	}
+	if sourceIP != "" {
+		args = append(args, "-S", sourceIP)
+	}
+	return args
+}
+
+// interfaceBindings maps interface names to the NIC and source address
+// their subnet should be scanned from. Without root nmap cannot spoof the
+// source with -S, so only the interface is kept.
+func interfaceBindings(interfaces []utils.InterfaceInfo, privileged bool, logf func(format string, args ...any)) map[string]utils.InterfaceInfo {
+	if !privileged {
+		logf(utils.Deco("⚠️ Not running as root; --bind-interface passes -e only (-S needs raw sockets)"))
+	}
+	bindings := make(map[string]utils.InterfaceInfo, len(interfaces))
+	for _, iface := range interfaces {
+		if !privileged {
+			iface.IP = ""
+		}
+		bindings[iface.Name] = iface
+	}
+	return bindings
+}
+
+// selfAddresses returns the agent's own interface addresses so the hosts
+// they belong to can be tagged is_self.
+func selfAddresses(interfaces []utils.InterfaceInfo) map[string]bool {
+	self := make(map[string]bool, len(interfaces))
+	for _, iface := range interfaces {
+		if iface.IP != "" {
+			self[iface.IP] = true
+		}
+	}
+	return self
+}
+
+// tagSelf marks record is_self when its IP is one of self's addresses.
+func tagSelf(record *HostRecord, self map[string]bool) {
+	if !self[record.IP] {
+		return
+	}
+	if record.Metadata == nil {
+		record.Metadata = map[string]any{}
+	}
+	record.Metadata["is_self"] = true
+}
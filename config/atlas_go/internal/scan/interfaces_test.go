@@ -0,0 +1,80 @@
+package scan
+
+import (
+	"strings"
+	"testing"
+
+	"atlas/internal/utils"
+)
+
+func TestTagSelfMarksOwnAddresses(t *testing.T) {
+	self := selfAddresses([]utils.InterfaceInfo{
+		{Name: "eth0", Subnet: "192.168.1.0/24", IP: "192.168.1.5"},
+		{Name: "wlan0", Subnet: "10.0.0.0/24"},
+	})
+	// Fast scan records carry metadata; a bare record gets a map.
+	own := HostRecord{IP: "192.168.1.5", Metadata: map[string]any{"scanner": "fastscan"}}
+	bare := HostRecord{IP: "192.168.1.5"}
+	other := HostRecord{IP: "192.168.1.6", Metadata: map[string]any{"scanner": "presencescan"}}
+	for _, r := range []*HostRecord{&own, &bare, &other} {
+		tagSelf(r, self)
+	}
+	if own.Metadata["is_self"] != true || own.Metadata["scanner"] != "fastscan" || bare.Metadata["is_self"] != true {
+		t.Fatalf("own address not tagged: %v / %v", own.Metadata, bare.Metadata)
+	}
+	if _, ok := other.Metadata["is_self"]; ok {
+		t.Fatalf("another host tagged is_self: %v", other.Metadata)
+	}
+	if meta := own.ToRemoteHostPayload("").Metadata; meta["is_self"] != true {
+		t.Fatalf("is_self missing from the payload: %v", meta)
+	}
+	if len(self) != 1 {
+		t.Fatalf("an interface without an address counted as self: %v", self)
+	}
+}
+
+func TestBindInterfaceArgs(t *testing.T) {
+	interfaces := []utils.InterfaceInfo{{Name: "eth1", Subnet: "10.1.0.0/24", IP: "10.1.0.2"}}
+	bindings := interfaceBindings(interfaces, true, func(string, ...any) {})
+	tcp := scanProfiles["quick"]
+	tcp.Interface, tcp.SourceIP = bindings["eth1"].Name, bindings["eth1"].IP
+	got := strings.Join(tcp.nmapArgs("10.1.0.9", "out.log"), " ")
+	if want := "-Pn --top-ports 100 -T4 -e eth1 -S 10.1.0.2 10.1.0.9 -oG out.log"; got != want {
+		t.Fatalf("want %q, got %q", want, got)
+	}
+
+	var warned bool
+	bindings = interfaceBindings(interfaces, false, func(string, ...any) { warned = true })
+	if b := bindings["eth1"]; b.Name != "eth1" || b.IP != "" || !warned {
+		t.Fatalf("unprivileged binding should drop the source address and warn: %+v (warned=%v)", b, warned)
+	}
+}
+
+func TestSanitizeInterfacesCollapsesDuplicateSubnets(t *testing.T) {
+	interfaces := []utils.InterfaceInfo{
+		{Name: "eth0", Subnet: "192.168.1.0/24", IP: "192.168.1.5"},
+		{Name: "bond0", Subnet: "192.168.1.0/24", IP: "192.168.1.6"},
+		{Name: "eth2", Subnet: "10.0.5.0/24", IP: "10.0.5.2"},
+		{Name: "eth1", Subnet: "10.0.0.0/16", IP: "10.0.0.2"},
+	}
+	names := func(ifaces []utils.InterfaceInfo) string {
+		var out []string
+		for _, i := range ifaces {
+			out = append(out, i.Name+"="+i.Subnet)
+		}
+		return strings.Join(out, " ")
+	}
+	var logged int
+	logf := func(string, ...any) { logged++ }
+
+	if got := names(sanitizeInterfaces(interfaces, 16, nil, logf)); got != "eth0=192.168.1.0/24 eth1=10.0.0.0/16" || logged != 2 {
+		t.Fatalf("default: %s (logged %d)", got, logged)
+	}
+	if got := names(sanitizeInterfaces(interfaces, 16, []string{"bond0"}, logf)); got != "bond0=192.168.1.0/24 eth1=10.0.0.0/16" {
+		t.Fatalf("primary bond0: %s", got)
+	}
+	// A preferred interface wins over a broader subnet that contains its own.
+	if got := names(sanitizeInterfaces(interfaces, 16, []string{"eth2"}, logf)); got != "eth0=192.168.1.0/24 eth2=10.0.5.0/24" {
+		t.Fatalf("primary eth2: %s", got)
+	}
+}
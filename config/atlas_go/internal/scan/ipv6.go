@@ -0,0 +1,191 @@
+package scan
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"atlas/internal/utils"
+)
+
+// ipv6Neighbor is one usable entry of the kernel IPv6 neighbor table.
+type ipv6Neighbor struct {
+	IP        string
+	MAC       string
+	Interface string
+	Router    bool
+}
+
+// discoverIPv6Neighbors pings the all-nodes multicast group (ff02::1) out of
+// each interface, which makes every IPv6 host on the link answer and land in
+// the neighbor cache, then reads that cache. This finds devices that only
+// speak IPv6, often only with a link-local address.
+func discoverIPv6Neighbors(ctx context.Context, interfaces []utils.InterfaceInfo, logf func(format string, args ...any)) []ipv6Neighbor {
+	var neighbors []ipv6Neighbor
+	seen := map[string]bool{}
+	for _, iface := range interfaces {
+		if seen[iface.Name] {
+			continue
+		}
+		seen[iface.Name] = true
+		// Hosts answer even when ping itself reports an error, e.g. for
+		// duplicate replies, so only the neighbor table decides.
+		_, _ = utils.RunCommand(ctx, "ping", "-6", "-c", "2", "-W", "1", "-I", iface.Name, "ff02::1")
+		out, err := utils.RunCommand(ctx, "ip", "-6", "neigh", "show", "dev", iface.Name)
+		if err != nil {
+			logf(utils.Deco("⚠️ Could not read IPv6 neighbors on %s: %v"), iface.Name, err)
+			continue
+		}
+		found := parseIPv6Neighbors(string(out), iface.Name)
+		logf("IPv6 neighbor discovery found %d addresses on %s", len(found), iface.Name)
+		neighbors = append(neighbors, found...)
+	}
+	return neighbors
+}
+
+// parseIPv6Neighbors reads `ip -6 neigh show dev <iface>` output, keeping
+// entries with a link-layer address that the kernel considers reachable or
+// recently reachable.
+func parseIPv6Neighbors(out, iface string) []ipv6Neighbor {
+	var neighbors []ipv6Neighbor
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 || net.ParseIP(fields[0]) == nil {
+			continue
+		}
+		switch fields[len(fields)-1] {
+		case "REACHABLE", "STALE", "DELAY", "PROBE":
+		default:
+			continue
+		}
+		n := ipv6Neighbor{IP: fields[0], Interface: iface}
+		for i, f := range fields {
+			switch {
+			case f == "lladdr" && i+1 < len(fields):
+				n.MAC = strings.ToLower(fields[i+1])
+			case f == "router":
+				n.Router = true
+			}
+		}
+		if n.MAC != "" {
+			neighbors = append(neighbors, n)
+		}
+	}
+	return neighbors
+}
+
+// ipv6Index groups discovered IPv6 neighbors by MAC, so a dual-stack device
+// stays one entry: each IPv4 host claims its device's addresses as it
+// finishes (claim), and the devices no host claimed become records of their
+// own (unclaimed). A nil index has no neighbors.
+type ipv6Index struct {
+	mu      sync.Mutex
+	byMAC   map[string][]ipv6Neighbor
+	macs    []string
+	claimed map[string]bool
+}
+
+func newIPv6Index(neighbors []ipv6Neighbor) *ipv6Index {
+	if len(neighbors) == 0 {
+		return nil
+	}
+	x := &ipv6Index{byMAC: map[string][]ipv6Neighbor{}, claimed: map[string]bool{}}
+	for _, n := range neighbors {
+		if _, ok := x.byMAC[n.MAC]; !ok {
+			x.macs = append(x.macs, n.MAC)
+		}
+		x.byMAC[n.MAC] = append(x.byMAC[n.MAC], n)
+	}
+	return x
+}
+
+// claim attaches the addresses of the neighbors sharing host's MAC as
+// ipv6_addresses metadata. It is safe for concurrent use.
+func (x *ipv6Index) claim(host *HostRecord) {
+	if x == nil || host.IP == "" || strings.Contains(host.IP, ":") {
+		return
+	}
+	mac := strings.ToLower(host.MAC)
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	group, ok := x.byMAC[mac]
+	if !ok {
+		return
+	}
+	if host.Metadata == nil {
+		host.Metadata = map[string]any{}
+	}
+	host.Metadata["ipv6_addresses"] = neighborAddresses(group)
+	x.claimed[mac] = true
+}
+
+// unclaimed groups the neighbors no IPv4 host claimed by MAC into new
+// records (ipv6_only), addressed by a global address when the device has one
+// and by its link-local address otherwise. Their OnlineStatus is left for
+// the caller to decide.
+func (x *ipv6Index) unclaimed(runID string) []HostRecord {
+	if x == nil {
+		return nil
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	var extra []HostRecord
+	for _, mac := range x.macs {
+		if x.claimed[mac] {
+			continue
+		}
+		group := x.byMAC[mac]
+		addrs := neighborAddresses(group)
+		record := HostRecord{
+			IP:            addrs[0],
+			Hostname:      "NoName",
+			OS:            "Unknown",
+			MAC:           mac,
+			PortSummary:   "Unknown",
+			InterfaceName: group[0].Interface,
+			NetworkName:   "LAN",
+			LastSeen:      time.Now(),
+			Metadata: map[string]any{
+				"scanner":        "deepscan",
+				"run_id":         runID,
+				"ipv6_only":      true,
+				"ipv6_addresses": addrs,
+			},
+		}
+		for _, n := range group {
+			if n.Router {
+				record.Metadata["ipv6_router"] = true
+			}
+		}
+		extra = append(extra, record)
+	}
+	return extra
+}
+
+// pingTarget is addr as ping takes it: a link-local address only routes with
+// its interface as the zone.
+func pingTarget(addr, iface string) string {
+	if ip := net.ParseIP(addr); ip != nil && ip.IsLinkLocalUnicast() && iface != "" {
+		return addr + "%" + iface
+	}
+	return addr
+}
+
+// neighborAddresses lists a device's addresses, global ones first.
+func neighborAddresses(group []ipv6Neighbor) []string {
+	addrs := make([]string, 0, len(group))
+	for _, n := range group {
+		addrs = append(addrs, n.IP)
+	}
+	sort.SliceStable(addrs, func(i, j int) bool {
+		li, lj := net.ParseIP(addrs[i]).IsLinkLocalUnicast(), net.ParseIP(addrs[j]).IsLinkLocalUnicast()
+		if li != lj {
+			return lj
+		}
+		return addrs[i] < addrs[j]
+	})
+	return addrs
+}
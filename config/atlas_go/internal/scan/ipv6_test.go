@@ -0,0 +1,48 @@
+package scan
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIPv6NeighborsMergeByMAC(t *testing.T) {
+	out := "fe80::1 lladdr AA:BB:CC:00:00:01 router REACHABLE\n" +
+		"fe80::20 lladdr aa:bb:cc:00:00:20 STALE\n" +
+		"2001:db8::20 lladdr aa:bb:cc:00:00:20 DELAY\n" +
+		"fe80::30 lladdr aa:bb:cc:00:00:30 FAILED\n" +
+		"fe80::40 INCOMPLETE\n"
+	neighbors := parseIPv6Neighbors(out, "eth0")
+	if len(neighbors) != 3 || !neighbors[0].Router || neighbors[0].MAC != "aa:bb:cc:00:00:01" {
+		t.Fatalf("unexpected neighbors %+v", neighbors)
+	}
+
+	index := newIPv6Index(neighbors)
+	host := HostRecord{IP: "192.168.1.1", MAC: "AA:BB:CC:00:00:01", InterfaceName: "eth0"}
+	index.claim(&host)
+	if got := host.Metadata["ipv6_addresses"]; !reflect.DeepEqual(got, []string{"fe80::1"}) {
+		t.Errorf("dual-stack host not correlated: %v", host.Metadata)
+	}
+	extra := index.unclaimed("run-1")
+	if len(extra) != 1 {
+		t.Fatalf("expected one IPv6-only device, got %+v", extra)
+	}
+	h := extra[0]
+	if h.IP != "2001:db8::20" || h.MAC != "aa:bb:cc:00:00:20" || h.Metadata["ipv6_only"] != true ||
+		!reflect.DeepEqual(h.Metadata["ipv6_addresses"], []string{"2001:db8::20", "fe80::20"}) {
+		t.Errorf("unexpected IPv6-only record %+v", h)
+	}
+	if h.OnlineStatus != "" {
+		t.Errorf("IPv6-only status %q decided before liveness", h.OnlineStatus)
+	}
+	if got := pingTarget("fe80::20", "eth0"); got != "fe80::20%eth0" {
+		t.Errorf("link-local ping target %q", got)
+	}
+	if got := pingTarget("2001:db8::20", "eth0"); got != "2001:db8::20" {
+		t.Errorf("global ping target %q", got)
+	}
+	var none *ipv6Index
+	none.claim(&host)
+	if none.unclaimed("run-1") != nil {
+		t.Error("nil index reported devices")
+	}
+}
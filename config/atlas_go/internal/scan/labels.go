@@ -0,0 +1,66 @@
+package scan
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var labelKeyPattern = regexp.MustCompile(`^[a-z][a-z0-9_.-]{0,62}$`)
+
+// reservedMetadataKeys are written by the scanners themselves; --label may
+// not replace them.
+var reservedMetadataKeys = map[string]bool{
+	"scanner": true, "subnet": true, "interface_name": true, "network_name": true,
+	"next_hop": true, "first_seen": true, "online_status": true, "gateway_ip": true,
+	"gateway_mac": true, "gateway_vendor": true, "is_gateway": true, "hop_path": true, "hop_count": true,
+	"target_hostname": true, "run_id": true, "mac_vendor": true, "fingerprint": true,
+	"scan_count": true, "ping_success_rate": true, "port_observations": true, "rescanned_empty": true,
+	"last_full_scan": true, "ssh_host_key_sha256": true, "is_self": true, "mac_conflict": true,
+	"scan_error": true, "liveness_signals": true,
+	"nmap_raw": true, "nmap_raw_path": true, "ipv6_addresses": true, "ipv6_only": true,
+	"ipv6_router": true, "vlan_id": true, "vlan_parent": true, "probe_port": true,
+	"agent_id": true, "first_agent": true, "os_source": true, "seen_interfaces": true, "seen_ips": true,
+}
+
+// ParseLabel splits a --label key=value pair and validates the key: lowercase
+// letters, digits, '_', '.', and '-', starting with a letter, and not one of
+// the scanner-owned metadata keys.
+func ParseLabel(pair string) (string, string, error) {
+	key, value, ok := strings.Cut(pair, "=")
+	key = strings.TrimSpace(key)
+	if !ok {
+		return "", "", fmt.Errorf("label %q: expected key=value", pair)
+	}
+	if !labelKeyPattern.MatchString(key) {
+		return "", "", fmt.Errorf("label %q: key must match %s", pair, labelKeyPattern)
+	}
+	if reservedMetadataKeys[key] {
+		return "", "", fmt.Errorf("label %q: %s is set by the scanner", pair, key)
+	}
+	return key, strings.TrimSpace(value), nil
+}
+
+// applyLabels merges labels into every host's metadata. Keys a scanner has
+// already set on a host are left alone.
+func applyLabels(hosts []HostRecord, labels map[string]string) {
+	if len(labels) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for i := range hosts {
+		if hosts[i].Metadata == nil {
+			hosts[i].Metadata = map[string]any{}
+		}
+		for _, k := range keys {
+			if _, exists := hosts[i].Metadata[k]; !exists {
+				hosts[i].Metadata[k] = labels[k]
+			}
+		}
+	}
+}
@@ -0,0 +1,21 @@
+package scan
+
+import "testing"
+
+func TestLabels(t *testing.T) {
+	for _, bad := range []string{"env", "Env=prod", "scanner=me", "1x=y"} {
+		if _, _, err := ParseLabel(bad); err == nil {
+			t.Errorf("ParseLabel(%q): expected error", bad)
+		}
+	}
+	key, value, err := ParseLabel("location=nyc")
+	if err != nil || key != "location" || value != "nyc" {
+		t.Fatalf("ParseLabel: %q %q %v", key, value, err)
+	}
+
+	hosts := []HostRecord{{IP: "10.0.0.1", Metadata: map[string]any{"scanner": "deepscan", "env": "lab"}}, {IP: "10.0.0.2"}}
+	applyLabels(hosts, map[string]string{"env": "prod", "location": "nyc"})
+	if hosts[0].Metadata["env"] != "lab" || hosts[0].Metadata["location"] != "nyc" || hosts[1].Metadata["env"] != "prod" {
+		t.Fatalf("unexpected metadata %v / %v", hosts[0].Metadata, hosts[1].Metadata)
+	}
+}
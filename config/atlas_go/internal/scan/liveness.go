@@ -0,0 +1,94 @@
+package scan
+
+import "fmt"
+
+// Liveness policies accepted by --liveness. They decide a host's
+// OnlineStatus from the signals a scan collected:
+//
+//   - any (default): online if the host answered ping, showed an open or
+//     filtered port, or has a resolved entry in the kernel neighbor cache.
+//     ICMP filtering alone no longer makes a host look offline.
+//   - ping: online only if the host answered ping, as before.
+//   - ports: online only if a port answered. Needs a port scan, so the
+//     presence scan does not accept it.
+const (
+	LivenessAny   = "any"
+	LivenessPing  = "ping"
+	LivenessPorts = "ports"
+)
+
+// ValidateLiveness checks a --liveness value.
+func ValidateLiveness(policy string) error {
+	switch policy {
+	case "", LivenessAny, LivenessPing, LivenessPorts:
+		return nil
+	}
+	return fmt.Errorf("unknown liveness policy %q (valid: any, ping, ports)", policy)
+}
+
+// livenessSignals are the observations a host's online status is decided
+// from.
+type livenessSignals struct {
+	// Ping is true when the host answered ICMP or is one of this machine's
+	// addresses (utils.PingHost).
+	Ping bool
+	// Ports is true when the scan found any open or filtered port.
+	Ports bool
+	// Neighbor is true when the kernel holds a resolved ARP/NDP entry.
+	Neighbor bool
+}
+
+// gatherLiveness collects the signals for ip. ping is a PingHost result,
+// ports the host's parsed ports (nil when it was not port-scanned), and
+// neighbors the run's neighbor cache snapshot.
+func gatherLiveness(ip, ping string, ports []RemotePort, neighbors neighborTable) livenessSignals {
+	return livenessSignals{Ping: ping == "online", Ports: len(ports) > 0, Neighbor: neighbors[ip]}
+}
+
+// status applies policy to the signals.
+func (s livenessSignals) status(policy string) string {
+	var up bool
+	switch policy {
+	case LivenessPing:
+		up = s.Ping
+	case LivenessPorts:
+		up = s.Ports
+	default:
+		up = s.Ping || s.Ports || s.Neighbor
+	}
+	if up {
+		return "online"
+	}
+	return "offline"
+}
+
+// names lists the signals that fired, for liveness_signals metadata.
+func (s livenessSignals) names() []string {
+	names := []string{}
+	if s.Ping {
+		names = append(names, "ping")
+	}
+	if s.Ports {
+		names = append(names, "ports")
+	}
+	if s.Neighbor {
+		names = append(names, "neighbor")
+	}
+	return names
+}
+
+// neighborTable is a snapshot of the kernel neighbor cache: the addresses
+// with a resolved ARP entry or a live NDP entry.
+type neighborTable map[string]bool
+
+// readNeighborTable reads the neighbor cache once for a whole run. Scanners
+// take it after discovery, since the sweep is what fills the cache.
+func readNeighborTable() neighborTable {
+	table := neighborTable{}
+	v4, _ := readARPCache()
+	v6, _ := readIPv6Neighbors()
+	for _, ip := range append(v4, v6...) {
+		table[ip] = true
+	}
+	return table
+}
@@ -0,0 +1,58 @@
+package scan
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestLivenessPolicies(t *testing.T) {
+	cases := []struct {
+		signals          livenessSignals
+		any, ping, ports string
+	}{
+		{livenessSignals{}, "offline", "offline", "offline"},
+		{livenessSignals{Ping: true}, "online", "online", "offline"},
+		{livenessSignals{Ports: true}, "online", "offline", "online"},
+		{livenessSignals{Neighbor: true}, "online", "offline", "offline"},
+		{livenessSignals{Ping: true, Ports: true, Neighbor: true}, "online", "online", "online"},
+	}
+	for _, c := range cases {
+		for policy, want := range map[string]string{"": c.any, LivenessAny: c.any, LivenessPing: c.ping, LivenessPorts: c.ports} {
+			if got := c.signals.status(policy); got != want {
+				t.Errorf("%+v under %q: want %s, got %s", c.signals, policy, want, got)
+			}
+		}
+	}
+	if err := ValidateLiveness("icmp"); err == nil {
+		t.Error("unknown policy accepted")
+	}
+}
+
+func TestGatherLivenessUsesNeighborSnapshot(t *testing.T) {
+	path := t.TempDir() + "/arp"
+	arp := "IP address       HW type     Flags       HW address            Mask     Device\n" +
+		"192.168.1.1      0x1         0x2         aa:bb:cc:00:00:01     *        eth0\n" +
+		"192.168.1.7      0x1         0x0         00:00:00:00:00:00     *        eth0\n"
+	if err := os.WriteFile(path, []byte(arp), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	prev := arpCachePath
+	arpCachePath = path
+	t.Cleanup(func() { arpCachePath = prev })
+
+	neighbors := readNeighborTable()
+	// The snapshot is all gatherLiveness consults; the cache is not reread
+	// per host.
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	s := gatherLiveness("192.168.1.1", "offline", nil, neighbors)
+	if s != (livenessSignals{Neighbor: true}) || s.status(LivenessAny) != "online" {
+		t.Errorf("resolved ARP entry: %+v", s)
+	}
+	s = gatherLiveness("192.168.1.7", "offline", []RemotePort{{Port: 22, Protocol: "tcp", State: "filtered"}}, neighbors)
+	if s != (livenessSignals{Ports: true}) || !reflect.DeepEqual(s.names(), []string{"ports"}) {
+		t.Errorf("incomplete ARP entry with a filtered port: %+v", s)
+	}
+}
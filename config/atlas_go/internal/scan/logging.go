@@ -0,0 +1,24 @@
+package scan
+
+import (
+	"io"
+	"sync"
+)
+
+// lockedWriter serializes writes to w so concurrent host goroutines can share
+// one progress log without interleaving partial lines. Each Fprintf call is a
+// single Write and therefore lands intact.
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func newLockedWriter(w io.Writer) *lockedWriter {
+	return &lockedWriter{w: w}
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
@@ -0,0 +1,125 @@
+package scan
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+
+	"atlas/internal/utils"
+)
+
+// macLookup finds ip's MAC in one neighbor-table source; ok is false when the
+// source is unavailable or has no resolved entry.
+type macLookup func(ip string) (mac string, ok bool)
+
+// macLookups lists the sources for this platform, most direct first. Linux
+// reads /proc/net/arp and falls back to `ip neigh`, which also covers IPv6
+// and works where /proc is masked; macOS and the BSDs only have `arp -an`.
+func macLookups() []macLookup {
+	if runtime.GOOS == "linux" {
+		return []macLookup{procARPMAC, ipNeighMAC, arpCommandMAC}
+	}
+	return []macLookup{arpCommandMAC}
+}
+
+// getMacAddress returns ip's MAC from the first source that knows it, or
+// "Unknown".
+func getMacAddress(ip string) string {
+	for _, lookup := range macLookups() {
+		if mac, ok := lookup(ip); ok {
+			return mac
+		}
+	}
+	return "Unknown"
+}
+
+func procARPMAC(ip string) (string, bool) {
+	file, err := os.Open(arpCachePath)
+	if err != nil {
+		return "", false
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Scan() // Skip header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 4 && fields[0] == ip {
+			return normalizeMAC(fields[3])
+		}
+	}
+	return "", false
+}
+
+func ipNeighMAC(ip string) (string, bool) {
+	out, err := utils.RunCommand(context.Background(), "ip", "neigh", "show", ip)
+	if err != nil {
+		return "", false
+	}
+	return parseIPNeighMAC(string(out), ip)
+}
+
+// parseIPNeighMAC reads `ip neigh show <ip>` output, e.g.
+// "192.168.1.1 dev eth0 lladdr aa:bb:cc:dd:ee:ff REACHABLE". Entries without
+// an lladdr (INCOMPLETE, FAILED) are skipped.
+func parseIPNeighMAC(out, ip string) (string, bool) {
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || fields[0] != ip {
+			continue
+		}
+		for i, f := range fields {
+			if f == "lladdr" && i+1 < len(fields) {
+				return normalizeMAC(fields[i+1])
+			}
+		}
+	}
+	return "", false
+}
+
+func arpCommandMAC(ip string) (string, bool) {
+	out, err := utils.RunCommand(context.Background(), "arp", "-an")
+	if err != nil {
+		return "", false
+	}
+	return parseARPAnMAC(string(out), ip)
+}
+
+// parseARPAnMAC reads `arp -an` output, which every platform formats as
+// "? (192.168.1.1) at <mac> ...". macOS drops leading zeros from each byte
+// ("0:11:2:…"), so the MAC is normalized; "(incomplete)" and "<incomplete>"
+// entries are skipped.
+func parseARPAnMAC(out, ip string) (string, bool) {
+	want := "(" + ip + ")"
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		for i := 0; i+2 < len(fields); i++ {
+			if fields[i] == want && fields[i+1] == "at" {
+				return normalizeMAC(fields[i+2])
+			}
+		}
+	}
+	return "", false
+}
+
+// normalizeMAC returns mac as lowercase, zero-padded aa:bb:cc:dd:ee:ff;
+// unparsable and all-zero (unresolved) addresses are rejected.
+func normalizeMAC(mac string) (string, bool) {
+	parts := strings.Split(mac, ":")
+	for i, p := range parts {
+		if len(p) == 1 {
+			parts[i] = "0" + p
+		}
+	}
+	hw, err := net.ParseMAC(strings.Join(parts, ":"))
+	if err != nil || len(hw) != 6 {
+		return "", false
+	}
+	if hw.String() == "00:00:00:00:00:00" {
+		return "", false
+	}
+	return hw.String(), true
+}
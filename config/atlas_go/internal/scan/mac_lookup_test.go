@@ -0,0 +1,49 @@
+package scan
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestMACLookupParsersAndFallback(t *testing.T) {
+	macOS := "? (192.168.1.1) at 0:11:22:3:44:55 on en0 ifscope [ethernet]\n" +
+		"? (192.168.1.7) at (incomplete) on en0 ifscope [ethernet]\n"
+	if mac, ok := parseARPAnMAC(macOS, "192.168.1.1"); !ok || mac != "00:11:22:03:44:55" {
+		t.Fatalf("macOS arp -an: got %q, %v", mac, ok)
+	}
+	if _, ok := parseARPAnMAC(macOS, "192.168.1.7"); ok {
+		t.Fatal("incomplete entry should not resolve")
+	}
+	netTools := "? (10.0.0.5) at AA:BB:CC:00:00:05 [ether] on eth1\n? (10.0.0.6) at <incomplete> on eth1\n"
+	if mac, ok := parseARPAnMAC(netTools, "10.0.0.5"); !ok || mac != "aa:bb:cc:00:00:05" {
+		t.Fatalf("net-tools arp -an: got %q, %v", mac, ok)
+	}
+	if _, ok := parseARPAnMAC(netTools, "10.0.0.50"); ok {
+		t.Fatal("10.0.0.50 must not match 10.0.0.5")
+	}
+	neigh := "fe80::1 dev eth0 lladdr aa:bb:cc:dd:ee:01 router STALE\n192.168.1.8 dev eth0 FAILED\n"
+	if mac, ok := parseIPNeighMAC(neigh, "fe80::1"); !ok || mac != "aa:bb:cc:dd:ee:01" {
+		t.Fatalf("ip neigh: got %q, %v", mac, ok)
+	}
+	if _, ok := parseIPNeighMAC(neigh, "192.168.1.8"); ok {
+		t.Fatal("FAILED entry should not resolve")
+	}
+
+	// A masked /proc/net/arp falls through to the commands.
+	prev := arpCachePath
+	arpCachePath = filepath.Join(t.TempDir(), "missing")
+	t.Cleanup(func() { arpCachePath = prev })
+	useFakeRunner(t, func(name string, args []string) ([]byte, error) {
+		if name == "arp" {
+			return []byte(netTools), nil
+		}
+		return nil, fmt.Errorf("%s not available", name)
+	})
+	if got := getMacAddress("10.0.0.5"); got != "aa:bb:cc:00:00:05" {
+		t.Fatalf("expected fallback MAC, got %q", got)
+	}
+	if got := getMacAddress("10.0.0.6"); got != "Unknown" {
+		t.Fatalf("expected Unknown when no source resolves, got %q", got)
+	}
+}
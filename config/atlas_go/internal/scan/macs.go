@@ -0,0 +1,95 @@
+package scan
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+
+	"atlas/internal/utils"
+)
+
+// hashMAC replaces mac with a stable pseudonym: the first five bytes of
+// HMAC-SHA256(salt, lowercased mac) behind a locally administered 02 prefix,
+// so the result still looks like a MAC to the controller. "" and "Unknown"
+// are returned unchanged.
+func hashMAC(mac, salt string) string {
+	if mac == "" || mac == "Unknown" {
+		return mac
+	}
+	h := hmac.New(sha256.New, []byte(salt))
+	h.Write([]byte(strings.ToLower(mac)))
+	sum := h.Sum(nil)
+	return fmt.Sprintf("02:%02x:%02x:%02x:%02x:%02x", sum[0], sum[1], sum[2], sum[3], sum[4])
+}
+
+// hashPayloadMACs rewrites every MAC in payload, including gateway_mac
+// metadata and the agent's own interfaces, and recomputes each host's
+// fingerprint over the hashed MAC. Host records, and so the local database,
+// keep the raw values.
+func hashPayloadMACs(payload *RemotePayload, salt string) {
+	if ifaces, ok := payload.Metadata["interfaces"].([]utils.InterfaceDetails); ok {
+		hashed := make([]utils.InterfaceDetails, len(ifaces))
+		for i, iface := range ifaces {
+			iface.MAC = hashMAC(iface.MAC, salt)
+			hashed[i] = iface
+		}
+		meta := make(map[string]any, len(payload.Metadata))
+		for k, v := range payload.Metadata {
+			meta[k] = v
+		}
+		meta["interfaces"] = hashed
+		payload.Metadata = meta
+	}
+	for i := range payload.Hosts {
+		host := &payload.Hosts[i]
+		host.MAC = hashMAC(host.MAC, salt)
+		if mac, ok := host.Metadata["gateway_mac"].(string); ok {
+			host.Metadata["gateway_mac"] = hashMAC(mac, salt)
+		}
+		if _, ok := host.Metadata["fingerprint"]; ok {
+			host.Metadata["fingerprint"] = fingerprint(host.IP, host.MAC, host.OS, host.Ports)
+		}
+	}
+}
+
+// flagMACConflicts marks hosts whose MAC is also reported for another IP in
+// the same run with mac_conflict metadata and logs one warning per MAC. Two
+// addresses answering with one MAC point at ARP spoofing or a misconfigured
+// device; a multi-homed host or proxy ARP can cause it legitimately.
+func flagMACConflicts(hosts []HostRecord, logf func(format string, args ...any)) int {
+	byMAC := map[string][]int{}
+	var order []string
+	for i, h := range hosts {
+		mac := strings.ToLower(h.MAC)
+		if mac == "" || mac == "unknown" {
+			continue
+		}
+		if _, ok := byMAC[mac]; !ok {
+			order = append(order, mac)
+		}
+		byMAC[mac] = append(byMAC[mac], i)
+	}
+	conflicts := 0
+	for _, mac := range order {
+		idx := byMAC[mac]
+		ips := map[string]bool{}
+		for _, i := range idx {
+			ips[hosts[i].IP] = true
+		}
+		if len(ips) < 2 {
+			continue
+		}
+		conflicts++
+		list := make([]string, 0, len(idx))
+		for _, i := range idx {
+			if hosts[i].Metadata == nil {
+				hosts[i].Metadata = map[string]any{}
+			}
+			hosts[i].Metadata["mac_conflict"] = true
+			list = append(list, hosts[i].IP)
+		}
+		logf(utils.Deco("⚠️ MAC %s is claimed by %d addresses (%s); possible spoofing or a misconfigured device"), mac, len(ips), strings.Join(list, ", "))
+	}
+	return conflicts
+}
@@ -0,0 +1,84 @@
+package scan
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"atlas/internal/utils"
+)
+
+func TestFlagMACConflicts(t *testing.T) {
+	hosts := []HostRecord{
+		{IP: "192.168.1.10", MAC: "AA:BB:CC:DD:EE:FF"},
+		{IP: "192.168.1.11", MAC: "aa:bb:cc:dd:ee:ff", Metadata: map[string]any{"scanner": "deepscan"}},
+		{IP: "192.168.1.12", MAC: "11:22:33:44:55:66"},
+		{IP: "192.168.1.13", MAC: "Unknown"},
+		{IP: "192.168.1.14", MAC: "Unknown"},
+	}
+	var logged []string
+	n := flagMACConflicts(hosts, func(format string, args ...any) { logged = append(logged, fmt.Sprintf(format, args...)) })
+	if n != 1 || len(logged) != 1 || !strings.Contains(logged[0], "192.168.1.10, 192.168.1.11") {
+		t.Fatalf("conflicts=%d logged=%q", n, logged)
+	}
+	for i, h := range hosts {
+		if want := i < 2; (h.Metadata["mac_conflict"] == true) != want {
+			t.Errorf("host %s mac_conflict=%v, want %v", h.IP, h.Metadata["mac_conflict"], want)
+		}
+	}
+	if hosts[1].Metadata["scanner"] != "deepscan" {
+		t.Fatal("existing metadata was dropped")
+	}
+}
+
+func TestEmitHostsHashesMACs(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "payload.json")
+	hosts := samplePayloadHosts()
+	hosts[0].Metadata = map[string]any{"gateway_mac": hosts[0].MAC}
+	meta := map[string]any{"interfaces": []utils.InterfaceDetails{{Name: "eth0", MAC: "AA:BB:CC:DD:EE:FF", MTU: 1500}}}
+	if err := emitHosts(hosts, meta, RemotePayloadOptions{MACSalt: "s3cret", JSONOutPath: out}); err != nil {
+		t.Fatalf("emitHosts: %v", err)
+	}
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var payload RemotePayload
+	if err := json.Unmarshal(b, &payload); err != nil {
+		t.Fatal(err)
+	}
+
+	got := payload.Hosts[0]
+	want := hashMAC("AA:BB:CC:DD:EE:FF", "s3cret")
+	if got.MAC != want || got.Metadata["gateway_mac"] != want || !strings.HasPrefix(want, "02:") || len(want) != 17 {
+		t.Fatalf("payload MACs %q / %v, want %q", got.MAC, got.Metadata["gateway_mac"], want)
+	}
+	ifaces, _ := payload.Metadata["interfaces"].([]any)
+	if len(ifaces) != 1 || ifaces[0].(map[string]any)["mac"] != want {
+		t.Fatalf("interfaces metadata %v, want mac %q", payload.Metadata["interfaces"], want)
+	}
+	if meta["interfaces"].([]utils.InterfaceDetails)[0].MAC != "AA:BB:CC:DD:EE:FF" {
+		t.Fatal("caller's interface metadata was rewritten")
+	}
+	if hosts[0].MAC != "aa:bb:cc:dd:ee:ff" {
+		t.Fatalf("host record MAC was rewritten to %q", hosts[0].MAC)
+	}
+	if hashMAC("aa:bb:cc:dd:ee:ff", "other") == want || hashMAC("Unknown", "s3cret") != "Unknown" {
+		t.Fatal("hash should depend on the salt and leave Unknown alone")
+	}
+
+	// The fingerprint covers the hashed MAC, so hashing guesses at the raw
+	// MAC with the host's other fields never reproduces it.
+	raw := hosts[0]
+	raw.Metadata = nil
+	if fp := got.Metadata["fingerprint"]; fp == hostFingerprint(raw) {
+		t.Fatal("payload fingerprint was built from the raw MAC")
+	}
+	raw.MAC = want
+	if fp := got.Metadata["fingerprint"]; fp != hostFingerprint(raw) {
+		t.Fatalf("payload fingerprint %v, want one over the hashed MAC", fp)
+	}
+}
@@ -0,0 +1,156 @@
+package scan
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// mergePorts unions port lists by port and protocol, keeping the order in
+// which ports were first seen. When two lists report the same port the
+// richer observation wins (see preferPort).
+func mergePorts(lists ...[]RemotePort) []RemotePort {
+	var merged []RemotePort
+	index := map[string]int{}
+	for _, ports := range lists {
+		for _, p := range ports {
+			key := fmt.Sprintf("%d/%s", p.Port, p.Protocol)
+			i, exists := index[key]
+			if !exists {
+				index[key] = len(merged)
+				merged = append(merged, p)
+				continue
+			}
+			merged[i] = preferPort(merged[i], p)
+		}
+	}
+	return merged
+}
+
+// preferPort picks between two observations of one port: an open state beats
+// any other, then the one with more service detail. Service and version
+// fields the winner lacks are filled from the other observation.
+func preferPort(a, b RemotePort) RemotePort {
+	best, other := a, b
+	aOpen, bOpen := strings.Contains(a.State, "open"), strings.Contains(b.State, "open")
+	if (bOpen && !aOpen) || (aOpen == bOpen && portDetail(b) > portDetail(a)) {
+		best, other = b, a
+	}
+	if best.State == "" {
+		best.State = other.State
+	}
+	if best.Service == "" {
+		best.Service = other.Service
+	}
+	if best.Version == "" {
+		best.Version = other.Version
+	}
+	return best
+}
+
+func portDetail(p RemotePort) int {
+	n := 0
+	for _, field := range []string{p.State, p.Service, p.Version} {
+		if field != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// mergeHostRecords folds b into a for two records that identify the same
+// device, such as one host seen on two interfaces. Ports are unioned and the
+// summary rebuilt from the union; a's other fields win unless unknown or
+// empty. The host is online if either record saw it online.
+func mergeHostRecords(a, b HostRecord) HostRecord {
+	merged := a
+	merged.Ports = mergePorts(a.Ports, b.Ports)
+	merged.PortSummary = summarizePorts(merged.Ports)
+	if merged.Hostname == "" {
+		merged.Hostname = b.Hostname
+	}
+	if merged.OS == "" || merged.OS == "Unknown" {
+		merged.OS = b.OS
+	}
+	if merged.MAC == "" || merged.MAC == "Unknown" {
+		merged.MAC = b.MAC
+	}
+	if b.LastSeen.After(merged.LastSeen) {
+		merged.LastSeen = b.LastSeen
+	}
+	if b.OnlineStatus == "online" {
+		merged.OnlineStatus = "online"
+	}
+	if len(b.Metadata) > 0 {
+		meta := make(map[string]any, len(a.Metadata)+len(b.Metadata))
+		for k, v := range b.Metadata {
+			meta[k] = v
+		}
+		for k, v := range a.Metadata {
+			meta[k] = v
+		}
+		merged.Metadata = meta
+	}
+	return merged
+}
+
+// collapseSameHost merges records that share a known MAC, i.e. one device
+// scanned twice, into the first of them with mergeHostRecords. That covers a
+// --target given both by address and by name as well as a multi-homed device
+// answering on two interfaces under different IPs. Two IPs behind one MAC on
+// the same interface stay apart so flagMACConflicts can report them. The
+// merged record lists the interfaces it was found on as seen_interfaces
+// metadata and, when they differ, its addresses as seen_ips. It returns the
+// remaining hosts and the records that absorbed others.
+func collapseSameHost(hosts []HostRecord) (kept, merged []HostRecord) {
+	byMAC := map[string][]int{}
+	absorbed := map[int]bool{}
+	seenInterfaces := func(h HostRecord) []string {
+		if seen, ok := h.Metadata["seen_interfaces"].([]string); ok {
+			return seen
+		}
+		return []string{h.InterfaceName}
+	}
+	for _, h := range hosts {
+		mac := strings.ToLower(h.MAC)
+		if mac == "" || mac == "unknown" {
+			kept = append(kept, h)
+			continue
+		}
+		i := slices.IndexFunc(byMAC[mac], func(i int) bool {
+			return kept[i].IP == h.IP || !slices.Contains(seenInterfaces(kept[i]), h.InterfaceName)
+		})
+		if i < 0 {
+			byMAC[mac] = append(byMAC[mac], len(kept))
+			kept = append(kept, h)
+			continue
+		}
+		i = byMAC[mac][i]
+		seen := seenInterfaces(kept[i])
+		ips, _ := kept[i].Metadata["seen_ips"].([]string)
+		if ips == nil {
+			ips = []string{kept[i].IP}
+		}
+		kept[i] = mergeHostRecords(kept[i], h)
+		if kept[i].Metadata == nil {
+			kept[i].Metadata = map[string]any{}
+		}
+		if !slices.Contains(seen, h.InterfaceName) {
+			seen = append(seen, h.InterfaceName)
+		}
+		kept[i].Metadata["seen_interfaces"] = seen
+		if !slices.Contains(ips, h.IP) {
+			ips = append(ips, h.IP)
+		}
+		if len(ips) > 1 {
+			kept[i].Metadata["seen_ips"] = ips
+		}
+		absorbed[i] = true
+	}
+	for i := range kept {
+		if absorbed[i] {
+			merged = append(merged, kept[i])
+		}
+	}
+	return kept, merged
+}
@@ -0,0 +1,83 @@
+package scan
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeHostRecordsUnionsPorts(t *testing.T) {
+	eth := HostRecord{IP: "192.168.1.10", MAC: "aa:bb:cc:dd:ee:ff", OS: "Unknown", OnlineStatus: "offline", Ports: []RemotePort{
+		{Port: 22, Protocol: "tcp", State: "filtered"},
+		{Port: 80, Protocol: "tcp", State: "open", Service: "http"},
+	}}
+	wlan := HostRecord{IP: "192.168.2.10", MAC: "aa:bb:cc:dd:ee:ff", OS: "Linux", OnlineStatus: "online", Ports: []RemotePort{
+		{Port: 22, Protocol: "tcp", State: "open", Service: "ssh"},
+		{Port: 80, Protocol: "tcp", State: "open", Service: "http", Version: "nginx 1.24"},
+		{Port: 53, Protocol: "udp", State: "open", Service: "domain"},
+	}}
+
+	merged := mergeHostRecords(eth, wlan)
+	want := []RemotePort{
+		{Port: 22, Protocol: "tcp", State: "open", Service: "ssh"},
+		{Port: 80, Protocol: "tcp", State: "open", Service: "http", Version: "nginx 1.24"},
+		{Port: 53, Protocol: "udp", State: "open", Service: "domain"},
+	}
+	if !reflect.DeepEqual(merged.Ports, want) {
+		t.Fatalf("merged ports %+v, want %+v", merged.Ports, want)
+	}
+	if got := merged.PortsSummary(); got != "22/tcp (ssh), 80/tcp (http), 53/udp (domain)" {
+		t.Fatalf("PortsSummary = %q", got)
+	}
+	if merged.IP != eth.IP || merged.OS != "Linux" || merged.OnlineStatus != "online" {
+		t.Fatalf("unexpected merged record %+v", merged)
+	}
+
+	disjoint := mergeHostRecords(
+		HostRecord{Ports: []RemotePort{{Port: 443, Protocol: "tcp", State: "open"}}, PortSummary: "443/tcp"},
+		HostRecord{Ports: []RemotePort{{Port: 8080, Protocol: "tcp", State: "open", Service: "http-proxy"}}},
+	)
+	if len(disjoint.Ports) != 2 || disjoint.PortsSummary() != "443/tcp, 8080/tcp (http-proxy)" {
+		t.Fatalf("disjoint merge %+v", disjoint)
+	}
+}
+
+func TestCollapseSameHostMergesAcrossInterfaces(t *testing.T) {
+	hosts := []HostRecord{
+		{IP: "10.0.0.5", MAC: "aa:bb:cc:00:00:05", InterfaceName: "eth0", Ports: []RemotePort{{Port: 22, Protocol: "tcp", State: "open"}}},
+		{IP: "10.0.0.6", MAC: "Unknown", InterfaceName: "eth0"},
+		{IP: "10.0.0.5", MAC: "AA:BB:CC:00:00:05", InterfaceName: "eth1", Ports: []RemotePort{{Port: 443, Protocol: "tcp", State: "open"}}},
+		{IP: "10.0.0.6", MAC: "Unknown", InterfaceName: "eth1"},
+		{IP: "10.0.0.5", MAC: "aa:bb:cc:00:00:05", InterfaceName: "eth0", Ports: []RemotePort{{Port: 22, Protocol: "tcp", State: "open", Service: "ssh"}}},
+	}
+	kept, merged := collapseSameHost(hosts)
+	if len(kept) != 3 || len(merged) != 1 {
+		t.Fatalf("kept %d, merged %d hosts", len(kept), len(merged))
+	}
+	h := kept[0]
+	if h.InterfaceName != "eth0" || h.PortsSummary() != "22/tcp (ssh), 443/tcp" ||
+		!reflect.DeepEqual(h.Metadata["seen_interfaces"], []string{"eth0", "eth1"}) {
+		t.Fatalf("unexpected merged host %+v", h)
+	}
+	// Without a MAC nothing says the two records are one device.
+	if kept[1].IP != "10.0.0.6" || kept[2].IP != "10.0.0.6" {
+		t.Fatalf("hosts without a MAC were merged: %+v", kept)
+	}
+
+	// One MAC on two interfaces is one multi-homed device even under two
+	// IPs; two IPs behind one MAC on a single interface stay apart.
+	hosts = []HostRecord{
+		{IP: "10.0.0.5", MAC: "aa:bb:cc:00:00:05", InterfaceName: "eth0", Ports: []RemotePort{{Port: 22, Protocol: "tcp", State: "open"}}},
+		{IP: "192.168.2.5", MAC: "AA:BB:CC:00:00:05", InterfaceName: "eth1", Ports: []RemotePort{{Port: 443, Protocol: "tcp", State: "open"}}},
+		{IP: "10.0.0.9", MAC: "aa:bb:cc:00:00:05", InterfaceName: "eth0"},
+	}
+	kept, merged = collapseSameHost(hosts)
+	if len(kept) != 2 || len(merged) != 1 || kept[1].IP != "10.0.0.9" {
+		t.Fatalf("kept %+v, merged %d hosts", kept, len(merged))
+	}
+	h = kept[0]
+	if h.IP != "10.0.0.5" || h.PortsSummary() != "22/tcp, 443/tcp" ||
+		!reflect.DeepEqual(h.Metadata["seen_interfaces"], []string{"eth0", "eth1"}) ||
+		!reflect.DeepEqual(h.Metadata["seen_ips"], []string{"10.0.0.5", "192.168.2.5"}) {
+		t.Fatalf("unexpected multi-homed host %+v", h)
+	}
+}
@@ -0,0 +1,48 @@
+package scan
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestEncodeMsgpack(t *testing.T) {
+	got, err := encodeMsgpack(map[string]any{"c": "x", "a": 1, "b": []any{true, nil, -5, 300}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{0x83, 0xa1, 'a', 0x01, 0xa1, 'b', 0x94, 0xc3, 0xc0, 0xfb, 0xcd, 0x01, 0x2c, 0xa1, 'c', 0xa1, 'x'}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("encodeMsgpack = % x, want % x", got, want)
+	}
+}
+
+func TestPostPayloadMsgpackNeedsControllerSupport(t *testing.T) {
+	var contentType string
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType = r.Header.Get("Content-Type")
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	rc := RemoteConfig{ControllerURL: srv.URL, SiteID: "lab", AgentID: "edge01", PayloadFormat: PayloadMsgpack, Discovery: &ControllerDiscovery{}}
+	if err := rc.PostPayload(samplePayload()); err != nil {
+		t.Fatal(err)
+	}
+	if contentType != "application/json" {
+		t.Fatalf("controller without msgpack should get JSON, got %s", contentType)
+	}
+
+	rc.Discovery.Capabilities.Formats = []string{"msgpack"}
+	if err := rc.PostPayload(samplePayload()); err != nil {
+		t.Fatal(err)
+	}
+	want, _ := encodeMsgpack(samplePayload())
+	if contentType != msgpackContentType || !reflect.DeepEqual(body, want) {
+		t.Fatalf("expected msgpack body, got %s (%d bytes)", contentType, len(body))
+	}
+}
@@ -0,0 +1,344 @@
+package scan
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"atlas/internal/utils"
+)
+
+// DefaultNameOrder is the hostname resolution chain used when none is
+// configured: the name nmap reported, then reverse DNS, then NetBIOS.
+var DefaultNameOrder = []string{"nmap", "dns", "netbios"}
+
+// NamePolicy (--name-policy) decides which name the database keeps when a
+// host shows up under a different one. Under either policy a real name is
+// never replaced by "NoName" or an empty name.
+type NamePolicy string
+
+const (
+	// NamePreferNew takes the newly resolved name, e.g. after a DHCP rename.
+	NamePreferNew NamePolicy = "prefer-new"
+	// NamePreferExisting keeps the stored name once a host has one.
+	NamePreferExisting NamePolicy = "prefer-existing"
+)
+
+// ParseNamePolicy validates a --name-policy value; "" selects NamePreferNew.
+func ParseNamePolicy(s string) (NamePolicy, error) {
+	switch p := NamePolicy(strings.ToLower(strings.TrimSpace(s))); p {
+	case "":
+		return NamePreferNew, nil
+	case NamePreferNew, NamePreferExisting:
+		return p, nil
+	default:
+		return "", fmt.Errorf("invalid --name-policy %q (valid: prefer-existing, prefer-new)", s)
+	}
+}
+
+func (p NamePolicy) orDefault() NamePolicy {
+	if p == "" {
+		return NamePreferNew
+	}
+	return p
+}
+
+// NameResolver looks up a hostname for ip. ok is false when it has no
+// answer, including when ctx expires first.
+type NameResolver interface {
+	Resolve(ctx context.Context, ip string) (name string, ok bool)
+}
+
+// NameResolverFunc adapts a function to NameResolver.
+type NameResolverFunc func(ctx context.Context, ip string) (string, bool)
+
+func (f NameResolverFunc) Resolve(ctx context.Context, ip string) (string, bool) {
+	return f(ctx, ip)
+}
+
+// nameLookupTimeout bounds a single external lookup; filtered networks can
+// leave nbtscan or avahi waiting far longer than the name is worth.
+var nameLookupTimeout = 3 * time.Second
+
+// nameLookupSlots caps how many external lookups run at once across all
+// host goroutines.
+var nameLookupSlots = make(chan struct{}, 16)
+
+// nameSources maps --name-order entries to resolvers. nmapNames holds the
+// names the discovery sweep reported, by IP; dns answers PTR lookups.
+var nameSources = map[string]func(nmapNames map[string]string, dns *net.Resolver) NameResolver{
+	"nmap":    func(nmapNames map[string]string, _ *net.Resolver) NameResolver { return staticNames(nmapNames) },
+	"dns":     func(_ map[string]string, dns *net.Resolver) NameResolver { return reverseDNS{dns} },
+	"netbios": func(map[string]string, *net.Resolver) NameResolver { return NameResolverFunc(netBIOSName) },
+	"mdns":    func(map[string]string, *net.Resolver) NameResolver { return NameResolverFunc(mDNSName) },
+}
+
+// ParseNameOrder validates a comma-separated resolver list (e.g.
+// "dns,nmap,netbios,mdns") and removes any disabled resolvers. An empty order
+// selects DefaultNameOrder.
+func ParseNameOrder(order string, disabled ...string) ([]string, error) {
+	names := DefaultNameOrder
+	if strings.TrimSpace(order) != "" {
+		names = nil
+		seen := map[string]bool{}
+		for _, part := range strings.Split(order, ",") {
+			name := strings.ToLower(strings.TrimSpace(part))
+			if name == "" || seen[name] {
+				continue
+			}
+			if _, ok := nameSources[name]; !ok {
+				return nil, fmt.Errorf("unknown name resolver %q (valid: dns, mdns, netbios, nmap)", name)
+			}
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	skip := map[string]bool{}
+	for _, d := range disabled {
+		skip[d] = true
+	}
+	result := make([]string, 0, len(names))
+	for _, name := range names {
+		if !skip[name] {
+			result = append(result, name)
+		}
+	}
+	return result, nil
+}
+
+// newNameResolvers builds the chain for order (nil uses DefaultNameOrder)
+// for one run, with reverse DNS sent to dns. External lookups share
+// nameLookupSlots and each gets nameLookupTimeout; every answer, including
+// "no name", is cached for the life of the chain.
+func newNameResolvers(order []string, nmapNames map[string]string, dns *net.Resolver) []NameResolver {
+	if order == nil {
+		order = DefaultNameOrder
+	}
+	resolvers := make([]NameResolver, 0, len(order))
+	for _, name := range order {
+		source, ok := nameSources[name]
+		if !ok {
+			continue
+		}
+		r := source(nmapNames, dns)
+		if name != "nmap" {
+			r = cachedNames(limitedNames(r, nameLookupSlots, nameLookupTimeout))
+		}
+		resolvers = append(resolvers, r)
+	}
+	return resolvers
+}
+
+// bestHostName walks the resolver chain and returns the first meaningful
+// name, or "NoName".
+func bestHostName(ctx context.Context, ip string, resolvers []NameResolver) string {
+	for _, r := range resolvers {
+		if name, ok := r.Resolve(ctx, ip); ok && usableName(name) {
+			return name
+		}
+	}
+	return "NoName"
+}
+
+// usableName rejects empty names and the "NoName" placeholder.
+func usableName(name string) bool {
+	return name != "" && name != "NoName"
+}
+
+// staticNames answers from a fixed IP-to-name map, such as the names nmap
+// reported during discovery.
+type staticNames map[string]string
+
+func (s staticNames) Resolve(_ context.Context, ip string) (string, bool) {
+	name := s[ip]
+	return name, usableName(name)
+}
+
+// limitedNames runs r while holding one of slots, with each lookup bounded
+// by timeout. Waiting for a slot counts against the caller's ctx only.
+func limitedNames(r NameResolver, slots chan struct{}, timeout time.Duration) NameResolver {
+	return NameResolverFunc(func(ctx context.Context, ip string) (string, bool) {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			return "", false
+		}
+		defer func() { <-slots }()
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		name, ok := r.Resolve(ctx, ip)
+		if ctx.Err() != nil {
+			return "", false
+		}
+		return name, ok
+	})
+}
+
+// nameCache remembers r's answer for each IP.
+type nameCache struct {
+	r     NameResolver
+	mu    sync.Mutex
+	names map[string]cachedName
+}
+
+type cachedName struct {
+	name string
+	ok   bool
+}
+
+func cachedNames(r NameResolver) *nameCache {
+	return &nameCache{r: r, names: map[string]cachedName{}}
+}
+
+func (c *nameCache) Resolve(ctx context.Context, ip string) (string, bool) {
+	c.mu.Lock()
+	hit, found := c.names[ip]
+	c.mu.Unlock()
+	if found {
+		return hit.name, hit.ok
+	}
+	name, ok := c.r.Resolve(ctx, ip)
+	if ctx.Err() != nil {
+		// A lookup cut short says nothing about the name; don't keep it.
+		return name, ok
+	}
+	c.mu.Lock()
+	c.names[ip] = cachedName{name, ok}
+	c.mu.Unlock()
+	return name, ok
+}
+
+// ParseDNSServer validates a --dns-server value, an IP with an optional port,
+// and returns it as host:port with port 53 by default. "" selects the
+// system resolver and is returned as is.
+func ParseDNSServer(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return "", nil
+	}
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		// No port: a bare IPv4 or IPv6 address, possibly bracketed.
+		host, port = strings.Trim(s, "[]"), "53"
+	}
+	if net.ParseIP(host) == nil {
+		return "", fmt.Errorf("invalid --dns-server %q (want an IP, optionally with :port)", s)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return "", fmt.Errorf("invalid --dns-server %q: bad port", s)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// nmapDNSArgs points the names nmap reports during discovery at server
+// (host:port, see ParseDNSServer). nmap can only query port 53, so a server
+// on another port gets no arguments; see preferDNS.
+func nmapDNSArgs(server string) []string {
+	host, port, err := net.SplitHostPort(server)
+	if err != nil || port != "53" {
+		return nil
+	}
+	return []string{"--dns-servers", host}
+}
+
+// preferDNS moves the "dns" resolver ahead of "nmap" in order, for when
+// nmap's names came from the system resolver instead of --dns-server.
+func preferDNS(order []string) []string {
+	if order == nil {
+		order = DefaultNameOrder
+	}
+	dns, nmap := slices.Index(order, "dns"), slices.Index(order, "nmap")
+	if dns < 0 || nmap < 0 || dns < nmap {
+		return order
+	}
+	moved := slices.Delete(slices.Clone(order), dns, dns+1)
+	return slices.Insert(moved, nmap, "dns")
+}
+
+// dnsNameOrder returns the name order to use when nmap cannot query server.
+// The default order gets preferDNS; an order the operator chose with
+// --name-order (explicit) is kept as given, with a warning that nmap's
+// names still come from the system resolver.
+func dnsNameOrder(order []string, explicit bool, server string, log io.Writer) []string {
+	if !explicit {
+		fmt.Fprintf(log, "nmap cannot query %s; asking it before nmap's names\n", server)
+		return preferDNS(order)
+	}
+	if slices.Contains(order, "nmap") {
+		fmt.Fprintf(log, utils.Deco("⚠️ nmap cannot query %s, so its names come from the system resolver; keeping --name-order %s\n"), server, strings.Join(order, ","))
+	}
+	return order
+}
+
+// newDNSResolver returns a resolver that sends every query to server
+// (host:port, see ParseDNSServer), or the system resolver when server is "".
+func newDNSResolver(server string) *net.Resolver {
+	if server == "" {
+		return net.DefaultResolver
+	}
+	return &net.Resolver{
+		// The cgo resolver would read /etc/resolv.conf and ignore Dial.
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, server)
+		},
+	}
+}
+
+// reverseDNS looks up PTR records through r.
+type reverseDNS struct {
+	r *net.Resolver
+}
+
+func (d reverseDNS) Resolve(ctx context.Context, ip string) (string, bool) {
+	return reverseDNSName(ctx, d.r, ip)
+}
+
+// reverseDNSName looks up ip's PTR record through r.
+func reverseDNSName(ctx context.Context, r *net.Resolver, ip string) (string, bool) {
+	names, err := r.LookupAddr(ctx, ip)
+	if err != nil || len(names) == 0 {
+		return "", false
+	}
+	name := strings.TrimSuffix(names[0], ".")
+	return name, usableName(name)
+}
+
+// netBIOSName asks nbtscan for the host's NetBIOS name.
+func netBIOSName(ctx context.Context, ip string) (string, bool) {
+	out, err := utils.RunCommand(ctx, "nbtscan", ip)
+	if err != nil {
+		return "", false
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		// nbtscan output format: IP Name <other info>
+		if len(fields) >= 2 && fields[0] == ip {
+			return fields[1], true
+		}
+	}
+	return "", false
+}
+
+// mDNSName asks avahi for the host's multicast DNS name.
+func mDNSName(ctx context.Context, ip string) (string, bool) {
+	out, err := utils.RunCommand(ctx, "avahi-resolve-address", ip)
+	if err != nil {
+		return "", false
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		// avahi output format: IP<TAB>name.local
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == ip {
+			return strings.TrimSuffix(fields[1], "."), true
+		}
+	}
+	return "", false
+}
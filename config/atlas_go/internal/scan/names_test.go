@@ -0,0 +1,129 @@
+package scan
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNameResolverChain(t *testing.T) {
+	calls := map[string]int{}
+	useFakeRunner(t, func(name string, args []string) ([]byte, error) {
+		calls[name]++
+		if name == "nbtscan" && args[0] == "10.0.0.2" {
+			return []byte("10.0.0.2  NAS  <server>  NAS  00:11:22:33:44:55\n"), nil
+		}
+		return nil, errors.New("no answer")
+	})
+	nmapNames := map[string]string{"10.0.0.1": "router.lan", "10.0.0.2": "NoName"}
+	resolvers := newNameResolvers([]string{"nmap", "netbios", "mdns"}, nmapNames, net.DefaultResolver)
+	ctx := context.Background()
+
+	if got := bestHostName(ctx, "10.0.0.1", resolvers); got != "router.lan" || len(calls) != 0 {
+		t.Fatalf("nmap name should win without lookups: %q %v", got, calls)
+	}
+	for range 2 {
+		if got := bestHostName(ctx, "10.0.0.2", resolvers); got != "NAS" {
+			t.Fatalf("want the NetBIOS name, got %q", got)
+		}
+		if got := bestHostName(ctx, "10.0.0.3", resolvers); got != "NoName" {
+			t.Fatalf("want NoName, got %q", got)
+		}
+	}
+	if calls["nbtscan"] != 2 || calls["avahi-resolve-address"] != 1 {
+		t.Fatalf("answers and misses should be cached: %v", calls)
+	}
+
+	slow := NameResolverFunc(func(ctx context.Context, ip string) (string, bool) {
+		<-ctx.Done()
+		return "late", true
+	})
+	if name, ok := limitedNames(slow, make(chan struct{}, 1), 10*time.Millisecond).Resolve(ctx, "10.0.0.4"); ok {
+		t.Fatalf("timed-out lookup should have no answer, got %q", name)
+	}
+	full := make(chan struct{}, 1)
+	full <- struct{}{}
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, ok := limitedNames(slow, full, time.Hour).Resolve(canceled, "10.0.0.4"); ok {
+		t.Fatal("a lookup waiting for a slot should give up with its context")
+	}
+}
+
+func TestDNSServerResolver(t *testing.T) {
+	for in, want := range map[string]string{
+		"":                 "",
+		"10.0.0.53":        "10.0.0.53:53",
+		" 10.0.0.53:5353 ": "10.0.0.53:5353",
+		"fd00::53":         "[fd00::53]:53",
+		"[fd00::53]:5353":  "[fd00::53]:5353",
+	} {
+		if got, err := ParseDNSServer(in); err != nil || got != want {
+			t.Errorf("ParseDNSServer(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	for _, bad := range []string{"dns.corp.local", "10.0.0.53:0", "10.0.0.53:dns"} {
+		if _, err := ParseDNSServer(bad); err == nil {
+			t.Errorf("ParseDNSServer(%q) accepted", bad)
+		}
+	}
+	if newDNSResolver("") != net.DefaultResolver {
+		t.Fatal("no --dns-server should use the system resolver")
+	}
+	if got := nmapDNSArgs("[fd00::53]:53"); !reflect.DeepEqual(got, []string{"--dns-servers", "fd00::53"}) {
+		t.Errorf("nmapDNSArgs = %v", got)
+	}
+	if got := nmapDNSArgs("10.0.0.53:5353"); got != nil {
+		t.Errorf("nmap cannot use a non-53 port, got %v", got)
+	}
+	if got := preferDNS(nil); !reflect.DeepEqual(got, []string{"dns", "nmap", "netbios"}) {
+		t.Errorf("preferDNS(default) = %v", got)
+	}
+	if got := preferDNS([]string{"netbios", "dns"}); !reflect.DeepEqual(got, []string{"netbios", "dns"}) {
+		t.Errorf("preferDNS without nmap = %v", got)
+	}
+	if !reflect.DeepEqual(DefaultNameOrder, []string{"nmap", "dns", "netbios"}) {
+		t.Fatalf("preferDNS changed DefaultNameOrder: %v", DefaultNameOrder)
+	}
+	var log bytes.Buffer
+	if got := dnsNameOrder(nil, false, "10.0.0.53:5353", &log); !reflect.DeepEqual(got, []string{"dns", "nmap", "netbios"}) {
+		t.Errorf("dnsNameOrder(default) = %v", got)
+	}
+	log.Reset()
+	explicit := []string{"nmap", "netbios", "dns"}
+	if got := dnsNameOrder(explicit, true, "10.0.0.53:5353", &log); !reflect.DeepEqual(got, explicit) {
+		t.Errorf("dnsNameOrder reordered an explicit --name-order: %v", got)
+	}
+	if !strings.Contains(log.String(), "keeping --name-order nmap,netbios,dns") {
+		t.Errorf("no warning for an explicit order: %q", log.String())
+	}
+
+	// The custom resolver must query the configured server, not resolv.conf.
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("no UDP listener: %v", err)
+	}
+	defer pc.Close()
+	queried := make(chan struct{}, 1)
+	go func() {
+		buf := make([]byte, 512)
+		if _, _, err := pc.ReadFrom(buf); err == nil {
+			queried <- struct{}{}
+		}
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	if name, ok := (reverseDNS{newDNSResolver(pc.LocalAddr().String())}).Resolve(ctx, "10.0.0.9"); ok {
+		t.Fatalf("silent server answered %q", name)
+	}
+	select {
+	case <-queried:
+	case <-time.After(time.Second):
+		t.Fatal("reverse lookup did not reach --dns-server")
+	}
+}
@@ -0,0 +1,130 @@
+package scan
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"atlas/internal/utils"
+)
+
+// Host discovery sources accepted by --discovery.
+//
+//   - nmap (default): an nmap ping sweep of each subnet.
+//   - neighbors: read the kernel ARP/NDP neighbor cache instead. Nearly
+//     instant and sends no probes, but only sees hosts this machine talked
+//     to recently; silent or new hosts are missed until they appear.
+const (
+	DiscoveryNmap      = "nmap"
+	DiscoveryNeighbors = "neighbors"
+)
+
+// ValidateDiscoverySource rejects unknown --discovery values.
+func ValidateDiscoverySource(source string) error {
+	switch source {
+	case "", DiscoveryNmap, DiscoveryNeighbors:
+		return nil
+	}
+	return fmt.Errorf("unknown discovery source %q (valid: %s, %s)", source, DiscoveryNmap, DiscoveryNeighbors)
+}
+
+// arpCachePath is the IPv4 neighbor table. Tests point it at a fixture.
+var arpCachePath = "/proc/net/arp"
+
+// arpFlagComplete marks a resolved /proc/net/arp entry (ATF_COM).
+const arpFlagComplete = 0x2
+
+// neighborHosts returns the hosts in subnet with a live entry in the kernel
+// neighbor cache: complete ARP entries for IPv4 and REACHABLE, STALE, DELAY,
+// or PROBE NDP entries for IPv6.
+func neighborHosts(subnet string) ([]HostInfo, error) {
+	_, ipNet, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return nil, err
+	}
+	read := readARPCache
+	if ipNet.IP.To4() == nil {
+		read = readIPv6Neighbors
+	}
+	ips, err := read()
+	if err != nil {
+		return nil, err
+	}
+	var hosts []HostInfo
+	for _, ip := range ips {
+		if parsed := net.ParseIP(ip); parsed != nil && ipNet.Contains(parsed) {
+			hosts = append(hosts, HostInfo{IP: ip, Name: "NoName"})
+		}
+	}
+	return hosts, nil
+}
+
+func readARPCache() ([]string, error) {
+	file, err := os.Open(arpCachePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var ips []string
+	scanner := bufio.NewScanner(file)
+	scanner.Scan() // Skip header
+	for scanner.Scan() {
+		// IP address, HW type, Flags, HW address, Mask, Device
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
+		var flags int
+		if _, err := fmt.Sscanf(fields[2], "0x%x", &flags); err != nil || flags&arpFlagComplete == 0 {
+			continue
+		}
+		if fields[3] == "00:00:00:00:00:00" {
+			continue
+		}
+		ips = append(ips, fields[0])
+	}
+	return ips, scanner.Err()
+}
+
+// readIPv6Neighbors lists addresses from `ip -6 neigh show` that the kernel
+// still considers reachable or recently reachable.
+func readIPv6Neighbors() ([]string, error) {
+	out, err := utils.RunCommand(context.Background(), "ip", "-6", "neigh", "show")
+	if err != nil {
+		return nil, err
+	}
+	var ips []string
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || !strings.Contains(line, "lladdr") {
+			continue
+		}
+		switch fields[len(fields)-1] {
+		case "REACHABLE", "STALE", "DELAY", "PROBE":
+			ips = append(ips, fields[0])
+		}
+	}
+	return ips, nil
+}
+
+// discoverHosts finds live hosts on subnet from the configured source. The
+// neighbors source falls back to nmap when the cache has nothing for subnet.
+// stats is zero when no nmap sweep ran.
+func discoverHosts(ctx context.Context, subnet, source, pingMode string, logf func(format string, args ...any), extraArgs ...string) ([]HostInfo, nmapStats, error) {
+	if source == DiscoveryNeighbors {
+		hosts, err := neighborHosts(subnet)
+		if err != nil {
+			logf(utils.Deco("⚠️ Could not read neighbor cache for %s: %v; falling back to nmap"), subnet, err)
+		} else if len(hosts) == 0 {
+			logf("Neighbor cache has no entries on %s; falling back to nmap", subnet)
+		} else {
+			logf("Neighbor cache lists %d hosts on %s", len(hosts), subnet)
+			return hosts, nmapStats{}, nil
+		}
+	}
+	return discoverWithEscalation(ctx, subnet, pingMode, logf, extraArgs...)
+}
@@ -0,0 +1,50 @@
+package scan
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNeighborHostsReadsCompleteARPEntries(t *testing.T) {
+	path := t.TempDir() + "/arp"
+	arp := "IP address       HW type     Flags       HW address            Mask     Device\n" +
+		"192.168.1.1      0x1         0x2         aa:bb:cc:00:00:01     *        eth0\n" +
+		"192.168.1.7      0x1         0x0         00:00:00:00:00:00     *        eth0\n" +
+		"192.168.1.9      0x1         0x6         aa:bb:cc:00:00:09     *        eth0\n" +
+		"10.0.0.5         0x1         0x2         aa:bb:cc:00:00:05     *        eth1\n"
+	if err := os.WriteFile(path, []byte(arp), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	prev := arpCachePath
+	arpCachePath = path
+	t.Cleanup(func() { arpCachePath = prev })
+
+	hosts, err := neighborHosts("192.168.1.0/24")
+	if err != nil {
+		t.Fatalf("neighborHosts: %v", err)
+	}
+	if len(hosts) != 2 || hosts[0].IP != "192.168.1.1" || hosts[1].IP != "192.168.1.9" {
+		t.Fatalf("unexpected hosts %+v", hosts)
+	}
+
+	// IPv6 subnets come from `ip -6 neigh`, even without /proc/net/arp.
+	arpCachePath = filepath.Join(t.TempDir(), "missing")
+	useFakeRunner(t, func(name string, args []string) ([]byte, error) {
+		if name != "ip" || strings.Join(args, " ") != "-6 neigh show" {
+			return nil, fmt.Errorf("unexpected command %s %v", name, args)
+		}
+		return []byte("fd00::7 dev eth0 lladdr aa:bb:cc:00:00:07 REACHABLE\n" +
+			"fd00::8 dev eth0 FAILED\n" +
+			"fe80::1 dev eth0 lladdr aa:bb:cc:00:00:01 router STALE\n"), nil
+	})
+	hosts, err = neighborHosts("fd00::/64")
+	if err != nil {
+		t.Fatalf("neighborHosts(v6): %v", err)
+	}
+	if len(hosts) != 1 || hosts[0].IP != "fd00::7" {
+		t.Fatalf("unexpected IPv6 hosts %+v", hosts)
+	}
+}
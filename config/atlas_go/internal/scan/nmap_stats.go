@@ -0,0 +1,78 @@
+package scan
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+)
+
+// reNmapDone matches the summary nmap prints when a run finishes, both in
+// normal output ("Nmap done: 256 IP addresses (3 hosts up) scanned in 2.05
+// seconds") and as the last line of grepable output ("# Nmap done at <date>
+// -- 1 IP address (1 host up) scanned in 0.50 seconds").
+var reNmapDone = regexp.MustCompile(`Nmap done(?: at .*? --|:) (\d+) IP address(?:es)? \((\d+) hosts? up\) scanned in ([\d.]+) seconds`)
+
+// nmapStats sums the "Nmap done" summaries of one or more nmap runs.
+type nmapStats struct {
+	Runs      int
+	Addresses int
+	HostsUp   int
+	Elapsed   float64 // seconds, as nmap reports it
+}
+
+// parseNmapDone finds the "Nmap done" summary in output. ok is false when
+// nmap did not get far enough to print one.
+func parseNmapDone(output string) (stats nmapStats, ok bool) {
+	m := reNmapDone.FindStringSubmatch(output)
+	if m == nil {
+		return nmapStats{}, false
+	}
+	stats.Runs = 1
+	stats.Addresses, _ = strconv.Atoi(m[1])
+	stats.HostsUp, _ = strconv.Atoi(m[2])
+	stats.Elapsed, _ = strconv.ParseFloat(m[3], 64)
+	return stats, true
+}
+
+func (s *nmapStats) add(o nmapStats) {
+	s.Runs += o.Runs
+	s.Addresses += o.Addresses
+	s.HostsUp += o.HostsUp
+	s.Elapsed += o.Elapsed
+}
+
+// nmapRunTotals splits a scan's nmap summaries into the discovery sweeps,
+// whose host counts are what nmap thought was up, and the per-host port
+// scans, which only add to the elapsed time.
+type nmapRunTotals struct {
+	Discovery nmapStats
+	Scans     nmapStats
+}
+
+// hostsUp is the number of hosts nmap's discovery sweeps reported up, or nil
+// when no sweep printed a summary (e.g. only the neighbor cache was used).
+func (t nmapRunTotals) hostsUp() any {
+	if t.Discovery.Runs == 0 {
+		return nil
+	}
+	return t.Discovery.HostsUp
+}
+
+// elapsed is the total seconds nmap reported across every run, or nil when
+// none printed a summary.
+func (t nmapRunTotals) elapsed() any {
+	if t.Discovery.Runs+t.Scans.Runs == 0 {
+		return nil
+	}
+	return math.Round((t.Discovery.Elapsed+t.Scans.Elapsed)*100) / 100
+}
+
+// apply records the totals in run metadata as nmap_hosts_up and nmap_elapsed.
+func (t nmapRunTotals) apply(meta map[string]any) {
+	if up := t.hostsUp(); up != nil {
+		meta["nmap_hosts_up"] = up
+	}
+	if elapsed := t.elapsed(); elapsed != nil {
+		meta["nmap_elapsed"] = elapsed
+	}
+}
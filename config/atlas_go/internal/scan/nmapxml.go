@@ -0,0 +1,158 @@
+package scan
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// The nmapXML* types are the subset of nmap's -oX document that common
+// importers (Metasploit db_import, Faraday, Dradis, ...) actually read.
+type nmapXMLRun struct {
+	XMLName          xml.Name      `xml:"nmaprun"`
+	Scanner          string        `xml:"scanner,attr"`
+	Args             string        `xml:"args,attr"`
+	Start            int64         `xml:"start,attr"`
+	StartStr         string        `xml:"startstr,attr"`
+	Version          string        `xml:"version,attr"`
+	XMLOutputVersion string        `xml:"xmloutputversion,attr"`
+	Hosts            []nmapXMLHost `xml:"host"`
+	RunStats         nmapXMLStats  `xml:"runstats"`
+}
+
+type nmapXMLHost struct {
+	StartTime int64             `xml:"starttime,attr,omitempty"`
+	Status    nmapXMLStatus     `xml:"status"`
+	Addresses []nmapXMLAddress  `xml:"address"`
+	Hostnames []nmapXMLHostname `xml:"hostnames>hostname"`
+	Ports     []nmapXMLPort     `xml:"ports>port"`
+	OSMatches []nmapXMLOSMatch  `xml:"os>osmatch,omitempty"`
+}
+
+type nmapXMLStatus struct {
+	State  string `xml:"state,attr"`
+	Reason string `xml:"reason,attr"`
+}
+
+type nmapXMLAddress struct {
+	Addr     string `xml:"addr,attr"`
+	AddrType string `xml:"addrtype,attr"`
+}
+
+type nmapXMLHostname struct {
+	Name string `xml:"name,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type nmapXMLPort struct {
+	Protocol string          `xml:"protocol,attr"`
+	PortID   int             `xml:"portid,attr"`
+	State    nmapXMLStatus   `xml:"state"`
+	Service  *nmapXMLService `xml:"service,omitempty"`
+}
+
+type nmapXMLService struct {
+	Name    string `xml:"name,attr"`
+	Product string `xml:"product,attr,omitempty"`
+	Method  string `xml:"method,attr"`
+}
+
+type nmapXMLOSMatch struct {
+	Name     string `xml:"name,attr"`
+	Accuracy int    `xml:"accuracy,attr"`
+}
+
+type nmapXMLStats struct {
+	Finished struct {
+		Time    int64  `xml:"time,attr"`
+		TimeStr string `xml:"timestr,attr"`
+		Exit    string `xml:"exit,attr"`
+	} `xml:"finished"`
+	Hosts struct {
+		Up    int `xml:"up,attr"`
+		Down  int `xml:"down,attr"`
+		Total int `xml:"total,attr"`
+	} `xml:"hosts"`
+}
+
+// buildNmapXML converts aggregated host records into an nmap-style run. The
+// scanner attribute stays "nmap" because several importers reject anything
+// else; args records that atlas produced the document.
+func buildNmapXML(hosts []HostRecord, scanner string) nmapXMLRun {
+	now := time.Now()
+	run := nmapXMLRun{
+		Scanner:          "nmap",
+		Args:             strings.TrimSpace("atlas " + scanner),
+		Start:            now.Unix(),
+		StartStr:         now.Format(time.ANSIC),
+		Version:          ScannerVersion,
+		XMLOutputVersion: "1.05",
+	}
+	for _, h := range hosts {
+		xh := nmapXMLHost{Status: nmapXMLStatus{State: "up", Reason: "user-set"}}
+		if !h.LastSeen.IsZero() {
+			xh.StartTime = h.LastSeen.Unix()
+			run.Start = min(run.Start, xh.StartTime)
+		}
+		if h.OnlineStatus == "offline" {
+			xh.Status.State = "down"
+			run.RunStats.Hosts.Down++
+		} else {
+			run.RunStats.Hosts.Up++
+		}
+		addrType := "ipv4"
+		if strings.Contains(h.IP, ":") {
+			addrType = "ipv6"
+		}
+		xh.Addresses = append(xh.Addresses, nmapXMLAddress{Addr: h.IP, AddrType: addrType})
+		if h.MAC != "" && h.MAC != "Unknown" {
+			xh.Addresses = append(xh.Addresses, nmapXMLAddress{Addr: strings.ToUpper(h.MAC), AddrType: "mac"})
+		}
+		if h.Hostname != "" && h.Hostname != "NoName" {
+			xh.Hostnames = append(xh.Hostnames, nmapXMLHostname{Name: h.Hostname, Type: "user"})
+		}
+		for _, p := range h.Ports {
+			xp := nmapXMLPort{Protocol: p.Protocol, PortID: p.Port, State: nmapXMLStatus{State: p.State, Reason: "unknown"}}
+			if xp.State.State == "" {
+				xp.State.State = "open"
+			}
+			if p.Service != "" {
+				xp.Service = &nmapXMLService{Name: p.Service, Product: p.Version, Method: "table"}
+				if p.Version != "" {
+					xp.Service.Method = "probed"
+				}
+			}
+			xh.Ports = append(xh.Ports, xp)
+		}
+		if h.OS != "" && h.OS != "Unknown" {
+			xh.OSMatches = append(xh.OSMatches, nmapXMLOSMatch{Name: h.OS, Accuracy: 100})
+		}
+		run.Hosts = append(run.Hosts, xh)
+	}
+	run.StartStr = time.Unix(run.Start, 0).Format(time.ANSIC)
+	run.RunStats.Hosts.Total = len(hosts)
+	run.RunStats.Finished.Time = now.Unix()
+	run.RunStats.Finished.TimeStr = now.Format(time.ANSIC)
+	run.RunStats.Finished.Exit = "success"
+	return run
+}
+
+// writeNmapXML writes hosts to path as an nmap-compatible XML document.
+func writeNmapXML(path string, hosts []HostRecord) error {
+	scanner := ""
+	if len(hosts) > 0 {
+		scanner, _ = hosts[0].Metadata["scanner"].(string)
+	}
+	b, err := xml.MarshalIndent(buildNmapXML(hosts, scanner), "", "  ")
+	if err != nil {
+		return err
+	}
+	doc := xml.Header + "<!DOCTYPE nmaprun>\n" + string(b) + "\n"
+	if err := os.WriteFile(path, []byte(doc), 0o644); err != nil {
+		return fmt.Errorf("write nmap XML to %s: %w", path, err)
+	}
+	fmt.Printf("[remote] wrote nmap XML for %d hosts to %s\n", len(hosts), path)
+	return nil
+}
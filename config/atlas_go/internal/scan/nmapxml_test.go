@@ -0,0 +1,36 @@
+package scan
+
+import (
+	"encoding/xml"
+	"os"
+	"testing"
+)
+
+func TestWriteNmapXML(t *testing.T) {
+	path := t.TempDir() + "/scan.xml"
+	hosts := samplePayloadHosts()
+	if err := writeNmapXML(path, hosts); err != nil {
+		t.Fatalf("writeNmapXML: %v", err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var run nmapXMLRun
+	if err := xml.Unmarshal(b, &run); err != nil {
+		t.Fatalf("output is not valid XML: %v", err)
+	}
+	if len(run.Hosts) != 1 || run.RunStats.Hosts.Up != 1 {
+		t.Fatalf("unexpected hosts %+v", run)
+	}
+	h := run.Hosts[0]
+	if h.Addresses[0].Addr != "192.168.1.10" || h.Addresses[1].AddrType != "mac" {
+		t.Errorf("unexpected addresses %+v", h.Addresses)
+	}
+	if len(h.Ports) != 1 || h.Ports[0].PortID != 22 || h.Ports[0].Service.Name != "ssh" {
+		t.Errorf("unexpected ports %+v", h.Ports)
+	}
+	if len(h.OSMatches) != 1 || h.OSMatches[0].Name != "Linux" {
+		t.Errorf("unexpected os %+v", h.OSMatches)
+	}
+}
@@ -0,0 +1,43 @@
+package scan
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// markStaleOffline flags hosts on the given interfaces offline when they have
+// not been seen since cutoff. Scanners call it only after a successful run,
+// so a scan that dies early leaves the previous online state in place. It
+// returns the addresses of the hosts it flagged.
+func markStaleOffline(db sqlExecutor, interfaces []string, cutoff time.Time) ([]string, error) {
+	if len(interfaces) == 0 {
+		return nil, nil
+	}
+	args := []any{dbTime(cutoff)}
+	for _, name := range interfaces {
+		args = append(args, name)
+	}
+	rows, err := db.Query(`
+        UPDATE hosts SET online_status = 'offline'
+        WHERE online_status != 'offline' AND last_seen < ?
+          AND interface_name IN (?`+strings.Repeat(", ?", len(interfaces)-1)+`)
+        RETURNING ip
+    `, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%w: mark offline: %w", ErrDatabase, err)
+	}
+	defer rows.Close()
+	var ips []string
+	for rows.Next() {
+		var ip string
+		if err := rows.Scan(&ip); err != nil {
+			return nil, fmt.Errorf("%w: mark offline: %w", ErrDatabase, err)
+		}
+		ips = append(ips, ip)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%w: mark offline: %w", ErrDatabase, err)
+	}
+	return ips, nil
+}
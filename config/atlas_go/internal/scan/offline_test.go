@@ -0,0 +1,34 @@
+package scan
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMarkStaleOfflineComparesInUTC(t *testing.T) {
+	conn := openTestDB(t)
+	// A host seen under one zone offset and a cutoff taken under another
+	// (a DST change, or TZ set differently for the agent) compare by instant.
+	seen := time.Date(2024, 5, 1, 9, 0, 0, 0, time.FixedZone("CEST", 2*60*60))
+	host := HostRecord{IP: "10.0.0.1", InterfaceName: "eth0", LastSeen: seen, OnlineStatus: "online"}
+	if err := upsertPresence(conn, host, ""); err != nil {
+		t.Fatal(err)
+	}
+	var stored string
+	if err := conn.QueryRow("SELECT CAST(last_seen AS TEXT) FROM hosts WHERE ip = ?", host.IP).Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored != "2024-05-01 07:00:00" {
+		t.Fatalf("last_seen stored as %q, want UTC", stored)
+	}
+
+	est := time.FixedZone("EST", -5*60*60)
+	// 03:30 EST is 08:30 UTC, after the host was last seen at 07:00 UTC, even
+	// though its wall clock reads earlier than the stored 09:00 CEST.
+	if ips, err := markStaleOffline(conn, []string{"eth0"}, time.Date(2024, 5, 1, 1, 30, 0, 0, est)); err != nil || len(ips) != 0 {
+		t.Fatalf("cutoff before the sighting flagged %v (%v)", ips, err)
+	}
+	if ips, err := markStaleOffline(conn, []string{"eth0"}, time.Date(2024, 5, 1, 3, 30, 0, 0, est)); err != nil || len(ips) != 1 {
+		t.Fatalf("cutoff after the sighting flagged %v (%v)", ips, err)
+	}
+}
@@ -0,0 +1,19 @@
+package scan
+
+import (
+	"sort"
+
+	"atlas/internal/utils"
+)
+
+// sortHosts orders hosts by interface, then numerically by IP, so payloads,
+// logs, and database writes are stable across runs no matter which goroutine
+// finished first.
+func sortHosts(hosts []HostRecord) {
+	sort.SliceStable(hosts, func(i, j int) bool {
+		if hosts[i].InterfaceName != hosts[j].InterfaceName {
+			return hosts[i].InterfaceName < hosts[j].InterfaceName
+		}
+		return utils.CompareIP(hosts[i].IP, hosts[j].IP) < 0
+	})
+}
@@ -0,0 +1,84 @@
+package scan
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// OSSourceManual is the os_source metadata value of hosts whose OS came from
+// an --os-hint instead of nmap.
+const OSSourceManual = "manual"
+
+// ParseOSHint splits an --os-hint ip=os pair. The IP is returned in canonical
+// form so that hints match however the scanner spells the address.
+func ParseOSHint(pair string) (string, string, error) {
+	ip, osName, ok := strings.Cut(pair, "=")
+	if !ok {
+		return "", "", fmt.Errorf("os hint %q: expected ip=os", pair)
+	}
+	parsed := net.ParseIP(strings.TrimSpace(ip))
+	if parsed == nil {
+		return "", "", fmt.Errorf("os hint %q: %q is not an IP address", pair, strings.TrimSpace(ip))
+	}
+	osName = strings.TrimSpace(osName)
+	if osName == "" || osName == "Unknown" {
+		return "", "", fmt.Errorf("os hint %q: missing OS name", pair)
+	}
+	return parsed.String(), osName, nil
+}
+
+// LoadOSHints reads an --os-hints-file: one ip=os pair per line, with blank
+// lines and lines starting with '#' ignored.
+func LoadOSHints(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	hints := map[string]string{}
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		ip, osName, err := ParseOSHint(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, n, err)
+		}
+		hints[ip] = osName
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return hints, nil
+}
+
+// applyOSHint fills record.OS from hints when nmap could not identify the
+// host, and tags it os_source=manual. A detected OS is never replaced, but
+// one an earlier hint supplied (a reused incremental record) follows the
+// current hints.
+func applyOSHint(record *HostRecord, hints map[string]string) {
+	if len(hints) == 0 {
+		return
+	}
+	if record.OS != "" && record.OS != "Unknown" && metadataString(record.Metadata, "os_source") != OSSourceManual {
+		return
+	}
+	ip := net.ParseIP(record.IP)
+	if ip == nil {
+		return
+	}
+	osName, ok := hints[ip.String()]
+	if !ok {
+		return
+	}
+	record.OS = osName
+	if record.Metadata == nil {
+		record.Metadata = map[string]any{}
+	}
+	record.Metadata["os_source"] = OSSourceManual
+}
@@ -0,0 +1,52 @@
+package scan
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestOSHintsFillUnknownOS(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "os-hints")
+	if err := os.WriteFile(path, []byte("# firewalled\n10.0.0.5 = FreeBSD 13\n\n2001:DB8::0:7=Windows Server 2019\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	hints, err := LoadOSHints(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(hints, map[string]string{"10.0.0.5": "FreeBSD 13", "2001:db8::7": "Windows Server 2019"}) {
+		t.Fatalf("unexpected hints %v", hints)
+	}
+	for _, bad := range []string{"10.0.0.5", "nas=Linux", "10.0.0.5=", "10.0.0.5=Unknown"} {
+		if _, _, err := ParseOSHint(bad); err == nil {
+			t.Errorf("ParseOSHint(%q) accepted", bad)
+		}
+	}
+
+	unknown := HostRecord{IP: "10.0.0.5", OS: "Unknown", Metadata: map[string]any{}}
+	applyOSHint(&unknown, hints)
+	if unknown.OS != "FreeBSD 13" || unknown.Metadata["os_source"] != OSSourceManual {
+		t.Errorf("hint not applied: %+v", unknown)
+	}
+	v6 := HostRecord{IP: "2001:db8::7"}
+	applyOSHint(&v6, hints)
+	if v6.OS != "Windows Server 2019" {
+		t.Errorf("IPv6 hint not matched canonically: %+v", v6)
+	}
+	detected := HostRecord{IP: "10.0.0.5", OS: "Linux 5.4", Metadata: map[string]any{}}
+	applyOSHint(&detected, hints)
+	if detected.OS != "Linux 5.4" || detected.Metadata["os_source"] != nil {
+		t.Errorf("detected OS overridden: %+v", detected)
+	}
+	// A record reused from an earlier run follows the current hint.
+	reused := HostRecord{IP: "10.0.0.5", OS: "FreeBSD 12", Metadata: map[string]any{"os_source": OSSourceManual}}
+	applyOSHint(&reused, hints)
+	if reused.OS != "FreeBSD 13" {
+		t.Errorf("stale hint kept: %+v", reused)
+	}
+	if _, _, err := ParseLabel("os_source=manual"); err == nil {
+		t.Error("os_source should be reserved")
+	}
+}
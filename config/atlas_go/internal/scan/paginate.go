@@ -0,0 +1,188 @@
+package scan
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// maxPages bounds how many pages GetPaginated follows before giving up.
+var maxPages = 100
+
+// pageEnvelope is an object-shaped page. Items may be under any of the
+// listed keys; the next page is a URL in Next or a cursor in NextCursor or
+// Cursor, sent back as the cursor query parameter.
+type pageEnvelope struct {
+	Items      json.RawMessage `json:"items"`
+	Data       json.RawMessage `json:"data"`
+	Results    json.RawMessage `json:"results"`
+	Next       string          `json:"next"`
+	NextCursor string          `json:"next_cursor"`
+	Cursor     string          `json:"cursor"`
+}
+
+// GetPaginated GETs apiPath (relative to the discovered API base, or
+// ControllerURL) and follows the controller's pagination until it runs out,
+// returning every item in order. A page is either a JSON array, continued by
+// a Link rel="next" header, or an object whose items, data, or results array
+// is continued by a next URL or a next_cursor/cursor value. Revisiting a page
+// or exceeding maxPages is an error rather than an endless loop.
+func (rc RemoteConfig) GetPaginated(apiPath string) ([]json.RawMessage, error) {
+	pageURL, err := rc.apiURL(apiPath)
+	if err != nil {
+		return nil, err
+	}
+	client := rc.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 60 * time.Second}
+	}
+	var items []json.RawMessage
+	visited := map[string]bool{}
+	for page := 1; pageURL != ""; page++ {
+		if page > maxPages {
+			return items, fmt.Errorf("%w: %s: more than %d pages", ErrRemoteFetch, apiPath, maxPages)
+		}
+		if visited[pageURL] {
+			return items, fmt.Errorf("%w: %s: controller returned page %s twice", ErrRemoteFetch, apiPath, pageURL)
+		}
+		visited[pageURL] = true
+		body, header, err := rc.do(client, http.MethodGet, pageURL, nil)
+		if err != nil {
+			return items, err
+		}
+		pageItems, next, err := parsePage(body, header, pageURL)
+		if err != nil {
+			return items, fmt.Errorf("%w: %s page %d: %v", ErrRemoteFetch, apiPath, page, err)
+		}
+		items = append(items, pageItems...)
+		pageURL = next
+	}
+	return items, nil
+}
+
+// apiURL resolves apiPath against the discovered API base or ControllerURL.
+func (rc RemoteConfig) apiURL(apiPath string) (string, error) {
+	base := rc.ControllerURL
+	if rc.Discovery != nil && rc.Discovery.APIBase != "" {
+		base = rc.Discovery.APIBase
+	}
+	if base == "" {
+		return "", fmt.Errorf("%w: no controller url configured", ErrRemoteFetch)
+	}
+	path := strings.NewReplacer("{site}", rc.SiteID, "{agent}", rc.AgentID).Replace(strings.TrimLeft(apiPath, "/"))
+	return strings.TrimRight(base, "/") + "/" + path, nil
+}
+
+// do sends one API request with an optional JSON body through
+// doWithRefresh, like PostPayload.
+func (rc RemoteConfig) do(client *http.Client, method, reqURL string, body []byte) ([]byte, http.Header, error) {
+	send := func() (*http.Response, error) {
+		req, err := http.NewRequest(method, reqURL, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		rc.applyHeaders(req)
+		req.Header.Set("Accept", "application/json")
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrRemoteFetch, err)
+		}
+		return resp, nil
+	}
+	resp, err := rc.doWithRefresh(client, ErrRemoteFetch, send)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 32<<20))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %s (read body error: %v)", ErrRemoteFetch, resp.Status, err)
+	}
+	if resp.StatusCode >= 300 {
+		return nil, nil, fmt.Errorf("%w: %s %s: %s - %s", ErrRemoteFetch, method, reqURL, resp.Status, strings.TrimSpace(string(respBody)))
+	}
+	return respBody, resp.Header, nil
+}
+
+// parsePage splits one page into its items and the absolute URL of the next
+// page ("" on the last page).
+func parsePage(body []byte, header http.Header, pageURL string) ([]json.RawMessage, string, error) {
+	current, err := url.Parse(pageURL)
+	if err != nil {
+		return nil, "", err
+	}
+	body = bytes.TrimSpace(body)
+	if bytes.HasPrefix(body, []byte("[")) {
+		var items []json.RawMessage
+		if err := json.Unmarshal(body, &items); err != nil {
+			return nil, "", err
+		}
+		return items, resolveNext(current, linkNext(header.Values("Link"))), nil
+	}
+	var env pageEnvelope
+	if err := json.Unmarshal(body, &env); err != nil {
+		return nil, "", err
+	}
+	var items []json.RawMessage
+	for _, raw := range []json.RawMessage{env.Items, env.Data, env.Results} {
+		if len(raw) > 0 && string(raw) != "null" {
+			if err := json.Unmarshal(raw, &items); err != nil {
+				return nil, "", err
+			}
+			break
+		}
+	}
+	cursor := env.NextCursor
+	if cursor == "" {
+		cursor = env.Cursor
+	}
+	switch {
+	case env.Next != "":
+		return items, resolveNext(current, env.Next), nil
+	case cursor != "" && len(items) > 0:
+		next := *current
+		q := next.Query()
+		q.Set("cursor", cursor)
+		next.RawQuery = q.Encode()
+		return items, next.String(), nil
+	}
+	return items, resolveNext(current, linkNext(header.Values("Link"))), nil
+}
+
+// linkNext returns the rel="next" target of RFC 8288 Link header values.
+func linkNext(values []string) string {
+	for _, v := range values {
+		for _, link := range strings.Split(v, ",") {
+			target, params, ok := strings.Cut(strings.TrimSpace(link), ";")
+			if !ok || !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+				continue
+			}
+			for _, p := range strings.Split(params, ";") {
+				name, value, _ := strings.Cut(strings.TrimSpace(p), "=")
+				if strings.EqualFold(name, "rel") && strings.Contains(" "+strings.Trim(value, `"`)+" ", " next ") {
+					return strings.Trim(target, "<>")
+				}
+			}
+		}
+	}
+	return ""
+}
+
+func resolveNext(current *url.URL, next string) string {
+	if next == "" {
+		return ""
+	}
+	ref, err := url.Parse(next)
+	if err != nil {
+		return ""
+	}
+	return current.ResolveReference(ref).String()
+}
@@ -0,0 +1,60 @@
+package scan
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGetPaginatedFollowsLinksAndCursors(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("missing bearer token on %s", r.URL)
+		}
+		switch r.URL.Path + "?" + r.URL.RawQuery {
+		case "/sites/site-1/hosts?":
+			w.Header().Set("Link", `</sites/site-1/hosts?page=2>; rel="next"`)
+			io.WriteString(w, `[{"ip":"10.0.0.1"}]`)
+		case "/sites/site-1/hosts?page=2":
+			io.WriteString(w, `{"items":[{"ip":"10.0.0.2"}],"next_cursor":"abc"}`)
+		case "/sites/site-1/hosts?cursor=abc&page=2":
+			io.WriteString(w, `{"data":[{"ip":"10.0.0.3"}],"next_cursor":""}`)
+		case "/loop?":
+			w.Header().Set("Link", `<`+srv.URL+`/loop>; rel="next"`)
+			io.WriteString(w, `[]`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	rc := RemoteConfig{ControllerURL: srv.URL, SiteID: "site-1", Token: "secret"}
+	items, err := rc.GetPaginated("/sites/{site}/hosts")
+	if err != nil {
+		t.Fatalf("GetPaginated: %v", err)
+	}
+	var ips []string
+	for _, raw := range items {
+		var h struct{ IP string }
+		if err := json.Unmarshal(raw, &h); err != nil {
+			t.Fatal(err)
+		}
+		ips = append(ips, h.IP)
+	}
+	if want := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}; !reflect.DeepEqual(ips, want) {
+		t.Fatalf("items %v, want %v", ips, want)
+	}
+
+	if _, err := rc.GetPaginated("loop"); !errors.Is(err, ErrRemoteFetch) || !strings.Contains(err.Error(), "twice") {
+		t.Fatalf("loop err = %v", err)
+	}
+	if _, err := rc.GetPaginated("missing"); !errors.Is(err, ErrRemoteFetch) {
+		t.Fatalf("404 err = %v", err)
+	}
+}
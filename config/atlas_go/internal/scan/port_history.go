@@ -0,0 +1,103 @@
+package scan
+
+import (
+	"fmt"
+	"time"
+)
+
+// PortChange is a port that opened, closed, or changed state since the last
+// recorded observation for a host.
+type PortChange struct {
+	IP       string `json:"ip"`
+	Port     int    `json:"port"`
+	Protocol string `json:"protocol"`
+	From     string `json:"from,omitempty"`
+	To       string `json:"to"`
+}
+
+func (c PortChange) String() string {
+	switch {
+	case c.From == "":
+		return fmt.Sprintf("%s: new %s port %d/%s", c.IP, c.To, c.Port, c.Protocol)
+	case c.To == "closed":
+		return fmt.Sprintf("%s: port %d/%s closed (was %s)", c.IP, c.Port, c.Protocol, c.From)
+	default:
+		return fmt.Sprintf("%s: port %d/%s changed %s -> %s", c.IP, c.Port, c.Protocol, c.From, c.To)
+	}
+}
+
+type portKey struct {
+	port  int
+	proto string
+}
+
+// recordPortChanges compares host's ports with the latest port_history state
+// for its IP on its interface and appends a row for every transition. Hosts without parsed ports are
+// skipped: an empty list usually means the scan failed or did not look at
+// ports (fastscan), not that every port closed.
+func recordPortChanges(db sqlExecutor, host HostRecord) ([]PortChange, error) {
+	if len(host.Ports) == 0 {
+		return nil, nil
+	}
+	previous, err := latestPortStates(db, host.IP, host.InterfaceName)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := diffPortStates(host.IP, previous, host.Ports)
+	observed := dbTime(time.Now())
+	for _, c := range changes {
+		if _, err := db.Exec(`INSERT INTO port_history (ip, interface_name, port, protocol, state, observed_at) VALUES (?, ?, ?, ?, ?, ?)`,
+			c.IP, host.InterfaceName, c.Port, c.Protocol, c.To, observed); err != nil {
+			return changes, err
+		}
+	}
+	return changes, nil
+}
+
+// diffPortStates lists the transitions from the previous state of each of
+// ip's ports to ports. A previously seen port missing from ports is reported
+// as closed.
+func diffPortStates(ip string, previous map[portKey]string, ports []RemotePort) []PortChange {
+	var changes []PortChange
+	current := map[portKey]bool{}
+	for _, p := range ports {
+		k := portKey{p.Port, p.Protocol}
+		current[k] = true
+		if prev, ok := previous[k]; !ok || prev != p.State {
+			changes = append(changes, PortChange{IP: ip, Port: p.Port, Protocol: p.Protocol, From: prev, To: p.State})
+		}
+	}
+	for k, prev := range previous {
+		if !current[k] && prev != "closed" {
+			changes = append(changes, PortChange{IP: ip, Port: k.port, Protocol: k.proto, From: prev, To: "closed"})
+		}
+	}
+	return changes
+}
+
+// latestPortStates returns the most recent recorded state of each port of ip
+// on interfaceName.
+func latestPortStates(db sqlExecutor, ip, interfaceName string) (map[portKey]string, error) {
+	rows, err := db.Query(`
+        SELECT port, protocol, state FROM port_history h
+        WHERE ip = ? AND interface_name = ? AND id = (
+            SELECT MAX(id) FROM port_history
+            WHERE ip = h.ip AND interface_name = h.interface_name AND port = h.port AND protocol = h.protocol
+        )
+    `, ip, interfaceName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	states := map[portKey]string{}
+	for rows.Next() {
+		var k portKey
+		var state string
+		if err := rows.Scan(&k.port, &k.proto, &state); err != nil {
+			return nil, err
+		}
+		states[k] = state
+	}
+	return states, rows.Err()
+}
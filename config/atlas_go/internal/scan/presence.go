@@ -0,0 +1,180 @@
+package scan
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"atlas/internal/utils"
+)
+
+// PresenceScanOptions controls the lightweight up/down refresh.
+type PresenceScanOptions struct {
+	SkipDB bool
+	// NoResolve passes -n to nmap so discovery skips reverse DNS.
+	NoResolve    bool
+	Remote       RemotePayloadOptions
+	MinPrefixLen int
+	// PrimaryInterfaces picks which interface a shared subnet is scanned
+	// and reported under, most preferred first.
+	PrimaryInterfaces []string
+	// Discovery selects where live hosts come from (see DiscoveryNmap).
+	Discovery string
+	// IncludeReserved keeps addresses dropReservedHosts would filter.
+	IncludeReserved bool
+	// AllowPublic keeps addresses dropPublicHosts would filter.
+	AllowPublic bool
+	// Liveness is the --liveness policy; ports is not meaningful here
+	// because presence scans do not port-scan.
+	Liveness string
+}
+
+// PresenceScan discovers live hosts and pings them, refreshing only
+// online_status and last_seen. It never touches OS, port, or MAC columns.
+func PresenceScan(opts PresenceScanOptions) error {
+	start := time.Now()
+	runID := opts.Remote.runID(start)
+	logf := func(format string, args ...any) {
+		fmt.Printf(format+"\n", args...)
+	}
+
+	interfaces, err := utils.GetAllInterfaces()
+	if err != nil {
+		return fmt.Errorf("%w: failed to detect network interfaces: %v", ErrNoInterfaces, err)
+	}
+	interfaces = sanitizeInterfaces(interfaces, opts.MinPrefixLen, opts.PrimaryInterfaces, logf)
+	if len(interfaces) == 0 {
+		return fmt.Errorf("%w: no interface subnet passed validation", ErrNoInterfaces)
+	}
+
+	var discoveryArgs []string
+	if opts.NoResolve {
+		discoveryArgs = append(discoveryArgs, "-n")
+	}
+
+	var hostInfos []HostInfo
+	var nmapRuns nmapRunTotals
+	// discoveredOn lists interfaces whose sweep succeeded; a failed sweep
+	// says nothing about which of its hosts went away.
+	var discoveredOn []string
+	for _, iface := range interfaces {
+		hosts, stats, err := discoverHosts(context.Background(), iface.Subnet, opts.Discovery, DiscoveryPingICMP, logf, discoveryArgs...)
+		nmapRuns.Discovery.add(stats)
+		if err != nil {
+			logf(utils.Deco("⚠️ Failed to discover hosts on %s (interface: %s): %v"), iface.Subnet, iface.Name, err)
+			if errors.Is(err, ErrNmapNotFound) {
+				return err
+			}
+			continue
+		}
+		discoveredOn = append(discoveredOn, iface.Name)
+		hosts = filterDiscovered(hosts, iface.Subnet, opts.IncludeReserved, opts.AllowPublic, logf)
+		for _, host := range hosts {
+			host.InterfaceName = iface.Name
+			hostInfos = append(hostInfos, host)
+		}
+	}
+
+	selfIPs := selfAddresses(interfaces)
+	neighbors := readNeighborTable()
+	records := make([]HostRecord, len(hostInfos))
+	var wg sync.WaitGroup
+	for idx, host := range hostInfos {
+		wg.Add(1)
+		go func(idx int, host HostInfo) {
+			defer wg.Done()
+			signals := gatherLiveness(host.IP, utils.PingHost(host.IP), nil, neighbors)
+			status := signals.status(opts.Liveness)
+			records[idx] = HostRecord{
+				IP:            host.IP,
+				Hostname:      host.Name,
+				InterfaceName: host.InterfaceName,
+				NetworkName:   "LAN",
+				LastSeen:      time.Now(),
+				OnlineStatus:  status,
+				Metadata: map[string]any{
+					"scanner":          "presencescan",
+					"run_id":           runID,
+					"online_status":    status,
+					"liveness_signals": signals.names(),
+				},
+			}
+			tagSelf(&records[idx], selfIPs)
+		}(idx, host)
+	}
+	wg.Wait()
+	sortHosts(records)
+
+	online := 0
+	for _, r := range records {
+		if r.OnlineStatus == "online" {
+			online++
+		}
+	}
+	logf("Presence scan found %d hosts (%d answering ping) in %s", len(records), online, time.Since(start))
+
+	if !opts.SkipDB {
+		if err := savePresenceToDB(dbPath, discoveredOn, records, start, opts.Remote.Config.AgentID); err != nil {
+			return err
+		}
+		run := scanRun{ID: runID, Scanner: "presencescan", StartedAt: start, FinishedAt: time.Now(), HostCount: len(records), Nmap: nmapRuns}
+		if err := saveScanRun(dbPath, run); err != nil {
+			logf(utils.Deco("⚠️ Failed to record scan run: %v"), err)
+		}
+	}
+	meta := map[string]any{"run_id": runID, "interfaces": utils.DescribeInterfaces(interfaces)}
+	nmapRuns.apply(meta)
+	// Any DB failure has already returned above.
+	return opts.Remote.tolerate(emitHosts(records, meta, opts.Remote), !opts.SkipDB)
+}
+
+// savePresenceToDB upserts the discovered hosts, updating only presence
+// columns on conflict, and then marks hosts on the interfaces in discoveredOn
+// offline when this run, which started at start, did not see them. agentID is
+// stored on every upserted host.
+func savePresenceToDB(path string, discoveredOn []string, hosts []HostRecord, start time.Time, agentID string) error {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrDatabase, err)
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrDatabase, err)
+	}
+	defer tx.Rollback()
+	for _, h := range hosts {
+		if err := upsertPresence(tx, h, agentID); err != nil {
+			return err
+		}
+	}
+	if _, err := markStaleOffline(tx, discoveredOn, start.Truncate(time.Second)); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%w: %w", ErrDatabase, err)
+	}
+	return nil
+}
+
+// upsertPresence records that h was seen without touching its ports, OS, or
+// other scan details. agentID is stored like upsertHost stores it.
+func upsertPresence(db sqlExecutor, h HostRecord, agentID string) error {
+	_, err := db.Exec(`
+        INSERT INTO hosts (ip, name, network_name, interface_name, first_seen, last_seen, online_status, first_agent, agent_id)
+        VALUES (?, ?, ?, ?, ?, ?, ?, NULLIF(?, ''), NULLIF(?, ''))
+        ON CONFLICT(ip, interface_name) DO UPDATE SET
+            last_seen=excluded.last_seen,
+            online_status=excluded.online_status,
+            first_agent=COALESCE(hosts.first_agent, excluded.first_agent),
+            agent_id=excluded.agent_id
+    `, h.IP, h.Hostname, h.NetworkName, h.InterfaceName, dbTime(h.LastSeen), dbTime(h.LastSeen), h.OnlineStatus, agentID, agentID)
+	if err != nil {
+		return fmt.Errorf("%w: presence upsert %s: %w", ErrDatabase, h.IP, err)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package scan
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSavePresenceKeepsHostsOnFailedInterfaces(t *testing.T) {
+	conn := openTestDB(t)
+	earlier := time.Now().Add(-time.Hour)
+	for _, h := range []HostRecord{
+		{IP: "192.168.1.10", InterfaceName: "eth0", LastSeen: earlier, OnlineStatus: "online"},
+		{IP: "192.168.1.11", InterfaceName: "eth0", LastSeen: earlier, OnlineStatus: "online"},
+		{IP: "10.0.0.10", InterfaceName: "wlan0", LastSeen: earlier, OnlineStatus: "online"},
+	} {
+		if err := upsertPresence(conn, h, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Discovery succeeded on eth0 and found one host; wlan0's sweep failed.
+	start := time.Now()
+	seen := []HostRecord{{IP: "192.168.1.10", InterfaceName: "eth0", LastSeen: start, OnlineStatus: "online"}}
+	if err := savePresenceToDB(dbPath, []string{"eth0"}, seen, start, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{"192.168.1.10": "online", "192.168.1.11": "offline", "10.0.0.10": "online"}
+	for ip, status := range want {
+		var got string
+		if err := conn.QueryRow("SELECT online_status FROM hosts WHERE ip = ?", ip).Scan(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got != status {
+			t.Errorf("%s: online_status %q, want %q", ip, got, status)
+		}
+	}
+}
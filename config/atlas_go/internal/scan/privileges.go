@@ -0,0 +1,144 @@
+package scan
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"atlas/internal/utils"
+)
+
+// procSelfStatus is read for the process's capability sets.
+var procSelfStatus = "/proc/self/status"
+
+// Capability bits from linux/capability.h.
+const (
+	capNetAdmin = 12
+	capNetRaw   = 13
+)
+
+// scanPrivileges is what nmap, started by this process, will be allowed to do.
+type scanPrivileges struct {
+	Root bool `json:"root"`
+	// RawSockets (CAP_NET_RAW) is what OS detection, SYN scans, and
+	// traceroute need. Root inside a container started without NET_RAW
+	// lacks it.
+	RawSockets bool `json:"cap_net_raw"`
+	NetAdmin   bool `json:"cap_net_admin"`
+}
+
+// currentPrivileges is swapped out by tests.
+var currentPrivileges = detectPrivileges
+
+// detectPrivileges checks the effective UID and, on Linux, the capability
+// sets in /proc/self/status. nmap run by root keeps root's effective set;
+// run by anyone else it only inherits the ambient set (e.g. systemd's
+// AmbientCapabilities=CAP_NET_RAW). Without /proc, root is assumed to have
+// every capability. NMAP_PRIVILEGED, which nmap itself honors, vouches for
+// raw sockets granted some other way, such as file capabilities on nmap.
+func detectPrivileges() scanPrivileges {
+	p := scanPrivileges{Root: os.Geteuid() == 0}
+	if !p.Root && os.Getenv("NMAP_PRIVILEGED") != "" {
+		p.RawSockets, p.NetAdmin = true, true
+		return p
+	}
+	b, err := os.ReadFile(procSelfStatus)
+	if err != nil {
+		p.RawSockets, p.NetAdmin = p.Root, p.Root
+		return p
+	}
+	set := "CapAmb"
+	if p.Root {
+		set = "CapEff"
+	}
+	caps := parseCapabilitySet(string(b), set)
+	p.RawSockets = caps&(1<<capNetRaw) != 0
+	p.NetAdmin = caps&(1<<capNetAdmin) != 0
+	return p
+}
+
+// parseCapabilitySet returns the named hex capability mask from
+// /proc/<pid>/status content, e.g. "CapEff:\t00000000a80425fb"; a missing or
+// malformed line yields 0.
+func parseCapabilitySet(status, name string) uint64 {
+	for _, line := range strings.Split(status, "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok || key != name {
+			continue
+		}
+		mask, err := strconv.ParseUint(strings.TrimSpace(value), 16, 64)
+		if err != nil {
+			return 0
+		}
+		return mask
+	}
+	return 0
+}
+
+// isPrivileged reports whether nmap will be able to open raw sockets, which
+// OS detection requires.
+func isPrivileged() bool {
+	return currentPrivileges().RawSockets
+}
+
+// scanCapabilities records the privileges a run had and the nmap features
+// they left it, as scan_capabilities run metadata.
+type scanCapabilities struct {
+	scanPrivileges
+	// ScanType is nmap's TCP scan technique: syn when the run passes -sS,
+	// connect otherwise.
+	ScanType   string `json:"scan_type"`
+	OSDetect   bool   `json:"os_detect"`
+	Traceroute bool   `json:"traceroute"`
+	// Disabled lists the requested features turned off for lack of
+	// privileges.
+	Disabled []string `json:"disabled,omitempty"`
+}
+
+// adjustForPrivileges disables nmap features that need raw sockets when p
+// lacks them, logging a warning instead of letting nmap fail or silently
+// degrade; a SYN scan falls back to a connect scan. With require
+// (--require-privileged) the run fails instead. A non-root process that
+// has CAP_NET_RAW gets nmap's --privileged so nmap uses it.
+func adjustForPrivileges(tcp TCPScanOptions, p scanPrivileges, require bool, logProgress io.Writer) (TCPScanOptions, scanCapabilities, error) {
+	caps := scanCapabilities{scanPrivileges: p, ScanType: scanType(tcp)}
+	if p.RawSockets {
+		tcp.Privileged = !p.Root
+		caps.OSDetect, caps.Traceroute = tcp.OSDetect, tcp.Traceroute
+		return tcp, caps, nil
+	}
+	reason := "not running as root"
+	if p.Root {
+		reason = "running as root without CAP_NET_RAW"
+	}
+	if require {
+		return tcp, caps, fmt.Errorf("%w: %s; run as root with CAP_NET_RAW (docker --cap-add NET_RAW) or drop --require-privileged", ErrUnprivileged, reason)
+	}
+	if tcp.OSDetect {
+		fmt.Fprintf(logProgress, utils.Deco("⚠️ %s; disabling OS detection (-O). Use --no-os-detect to silence this warning.\n"), reason)
+		tcp.OSDetect = false
+		caps.Disabled = append(caps.Disabled, "os_detect")
+	}
+	if tcp.SYNScan {
+		fmt.Fprintf(logProgress, utils.Deco("⚠️ %s; using a TCP connect scan instead of a SYN scan (-sS).\n"), reason)
+		tcp.SYNScan = false
+		caps.Disabled = append(caps.Disabled, "syn_scan")
+	}
+	if tcp.Traceroute {
+		fmt.Fprintf(logProgress, utils.Deco("⚠️ %s; disabling --traceroute.\n"), reason)
+		tcp.Traceroute = false
+		caps.Disabled = append(caps.Disabled, "traceroute")
+	}
+	caps.ScanType = scanType(tcp)
+	return tcp, caps, nil
+}
+
+// scanType names the TCP scan technique tcp asks nmap for.
+func scanType(tcp TCPScanOptions) string {
+	if tcp.SYNScan {
+		return "syn"
+	}
+	return "connect"
+}
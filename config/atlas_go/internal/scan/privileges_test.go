@@ -0,0 +1,56 @@
+package scan
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAdjustForPrivileges(t *testing.T) {
+	status := "Name:\tatlas\nCapEff:\t00000000a80425fb\nCapAmb:\t0000000000002000\n"
+	if eff := parseCapabilitySet(status, "CapEff"); eff&(1<<capNetRaw) == 0 || eff&(1<<capNetAdmin) != 0 {
+		t.Fatalf("docker default CapEff should have NET_RAW but not NET_ADMIN: %x", eff)
+	}
+	if amb := parseCapabilitySet(status, "CapAmb"); amb != 1<<capNetRaw {
+		t.Fatalf("CapAmb = %x", amb)
+	}
+	if parseCapabilitySet(status, "CapBnd") != 0 {
+		t.Fatal("missing set should be empty")
+	}
+
+	requested := TCPScanOptions{TopPorts: 200, OSDetect: true, SYNScan: true, Traceroute: true}
+	tcp, caps, err := adjustForPrivileges(requested, scanPrivileges{Root: true}, false, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tcp.OSDetect || tcp.SYNScan || tcp.Traceroute || caps.ScanType != "connect" {
+		t.Fatalf("root without NET_RAW should fall back: %+v %+v", tcp, caps)
+	}
+	if want := []string{"os_detect", "syn_scan", "traceroute"}; !reflect.DeepEqual(caps.Disabled, want) {
+		t.Fatalf("disabled = %v", caps.Disabled)
+	}
+
+	if _, _, err := adjustForPrivileges(requested, scanPrivileges{}, true, io.Discard); !errors.Is(err, ErrUnprivileged) || !isFatalScanError(err) {
+		t.Fatalf("--require-privileged should fail fatally, got %v", err)
+	}
+
+	tcp, caps, err = adjustForPrivileges(requested, scanPrivileges{RawSockets: true}, true, io.Discard)
+	if err != nil || !tcp.OSDetect || !caps.OSDetect || caps.ScanType != "syn" || len(caps.Disabled) != 0 {
+		t.Fatalf("ambient NET_RAW should keep every feature: %+v %+v %v", tcp, caps, err)
+	}
+	if args := tcp.nmapArgs("10.0.0.5", "out.log"); args[0] != "--privileged" {
+		t.Fatalf("non-root nmap needs --privileged: %v", args)
+	}
+	noSYN := requested
+	noSYN.SYNScan = false
+	if _, caps, _ := adjustForPrivileges(noSYN, scanPrivileges{Root: true, RawSockets: true}, false, io.Discard); caps.ScanType != "connect" {
+		t.Fatalf("a run without -sS reported scan type %q", caps.ScanType)
+	}
+	b, _ := json.Marshal(caps)
+	if !strings.Contains(string(b), `"cap_net_raw":true`) || !strings.Contains(string(b), `"scan_type":"syn"`) {
+		t.Fatalf("scan_capabilities metadata: %s", b)
+	}
+}
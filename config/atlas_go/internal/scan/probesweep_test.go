@@ -0,0 +1,83 @@
+package scan
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestProbeSweepKeepsStoredScan(t *testing.T) {
+	opts, err := ProbeSweepOptions(DeepScanOptions{TCP: scanProfiles["thorough"], Count: 3, RescanEmpty: true}, 445)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(opts.TCP.nmapArgs("10.0.0.5", "out.gnmap"), " "); got != "-Pn -p 445 -T4 10.0.0.5 -oG out.gnmap" {
+		t.Fatalf("probe args %q", got)
+	}
+	if opts.Count != 1 || opts.RescanEmpty || opts.Profile != "probe" {
+		t.Fatalf("probe left deep scan settings on: %+v", opts)
+	}
+	for _, port := range []int{0, 65536} {
+		if _, err := ProbeSweepOptions(DeepScanOptions{}, port); err == nil {
+			t.Errorf("port %d accepted", port)
+		}
+	}
+
+	useFakeRunner(t, func(name string, args []string) ([]byte, error) {
+		return nil, fmt.Errorf("%s ran outside the replay", name)
+	})
+	conn := openTestDB(t)
+	stored := HostRecord{IP: "192.168.1.10", Hostname: "nas.lan", OS: "Linux 5.x", PortSummary: "22/tcp (ssh), 80/tcp (http)", InterfaceName: "eth0", OnlineStatus: "online"}
+	if err := upsertHost(conn, &stored, NamePreferNew, ""); err != nil {
+		t.Fatal(err)
+	}
+	capture := filepath.Join(t.TempDir(), "capture.gnmap")
+	if err := os.WriteFile(capture, []byte(grepableFixture), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	opts.Replay = capture
+	opts.Remote.JSONOutPath = filepath.Join(t.TempDir(), "payload.json")
+	if err := DeepScan(opts); err != nil {
+		t.Fatalf("DeepScan: %v", err)
+	}
+	var osDetails, ports string
+	if err := conn.QueryRow("SELECT os_details, open_ports FROM hosts WHERE ip = ?", "192.168.1.10").Scan(&osDetails, &ports); err != nil {
+		t.Fatal(err)
+	}
+	if osDetails != stored.OS || ports != stored.PortSummary {
+		t.Fatalf("probe overwrote the stored scan: %q / %q", osDetails, ports)
+	}
+	b, err := os.ReadFile(opts.Remote.JSONOutPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var payload RemotePayload
+	if err := json.Unmarshal(b, &payload); err != nil || len(payload.Hosts) != 1 {
+		t.Fatalf("payload %s: %v", b, err)
+	}
+	if payload.Metadata["probe_port"] != float64(445) || payload.Hosts[0].Metadata["scanner"] != "probesweep" {
+		t.Fatalf("probe not labelled: %v / %v", payload.Metadata["probe_port"], payload.Hosts[0].Metadata)
+	}
+}
+
+func TestProbeSweepRecordsClosedPort(t *testing.T) {
+	opts, err := ProbeSweepOptions(DeepScanOptions{}, 445)
+	if err != nil {
+		t.Fatal(err)
+	}
+	useFakeRunner(t, func(name string, args []string) ([]byte, error) {
+		writeGrepable(t, args, "Host: 192.168.1.10 ()\tPorts: 445/closed/tcp//microsoft-ds///\n")
+		return nil, nil
+	})
+	result := scanAllTcp(context.Background(), "192.168.1.10", opts.TCP, t.TempDir(), io.Discard)
+	want := []RemotePort{{Port: 445, Protocol: "tcp", Service: "microsoft-ds", State: "closed"}}
+	if !reflect.DeepEqual(result.Ports.Ports, want) {
+		t.Fatalf("probed port %+v, want %+v", result.Ports.Ports, want)
+	}
+}
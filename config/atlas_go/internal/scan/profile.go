@@ -0,0 +1,127 @@
+package scan
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultProfile is used when no --profile is given.
+const DefaultProfile = "standard"
+
+// TCPScanOptions tunes the per-host nmap port scan run by DeepScan.
+type TCPScanOptions struct {
+	// TopPorts scans nmap's N most common ports; ignored when Ports is set.
+	TopPorts int
+	// Ports is an explicit nmap -p specification ("-" for all ports).
+	Ports string
+	// Timing selects nmap's -T template (1-5); 0 leaves nmap's default.
+	Timing         int
+	OSDetect       bool
+	ServiceVersion bool
+	// SkipPing passes -Pn so hosts that drop ICMP are still port scanned.
+	SkipPing bool
+	SYNScan  bool
+	// Privileged passes --privileged, telling nmap it has raw sockets
+	// despite not running as root (see adjustForPrivileges).
+	Privileged bool
+	// Traceroute adds --traceroute so each host's next hop can be recorded.
+	Traceroute bool
+	// Interface and SourceIP pin the scan to one NIC (nmap -e / -S). DeepScan
+	// sets them per host when BindInterface is on.
+	Interface string
+	SourceIP  string
+	// AllPortStates keeps closed ports in the results, so a probesweep
+	// records its one port whatever nmap found.
+	AllPortStates bool
+}
+
+var scanProfiles = map[string]TCPScanOptions{
+	"quick":    {TopPorts: 100, Timing: 4, SkipPing: true},
+	"standard": {TopPorts: 200, Timing: 4, SkipPing: true, OSDetect: true},
+	"thorough": {Ports: "-", Timing: 4, SkipPing: true, OSDetect: true, ServiceVersion: true},
+	"stealth":  {TopPorts: 200, Timing: 2, SkipPing: true, SYNScan: true},
+}
+
+// ProfileNames lists the accepted --profile values in sorted order.
+func ProfileNames() []string {
+	names := make([]string, 0, len(scanProfiles))
+	for name := range scanProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DeepScanProfile returns DeepScanOptions populated from the named preset.
+// Callers may override individual TCP fields afterwards.
+func DeepScanProfile(name string) (DeepScanOptions, error) {
+	if name == "" {
+		name = DefaultProfile
+	}
+	name = strings.ToLower(strings.TrimSpace(name))
+	tcp, ok := scanProfiles[name]
+	if !ok {
+		return DeepScanOptions{}, fmt.Errorf("unknown scan profile %q (valid: %s)", name, strings.Join(ProfileNames(), ", "))
+	}
+	return DeepScanOptions{Profile: name, TCP: tcp}, nil
+}
+
+// ProbeSweepOptions narrows opts to a probesweep of one TCP port: every live
+// host gets a single "nmap -Pn -p PORT" pass, without OS or version
+// detection, traceroute, or rescans. Discovery, targeting, and output
+// settings are kept; only --timing carries over from the port scan settings.
+func ProbeSweepOptions(opts DeepScanOptions, port int) (DeepScanOptions, error) {
+	if port < 1 || port > 65535 {
+		return opts, fmt.Errorf("--port must be between 1 and 65535")
+	}
+	opts.Profile = "probe"
+	opts.ProbePort = port
+	opts.TCP = TCPScanOptions{Ports: strconv.Itoa(port), Timing: opts.TCP.Timing, SkipPing: true, AllPortStates: true}
+	opts.Count = 1
+	opts.RescanEmpty = false
+	opts.SSHFingerprint = false
+	opts.Incremental = nil
+	return opts, nil
+}
+
+// nmapArgs builds the nmap argument list for scanning ip, writing grepable
+// output to logFile.
+func (o TCPScanOptions) nmapArgs(ip, logFile string) []string {
+	var args []string
+	if o.Privileged {
+		args = append(args, "--privileged")
+	}
+	if o.SYNScan {
+		args = append(args, "-sS")
+	}
+	if o.OSDetect {
+		args = append(args, "-O")
+	}
+	if o.ServiceVersion {
+		args = append(args, "-sV")
+	}
+	if o.SkipPing {
+		args = append(args, "-Pn")
+	}
+	switch {
+	case o.Ports != "":
+		args = append(args, "-p", o.Ports)
+	case o.TopPorts > 0:
+		args = append(args, "--top-ports", strconv.Itoa(o.TopPorts))
+	default:
+		args = append(args, "--top-ports", topTcpPorts)
+	}
+	if o.Timing > 0 {
+		args = append(args, fmt.Sprintf("-T%d", o.Timing))
+	}
+	if o.Traceroute {
+		args = append(args, "--traceroute")
+	}
+	if strings.Contains(ip, ":") {
+		args = append(args, "-6")
+	}
+	args = append(args, sourceArgs(o.Interface, o.SourceIP)...)
+	return append(args, ip, "-oG", logFile)
+}
@@ -0,0 +1,20 @@
+package scan
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStandardProfileMatchesLegacyArgs(t *testing.T) {
+	opts, err := DeepScanProfile("")
+	if err != nil {
+		t.Fatalf("DeepScanProfile: %v", err)
+	}
+	got := strings.Join(opts.TCP.nmapArgs("10.0.0.5", "out.log"), " ")
+	if want := "-O -Pn --top-ports 200 -T4 10.0.0.5 -oG out.log"; got != want {
+		t.Fatalf("want %q, got %q", want, got)
+	}
+	if _, err := DeepScanProfile("turbo"); err == nil {
+		t.Fatal("expected error for unknown profile")
+	}
+}
@@ -0,0 +1,111 @@
+package scan
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"atlas/internal/utils"
+)
+
+// writePromTextfile replaces path with the run's results in the Prometheus
+// text exposition format, for node_exporter's textfile collector
+// (--prom-textfile). The file is written next to path and renamed into place,
+// so the collector never reads a partial file; the temporary name does not
+// end in .prom, so the collector skips it.
+func writePromTextfile(path string, hosts []HostRecord, meta map[string]any, now time.Time) error {
+	var b strings.Builder
+	metric := func(name, help, typ string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+
+	metric("atlas_scan_timestamp_seconds", "Unix time the last atlas scan finished.", "gauge")
+	fmt.Fprintf(&b, "atlas_scan_timestamp_seconds %d\n", now.Unix())
+
+	var online int
+	for _, h := range hosts {
+		if h.OnlineStatus != "offline" {
+			online++
+		}
+	}
+	metric("atlas_scan_hosts", "Hosts reported by the last scan, by online status.", "gauge")
+	fmt.Fprintf(&b, "atlas_scan_hosts{status=\"online\"} %d\n", online)
+	fmt.Fprintf(&b, "atlas_scan_hosts{status=\"offline\"} %d\n", len(hosts)-online)
+
+	if health, ok := meta["health_score"].(HealthScore); ok {
+		metric("atlas_health_score", "Network health score of the last scan (0-100).", "gauge")
+		fmt.Fprintf(&b, "atlas_health_score %g\n", health.Score)
+	}
+	if stats, ok := meta["subnet_stats"].([]SubnetStats); ok && len(stats) > 0 {
+		metric("atlas_subnet_live_hosts", "Live hosts per scanned subnet.", "gauge")
+		for _, s := range stats {
+			fmt.Fprintf(&b, "atlas_subnet_live_hosts%s %d\n", promLabels("subnet", s.Subnet, "interface", s.Interface), s.LiveHosts)
+		}
+		metric("atlas_subnet_utilization_ratio", "Share of each subnet's usable addresses in use.", "gauge")
+		for _, s := range stats {
+			fmt.Fprintf(&b, "atlas_subnet_utilization_ratio%s %g\n", promLabels("subnet", s.Subnet, "interface", s.Interface), s.Utilization)
+		}
+	}
+
+	sorted := append([]HostRecord(nil), hosts...)
+	sort.SliceStable(sorted, func(i, j int) bool { return utils.CompareIP(sorted[i].IP, sorted[j].IP) < 0 })
+	if len(sorted) > 0 {
+		metric("atlas_host_online", "1 if the host was online in the last scan, else 0.", "gauge")
+		for _, h := range sorted {
+			up := 0
+			if h.OnlineStatus != "offline" {
+				up = 1
+			}
+			fmt.Fprintf(&b, "atlas_host_online%s %d\n", hostPromLabels(h), up)
+		}
+		metric("atlas_host_open_ports", "Open ports found on the host by the last scan.", "gauge")
+		for _, h := range sorted {
+			open := 0
+			for _, p := range h.Ports {
+				if p.State == "open" {
+					open++
+				}
+			}
+			fmt.Fprintf(&b, "atlas_host_open_ports%s %d\n", hostPromLabels(h), open)
+		}
+		metric("atlas_host_last_seen_timestamp_seconds", "Unix time the host was last seen.", "gauge")
+		for _, h := range sorted {
+			if !h.LastSeen.IsZero() {
+				fmt.Fprintf(&b, "atlas_host_last_seen_timestamp_seconds%s %d\n", hostPromLabels(h), h.LastSeen.Unix())
+			}
+		}
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(b.String()), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// hostPromLabels identifies a host by IP and the interface it was found on.
+// The hostname is left out so a rename does not start a new series.
+func hostPromLabels(h HostRecord) string {
+	return promLabels("ip", h.IP, "interface", h.InterfaceName)
+}
+
+// promLabels renders name/value pairs as {name="value",...}, escaping values
+// and skipping empty ones.
+func promLabels(pairs ...string) string {
+	var parts []string
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i+1] == "" {
+			continue
+		}
+		parts = append(parts, pairs[i]+`="`+promEscaper.Replace(pairs[i+1])+`"`)
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// promEscaper applies the only escapes the text format knows in label values.
+var promEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
@@ -0,0 +1,50 @@
+package scan
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEmitHostsWritesPromTextfile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "atlas.prom")
+	hosts := append(samplePayloadHosts(), HostRecord{IP: "192.168.1.2", InterfaceName: `we"ird`, OnlineStatus: "offline"})
+	meta := map[string]any{"subnet_stats": []SubnetStats{{Subnet: "192.168.1.0/24", Interface: "eth0", LiveHosts: 2, Utilization: 0.5}}}
+	if err := emitHosts(hosts, meta, RemotePayloadOptions{PromTextfile: path}); err != nil {
+		t.Fatalf("emitHosts: %v", err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(b)
+	for _, want := range []string{
+		"# TYPE atlas_host_online gauge\n",
+		"atlas_scan_hosts{status=\"online\"} 1\n",
+		"atlas_scan_hosts{status=\"offline\"} 1\n",
+		`atlas_host_online{ip="192.168.1.2",interface="we\"ird"} 0` + "\n",
+		`atlas_host_online{ip="192.168.1.10",interface="eth0"} 1` + "\n",
+		`atlas_host_open_ports{ip="192.168.1.10",interface="eth0"} 1` + "\n",
+		`atlas_host_last_seen_timestamp_seconds{ip="192.168.1.10",interface="eth0"} 1704164645` + "\n",
+		`atlas_subnet_utilization_ratio{subnet="192.168.1.0/24",interface="eth0"} 0.5` + "\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in\n%s", want, got)
+		}
+	}
+	if strings.Index(got, `ip="192.168.1.2"`) > strings.Index(got, `ip="192.168.1.10"`) {
+		t.Error("hosts should be in IP order")
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file left behind: %v", err)
+	}
+
+	// An empty run still replaces the file, so departed hosts disappear.
+	if err := emitHosts(nil, nil, RemotePayloadOptions{PromTextfile: path}); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(path); strings.Contains(string(b), "atlas_host_online") || !strings.Contains(string(b), "atlas_scan_hosts{status=\"online\"} 0") {
+		t.Fatalf("empty run metrics:\n%s", b)
+	}
+}
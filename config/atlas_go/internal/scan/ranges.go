@@ -0,0 +1,62 @@
+package scan
+
+import (
+	"fmt"
+	"net/netip"
+	"sort"
+)
+
+// summarizeIPRanges collapses ips into a compact, sorted list of ranges such
+// as "192.168.1.10-14" or "192.168.1.20". Runs that exactly fill an aligned
+// block of four or more addresses are written as a CIDR instead. Duplicate and
+// unparseable entries are ignored.
+func summarizeIPRanges(ips []string) []string {
+	var addrs []netip.Addr
+	seen := map[netip.Addr]bool{}
+	for _, s := range ips {
+		a, err := netip.ParseAddr(s)
+		if err != nil {
+			continue
+		}
+		a = a.Unmap()
+		if !seen[a] {
+			seen[a] = true
+			addrs = append(addrs, a)
+		}
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i].Less(addrs[j]) })
+
+	var out []string
+	for i := 0; i < len(addrs); {
+		j := i
+		for j+1 < len(addrs) && addrs[j].Next() == addrs[j+1] {
+			j++
+		}
+		out = append(out, formatIPRange(addrs[i], addrs[j], j-i+1))
+		i = j + 1
+	}
+	return out
+}
+
+// formatIPRange renders the inclusive range first..last holding n addresses.
+func formatIPRange(first, last netip.Addr, n int) string {
+	if n == 1 {
+		return first.String()
+	}
+	if n >= 4 && n&(n-1) == 0 {
+		bits := first.BitLen()
+		for size := n; size > 1; size >>= 1 {
+			bits--
+		}
+		if p := netip.PrefixFrom(first, bits); p.Masked().Addr() == first {
+			return p.String()
+		}
+	}
+	if first.Is4() {
+		a, b := first.As4(), last.As4()
+		if a[0] == b[0] && a[1] == b[1] && a[2] == b[2] {
+			return fmt.Sprintf("%s-%d", first, b[3])
+		}
+	}
+	return first.String() + "-" + last.String()
+}
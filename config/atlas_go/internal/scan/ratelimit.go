@@ -0,0 +1,83 @@
+package scan
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ParseHostRate reads a --max-rate-hosts value such as "5/sec", "0.5/s",
+// "30/min", or a bare "5" (per second) and returns hosts per second. Empty
+// or zero means no limit.
+func ParseHostRate(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	num, unit, _ := strings.Cut(s, "/")
+	n, err := strconv.ParseFloat(strings.TrimSpace(num), 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("--max-rate-hosts %q: want N/sec or N/min", s)
+	}
+	switch strings.ToLower(strings.TrimSpace(unit)) {
+	case "", "s", "sec", "second":
+		return n, nil
+	case "m", "min", "minute":
+		return n / 60, nil
+	}
+	return 0, fmt.Errorf("--max-rate-hosts %q: unknown unit %q (use sec or min)", s, unit)
+}
+
+// hostRateLimiter is a token bucket of size one: each host scan may start
+// no sooner than interval after the previous one. It paces how often scans
+// begin, not how many run at once. A nil limiter never waits.
+type hostRateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+	now      func() time.Time
+	after    func(time.Duration) <-chan time.Time
+}
+
+// newHostRateLimiter returns a limiter for perSecond starts, or nil when
+// perSecond is not positive.
+func newHostRateLimiter(perSecond float64) *hostRateLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	return &hostRateLimiter{
+		interval: time.Duration(float64(time.Second) / perSecond),
+		now:      time.Now,
+		after:    time.After,
+	}
+}
+
+// wait blocks until the caller's turn, or returns ctx's error if it ends
+// first.
+func (l *hostRateLimiter) wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	now := l.now()
+	start := l.next
+	if start.Before(now) {
+		start = now
+	}
+	l.next = start.Add(l.interval)
+	l.mu.Unlock()
+
+	delay := start.Sub(now)
+	if delay <= 0 {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-l.after(delay):
+		return nil
+	}
+}
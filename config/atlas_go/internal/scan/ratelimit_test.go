@@ -0,0 +1,67 @@
+package scan
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseHostRate(t *testing.T) {
+	cases := map[string]float64{"": 0, "0": 0, "5": 5, "5/sec": 5, "0.5/s": 0.5, "30/min": 0.5, " 2 / SEC ": 2}
+	for in, want := range cases {
+		got, err := ParseHostRate(in)
+		if err != nil || got != want {
+			t.Errorf("ParseHostRate(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"fast", "-1/sec", "5/hour"} {
+		if _, err := ParseHostRate(in); err == nil {
+			t.Errorf("ParseHostRate(%q) accepted", in)
+		}
+	}
+}
+
+func TestHostRateLimiterPacesStarts(t *testing.T) {
+	if err := (*hostRateLimiter)(nil).wait(context.Background()); err != nil {
+		t.Fatalf("nil limiter: %v", err)
+	}
+	// A fake clock: each wait advances it by the requested delay at once.
+	clock := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var delays []time.Duration
+	limiter := newHostRateLimiter(50) // one start every 20ms
+	limiter.now = func() time.Time { return clock }
+	limiter.after = func(d time.Duration) <-chan time.Time {
+		delays = append(delays, d)
+		clock = clock.Add(d)
+		ch := make(chan time.Time, 1)
+		ch <- clock
+		return ch
+	}
+	for i := 0; i < 5; i++ {
+		if err := limiter.wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []time.Duration{20 * time.Millisecond, 20 * time.Millisecond, 20 * time.Millisecond, 20 * time.Millisecond}
+	if !reflect.DeepEqual(delays, want) {
+		t.Fatalf("delays %v, want %v (the first start must not wait)", delays, want)
+	}
+
+	// A caller that comes back after a pause starts at once.
+	clock = clock.Add(time.Second)
+	delays = nil
+	if err := limiter.wait(context.Background()); err != nil || delays != nil {
+		t.Fatalf("start after a pause waited %v (%v)", delays, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	slow := newHostRateLimiter(0.1)
+	slow.after = func(time.Duration) <-chan time.Time { return nil }
+	_ = slow.wait(ctx) // first start is immediate
+	if err := slow.wait(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected cancellation while waiting, got %v", err)
+	}
+}
@@ -0,0 +1,122 @@
+package scan
+
+import (
+	"fmt"
+	"strings"
+
+	"atlas/internal/utils"
+)
+
+// ParseRedactFields splits a --redact list such as "mac,hostname". Host
+// fields are ip, hostname, os, mac, note, tags, and ports; any other name is
+// taken as a metadata key, on hosts and on the payload itself.
+func ParseRedactFields(s string) ([]string, error) {
+	var fields []string
+	for _, f := range strings.Split(s, ",") {
+		f = strings.ToLower(strings.TrimSpace(f))
+		if f == "" {
+			continue
+		}
+		if strings.ContainsAny(f, " \t=") {
+			return nil, fmt.Errorf("--redact: invalid field %q", f)
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// redactAliases maps a host field to the metadata keys that carry the same
+// data or are derived from it, on hosts and on the payload, so redacting the
+// field leaves no copy behind. The SSH host key and the fingerprint identify
+// the host as well as its address or MAC do.
+var redactAliases = map[string][]string{
+	"ip": {"gateway_ip", "next_hop", "hop_path", "ipv6_addresses", "nmap_raw", "nmap_raw_path",
+		"fingerprint", "ssh_host_key_sha256", "external_ip", "previous_external_ip"},
+	"hostname": {"target_hostname", "nmap_raw", "ssh_host_key_sha256"},
+	"mac": {"gateway_mac", "gateway_vendor", "mac_vendor", "device_type", "ipv6_addresses", "fingerprint",
+		"ssh_host_key_sha256"},
+	"ports": {"port_observations", "nmap_raw", "fingerprint"},
+}
+
+// redactPayload returns a copy of payload with fields blanked for sharing.
+// Blanked strings and lists drop out of the JSON through omitempty, except
+// ip, which stays as "". Each field also removes its redactAliases keys, and
+// ip and mac blank the agent's interface addresses and MACs; ip also drops
+// the live host ranges from subnet_stats. payload itself is left untouched
+// so the controller and the archive still get the full values.
+func redactPayload(payload RemotePayload, fields []string) RemotePayload {
+	if len(fields) == 0 {
+		return payload
+	}
+	drop := map[string]bool{}
+	for _, f := range fields {
+		drop[f] = true
+		for _, alias := range redactAliases[f] {
+			drop[alias] = true
+		}
+	}
+	out := payload
+	out.Metadata = redactMetadata(payload.Metadata, drop)
+	if ifaces, ok := out.Metadata["interfaces"].([]utils.InterfaceDetails); ok && (drop["ip"] || drop["mac"]) {
+		blanked := make([]utils.InterfaceDetails, len(ifaces))
+		for i, iface := range ifaces {
+			if drop["ip"] {
+				iface.IP = ""
+			}
+			if drop["mac"] {
+				iface.MAC = ""
+			}
+			blanked[i] = iface
+		}
+		out.Metadata["interfaces"] = blanked
+	}
+	if stats, ok := out.Metadata["subnet_stats"].([]SubnetStats); ok && drop["ip"] {
+		blanked := make([]SubnetStats, len(stats))
+		for i, s := range stats {
+			s.HostRanges = nil
+			blanked[i] = s
+		}
+		out.Metadata["subnet_stats"] = blanked
+	}
+	out.Hosts = make([]RemoteHostPayload, len(payload.Hosts))
+	for i, h := range payload.Hosts {
+		if drop["ip"] {
+			h.IP = ""
+		}
+		if drop["hostname"] {
+			h.Hostname = ""
+		}
+		if drop["os"] {
+			h.OS = ""
+		}
+		if drop["mac"] {
+			h.MAC = ""
+		}
+		if drop["note"] {
+			h.Note = ""
+		}
+		if drop["tags"] {
+			h.Tags = nil
+		}
+		if drop["ports"] {
+			h.Ports = nil
+		}
+		h.Metadata = redactMetadata(h.Metadata, drop)
+		out.Hosts[i] = h
+	}
+	return out
+}
+
+// redactMetadata copies meta without the keys in drop.
+func redactMetadata(meta map[string]any, drop map[string]bool) map[string]any {
+	if meta == nil {
+		return nil
+	}
+	out := make(map[string]any, len(meta))
+	for k, v := range meta {
+		if !drop[k] {
+			out[k] = v
+		}
+	}
+	return out
+}
@@ -0,0 +1,122 @@
+package scan
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"atlas/internal/utils"
+)
+
+// walkJSON calls visit with every object key and string value in v.
+func walkJSON(v any, visit func(s string)) {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, child := range v {
+			visit(k)
+			walkJSON(child, visit)
+		}
+	case []any:
+		for _, child := range v {
+			walkJSON(child, visit)
+		}
+	case string:
+		visit(v)
+	}
+}
+
+func TestRedactPayloadLeavesNoDerivedCopies(t *testing.T) {
+	host := HostRecord{
+		IP:            "192.168.1.10",
+		Hostname:      "vault-7",
+		MAC:           "aa:bb:cc:00:00:01",
+		OS:            "Linux",
+		Ports:         []RemotePort{{Port: 22, Protocol: "tcp", Service: "ssh", State: "open"}},
+		NextHop:       "192.168.1.1",
+		InterfaceName: "eth0",
+		LastSeen:      time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		Metadata: map[string]any{
+			"gateway_ip":          "192.168.1.1",
+			"gateway_mac":         "aa:bb:cc:00:00:99",
+			"hop_path":            []string{"192.168.1.1", "192.168.1.10"},
+			"ipv6_addresses":      []string{"fe80::a8bb:ccff:fe00:1"},
+			"target_hostname":     "vault-7.lan",
+			"nmap_raw":            "Host: 192.168.1.10 (vault-7)\tPorts: 22/open/tcp//ssh///\n",
+			"nmap_raw_path":       "/config/logs/nmap_tcp_192_168_1_10.log",
+			"ssh_host_key_sha256": "SHA256:hostkeyhostkey",
+			"mac_vendor":          "Synology",
+			"device_type":         "nas",
+		},
+	}
+	stats := []SubnetStats{{Subnet: "192.168.1.0/24", Interface: "eth0", LiveHosts: 1, Usable: 254, HostRanges: []string{"192.168.1.10"}}}
+	meta := map[string]any{
+		"run_id":               "run-1",
+		"external_ip":          "203.0.113.7",
+		"previous_external_ip": "203.0.113.6",
+		"subnet_stats":         stats,
+		"interfaces":           []utils.InterfaceDetails{{Name: "eth0", IP: "192.168.1.5", MAC: "aa:bb:cc:00:00:05"}},
+	}
+	payload := hostsPayload([]HostRecord{host}, meta, RemotePayloadOptions{Config: RemoteConfig{SiteID: "lab", AgentID: "edge01"}})
+
+	fields, err := ParseRedactFields("ip,hostname,mac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(redactPayload(payload, fields))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc any
+	if err := json.Unmarshal(b, &doc); err != nil {
+		t.Fatal(err)
+	}
+	secrets := []string{"192.168.1.1", "192_168_1_10", "vault-7", "aa:bb:cc", "fe80::", "203.0.113.", "hostkey", "Synology", "fingerprint"}
+	walkJSON(doc, func(s string) {
+		for _, secret := range secrets {
+			if strings.Contains(s, secret) {
+				t.Errorf("redacted payload still carries %q in %q", secret, s)
+			}
+		}
+	})
+	if !strings.Contains(string(b), `"run-1"`) || !strings.Contains(string(b), `"192.168.1.0/24"`) || !strings.Contains(string(b), `"ssh"`) {
+		t.Fatalf("redaction dropped unrelated fields:\n%s", b)
+	}
+	if payload.Hosts[0].Metadata["gateway_ip"] != "192.168.1.1" || stats[0].HostRanges == nil {
+		t.Fatal("redaction modified the caller's payload")
+	}
+}
+
+func TestEmitHostsRedactsJSONOutputOnly(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "payload.json")
+	hosts := samplePayloadHosts()
+	hosts[0].Metadata = map[string]any{"gateway_mac": hosts[0].MAC, "site_code": "hq"}
+	meta := map[string]any{"run_id": "run-1", "interfaces": []utils.InterfaceDetails{{Name: "eth0", MAC: "aa:bb:cc:00:00:01"}}}
+	fields, err := ParseRedactFields(" mac, Hostname,site_code ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := emitHosts(hosts, meta, RemotePayloadOptions{Redact: fields, JSONOutPath: out}); err != nil {
+		t.Fatalf("emitHosts: %v", err)
+	}
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, leaked := range []string{"aa:bb:cc", `"hostname"`, "site_code"} {
+		if strings.Contains(string(b), leaked) {
+			t.Fatalf("redacted output still contains %q:\n%s", leaked, b)
+		}
+	}
+	if !strings.Contains(string(b), `"ip": "192.168.1.10"`) || !strings.Contains(string(b), `"run-1"`) {
+		t.Fatalf("redaction dropped unrelated fields:\n%s", b)
+	}
+	if hosts[0].Hostname != "nas" || meta["interfaces"].([]utils.InterfaceDetails)[0].MAC == "" {
+		t.Fatal("redaction modified the caller's records")
+	}
+	if _, err := ParseRedactFields("mac,host name"); err == nil {
+		t.Fatal("expected an error for a field with a space")
+	}
+}
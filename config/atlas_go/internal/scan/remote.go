@@ -2,11 +2,13 @@ package scan
 
 import (
 	"bytes"
+	"compress/gzip"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"path"
 	"strings"
 	"time"
@@ -21,6 +23,91 @@ type RemoteConfig struct {
 	AgentVersion  string
 	Token         string
 	HTTPClient    *http.Client
+	// Discovery caches the controller's /.well-known/atlas document; see
+	// Discover.
+	Discovery *ControllerDiscovery
+	// Headers are added to every controller request, e.g. an API gateway's
+	// X-Api-Key or a tenant header. They cannot replace Content-Type or
+	// Content-Encoding, and an Authorization header only applies when Token
+	// is empty and, like Token, only on ControllerURL's origin.
+	Headers map[string]string
+	// PayloadFormat selects the ingest encoding (PayloadJSON or
+	// PayloadMsgpack). Anything but JSON is only used when the controller
+	// lists it in its discovered formats.
+	PayloadFormat string
+	// Refresh, when set, replaces Token after a 401 and the POST is retried
+	// once with the new token.
+	Refresh *TokenRefresher
+	// Breaker, when set, skips uploads while the controller keeps failing.
+	// It is shared by pointer so every run in an agent sees the same state.
+	Breaker *CircuitBreaker
+}
+
+// NewHTTPClient returns a client for repeated uploads to one controller:
+// keep-alives on and a small idle pool held for idleTimeout, so an agent that
+// posts every interval reuses its TLS connection instead of handshaking each
+// time. Create one per agent and share it through RemoteConfig.HTTPClient.
+func NewHTTPClient(idleTimeout time.Duration) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = 8
+	transport.MaxIdleConnsPerHost = 4
+	transport.IdleConnTimeout = idleTimeout
+	transport.TLSHandshakeTimeout = 15 * time.Second
+	return &http.Client{Timeout: 60 * time.Second, Transport: transport}
+}
+
+// userAgent is sent unless Headers supplies its own User-Agent.
+func (rc RemoteConfig) userAgent() string {
+	version := rc.AgentVersion
+	if version == "" {
+		version = ScannerVersion
+	}
+	return "atlas/" + version
+}
+
+// applyHeaders sets the User-Agent, custom headers, and bearer token on req.
+// Authorization, whether from Token or Headers, is only sent to
+// ControllerURL's origin, so a discovered api_base, a pagination next link,
+// or a commands path elsewhere never receives the credentials. Callers set
+// Content-Type and Content-Encoding afterwards.
+func (rc RemoteConfig) applyHeaders(req *http.Request) {
+	req.Header.Set("User-Agent", rc.userAgent())
+	for k, v := range rc.Headers {
+		req.Header.Set(k, v)
+	}
+	if !rc.sameOrigin(req.URL) {
+		req.Header.Del("Authorization")
+		return
+	}
+	if token := rc.bearerToken(); token != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	}
+}
+
+// sameOrigin reports whether target has ControllerURL's scheme, host, and
+// port, with an omitted port meaning the scheme's default.
+func (rc RemoteConfig) sameOrigin(target *url.URL) bool {
+	base, err := url.Parse(rc.ControllerURL)
+	if err != nil || target == nil {
+		return false
+	}
+	origin := func(u *url.URL) string {
+		scheme := strings.ToLower(u.Scheme)
+		port := u.Port()
+		if port == "" {
+			port = map[string]string{"http": "80", "https": "443"}[scheme]
+		}
+		return scheme + "://" + strings.ToLower(u.Hostname()) + ":" + port
+	}
+	return origin(base) == origin(target)
+}
+
+// bearerToken prefers a refreshed token over the configured one.
+func (rc RemoteConfig) bearerToken() string {
+	if token := rc.Refresh.current(); token != "" {
+		return token
+	}
+	return rc.Token
 }
 
 // Enabled returns true when all mandatory fields are set.
@@ -32,9 +119,74 @@ func (rc RemoteConfig) endpoint() (string, error) {
 	if !rc.Enabled() {
 		return "", errors.New("remote config incomplete: controller url, site id, and agent id are required")
 	}
-	base := strings.TrimRight(rc.ControllerURL, "/")
+	base := rc.ControllerURL
 	ingestPath := path.Join("sites", rc.SiteID, "agents", rc.AgentID, "ingest")
-	return fmt.Sprintf("%s/%s", base, ingestPath), nil
+	if d := rc.Discovery; d != nil {
+		if d.APIBase != "" {
+			base = d.APIBase
+		}
+		if d.IngestPath != "" {
+			ingestPath = strings.NewReplacer("{site}", rc.SiteID, "{agent}", rc.AgentID).Replace(strings.TrimLeft(d.IngestPath, "/"))
+		}
+	}
+	return fmt.Sprintf("%s/%s", strings.TrimRight(base, "/"), ingestPath), nil
+}
+
+// postBatched posts payload, splitting it into several requests when the
+// controller advertises a max batch size smaller than the host count. Each
+// request's batch_index and batch_count override any the payload carries.
+func (rc RemoteConfig) postBatched(payload RemotePayload) error {
+	size := rc.Discovery.maxBatchSize()
+	if size <= 0 || len(payload.Hosts) <= size {
+		return rc.PostPayload(payload)
+	}
+	count := (len(payload.Hosts) + size - 1) / size
+	for i := 0; i < count; i++ {
+		end := min((i+1)*size, len(payload.Hosts))
+		batch := payload
+		batch.Hosts = payload.Hosts[i*size : end]
+		batch.Metadata = make(map[string]any, len(payload.Metadata)+2)
+		for k, v := range payload.Metadata {
+			batch.Metadata[k] = v
+		}
+		batch.Metadata["batch_index"] = i
+		batch.Metadata["batch_count"] = count
+		if err := rc.PostPayload(batch); err != nil {
+			return fmt.Errorf("batch %d/%d: %w", i+1, count, err)
+		}
+	}
+	return nil
+}
+
+// encodePayload serializes payload in the configured format, falling back to
+// JSON when the controller has not advertised that format.
+func (rc RemoteConfig) encodePayload(payload RemotePayload) ([]byte, string, error) {
+	if rc.PayloadFormat == PayloadMsgpack {
+		if rc.Discovery.supportsFormat(PayloadMsgpack) {
+			body, err := encodeMsgpack(payload)
+			return body, msgpackContentType, err
+		}
+		fmt.Println("[remote] controller does not advertise msgpack; sending JSON")
+	}
+	body, err := json.Marshal(payload)
+	return body, "application/json", err
+}
+
+// doWithRefresh sends a request through send and, when the controller
+// answers 401 and Refresh is set, refreshes the token and sends it once
+// more; send must build a new request each time so it picks up the token.
+// A failed refresh is reported as sentinel.
+func (rc RemoteConfig) doWithRefresh(client *http.Client, sentinel error, send func() (*http.Response, error)) (*http.Response, error) {
+	resp, err := send()
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || rc.Refresh == nil {
+		return resp, err
+	}
+	resp.Body.Close()
+	fmt.Println("[remote] controller answered 401; refreshing the token and retrying once")
+	if _, err := rc.Refresh.refresh(client); err != nil {
+		return nil, fmt.Errorf("%w: 401 Unauthorized and token refresh failed: %v", sentinel, err)
+	}
+	return send()
 }
 
 // PostPayload sends the payload to the controller.
@@ -43,7 +195,7 @@ func (rc RemoteConfig) PostPayload(payload RemotePayload) error {
 	if err != nil {
 		return err
 	}
-	body, err := json.Marshal(payload)
+	body, contentType, err := rc.encodePayload(payload)
 	if err != nil {
 		return err
 	}
@@ -51,27 +203,48 @@ func (rc RemoteConfig) PostPayload(payload RemotePayload) error {
 	if client == nil {
 		client = &http.Client{Timeout: 60 * time.Second}
 	}
-	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
-	if err != nil {
-		return err
+	gzipBody := rc.Discovery.supportsCompression("gzip")
+	if gzipBody {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(body); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		body = buf.Bytes()
 	}
-	req.Header.Set("Content-Type", "application/json")
-	if rc.Token != "" {
-		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", rc.Token))
+	send := func() (*http.Response, error) {
+		req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		rc.applyHeaders(req)
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Del("Content-Encoding")
+		if gzipBody {
+			req.Header.Set("Content-Encoding", "gzip")
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrRemoteIngest, err)
+		}
+		return resp, nil
 	}
 	start := time.Now()
-	resp, err := client.Do(req)
+	resp, err := rc.doWithRefresh(client, ErrRemoteIngest, send)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 	bodyBytes, readErr := io.ReadAll(resp.Body)
 	if readErr != nil {
-		return fmt.Errorf("remote ingest failed: %s (read body error: %v)", resp.Status, readErr)
+		return fmt.Errorf("%w: %s (read body error: %v)", ErrRemoteIngest, resp.Status, readErr)
 	}
 	elapsed := time.Since(start)
 	if resp.StatusCode >= 300 {
-		return fmt.Errorf("remote ingest failed in %s: %s - %s", elapsed, resp.Status, strings.TrimSpace(string(bodyBytes)))
+		return &RemoteIngestError{StatusCode: resp.StatusCode, Status: resp.Status, Body: string(bodyBytes), Elapsed: elapsed}
 	}
 	fmt.Printf("[remote] ingest to %s succeeded in %s (%s)\n", endpoint, elapsed, resp.Status)
 	return nil
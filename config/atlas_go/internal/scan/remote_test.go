@@ -0,0 +1,194 @@
+package scan
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// capturedRequest records what the fake controller received.
+type capturedRequest struct {
+	Path    string
+	Header  http.Header
+	Payload RemotePayload
+}
+
+func newIngestServer(t *testing.T, status int, body string) (*httptest.Server, *capturedRequest) {
+	t.Helper()
+	captured := &capturedRequest{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		captured.Path = r.URL.Path
+		captured.Header = r.Header.Clone()
+		if err := json.NewDecoder(r.Body).Decode(&captured.Payload); err != nil {
+			t.Errorf("decode body: %v", err)
+		}
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, captured
+}
+
+func samplePayload() RemotePayload {
+	return BuildRemotePayload("Lab", "v1.2.3", "", samplePayloadHosts())
+}
+
+func samplePayloadHosts() []HostRecord {
+	return []HostRecord{{
+		IP:            "192.168.1.10",
+		Hostname:      "nas",
+		OS:            "Linux",
+		MAC:           "aa:bb:cc:dd:ee:ff",
+		Ports:         []RemotePort{{Port: 22, Protocol: "tcp", Service: "ssh", State: "open"}},
+		InterfaceName: "eth0",
+		LastSeen:      time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	}}
+}
+
+func TestPostPayloadSendsToIngestEndpoint(t *testing.T) {
+	srv, captured := newIngestServer(t, http.StatusAccepted, "{}")
+	rc := RemoteConfig{ControllerURL: srv.URL + "/api/", SiteID: "lab", AgentID: "edge01", Token: "secret"}
+
+	payload := samplePayload()
+	if err := rc.PostPayload(payload); err != nil {
+		t.Fatalf("PostPayload: %v", err)
+	}
+	if captured.Path != "/api/sites/lab/agents/edge01/ingest" {
+		t.Errorf("unexpected path %q", captured.Path)
+	}
+	if got := captured.Header.Get("Authorization"); got != "Bearer secret" {
+		t.Errorf("unexpected Authorization header %q", got)
+	}
+	if got := captured.Header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("unexpected Content-Type %q", got)
+	}
+
+	// Round-trip the expected payload through JSON so map value types match
+	// what the server decoded.
+	var want RemotePayload
+	b, _ := json.Marshal(payload)
+	_ = json.Unmarshal(b, &want)
+	if !reflect.DeepEqual(captured.Payload, want) {
+		t.Errorf("payload mismatch:\nwant %+v\ngot  %+v", want, captured.Payload)
+	}
+}
+
+func TestPostPayloadSendsUserAgentAndCustomHeaders(t *testing.T) {
+	srv, captured := newIngestServer(t, http.StatusOK, "{}")
+	rc := RemoteConfig{ControllerURL: srv.URL, SiteID: "lab", AgentID: "edge01", AgentVersion: "v1.2.3", Token: "secret",
+		Headers: map[string]string{"X-Api-Key": "k1", "Authorization": "Basic nope", "Content-Type": "text/plain"}}
+
+	if err := rc.PostPayload(samplePayload()); err != nil {
+		t.Fatalf("PostPayload: %v", err)
+	}
+	for name, want := range map[string]string{
+		"User-Agent":    "atlas/v1.2.3",
+		"X-Api-Key":     "k1",
+		"Authorization": "Bearer secret",
+		"Content-Type":  "application/json",
+	} {
+		if got := captured.Header.Get(name); got != want {
+			t.Errorf("%s: want %q, got %q", name, want, got)
+		}
+	}
+}
+
+func TestPostPayloadOmitsAuthorizationWithoutToken(t *testing.T) {
+	srv, captured := newIngestServer(t, http.StatusOK, "{}")
+	rc := RemoteConfig{ControllerURL: srv.URL, SiteID: "lab", AgentID: "edge01"}
+
+	if err := rc.PostPayload(samplePayload()); err != nil {
+		t.Fatalf("PostPayload: %v", err)
+	}
+	if captured.Path != "/sites/lab/agents/edge01/ingest" {
+		t.Errorf("unexpected path %q", captured.Path)
+	}
+	if got := captured.Header.Get("Authorization"); got != "" {
+		t.Errorf("expected no Authorization header, got %q", got)
+	}
+}
+
+func TestPostPayloadErrorStatuses(t *testing.T) {
+	for _, status := range []int{http.StatusUnprocessableEntity, http.StatusBadGateway} {
+		srv, _ := newIngestServer(t, status, "  upstream said no \n")
+		rc := RemoteConfig{ControllerURL: srv.URL, SiteID: "lab", AgentID: "edge01"}
+
+		err := rc.PostPayload(samplePayload())
+		if !errors.Is(err, ErrRemoteIngest) {
+			t.Fatalf("status %d: expected ErrRemoteIngest, got %v", status, err)
+		}
+		var ingestErr *RemoteIngestError
+		if !errors.As(err, &ingestErr) || ingestErr.StatusCode != status {
+			t.Fatalf("status %d: expected RemoteIngestError, got %#v", status, err)
+		}
+		msg := err.Error()
+		if !strings.HasPrefix(msg, "remote ingest failed in ") || !strings.HasSuffix(msg, http.StatusText(status)+" - upstream said no") {
+			t.Errorf("status %d: unexpected error message %q", status, msg)
+		}
+	}
+}
+
+func TestPostPayloadRequiresCompleteConfig(t *testing.T) {
+	rc := RemoteConfig{ControllerURL: "http://127.0.0.1", SiteID: "lab"}
+	if err := rc.PostPayload(samplePayload()); err == nil {
+		t.Fatal("expected error for missing agent id")
+	}
+}
+
+func TestAuthorizationStaysOnControllerOrigin(t *testing.T) {
+	var leaked []string
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth := r.Header.Get("Authorization"); auth != "" {
+			leaked = append(leaked, r.URL.Path+": "+auth)
+		}
+		if r.Header.Get("X-Api-Key") != "k1" {
+			t.Errorf("custom header missing on %s", r.URL)
+		}
+		io.WriteString(w, `[{"ip":"10.0.0.2"}]`)
+	}))
+	defer other.Close()
+	controller := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("missing bearer token on %s", r.URL)
+		}
+		w.Header().Set("Link", `<`+other.URL+`/hosts?page=2>; rel="next"`)
+		io.WriteString(w, `[{"ip":"10.0.0.1"}]`)
+	}))
+	defer controller.Close()
+
+	rc := RemoteConfig{ControllerURL: controller.URL, Token: "secret", Headers: map[string]string{"X-Api-Key": "k1"}}
+	items, err := rc.GetPaginated("hosts")
+	if err != nil || len(items) != 2 {
+		t.Fatalf("GetPaginated: %d items, %v", len(items), err)
+	}
+	// A discovered api_base elsewhere gets no token either, not even one
+	// from --header.
+	rc.Token = ""
+	rc.Headers["Authorization"] = "Basic c2VjcmV0"
+	rc.Discovery = &ControllerDiscovery{APIBase: other.URL}
+	if _, err := rc.GetPaginated("hosts"); err != nil {
+		t.Fatalf("GetPaginated via api_base: %v", err)
+	}
+	if len(leaked) > 0 {
+		t.Fatalf("Authorization sent to another origin: %v", leaked)
+	}
+
+	for raw, want := range map[string]bool{
+		"HTTPS://Controller.example:443/x": true,
+		"https://controller.example:8443":  false,
+		"http://controller.example":        false,
+		"https://evil.example":             false,
+	} {
+		u, _ := url.Parse(raw)
+		if got := (RemoteConfig{ControllerURL: "https://controller.example/api"}).sameOrigin(u); got != want {
+			t.Errorf("sameOrigin(%s) = %v, want %v", raw, got, want)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package scan
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+)
+
+// MaxScanCount bounds --count so a lossy network cannot turn one deep scan
+// into an unbounded loop.
+const MaxScanCount = 10
+
+// scanRepeatDelay spaces repeated scans of one host. Tests shorten it.
+var scanRepeatDelay = 2 * time.Second
+
+// repeatHostScan runs scan and ping count times, scanRepeatDelay apart, and
+// merges the results (see mergeScanResults). The host is online if any ping
+// answered. With count above one, meta records how many runs completed, the
+// ping success rate, and how many runs saw each port. ok is false when ctx
+// expired before a single run completed.
+func repeatHostScan(ctx context.Context, count int, scan func() tcpScanResult, ping func() string) (result tcpScanResult, status string, meta map[string]any, ok bool) {
+	if count < 1 {
+		count = 1
+	}
+	var results []tcpScanResult
+	answered := 0
+	for i := 0; i < count; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(scanRepeatDelay):
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		r := scan()
+		if ctx.Err() != nil {
+			break
+		}
+		results = append(results, r)
+		if ping() == "online" {
+			answered++
+		}
+	}
+	if len(results) == 0 {
+		return tcpScanResult{}, "", nil, false
+	}
+	status = "offline"
+	if answered > 0 {
+		status = "online"
+	}
+	result, seen := mergeScanResults(results)
+	if count == 1 {
+		return result, status, nil, true
+	}
+	meta = map[string]any{
+		"scan_count":        len(results),
+		"ping_success_rate": math.Round(float64(answered)/float64(len(results))*100) / 100,
+		"port_observations": seen,
+	}
+	return result, status, meta, true
+}
+
+// mergeScanResults unions the ports of several scans of one host (see
+// mergePorts). The first known OS and vendor and the last hop list win. seen
+// counts the runs each "port/proto" appeared in.
+func mergeScanResults(results []tcpScanResult) (tcpScanResult, map[string]int) {
+	seen := map[string]int{}
+	var merged tcpScanResult
+	for _, r := range results {
+		for _, p := range r.Ports.Ports {
+			seen[fmt.Sprintf("%d/%s", p.Port, p.Protocol)]++
+		}
+		merged.Ports.Ports = mergePorts(merged.Ports.Ports, r.Ports.Ports)
+		if merged.OS == "" || merged.OS == "Unknown" {
+			merged.OS = r.OS
+		}
+		if merged.Vendor == "" {
+			merged.Vendor = r.Vendor
+		}
+		if len(r.Hops) > 0 {
+			merged.Hops = r.Hops
+		}
+		if r.RawPath != "" {
+			merged.Raw, merged.RawPath = r.Raw, r.RawPath
+		}
+	}
+	if len(results) == 1 {
+		merged.Ports.Summary = results[0].Ports.Summary
+	} else {
+		merged.Ports.Summary = summarizePorts(merged.Ports.Ports)
+	}
+	return merged, seen
+}
+
+// rescanIfEmpty runs scan with tcp and, when that parses no ports, once more
+// with a different strategy: -Pn forced, or, when tcp already skips ping, the
+// next slower -T template so a host that drops probes under a fast timing
+// gets another chance. An empty result is more often nmap giving up on a
+// host than a host with nothing open. rescanned reports whether the second
+// run happened; a T1 scan that already skips ping has nothing left to try.
+func rescanIfEmpty(ctx context.Context, tcp TCPScanOptions, scan func(TCPScanOptions) tcpScanResult, logf func(format string, args ...any)) (result tcpScanResult, rescanned bool) {
+	result = scan(tcp)
+	if len(result.Ports.Ports) > 0 || ctx.Err() != nil {
+		return result, false
+	}
+	retry := tcp
+	if !tcp.SkipPing {
+		retry.SkipPing = true
+		logf("no ports parsed; rescanning once with -Pn")
+		return scan(retry), true
+	}
+	timing := tcp.Timing
+	if timing == 0 {
+		// nmap's default template.
+		timing = 3
+	}
+	if timing <= 1 {
+		return result, false
+	}
+	retry.Timing = timing - 1
+	logf("no ports parsed; rescanning once with -T%d", retry.Timing)
+	return scan(retry), true
+}
@@ -0,0 +1,81 @@
+package scan
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRepeatHostScanMergesRuns(t *testing.T) {
+	prev := scanRepeatDelay
+	scanRepeatDelay = 0
+	t.Cleanup(func() { scanRepeatDelay = prev })
+
+	runs := []tcpScanResult{
+		{Ports: PortDetails{Ports: []RemotePort{{Port: 22, Protocol: "tcp", State: "filtered"}}}, OS: "Unknown"},
+		{Ports: PortDetails{Ports: []RemotePort{{Port: 22, Protocol: "tcp", State: "open", Service: "ssh"}, {Port: 80, Protocol: "tcp", State: "open", Service: "http"}}}, OS: "Linux"},
+		{Ports: PortDetails{Ports: []RemotePort{{Port: 22, Protocol: "tcp", State: "open", Service: "ssh"}}}, OS: "Windows"},
+	}
+	pings := []string{"offline", "online", "offline"}
+	i, j := 0, 0
+	result, status, meta, ok := repeatHostScan(context.Background(), 3, func() tcpScanResult {
+		i++
+		return runs[i-1]
+	}, func() string {
+		j++
+		return pings[j-1]
+	})
+	if !ok || status != "online" {
+		t.Fatalf("ok=%v status=%s", ok, status)
+	}
+	if result.OS != "Linux" || result.Ports.Summary != "22/tcp (ssh), 80/tcp (http)" || result.Ports.Ports[0].State != "open" {
+		t.Fatalf("unexpected merge %+v", result)
+	}
+	seen := meta["port_observations"].(map[string]int)
+	if meta["scan_count"] != 3 || meta["ping_success_rate"] != 0.33 || seen["22/tcp"] != 3 || seen["80/tcp"] != 1 {
+		t.Fatalf("unexpected observations %v", meta)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, _, ok := repeatHostScan(ctx, 2, func() tcpScanResult { return runs[0] }, func() string { return "online" }); ok {
+		t.Fatal("an expired context should report no completed run")
+	}
+}
+
+func TestRescanIfEmptyChangesStrategy(t *testing.T) {
+	var calls []bool
+	scan := func(tcp TCPScanOptions) tcpScanResult {
+		calls = append(calls, tcp.SkipPing)
+		if !tcp.SkipPing {
+			return tcpScanResult{Ports: PortDetails{Summary: "Unknown"}}
+		}
+		return tcpScanResult{Ports: PortDetails{Ports: []RemotePort{{Port: 443, Protocol: "tcp", State: "open"}}}}
+	}
+	result, rescanned := rescanIfEmpty(context.Background(), TCPScanOptions{}, scan, func(string, ...any) {})
+	if !rescanned || len(result.Ports.Ports) != 1 || len(calls) != 2 || calls[1] != true {
+		t.Fatalf("rescanned=%v result=%+v calls=%v", rescanned, result, calls)
+	}
+
+	calls = nil
+	if _, rescanned := rescanIfEmpty(context.Background(), TCPScanOptions{SkipPing: true}, scan, func(string, ...any) {}); rescanned || len(calls) != 1 {
+		t.Fatalf("a host with ports should not be rescanned (calls=%v)", calls)
+	}
+
+	// With -Pn already on, the retry slows the timing down instead of
+	// repeating the same scan.
+	var timings []int
+	empty := func(tcp TCPScanOptions) tcpScanResult {
+		timings = append(timings, tcp.Timing)
+		return tcpScanResult{Ports: PortDetails{Summary: "Unknown"}}
+	}
+	for _, tc := range []struct{ timing, retry int }{{4, 3}, {0, 2}, {2, 1}} {
+		timings = nil
+		if _, rescanned := rescanIfEmpty(context.Background(), TCPScanOptions{SkipPing: true, Timing: tc.timing}, empty, func(string, ...any) {}); !rescanned || len(timings) != 2 || timings[1] != tc.retry {
+			t.Fatalf("-T%d: rescanned=%v timings=%v, want a -T%d retry", tc.timing, rescanned, timings, tc.retry)
+		}
+	}
+	timings = nil
+	if _, rescanned := rescanIfEmpty(context.Background(), TCPScanOptions{SkipPing: true, Timing: 1}, empty, func(string, ...any) {}); rescanned || len(timings) != 1 {
+		t.Fatalf("a -T1 -Pn scan has no slower retry: rescanned=%v timings=%v", rescanned, timings)
+	}
+}
@@ -0,0 +1,68 @@
+package scan
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReplayDeepScan(t *testing.T) {
+	useFakeRunner(t, func(name string, args []string) ([]byte, error) {
+		return nil, fmt.Errorf("%s ran outside the replay", name)
+	})
+	conn := openTestDB(t)
+	capture := filepath.Join(t.TempDir(), "capture.gnmap")
+	if err := os.WriteFile(capture, []byte(grepableFixture), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := DeepScanOptions{Replay: capture, TCP: scanProfiles["standard"], SummaryJSON: filepath.Join(t.TempDir(), "summary.json")}
+	opts.Remote.JSONOutPath = filepath.Join(t.TempDir(), "payload.json")
+	if err := DeepScan(opts); err != nil {
+		t.Fatalf("DeepScan: %v", err)
+	}
+
+	var name, ports string
+	if err := conn.QueryRow("SELECT name, open_ports FROM hosts WHERE ip = ?", "192.168.1.10").Scan(&name, &ports); err != nil {
+		t.Fatalf("replayed host not persisted: %v", err)
+	}
+	if name != "nas.lan" || !strings.Contains(ports, "22/tcp") {
+		t.Fatalf("persisted %q / %q", name, ports)
+	}
+	var open int
+	if err := conn.QueryRow(`SELECT COUNT(*) FROM hosts, json_each(hosts.open_ports_json) p
+		WHERE hosts.ip = ? AND json_extract(p.value, '$.state') = 'open'`, "192.168.1.10").Scan(&open); err != nil {
+		t.Fatalf("query open_ports_json: %v", err)
+	}
+	if open != 2 {
+		t.Fatalf("expected 2 open ports in open_ports_json, got %d", open)
+	}
+	b, err := os.ReadFile(opts.Remote.JSONOutPath)
+	if err != nil || !strings.Contains(string(b), `"ip": "192.168.1.10"`) {
+		t.Fatalf("payload not emitted: %v\n%s", err, b)
+	}
+
+	b, err = os.ReadFile(opts.SummaryJSON)
+	if err != nil {
+		t.Fatalf("summary not written: %v", err)
+	}
+	var summary runSummary
+	if err := json.Unmarshal(b, &summary); err != nil {
+		t.Fatal(err)
+	}
+	if summary.RunID == "" || summary.StartedAt.IsZero() || summary.FinishedAt.Before(summary.StartedAt) {
+		t.Fatalf("summary lacks run id or timestamps: %s", b)
+	}
+	if summary.HostCount != 1 || summary.Online+summary.Offline != 1 {
+		t.Fatalf("unexpected host counts: %s", b)
+	}
+	if len(summary.NewHosts) != 1 || summary.NewHosts[0] != "192.168.1.10" {
+		t.Fatalf("expected the replayed host to be new, got %v", summary.NewHosts)
+	}
+	if len(summary.TopPorts) != 2 || summary.TopPorts[0].Port != 22 || summary.TopPorts[0].Hosts != 1 {
+		t.Fatalf("unexpected top ports %+v", summary.TopPorts)
+	}
+}
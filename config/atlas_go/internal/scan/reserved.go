@@ -0,0 +1,127 @@
+package scan
+
+import (
+	"net"
+
+	"atlas/internal/utils"
+)
+
+// reservedReason says why ip should not be scanned as a host on subnet, or
+// returns "" when it is an ordinary address. Link-local and multicast
+// addresses are never real inventory, and on IPv4 subnets larger than a /31
+// the network and broadcast addresses only answer as artifacts of the sweep.
+func reservedReason(ip, subnet string) string {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return ""
+	}
+	switch {
+	case addr.IsLinkLocalUnicast():
+		return "link-local"
+	case addr.IsMulticast(), addr.IsLinkLocalMulticast(), addr.IsInterfaceLocalMulticast():
+		return "multicast"
+	case addr.IsUnspecified():
+		return "unspecified"
+	case addr.Equal(net.IPv4bcast):
+		return "broadcast"
+	}
+
+	_, ipnet, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return ""
+	}
+	v4 := addr.To4()
+	network := ipnet.IP.To4()
+	if v4 == nil || network == nil || !ipnet.Contains(v4) {
+		return ""
+	}
+	ones, bits := ipnet.Mask.Size()
+	if bits-ones < 2 {
+		return ""
+	}
+	if v4.Equal(network) {
+		return "network address"
+	}
+	if v4.Equal(lastAddr(ipnet)) {
+		return "broadcast"
+	}
+	return ""
+}
+
+// lastAddr returns the highest address in ipnet (the IPv4 broadcast).
+func lastAddr(ipnet *net.IPNet) net.IP {
+	last := make(net.IP, len(ipnet.IP))
+	for i := range ipnet.IP {
+		last[i] = ipnet.IP[i] | ^ipnet.Mask[i]
+	}
+	return last
+}
+
+// dropReservedHosts removes the hosts reservedReason rejects for subnet,
+// logging each one so an unexpected gap in the results can be explained.
+// With subnet "" (explicit targets) only network and broadcast addresses
+// are kept, as there is no range to tell them by.
+func dropReservedHosts(hosts []HostInfo, subnet string, logf func(format string, args ...any)) []HostInfo {
+	kept := hosts[:0]
+	for _, h := range hosts {
+		if reason := reservedReason(h.IP, subnet); reason != "" {
+			if subnet == "" {
+				logf("Skipping target %s: %s (use --include-reserved to scan it)", h.IP, reason)
+			} else {
+				logf("Skipping %s on %s: %s (use --include-reserved to scan it)", h.IP, subnet, reason)
+			}
+			continue
+		}
+		kept = append(kept, h)
+	}
+	return kept
+}
+
+// sharedAddressSpace is the RFC 6598 carrier-grade NAT range. It is not
+// routed on the internet, so hosts in it are as local as RFC 1918 ones.
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0).To4(), Mask: net.CIDRMask(10, 32)}
+
+// filterDiscovered applies the default host filters to hosts found on
+// subnet: dropReservedHosts unless includeReserved, then dropPublicHosts
+// unless allowPublic. Every scanner passes its discovered hosts through it.
+func filterDiscovered(hosts []HostInfo, subnet string, includeReserved, allowPublic bool, logf func(format string, args ...any)) []HostInfo {
+	if !includeReserved {
+		hosts = dropReservedHosts(hosts, subnet, logf)
+	}
+	if !allowPublic {
+		hosts = dropPublicHosts(hosts, logf)
+	}
+	return hosts
+}
+
+// sharedAddressSpace whether ip is in RFC 1918, RFC 6598 shared (CGNAT), or
+// unique local (fc00::/7) space, or is link-local or loopback.
+func isPrivateIP(ip net.IP) bool {
+	return ip.IsPrivate() || sharedAddressSpace.Contains(ip) || ip.IsLinkLocalUnicast() || ip.IsLoopback()
+}
+
+// publicTarget reports whether a --target IP or CIDR reaches outside private
+// space. Hostnames report false; their addresses are checked once resolved.
+func publicTarget(target string) bool {
+	if ip := net.ParseIP(target); ip != nil {
+		return !isPrivateIP(ip)
+	}
+	if _, ipnet, err := net.ParseCIDR(target); err == nil {
+		return !isPrivateIP(ipnet.IP) || !isPrivateIP(lastAddr(ipnet))
+	}
+	return false
+}
+
+// dropPublicHosts removes hosts with public addresses so a typo or a
+// hostname resolving off-site never turns into an internet scan.
+func dropPublicHosts(hosts []HostInfo, logf func(format string, args ...any)) []HostInfo {
+	kept := hosts[:0]
+	for _, h := range hosts {
+		if ip := net.ParseIP(h.IP); ip != nil && !isPrivateIP(ip) {
+			logf(utils.Deco("⚠️ Skipping public address %s (use --allow-public to scan it)"), h.IP)
+			continue
+		}
+		kept = append(kept, h)
+	}
+	return kept
+}
@@ -0,0 +1,88 @@
+package scan
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestReservedReason(t *testing.T) {
+	cases := []struct {
+		ip, subnet, want string
+	}{
+		{"192.168.1.10", "192.168.1.0/24", ""},
+		{"192.168.1.0", "192.168.1.0/24", "network address"},
+		{"192.168.1.255", "192.168.1.0/24", "broadcast"},
+		{"10.0.0.63", "10.0.0.0/26", "broadcast"},
+		{"10.0.0.64", "10.0.0.0/26", ""},
+		{"10.0.0.0", "10.0.0.0/31", ""},
+		{"169.254.3.4", "192.168.1.0/24", "link-local"},
+		{"224.0.0.251", "192.168.1.0/24", "multicast"},
+		{"255.255.255.255", "192.168.1.0/24", "broadcast"},
+		{"fe80::1", "fd00::/64", "link-local"},
+		{"ff02::1", "fd00::/64", "multicast"},
+		{"fd00::", "fd00::/64", ""},
+	}
+	for _, c := range cases {
+		if got := reservedReason(c.ip, c.subnet); got != c.want {
+			t.Errorf("reservedReason(%s, %s) = %q, want %q", c.ip, c.subnet, got, c.want)
+		}
+	}
+
+	hosts := []HostInfo{{IP: "192.168.1.0"}, {IP: "192.168.1.5"}, {IP: "169.254.1.1"}, {IP: "192.168.1.255"}}
+	var logged int
+	kept := dropReservedHosts(hosts, "192.168.1.0/24", func(string, ...any) { logged++ })
+	if len(kept) != 1 || kept[0].IP != "192.168.1.5" || logged != 3 {
+		t.Fatalf("dropReservedHosts kept %+v (logged %d)", kept, logged)
+	}
+
+	// --target addresses have no subnet; only the subnet-free checks apply.
+	hosts = []HostInfo{{IP: "192.168.1.0"}, {IP: "169.254.1.1"}, {IP: "ff02::1"}, {IP: "192.168.1.255"}}
+	var msgs []string
+	kept = dropReservedHosts(hosts, "", func(format string, args ...any) { msgs = append(msgs, fmt.Sprintf(format, args...)) })
+	if len(kept) != 2 || kept[0].IP != "192.168.1.0" || kept[1].IP != "192.168.1.255" {
+		t.Fatalf("dropReservedHosts without a subnet kept %+v", kept)
+	}
+	if len(msgs) != 2 || !strings.HasPrefix(msgs[0], "Skipping target 169.254.1.1: link-local") {
+		t.Fatalf("logged %q", msgs)
+	}
+}
+
+func TestPublicTargets(t *testing.T) {
+	for target, want := range map[string]bool{
+		"192.168.1.10":   false,
+		"10.0.0.0/8":     false,
+		"172.16.4.0/22":  false,
+		"fd00::/64":      false,
+		"169.254.7.7":    false,
+		"100.64.0.0/10":  false,
+		"100.127.3.4":    false,
+		"100.128.0.1":    true,
+		"8.8.8.8":        true,
+		"10.0.0.0/7":     true,
+		"172.0.0.0/8":    true,
+		"2001:db8::1":    true,
+		"nas.corp.local": false,
+	} {
+		if got := publicTarget(target); got != want {
+			t.Errorf("publicTarget(%s) = %v, want %v", target, got, want)
+		}
+	}
+
+	hosts := []HostInfo{{IP: "10.0.0.5"}, {IP: "93.184.216.34"}, {IP: "fe80::1"}}
+	kept := dropPublicHosts(hosts, func(string, ...any) {})
+	if len(kept) != 2 || kept[0].IP != "10.0.0.5" || kept[1].IP != "fe80::1" {
+		t.Fatalf("dropPublicHosts kept %+v", kept)
+	}
+
+	// Every scanner filters its discovered hosts the same way.
+	hosts = []HostInfo{{IP: "100.64.0.0"}, {IP: "100.64.0.9"}, {IP: "169.254.1.1"}, {IP: "8.8.8.8"}}
+	kept = filterDiscovered(hosts, "100.64.0.0/24", false, false, func(string, ...any) {})
+	if len(kept) != 1 || kept[0].IP != "100.64.0.9" {
+		t.Fatalf("filterDiscovered kept %+v", kept)
+	}
+	hosts = []HostInfo{{IP: "169.254.1.1"}, {IP: "8.8.8.8"}}
+	if kept = filterDiscovered(hosts, "0.0.0.0/0", true, true, func(string, ...any) {}); len(kept) != 2 {
+		t.Fatalf("filterDiscovered with both overrides kept %+v", kept)
+	}
+}
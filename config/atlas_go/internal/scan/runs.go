@@ -0,0 +1,80 @@
+package scan
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// runIDLayout keeps run IDs fixed-width so they sort by start time.
+const runIDLayout = "20060102T150405.000Z"
+
+// newRunID returns an ID for a scan started at t: the UTC start time followed
+// by a random suffix that separates runs started in the same millisecond.
+func newRunID(t time.Time) string {
+	var suffix [4]byte
+	_, _ = rand.Read(suffix[:])
+	return t.UTC().Format(runIDLayout) + "-" + hex.EncodeToString(suffix[:])
+}
+
+// runID returns the caller-supplied RunID or a fresh one for a scan that
+// started at start.
+func (o RemotePayloadOptions) runID(start time.Time) string {
+	if o.RunID != "" {
+		return o.RunID
+	}
+	return newRunID(start)
+}
+
+// tagRunID stamps every host with the run that produced it.
+func tagRunID(hosts []HostRecord, runID string) {
+	for i := range hosts {
+		if hosts[i].Metadata == nil {
+			hosts[i].Metadata = map[string]any{}
+		}
+		hosts[i].Metadata["run_id"] = runID
+	}
+}
+
+// scanRun is one row of the scan_runs table.
+type scanRun struct {
+	ID         string
+	Scanner    string
+	StartedAt  time.Time
+	FinishedAt time.Time
+	HostCount  int
+	Partial    bool
+	// Nmap fills nmap_hosts_up and nmap_elapsed; they stay NULL for runs
+	// where nmap printed no summary.
+	Nmap nmapRunTotals
+}
+
+func recordScanRun(db sqlExecutor, run scanRun) error {
+	_, err := db.Exec(`
+        INSERT INTO scan_runs (run_id, scanner, started_at, finished_at, host_count, partial, nmap_hosts_up, nmap_elapsed)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+        ON CONFLICT(run_id) DO UPDATE SET
+            finished_at=excluded.finished_at,
+            host_count=excluded.host_count,
+            partial=excluded.partial,
+            nmap_hosts_up=excluded.nmap_hosts_up,
+            nmap_elapsed=excluded.nmap_elapsed
+    `, run.ID, run.Scanner, dbTime(run.StartedAt), dbTime(run.FinishedAt), run.HostCount, run.Partial,
+		run.Nmap.hostsUp(), run.Nmap.elapsed())
+	if err != nil {
+		return fmt.Errorf("%w: record run %s: %w", ErrDatabase, run.ID, err)
+	}
+	return nil
+}
+
+// saveScanRun is recordScanRun against the database at path.
+func saveScanRun(path string, run scanRun) error {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrDatabase, err)
+	}
+	defer db.Close()
+	return recordScanRun(db, run)
+}
@@ -0,0 +1,78 @@
+package scan
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// SampleOptions limits a deep scan to a random subset of the discovered
+// hosts. Count wins over Percent; both zero disables sampling.
+type SampleOptions struct {
+	Count   int
+	Percent float64
+	// Seed makes the selection reproducible; 0 picks a time-based seed that
+	// is logged so the run can be repeated.
+	Seed int64
+}
+
+func (s SampleOptions) enabled() bool {
+	return s.Count > 0 || s.Percent > 0
+}
+
+// size returns how many of total hosts to scan, always at least one.
+func (s SampleOptions) size(total int) int {
+	n := s.Count
+	if n <= 0 {
+		n = int(math.Ceil(float64(total) * s.Percent / 100))
+	}
+	if n < 1 {
+		n = 1
+	}
+	if n > total {
+		n = total
+	}
+	return n
+}
+
+// sampleHosts splits hosts into the randomly chosen subset to deep-scan and
+// the remainder, each kept in discovery order. It returns the seed used.
+func sampleHosts(hosts []HostInfo, s SampleOptions) (sampled, skipped []HostInfo, seed int64) {
+	seed = s.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	if len(hosts) == 0 {
+		return nil, nil, seed
+	}
+	picked := rand.New(rand.NewSource(seed)).Perm(len(hosts))[:s.size(len(hosts))]
+	sort.Ints(picked)
+	chosen := make(map[int]bool, len(picked))
+	for _, i := range picked {
+		chosen[i] = true
+	}
+	for i, h := range hosts {
+		if chosen[i] {
+			sampled = append(sampled, h)
+		} else {
+			skipped = append(skipped, h)
+		}
+	}
+	return sampled, skipped, seed
+}
+
+// Validate rejects out-of-range sampling flags.
+func (s SampleOptions) Validate() error {
+	if s.Count < 0 {
+		return fmt.Errorf("--sample must not be negative")
+	}
+	if s.Percent < 0 || s.Percent > 100 {
+		return fmt.Errorf("--sample-pct must be between 0 and 100")
+	}
+	if s.Count > 0 && s.Percent > 0 {
+		return fmt.Errorf("--sample and --sample-pct are mutually exclusive")
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+package scan
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestSampleHostsIsReproducible(t *testing.T) {
+	var hosts []HostInfo
+	for i := 1; i <= 20; i++ {
+		hosts = append(hosts, HostInfo{IP: "10.0.0." + strconv.Itoa(i)})
+	}
+	opts := SampleOptions{Percent: 25, Seed: 42}
+	a, skipped, _ := sampleHosts(hosts, opts)
+	b, _, _ := sampleHosts(hosts, opts)
+	if len(a) != 5 || len(skipped) != 15 {
+		t.Fatalf("expected 5 sampled and 15 skipped, got %d/%d", len(a), len(skipped))
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("same seed picked different hosts: %v vs %v", a, b)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package scan
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// validatePayloadSchema checks payload against the JSON Schema at schemaPath
+// and returns an error listing every violation.
+func validatePayloadSchema(payload RemotePayload, schemaPath string) error {
+	schema, err := jsonschema.Compile(schemaPath)
+	if err != nil {
+		return fmt.Errorf("load schema %s: %w", schemaPath, err)
+	}
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	// Decode with UseNumber so integer fields keep their precision.
+	var doc any
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return err
+	}
+	if err := schema.Validate(doc); err != nil {
+		var verr *jsonschema.ValidationError
+		if errors.As(err, &verr) {
+			return fmt.Errorf("payload does not match schema %s:\n%#v", schemaPath, verr)
+		}
+		return err
+	}
+	return nil
+}
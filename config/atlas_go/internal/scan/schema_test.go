@@ -0,0 +1,43 @@
+package scan
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestValidatePayloadSchema(t *testing.T) {
+	dir := t.TempDir()
+	schemaPath := dir + "/schema.json"
+	schema := `{
+  "type": "object",
+  "required": ["hosts"],
+  "properties": {
+    "hosts": {
+      "type": "array",
+      "items": {
+        "type": "object",
+        "required": ["ip"],
+        "properties": {
+          "ip": {"type": "string"},
+          "metadata": {"type": "object", "properties": {"interface_name": {"type": "integer"}}}
+        }
+      }
+    }
+  }
+}`
+	if err := os.WriteFile(schemaPath, []byte(schema), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	payload := samplePayload()
+	err := validatePayloadSchema(payload, schemaPath)
+	if err == nil || !strings.Contains(err.Error(), "interface_name") {
+		t.Fatalf("expected interface_name violation, got %v", err)
+	}
+
+	payload.Hosts[0].Metadata = nil
+	if err := validatePayloadSchema(payload, schemaPath); err != nil {
+		t.Fatalf("expected valid payload, got %v", err)
+	}
+}
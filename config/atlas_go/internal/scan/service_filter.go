@@ -0,0 +1,28 @@
+package scan
+
+import "strings"
+
+// ParseServiceList splits an --only-service list such as "ssh,http" into
+// lowercase service names as nmap reports them.
+func ParseServiceList(s string) []string {
+	var services []string
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			services = append(services, name)
+		}
+	}
+	return services
+}
+
+// offersService reports whether any of h's parsed ports runs one of
+// services. A host without parsed ports offers nothing.
+func offersService(h HostRecord, services []string) bool {
+	for _, p := range h.Ports {
+		for _, s := range services {
+			if strings.EqualFold(p.Service, s) {
+				return true
+			}
+		}
+	}
+	return false
+}
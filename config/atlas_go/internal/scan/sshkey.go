@@ -0,0 +1,64 @@
+package scan
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+
+	"atlas/internal/utils"
+)
+
+// sshKeyscanTimeout bounds a single ssh-keyscan handshake.
+var sshKeyscanTimeout = 5 * time.Second
+
+// sshKeyTypes are requested from ssh-keyscan in order of preference; the
+// first one the host offers is the fingerprint that gets recorded.
+var sshKeyTypes = []string{"ssh-ed25519", "ecdsa-sha2-nistp256", "ssh-rsa"}
+
+// sshHostKeyFingerprint fetches ip's SSH host key without authenticating and
+// returns its OpenSSH-style SHA256 fingerprint ("SHA256:<base64>"). Returns
+// "" on error, timeout, or when the host offers none of sshKeyTypes.
+func sshHostKeyFingerprint(ctx context.Context, ip string) string {
+	ctx, cancel := context.WithTimeout(ctx, sshKeyscanTimeout)
+	defer cancel()
+	timeout := strconv.Itoa(int(sshKeyscanTimeout / time.Second))
+	// ssh-keyscan can exit non-zero when some key types were not offered
+	// but still prints the ones that were, so its error is not checked.
+	out, _ := utils.RunCommand(ctx, "ssh-keyscan", "-T", timeout, "-t", "ed25519,ecdsa,rsa", ip)
+	if ctx.Err() != nil {
+		return ""
+	}
+	keys := map[string]string{}
+	for _, line := range strings.Split(string(out), "\n") {
+		// Key lines are "<host> <type> <base64 blob>"; comments start with #.
+		fields := strings.Fields(line)
+		if len(fields) != 3 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		blob, err := base64.StdEncoding.DecodeString(fields[2])
+		if err != nil {
+			continue
+		}
+		sum := sha256.Sum256(blob)
+		keys[fields[1]] = "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+	}
+	for _, t := range sshKeyTypes {
+		if fp, ok := keys[t]; ok {
+			return fp
+		}
+	}
+	return ""
+}
+
+// hasOpenPort reports whether h has port open over TCP.
+func hasOpenPort(h HostRecord, port int) bool {
+	for _, p := range h.Ports {
+		if p.Port == port && p.Protocol == "tcp" && p.State == "open" {
+			return true
+		}
+	}
+	return false
+}
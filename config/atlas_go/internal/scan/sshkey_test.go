@@ -0,0 +1,28 @@
+package scan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestSSHHostKeyFingerprint(t *testing.T) {
+	useFakeRunner(t, func(name string, args []string) ([]byte, error) {
+		if name != "ssh-keyscan" || args[len(args)-1] != "192.168.1.10" {
+			return nil, fmt.Errorf("unexpected command %s %v", name, args)
+		}
+		return []byte("# 192.168.1.10:22 SSH-2.0-OpenSSH_9.6\n" +
+			"192.168.1.10 ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAAAgQC7\n" +
+			"192.168.1.10 ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAICJRzDyiMKc2/WzPYQdroW+o+SdUf2JV7pn0hYnxBeZL\n"), errors.New("exit status 1")
+	})
+	got := sshHostKeyFingerprint(context.Background(), "192.168.1.10")
+	if want := "SHA256:9pAEOHwoH5UItoi+QFFYTO57Pp6LsrrOL2aQemrVjv0"; got != want {
+		t.Fatalf("fingerprint = %q, want %q (the ed25519 key, as ssh-keygen -l prints it)", got, want)
+	}
+
+	useFakeRunner(t, func(string, []string) ([]byte, error) { return nil, errors.New("connection refused") })
+	if got := sshHostKeyFingerprint(context.Background(), "192.168.1.10"); got != "" {
+		t.Fatalf("fingerprint on failure = %q, want empty", got)
+	}
+}
@@ -0,0 +1,333 @@
+package scan
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"atlas/internal/utils"
+)
+
+// summaryTopPorts is how many ports the run summary ranks.
+const summaryTopPorts = 10
+
+// runSummary is the per-run overview written alongside bundled artifacts and
+// to --summary-json.
+type runSummary struct {
+	Scanner    string           `json:"scanner"`
+	RunID      string           `json:"run_id"`
+	Version    string           `json:"version"`
+	Profile    string           `json:"profile,omitempty"`
+	StartedAt  time.Time        `json:"started_at"`
+	FinishedAt time.Time        `json:"finished_at"`
+	Duration   string           `json:"duration"`
+	Durations  summaryDurations `json:"durations"`
+	HostCount  int              `json:"host_count"`
+	Online     int              `json:"online_hosts"`
+	Offline    int              `json:"offline_hosts"`
+	// Partial is set when --max-duration or an interrupt cut the run short.
+	Partial     bool          `json:"partial,omitempty"`
+	Skipped     int           `json:"skipped_hosts,omitempty"`
+	TopPorts    []PortCount   `json:"top_ports"`
+	SubnetStats []SubnetStats `json:"subnet_stats,omitempty"`
+	Health      *HealthScore  `json:"health_score,omitempty"`
+	Groups      []HostGroup   `json:"groups,omitempty"`
+	// NewHosts were first recorded by this run and RemovedHosts were marked
+	// offline by it. Both are null without the local database. RemovedHosts is
+	// also null on partial and --only-service runs, which mark nothing
+	// offline.
+	NewHosts     []string `json:"new_hosts"`
+	RemovedHosts []string `json:"removed_hosts"`
+}
+
+// summaryDurations breaks a run's wall time down in seconds. Discovery and
+// Scans add up the elapsed times nmap reported, so with parallel host scans
+// Scans can exceed Total.
+type summaryDurations struct {
+	Total     float64 `json:"total"`
+	Discovery float64 `json:"nmap_discovery"`
+	Scans     float64 `json:"nmap_scans"`
+}
+
+// PortCount is how many hosts have a port open.
+type PortCount struct {
+	Port     int    `json:"port"`
+	Protocol string `json:"protocol"`
+	Service  string `json:"service,omitempty"`
+	Hosts    int    `json:"hosts"`
+}
+
+// newRunSummary aggregates hosts into the summary fields that only depend on
+// the records; the caller fills in the rest.
+func newRunSummary(hosts []HostRecord, skipped int, partial bool, startedAt, finishedAt time.Time, nmap nmapRunTotals) runSummary {
+	s := runSummary{
+		StartedAt:  startedAt.UTC(),
+		FinishedAt: finishedAt.UTC(),
+		Duration:   finishedAt.Sub(startedAt).String(),
+		Durations: summaryDurations{
+			Total:     math.Round(finishedAt.Sub(startedAt).Seconds()*100) / 100,
+			Discovery: math.Round(nmap.Discovery.Elapsed*100) / 100,
+			Scans:     math.Round(nmap.Scans.Elapsed*100) / 100,
+		},
+		HostCount: len(hosts),
+		Partial:   partial,
+		Skipped:   skipped,
+		TopPorts:  topOpenPorts(hosts, summaryTopPorts),
+	}
+	for _, h := range hosts {
+		if h.OnlineStatus == "offline" {
+			s.Offline++
+		} else {
+			s.Online++
+		}
+	}
+	return s
+}
+
+// topOpenPorts ranks open ports by how many hosts expose them, breaking ties
+// by port number, and keeps the first n.
+func topOpenPorts(hosts []HostRecord, n int) []PortCount {
+	counts := map[portKey]*PortCount{}
+	for _, h := range hosts {
+		seen := map[portKey]bool{}
+		for _, p := range h.Ports {
+			key := portKey{p.Port, p.Protocol}
+			if p.State != "open" || seen[key] {
+				continue
+			}
+			seen[key] = true
+			c, ok := counts[key]
+			if !ok {
+				c = &PortCount{Port: p.Port, Protocol: p.Protocol, Service: p.Service}
+				counts[key] = c
+			}
+			c.Hosts++
+		}
+	}
+	top := make([]PortCount, 0, len(counts))
+	for _, c := range counts {
+		top = append(top, *c)
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Hosts != top[j].Hosts {
+			return top[i].Hosts > top[j].Hosts
+		}
+		if top[i].Port != top[j].Port {
+			return top[i].Port < top[j].Port
+		}
+		return top[i].Protocol < top[j].Protocol
+	})
+	if len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+// write replaces path through a temporary file so a dashboard polling it
+// never reads half a summary.
+func (s runSummary) write(path string) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(b, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// SubnetStats reports how many addresses of a scanned subnet are in use.
+type SubnetStats struct {
+	Subnet      string  `json:"subnet"`
+	Interface   string  `json:"interface,omitempty"`
+	LiveHosts   int     `json:"live_hosts"`
+	Usable      uint64  `json:"usable_addresses"`
+	Utilization float64 `json:"utilization"`
+	// HostRanges lists the live addresses as coalesced ranges.
+	HostRanges []string `json:"host_ranges,omitempty"`
+}
+
+func (s SubnetStats) String() string {
+	line := fmt.Sprintf("Subnet %s (%s): %d/%d addresses in use (%.1f%%)", s.Subnet, s.Interface, s.LiveHosts, s.Usable, s.Utilization*100)
+	if len(s.HostRanges) > 0 {
+		line += ": " + strings.Join(s.HostRanges, ", ")
+	}
+	return line
+}
+
+// usableAddresses returns the number of assignable host addresses in ipNet.
+// /31 networks are point-to-point links where both addresses are usable
+// (RFC 3021) and a /32 is a single host.
+func usableAddresses(ipNet *net.IPNet) uint64 {
+	ones, bits := ipNet.Mask.Size()
+	hostBits := bits - ones
+	switch {
+	case hostBits <= 0:
+		return 1
+	case hostBits == 1:
+		return 2
+	case hostBits >= 64:
+		return ^uint64(0)
+	}
+	return (uint64(1) << hostBits) - 2
+}
+
+// computeSubnetStats counts discovered hosts per interface subnet. Subnets that
+// fail to parse are skipped.
+func computeSubnetStats(interfaces []utils.InterfaceInfo, hosts []HostRecord) []SubnetStats {
+	var stats []SubnetStats
+	for _, iface := range interfaces {
+		_, ipNet, err := net.ParseCIDR(iface.Subnet)
+		if err != nil {
+			continue
+		}
+		var liveIPs []string
+		for _, h := range hosts {
+			if ip := net.ParseIP(h.IP); ip != nil && ipNet.Contains(ip) {
+				liveIPs = append(liveIPs, h.IP)
+			}
+		}
+		live := len(liveIPs)
+		usable := usableAddresses(ipNet)
+		stats = append(stats, SubnetStats{
+			Subnet:      ipNet.String(),
+			Interface:   iface.Name,
+			LiveHosts:   live,
+			Usable:      usable,
+			Utilization: float64(live) / float64(usable),
+			HostRanges:  summarizeIPRanges(liveIPs),
+		})
+	}
+	return stats
+}
+
+// HostGroup aggregates a run's hosts by network segment: the network name,
+// the interface they were found on, the subnet when one is known, and the
+// VLAN where the scanner recorded it.
+type HostGroup struct {
+	NetworkName string  `json:"network_name,omitempty"`
+	Interface   string  `json:"interface,omitempty"`
+	Subnet      string  `json:"subnet,omitempty"`
+	VLANID      int     `json:"vlan_id,omitempty"`
+	Hosts       int     `json:"hosts"`
+	Online      int     `json:"online"`
+	OnlineRatio float64 `json:"online_ratio"`
+}
+
+func (g HostGroup) String() string {
+	name := g.NetworkName
+	if name == "" {
+		name = "unnamed"
+	}
+	if g.Interface != "" {
+		name += "/" + g.Interface
+	}
+	if g.Subnet != "" {
+		name += " " + g.Subnet
+	}
+	if g.VLANID != 0 {
+		name += fmt.Sprintf(" (VLAN %d)", g.VLANID)
+	}
+	return fmt.Sprintf("Group %s: %d/%d hosts online (%.1f%%)", name, g.Online, g.Hosts, g.OnlineRatio*100)
+}
+
+// groupHosts splits hosts into HostGroups keyed on NetworkName,
+// InterfaceName, subnet, and the vlan_id metadata, ordered by those keys. A
+// host's subnet is its subnet metadata when the scanner recorded one, and
+// otherwise the interface subnet containing its address, preferring the
+// interface it was found on, as computeSubnetStats counts it.
+func groupHosts(interfaces []utils.InterfaceInfo, hosts []HostRecord) []HostGroup {
+	type ifaceNet struct {
+		name string
+		net  *net.IPNet
+	}
+	var nets []ifaceNet
+	for _, iface := range interfaces {
+		if _, ipNet, err := net.ParseCIDR(iface.Subnet); err == nil {
+			nets = append(nets, ifaceNet{iface.Name, ipNet})
+		}
+	}
+	subnetOf := func(h HostRecord) string {
+		if s := metadataString(h.Metadata, "subnet"); s != "" {
+			return s
+		}
+		ip := net.ParseIP(h.IP)
+		if ip == nil {
+			return ""
+		}
+		found := ""
+		for _, n := range nets {
+			if !n.net.Contains(ip) {
+				continue
+			}
+			if n.name == h.InterfaceName {
+				return n.net.String()
+			}
+			if found == "" {
+				found = n.net.String()
+			}
+		}
+		return found
+	}
+
+	index := map[HostGroup]int{}
+	var groups []HostGroup
+	for _, h := range hosts {
+		key := HostGroup{
+			NetworkName: h.NetworkName,
+			Interface:   h.InterfaceName,
+			Subnet:      subnetOf(h),
+			VLANID:      metadataInt(h.Metadata, "vlan_id"),
+		}
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, key)
+		}
+		groups[i].Hosts++
+		if h.OnlineStatus != "offline" {
+			groups[i].Online++
+		}
+	}
+	for i := range groups {
+		groups[i].OnlineRatio = float64(groups[i].Online) / float64(groups[i].Hosts)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		a, b := groups[i], groups[j]
+		switch {
+		case a.NetworkName != b.NetworkName:
+			return a.NetworkName < b.NetworkName
+		case a.Interface != b.Interface:
+			return a.Interface < b.Interface
+		case a.Subnet != b.Subnet:
+			return a.Subnet < b.Subnet
+		}
+		return a.VLANID < b.VLANID
+	})
+	return groups
+}
+
+// metadataString returns meta[key] if it is a string.
+func metadataString(meta map[string]any, key string) string {
+	s, _ := meta[key].(string)
+	return s
+}
+
+// metadataInt returns meta[key] as an int, accepting the float64 a JSON
+// round trip (e.g. --replay) leaves behind.
+func metadataInt(meta map[string]any, key string) int {
+	switch v := meta[key].(type) {
+	case int:
+		return v
+	case float64:
+		return int(v)
+	}
+	return 0
+}
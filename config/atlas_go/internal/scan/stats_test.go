@@ -0,0 +1,121 @@
+package scan
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"atlas/internal/utils"
+)
+
+func TestComputeSubnetStatsEdgeCases(t *testing.T) {
+	interfaces := []utils.InterfaceInfo{
+		{Name: "eth0", Subnet: "192.168.1.0/24"},
+		{Name: "p2p0", Subnet: "10.0.0.0/31"},
+		{Name: "lo1", Subnet: "10.9.9.9/32"},
+		{Name: "bad", Subnet: "not-a-cidr"},
+	}
+	hosts := []HostRecord{{IP: "192.168.1.1"}, {IP: "192.168.1.20"}, {IP: "10.0.0.1"}, {IP: "172.16.0.1"}}
+
+	stats := computeSubnetStats(interfaces, hosts)
+	if len(stats) != 3 {
+		t.Fatalf("expected 3 subnet stats, got %+v", stats)
+	}
+	want := []struct {
+		live   int
+		usable uint64
+	}{{2, 254}, {1, 2}, {0, 1}}
+	for i, w := range want {
+		if stats[i].LiveHosts != w.live || stats[i].Usable != w.usable {
+			t.Errorf("%s: want %d/%d, got %d/%d", stats[i].Subnet, w.live, w.usable, stats[i].LiveHosts, stats[i].Usable)
+		}
+	}
+}
+
+func TestSortHostsNumericByInterface(t *testing.T) {
+	hosts := []HostRecord{
+		{IP: "10.0.0.10", InterfaceName: "eth1"},
+		{IP: "192.168.1.100", InterfaceName: "eth0"},
+		{IP: "fe80::1", InterfaceName: "eth0"},
+		{IP: "192.168.1.9", InterfaceName: "eth0"},
+		{IP: "10.0.0.2", InterfaceName: "eth1"},
+	}
+	sortHosts(hosts)
+	var got []string
+	for _, h := range hosts {
+		got = append(got, h.InterfaceName+"/"+h.IP)
+	}
+	want := "eth0/192.168.1.9,eth0/192.168.1.100,eth0/fe80::1,eth1/10.0.0.2,eth1/10.0.0.10"
+	if strings.Join(got, ",") != want {
+		t.Fatalf("unexpected order %v", got)
+	}
+}
+
+func TestSummarizeIPRanges(t *testing.T) {
+	cases := []struct {
+		ips  []string
+		want string
+	}{
+		{[]string{"192.168.1.20", "192.168.1.12", "192.168.1.10", "192.168.1.11", "192.168.1.13", "192.168.1.14"}, "192.168.1.10-14,192.168.1.20"},
+		{[]string{"10.0.0.8", "10.0.0.9", "10.0.0.10", "10.0.0.11"}, "10.0.0.8/30"},
+		{[]string{"10.0.0.9", "10.0.0.10", "10.0.0.11", "10.0.0.12"}, "10.0.0.9-12"},
+		{[]string{"10.0.0.255", "10.0.1.0", "10.0.1.1"}, "10.0.0.255-10.0.1.1"},
+		{[]string{"10.0.0.1", "10.0.0.1", "bogus", "10.0.0.3"}, "10.0.0.1,10.0.0.3"},
+		{nil, ""},
+	}
+	for _, c := range cases {
+		if got := strings.Join(summarizeIPRanges(c.ips), ","); got != c.want {
+			t.Errorf("summarizeIPRanges(%v) = %q, want %q", c.ips, got, c.want)
+		}
+	}
+}
+
+func TestGroupHosts(t *testing.T) {
+	hosts := []HostRecord{
+		{IP: "10.0.20.5", NetworkName: "LAN", InterfaceName: "eth0.20", OnlineStatus: "online", Metadata: map[string]any{"vlan_id": 20}},
+		{IP: "192.168.1.10", NetworkName: "LAN", InterfaceName: "eth0", OnlineStatus: "online", Metadata: map[string]any{"subnet": "192.168.1.0/24"}},
+		{IP: "192.168.1.11", NetworkName: "LAN", InterfaceName: "eth0", OnlineStatus: "offline", Metadata: map[string]any{"subnet": "192.168.1.0/24"}},
+		{IP: "10.0.0.2", NetworkName: "LAN", InterfaceName: "eth1", OnlineStatus: "online"},
+		// A JSON round trip leaves vlan_id as a float64.
+		{IP: "10.0.20.6", NetworkName: "LAN", InterfaceName: "eth0.20", OnlineStatus: "offline", Metadata: map[string]any{"vlan_id": float64(20)}},
+		{IP: "172.16.0.9", NetworkName: "Docker", InterfaceName: "docker0", OnlineStatus: "online"},
+	}
+	// Deep scan hosts have no subnet metadata; theirs comes from the
+	// interface, preferring the one they were found on.
+	interfaces := []utils.InterfaceInfo{
+		{Name: "eth0", Subnet: "192.168.1.1/24"},
+		{Name: "eth9", Subnet: "10.0.0.0/8"},
+		{Name: "eth0.20", Subnet: "10.0.20.1/24", VLANID: 20, Parent: "eth0"},
+		{Name: "bad", Subnet: "not-a-cidr"},
+	}
+	got := groupHosts(interfaces, hosts)
+	want := []HostGroup{
+		{NetworkName: "Docker", Interface: "docker0", Hosts: 1, Online: 1, OnlineRatio: 1},
+		{NetworkName: "LAN", Interface: "eth0", Subnet: "192.168.1.0/24", Hosts: 2, Online: 1, OnlineRatio: 0.5},
+		{NetworkName: "LAN", Interface: "eth0.20", Subnet: "10.0.20.0/24", VLANID: 20, Hosts: 2, Online: 1, OnlineRatio: 0.5},
+		{NetworkName: "LAN", Interface: "eth1", Subnet: "10.0.0.0/8", Hosts: 1, Online: 1, OnlineRatio: 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("groupHosts:\n got %+v\nwant %+v", got, want)
+	}
+	if s := got[2].String(); s != "Group LAN/eth0.20 10.0.20.0/24 (VLAN 20): 1/2 hosts online (50.0%)" {
+		t.Errorf("String() = %q", s)
+	}
+	if groups := groupHosts(interfaces, nil); len(groups) != 0 {
+		t.Errorf("no hosts should give no groups, got %+v", groups)
+	}
+}
+
+func TestNewRunSummaryTakesPartialFromCaller(t *testing.T) {
+	start := time.Now()
+	hosts := []HostRecord{{IP: "10.0.0.1", OnlineStatus: "online"}, {IP: "10.0.0.2", OnlineStatus: "offline"}}
+	// An interrupted run can be partial without skipping a host.
+	s := newRunSummary(hosts, 0, true, start, start.Add(time.Minute), nmapRunTotals{})
+	if !s.Partial || s.Skipped != 0 || s.Online != 1 || s.Offline != 1 {
+		t.Fatalf("summary %+v", s)
+	}
+	if s := newRunSummary(hosts, 0, false, start, start.Add(time.Minute), nmapRunTotals{}); s.Partial {
+		t.Fatal("complete run reported as partial")
+	}
+}
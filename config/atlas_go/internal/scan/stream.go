@@ -0,0 +1,212 @@
+package scan
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"atlas/internal/utils"
+)
+
+// Defaults for --stream-every and --stream-interval.
+const (
+	DefaultStreamEvery    = 25
+	DefaultStreamInterval = 30 * time.Second
+)
+
+// hostStream posts finished hosts to the controller in small batches while a
+// deep scan is still running (--stream-ingest), so a long run shows up
+// incrementally and a crash loses at most the unflushed hosts. Every batch
+// carries the run_id and a stream_batch_index; the final batch adds
+// stream_batch_count and the run metadata so the controller knows the run is
+// complete. The keys are namespaced because postBatched sets its own
+// batch_index and batch_count when a stream batch is split further.
+type hostStream struct {
+	opts     RemotePayloadOptions
+	runID    string
+	every    int
+	interval time.Duration
+	logf     func(format string, args ...any)
+
+	mu      sync.Mutex
+	pending []HostRecord
+	kick    chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+	// Owned by run until done is closed. sent holds each delivered host's
+	// IP and interface once, however often it was re-sent.
+	batches int
+	sent    map[[2]string]bool
+}
+
+// newHostStream starts streaming to the controller in opts.Config. It
+// returns nil when no controller is configured; a nil stream ignores add.
+func newHostStream(opts RemotePayloadOptions, runID string, every int, interval time.Duration, logf func(format string, args ...any)) *hostStream {
+	if !opts.Config.Enabled() {
+		logf(utils.Deco("⚠️ --stream-ingest needs a controller (--remote, --site, --agent); emitting once at the end"))
+		return nil
+	}
+	if every <= 0 {
+		every = DefaultStreamEvery
+	}
+	s := &hostStream{
+		// Only the controller gets batches; files and stdout get the
+		// complete payload at the end.
+		opts:     RemotePayloadOptions{Config: opts.Config, SchemaPath: opts.SchemaPath, Labels: opts.Labels, MACSalt: opts.MACSalt},
+		runID:    runID,
+		every:    every,
+		interval: interval,
+		logf:     logf,
+		kick:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+		sent:     map[[2]string]bool{},
+	}
+	// Discover once; every batch would otherwise fetch the document again.
+	s.opts.Config.Discover()
+	go s.run()
+	return s
+}
+
+// add queues a finished host. The record's metadata is copied, since the
+// scan keeps annotating its own copy after this. Adding a host again, e.g.
+// after post-scan analysis changed it, replaces its queued copy or sends it
+// once more if it already went out.
+func (s *hostStream) add(record HostRecord) {
+	if s == nil {
+		return
+	}
+	meta := make(map[string]any, len(record.Metadata)+len(s.opts.Labels))
+	for k, v := range record.Metadata {
+		meta[k] = v
+	}
+	record.Metadata = meta
+	s.mu.Lock()
+	queued := false
+	for i, p := range s.pending {
+		if p.IP == record.IP && p.InterfaceName == record.InterfaceName {
+			s.pending[i] = record
+			queued = true
+			break
+		}
+	}
+	if !queued {
+		s.pending = append(s.pending, record)
+	}
+	full := len(s.pending) >= s.every
+	s.mu.Unlock()
+	if full {
+		select {
+		case s.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (s *hostStream) run() {
+	defer close(s.done)
+	var tick <-chan time.Time
+	if s.interval > 0 {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-s.kick:
+		case <-tick:
+		}
+		if batch := s.take(); len(batch) > 0 {
+			s.post(batch, nil)
+		}
+	}
+}
+
+func (s *hostStream) take() []HostRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	batch := s.pending
+	s.pending = nil
+	return batch
+}
+
+// post sends one batch. A failed intermediate batch is queued again for the
+// next flush; final is non-nil for the closing batch, whose error is returned.
+func (s *hostStream) post(batch []HostRecord, final map[string]any) error {
+	meta := map[string]any{"run_id": s.runID, "stream": true, "stream_batch_index": s.batches}
+	for k, v := range final {
+		meta[k] = v
+	}
+	if final != nil {
+		meta["stream_batch_count"] = s.batches + 1
+		meta["final"] = true
+	}
+	applyLabels(batch, s.opts.Labels)
+	if err := s.opts.emit(hostsPayload(batch, meta, s.opts)); err != nil {
+		if final != nil {
+			return err
+		}
+		s.logf(utils.Deco("⚠️ Streaming %d hosts failed, retrying with the next batch: %v"), len(batch), err)
+		s.mu.Lock()
+		// A host re-added since keeps only its newer copy.
+		newer := map[[2]string]bool{}
+		for _, h := range s.pending {
+			newer[[2]string{h.IP, h.InterfaceName}] = true
+		}
+		var retry []HostRecord
+		for _, h := range batch {
+			if !newer[[2]string{h.IP, h.InterfaceName}] {
+				retry = append(retry, h)
+			}
+		}
+		s.pending = append(retry, s.pending...)
+		s.mu.Unlock()
+		return nil
+	}
+	s.batches++
+	for _, h := range batch {
+		s.sent[[2]string{h.IP, h.InterfaceName}] = true
+	}
+	return nil
+}
+
+// finish stops the background flushes and posts the remaining hosts with the
+// run metadata as the final batch. emitEmpty mirrors --emit-empty for a run
+// that found nothing.
+func (s *hostStream) finish(runMeta map[string]any, emitEmpty bool) error {
+	if s == nil {
+		return nil
+	}
+	close(s.stop)
+	<-s.done
+	batch := s.take()
+	before := len(s.sent)
+	total := before
+	counted := map[[2]string]bool{}
+	for _, h := range batch {
+		key := [2]string{h.IP, h.InterfaceName}
+		if !s.sent[key] && !counted[key] {
+			counted[key] = true
+			total++
+		}
+	}
+	if total == 0 {
+		if !emitEmpty {
+			fmt.Println(utils.Deco("⚠️ No hosts discovered; skipping remote payload"))
+			return nil
+		}
+		runMeta = emptyRunMetadata(runMeta, s.opts.Config)
+	}
+	final := map[string]any{"streamed_hosts": total}
+	for k, v := range runMeta {
+		final[k] = v
+	}
+	sortHosts(batch)
+	if err := s.post(batch, final); err != nil {
+		return fmt.Errorf("final stream batch (%d hosts, %d streamed before): %w", len(batch), before, err)
+	}
+	s.logf("Streamed %d hosts to the controller in %d batches", total, s.batches)
+	return nil
+}
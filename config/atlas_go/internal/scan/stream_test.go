@@ -0,0 +1,158 @@
+package scan
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHostStreamPostsBatchesAndFinalRun(t *testing.T) {
+	requests := make(chan RemotePayload, 4)
+	fail := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == wellKnownPath {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var p RemotePayload
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			t.Errorf("decode body: %v", err)
+		}
+		if fail {
+			fail = false
+			w.WriteHeader(http.StatusServiceUnavailable)
+		} else {
+			w.WriteHeader(http.StatusAccepted)
+		}
+		requests <- p
+	}))
+	defer srv.Close()
+
+	opts := RemotePayloadOptions{
+		Config: RemoteConfig{ControllerURL: srv.URL + "/api", SiteID: "lab", AgentID: "edge01"},
+		Labels: map[string]string{"site": "hq"},
+	}
+	stream := newHostStream(opts, "run-1", 2, 0, t.Logf)
+	host := func(ip string) HostRecord {
+		return HostRecord{IP: ip, InterfaceName: "eth0", Metadata: map[string]any{"run_id": "run-1"}}
+	}
+	next := func() RemotePayload {
+		select {
+		case p := <-requests:
+			return p
+		case <-time.After(5 * time.Second):
+			t.Fatal("no batch posted")
+		}
+		return RemotePayload{}
+	}
+
+	// The first batch is rejected and kept for a later one.
+	stream.add(host("10.0.0.1"))
+	stream.add(host("10.0.0.2"))
+	if p := next(); len(p.Hosts) != 2 || p.Metadata["stream"] != true || p.Metadata["final"] != nil {
+		t.Fatalf("unexpected first batch: %d hosts, %v", len(p.Hosts), p.Metadata)
+	}
+	stream.add(host("10.0.0.3"))
+	stream.add(host("10.0.0.4"))
+	stream.add(host("10.0.0.5"))
+	if err := stream.finish(map[string]any{"run_id": "run-1", "health_score": 90}, false); err != nil {
+		t.Fatalf("finish: %v", err)
+	}
+	close(requests)
+
+	// Whether the retry went out on its own or with the final batch depends
+	// on timing; every host must arrive exactly once either way.
+	var batches []RemotePayload
+	for p := range requests {
+		batches = append(batches, p)
+	}
+	seen := map[string]int{}
+	for i, p := range batches {
+		if p.Metadata["stream_batch_index"] != float64(i) {
+			t.Fatalf("batch %d has stream_batch_index %v", i, p.Metadata["stream_batch_index"])
+		}
+		for _, h := range p.Hosts {
+			seen[h.IP]++
+			if h.Metadata["site"] != "hq" {
+				t.Fatalf("labels not applied to streamed host %s: %v", h.IP, h.Metadata)
+			}
+		}
+	}
+	if len(seen) != 5 {
+		t.Fatalf("expected 5 distinct hosts, got %v", seen)
+	}
+	for ip, n := range seen {
+		if n != 1 {
+			t.Fatalf("host %s delivered %d times", ip, n)
+		}
+	}
+	last := batches[len(batches)-1]
+	want := map[string]any{"run_id": "run-1", "stream": true, "final": true, "stream_batch_index": float64(len(batches) - 1),
+		"stream_batch_count": float64(len(batches)), "streamed_hosts": float64(5), "health_score": float64(90)}
+	if !reflect.DeepEqual(last.Metadata, want) {
+		t.Fatalf("final metadata %v, want %v", last.Metadata, want)
+	}
+}
+
+func TestHostStreamResendsUpdatedHosts(t *testing.T) {
+	var mu sync.Mutex
+	var batches []RemotePayload
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == wellKnownPath {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var p RemotePayload
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			t.Errorf("decode body: %v", err)
+		}
+		mu.Lock()
+		batches = append(batches, p)
+		mu.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	opts := RemotePayloadOptions{Config: RemoteConfig{ControllerURL: srv.URL, SiteID: "lab", AgentID: "edge01"}}
+	stream := newHostStream(opts, "run-1", 1, 0, t.Logf)
+	first := HostRecord{IP: "10.0.0.1", InterfaceName: "eth0", Metadata: map[string]any{}}
+	stream.add(first)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		n := len(batches)
+		mu.Unlock()
+		if n > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no batch posted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Post-scan analysis flags the host after it was streamed.
+	first.Metadata = map[string]any{"mac_conflict": true}
+	stream.add(first)
+	if err := stream.finish(map[string]any{"run_id": "run-1"}, false); err != nil {
+		t.Fatalf("finish: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	var last RemoteHostPayload
+	for _, p := range batches {
+		for _, h := range p.Hosts {
+			last = h
+		}
+	}
+	if last.Metadata["mac_conflict"] != true {
+		t.Fatalf("host last delivered without mac_conflict: %v", last.Metadata)
+	}
+	if final := batches[len(batches)-1].Metadata; final["streamed_hosts"] != float64(1) || final["final"] != true {
+		t.Fatalf("unexpected final metadata %v", final)
+	}
+}
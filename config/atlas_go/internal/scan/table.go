@@ -0,0 +1,43 @@
+package scan
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"unicode/utf8"
+)
+
+// tablePortsWidth caps the Ports column unless --wide is set.
+const tablePortsWidth = 40
+
+// writeHostTable renders hosts as an aligned text table for interactive use.
+func writeHostTable(w io.Writer, hosts []HostRecord, wide bool) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "IP\tNAME\tOS\tMAC\tPORTS")
+	for _, h := range hosts {
+		ports := h.PortSummary
+		if ports == "" {
+			ports = h.PortsSummary()
+		}
+		if !wide {
+			ports = truncate(ports, tablePortsWidth)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", h.IP, orDash(h.Hostname), orDash(h.OS), orDash(h.MAC), orDash(ports))
+	}
+	return tw.Flush()
+}
+
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	r := []rune(s)
+	return string(r[:n-1]) + "…"
+}
+
+func orDash(s string) string {
+	if s == "" || s == "Unknown" || s == "NoName" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,23 @@
+package scan
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteHostTableTruncatesPorts(t *testing.T) {
+	hosts := []HostRecord{{IP: "10.0.0.1", Hostname: "nas", OS: "Unknown", PortSummary: strings.Repeat("22/tcp (ssh), ", 5)}}
+	var narrow, wide strings.Builder
+	if err := writeHostTable(&narrow, hosts, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeHostTable(&wide, hosts, true); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(narrow.String(), "…") || strings.Contains(wide.String(), "…") {
+		t.Fatalf("unexpected truncation:\n%s\n%s", narrow.String(), wide.String())
+	}
+	if !strings.HasPrefix(narrow.String(), "IP        NAME  OS  MAC  PORTS\n10.0.0.1  nas   -   -    ") {
+		t.Fatalf("unexpected layout:\n%s", narrow.String())
+	}
+}
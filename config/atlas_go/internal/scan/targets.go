@@ -0,0 +1,73 @@
+package scan
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"atlas/internal/utils"
+)
+
+// lookupHost resolves a target hostname to its A/AAAA addresses through r.
+// Tests replace it to avoid real DNS.
+var lookupHost = func(r *net.Resolver, name string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return r.LookupHost(ctx, name)
+}
+
+// resolveTargets expands --target values into hosts to scan. IPs are used
+// as-is, CIDRs are swept with discover, and hostnames are resolved to every
+// A/AAAA record (looked up through dns) with the original name kept on each
+// host. A target that fails is reported in errs and skipped; it never aborts
+// the others.
+func resolveTargets(targets []string, interfaces []utils.InterfaceInfo, dns *net.Resolver, discover func(subnet string) ([]HostInfo, error)) (hosts []HostInfo, errs []error) {
+	for _, target := range targets {
+		target = strings.TrimSpace(target)
+		if target == "" {
+			continue
+		}
+		switch {
+		case net.ParseIP(target) != nil:
+			hosts = append(hosts, HostInfo{IP: target, Name: "NoName", InterfaceName: interfaceFor(target, interfaces)})
+		case strings.Contains(target, "/"):
+			found, err := discover(target)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("target %s: %w", target, err))
+				continue
+			}
+			for _, h := range found {
+				h.InterfaceName = interfaceFor(h.IP, interfaces)
+				hosts = append(hosts, h)
+			}
+		default:
+			addrs, err := lookupHost(dns, target)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("target %s: resolve failed: %w", target, err))
+				continue
+			}
+			if len(addrs) == 0 {
+				errs = append(errs, fmt.Errorf("target %s: no A/AAAA records", target))
+				continue
+			}
+			for _, ip := range addrs {
+				hosts = append(hosts, HostInfo{IP: ip, Name: target, InterfaceName: interfaceFor(ip, interfaces), TargetHostname: target})
+			}
+		}
+	}
+	return hosts, errs
+}
+
+// interfaceFor returns the name of the interface whose subnet contains ip,
+// or "target" for addresses reached through a router.
+func interfaceFor(ip string, interfaces []utils.InterfaceInfo) string {
+	parsed := net.ParseIP(ip)
+	for _, iface := range interfaces {
+		if _, ipNet, err := net.ParseCIDR(iface.Subnet); err == nil && parsed != nil && ipNet.Contains(parsed) {
+			return iface.Name
+		}
+	}
+	return "target"
+}
@@ -0,0 +1,40 @@
+package scan
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"atlas/internal/utils"
+)
+
+func TestResolveTargetsHostnames(t *testing.T) {
+	prev := lookupHost
+	lookupHost = func(_ *net.Resolver, name string) ([]string, error) {
+		if name == "fileserver.corp.local" {
+			return []string{"10.0.5.20", "fd00::20"}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+	t.Cleanup(func() { lookupHost = prev })
+
+	interfaces := []utils.InterfaceInfo{{Name: "eth0", Subnet: "10.0.5.0/24"}}
+	hosts, errs := resolveTargets([]string{"fileserver.corp.local", "missing.corp.local", "192.168.9.9"}, interfaces, net.DefaultResolver, nil)
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "missing.corp.local") {
+		t.Fatalf("expected one resolution error for missing.corp.local, got %v", errs)
+	}
+	want := []HostInfo{
+		{IP: "10.0.5.20", Name: "fileserver.corp.local", InterfaceName: "eth0", TargetHostname: "fileserver.corp.local"},
+		{IP: "fd00::20", Name: "fileserver.corp.local", InterfaceName: "target", TargetHostname: "fileserver.corp.local"},
+		{IP: "192.168.9.9", Name: "NoName", InterfaceName: "target"},
+	}
+	if len(hosts) != len(want) {
+		t.Fatalf("expected %d hosts, got %+v", len(want), hosts)
+	}
+	for i := range want {
+		if hosts[i] != want[i] {
+			t.Errorf("host %d: want %+v got %+v", i, want[i], hosts[i])
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package scan
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tokenCommandTimeout bounds a --token-command run.
+var tokenCommandTimeout = 30 * time.Second
+
+// TokenRefresher obtains a new bearer token when the controller answers 401,
+// either by running Command and reading the token from its stdout or by an
+// OAuth2 client-credentials request to URL. It is shared by pointer so the
+// refreshed token outlives the run that fetched it.
+type TokenRefresher struct {
+	Command      string
+	URL          string
+	ClientID     string
+	ClientSecret string
+
+	mu    sync.Mutex
+	token string
+}
+
+// current returns the last refreshed token, or "" before the first refresh.
+func (t *TokenRefresher) current() string {
+	if t == nil {
+		return ""
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.token
+}
+
+// refresh fetches and caches a new token.
+func (t *TokenRefresher) refresh(client *http.Client) (string, error) {
+	var (
+		token string
+		err   error
+	)
+	if t.Command != "" {
+		token, err = runTokenCommand(t.Command)
+	} else {
+		token, err = t.clientCredentials(client)
+	}
+	if err != nil {
+		return "", err
+	}
+	if token == "" {
+		return "", errors.New("token refresh returned an empty token")
+	}
+	t.mu.Lock()
+	t.token = token
+	t.mu.Unlock()
+	return token, nil
+}
+
+// runTokenCommand runs command through sh and returns its trimmed stdout.
+// It bypasses utils.Runner, which only exists for the scanners' tools and
+// is swapped out by replays and tests.
+func runTokenCommand(command string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), tokenCommandTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "sh", "-c", command).Output()
+	if err != nil {
+		return "", fmt.Errorf("token command: %w", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func (t *TokenRefresher) clientCredentials(client *http.Client) (string, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	req, err := http.NewRequest(http.MethodPost, t.URL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(t.ClientID), url.QueryEscape(t.ClientSecret))
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("token endpoint: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", fmt.Errorf("token endpoint: %w", err)
+	}
+	if resp.StatusCode >= 300 {
+		return "", fmt.Errorf("token endpoint: %s - %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	var tok struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.Unmarshal(body, &tok); err != nil {
+		return "", fmt.Errorf("token endpoint: decode response: %w", err)
+	}
+	return tok.AccessToken, nil
+}
@@ -0,0 +1,81 @@
+package scan
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPostPayloadRefreshesTokenOn401(t *testing.T) {
+	var auths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/oauth/token":
+			if id, secret, _ := r.BasicAuth(); id != "agent" || secret != "s3cret" || r.FormValue("grant_type") != "client_credentials" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			_, _ = w.Write([]byte(`{"access_token":"fresh","token_type":"bearer"}`))
+		default:
+			auths = append(auths, r.Header.Get("Authorization"))
+			if r.Header.Get("Authorization") != "Bearer fresh" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.WriteHeader(http.StatusAccepted)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	refresh := &TokenRefresher{URL: srv.URL + "/oauth/token", ClientID: "agent", ClientSecret: "s3cret"}
+	rc := RemoteConfig{ControllerURL: srv.URL, SiteID: "lab", AgentID: "edge01", Token: "expired", Refresh: refresh}
+	if err := rc.PostPayload(samplePayload()); err != nil {
+		t.Fatalf("PostPayload: %v", err)
+	}
+	if strings.Join(auths, ",") != "Bearer expired,Bearer fresh" {
+		t.Fatalf("unexpected Authorization sequence %v", auths)
+	}
+	// Later runs copy the config but share the refresher, so they start
+	// with the new token.
+	auths = nil
+	if err := rc.PostPayload(samplePayload()); err != nil || len(auths) != 1 {
+		t.Fatalf("second post: err=%v auths=%v", err, auths)
+	}
+
+	rc.Refresh = &TokenRefresher{Command: "echo stale"}
+	err := rc.PostPayload(samplePayload())
+	var ingestErr *RemoteIngestError
+	if !errors.As(err, &ingestErr) || ingestErr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("a refreshed token that is still rejected should surface the 401, got %v", err)
+	}
+}
+
+func TestGetPaginatedRefreshesTokenOn401(t *testing.T) {
+	var auths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auths = append(auths, r.Header.Get("Authorization"))
+		if r.Header.Get("Authorization") != "Bearer fresh" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		io.WriteString(w, `[{"ip":"10.0.0.1"}]`)
+	}))
+	t.Cleanup(srv.Close)
+
+	rc := RemoteConfig{ControllerURL: srv.URL, Token: "expired", Refresh: &TokenRefresher{Command: "echo fresh"}}
+	items, err := rc.GetPaginated("hosts")
+	if err != nil || len(items) != 1 {
+		t.Fatalf("GetPaginated: %d items, %v", len(items), err)
+	}
+	if strings.Join(auths, ",") != "Bearer expired,Bearer fresh" {
+		t.Fatalf("unexpected Authorization sequence %v", auths)
+	}
+
+	rc.Refresh = &TokenRefresher{Command: "false"}
+	if _, err := rc.GetPaginated("hosts"); !errors.Is(err, ErrRemoteFetch) || !strings.Contains(err.Error(), "token refresh failed") {
+		t.Fatalf("failed refresh: %v", err)
+	}
+}
@@ -0,0 +1,80 @@
+package scan
+
+import (
+	"strconv"
+	"strings"
+)
+
+// unknownHop stands in for a hop that did not answer, so hop_path keeps each
+// hop at its position and hop_count matches nmap's hop numbers.
+const unknownHop = "*"
+
+// parseTraceroute extracts hop addresses from the TRACEROUTE section of nmap's
+// normal output, e.g.
+//
+//	HOP RTT     ADDRESS
+//	1   0.51 ms router.lan (192.168.1.1)
+//	2   1.20 ms 10.0.0.5
+//
+// Hops that did not answer ("2 ..." or the range "2 ... 4") are recorded as
+// unknownHop.
+func parseTraceroute(out string) []string {
+	var hops []string
+	inSection := false
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "TRACEROUTE") {
+			inSection = true
+			continue
+		}
+		if !inSection || strings.HasPrefix(line, "HOP") {
+			continue
+		}
+		if line == "" {
+			inSection = false
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		hop, err := strconv.Atoi(fields[0])
+		if err != nil {
+			continue
+		}
+		if fields[1] == "..." {
+			last := hop
+			if len(fields) > 2 {
+				if n, err := strconv.Atoi(fields[2]); err == nil {
+					last = n
+				}
+			}
+			for len(hops) < last {
+				hops = append(hops, unknownHop)
+			}
+			continue
+		}
+		for len(hops) < hop-1 {
+			hops = append(hops, unknownHop)
+		}
+		hops = append(hops, strings.Trim(fields[len(fields)-1], "()"))
+	}
+	return hops
+}
+
+// applyHops records the traceroute path on record. Directly connected hosts
+// (a single hop) keep the gateway as their next hop; routed hosts use the
+// first hop that was crossed, unless it did not answer.
+func applyHops(record *HostRecord, hops []string) {
+	if len(hops) == 0 {
+		return
+	}
+	if record.Metadata == nil {
+		record.Metadata = map[string]any{}
+	}
+	record.Metadata["hop_path"] = hops
+	record.Metadata["hop_count"] = len(hops)
+	if len(hops) > 1 && hops[0] != unknownHop {
+		record.NextHop = hops[0]
+	}
+}
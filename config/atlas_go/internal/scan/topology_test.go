@@ -0,0 +1,42 @@
+package scan
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseTraceroute(t *testing.T) {
+	out := "PORT   STATE SERVICE\n22/tcp open  ssh\n\n" +
+		"TRACEROUTE (using port 22/tcp)\n" +
+		"HOP RTT     ADDRESS\n" +
+		"1   0.51 ms router.lan (192.168.1.1)\n" +
+		"2   ...\n" +
+		"3   1.20 ms 10.0.0.5\n" +
+		"\n" +
+		"OS detection performed.\n"
+	hops := parseTraceroute(out)
+	if strings.Join(hops, ",") != "192.168.1.1,*,10.0.0.5" {
+		t.Fatalf("unexpected hops %v", hops)
+	}
+
+	record := HostRecord{IP: "10.0.0.5", NextHop: "192.168.1.254"}
+	applyHops(&record, hops)
+	if record.NextHop != "192.168.1.1" || record.Metadata["hop_count"] != 3 {
+		t.Fatalf("unexpected record %+v", record)
+	}
+
+	// A silent range, including the first hop, keeps every position.
+	out = "TRACEROUTE (using port 443/tcp)\n" +
+		"HOP RTT     ADDRESS\n" +
+		"1   ... 3\n" +
+		"4   9.80 ms 203.0.113.7\n"
+	hops = parseTraceroute(out)
+	if strings.Join(hops, ",") != "*,*,*,203.0.113.7" {
+		t.Fatalf("unexpected hops %v", hops)
+	}
+	record = HostRecord{IP: "203.0.113.7", NextHop: "192.168.1.254"}
+	applyHops(&record, hops)
+	if record.NextHop != "192.168.1.254" || record.Metadata["hop_count"] != 4 {
+		t.Fatalf("an unanswered first hop replaced the next hop: %+v", record)
+	}
+}
@@ -0,0 +1,109 @@
+package scan
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"atlas/internal/utils"
+)
+
+// vlanSetupTimeout bounds creating one sub-interface.
+const vlanSetupTimeout = 10 * time.Second
+
+// VLANSpec is a --vlan ID@PARENT=ADDRESS request for a tagged sub-interface
+// to scan.
+type VLANSpec struct {
+	ID     int
+	Parent string
+	// Address is assigned to the sub-interface in CIDR notation.
+	Address string
+}
+
+func (v VLANSpec) String() string {
+	return fmt.Sprintf("%d@%s=%s", v.ID, v.Parent, v.Address)
+}
+
+// ParseVLANSpec parses "20@eth0=10.0.20.5/24". The address is required; a
+// DHCP lease would replace the agent's default route and resolver.
+func ParseVLANSpec(s string) (VLANSpec, error) {
+	spec, address, _ := strings.Cut(strings.TrimSpace(s), "=")
+	id, parent, ok := strings.Cut(spec, "@")
+	if !ok || parent == "" {
+		return VLANSpec{}, fmt.Errorf("invalid --vlan %q (want ID@PARENT=ADDRESS, e.g. 20@eth0=10.0.20.5/24)", s)
+	}
+	n, err := strconv.Atoi(id)
+	if err != nil || n < 1 || n > 4094 {
+		return VLANSpec{}, fmt.Errorf("invalid --vlan %q: VLAN ID must be 1-4094", s)
+	}
+	if _, _, err := net.ParseCIDR(address); err != nil {
+		return VLANSpec{}, fmt.Errorf("invalid --vlan %q: needs a CIDR address for the sub-interface, e.g. 20@eth0=10.0.20.5/24", s)
+	}
+	return VLANSpec{ID: n, Parent: parent, Address: address}, nil
+}
+
+// setupVLANs creates the requested sub-interfaces so interface discovery
+// picks them up. Sub-interfaces that already exist are used as they are.
+// The returned cleanup removes only the ones created here.
+func setupVLANs(specs []VLANSpec, logf func(format string, args ...any)) (cleanup func(), err error) {
+	var created []string
+	cleanup = func() {
+		for _, name := range created {
+			if err := utils.DeleteVLAN(context.Background(), name); err != nil {
+				logf(utils.Deco("⚠️ Could not remove VLAN interface: %v"), err)
+			} else {
+				logf("Removed VLAN interface %s", name)
+			}
+		}
+	}
+	if len(specs) == 0 {
+		return cleanup, nil
+	}
+	if !currentPrivileges().NetAdmin {
+		return cleanup, fmt.Errorf("%w: --vlan creates interfaces and needs CAP_NET_ADMIN (docker --cap-add NET_ADMIN)", ErrNoInterfaces)
+	}
+	for _, spec := range specs {
+		ctx, cancel := context.WithTimeout(context.Background(), vlanSetupTimeout)
+		name, isNew, err := utils.CreateVLAN(ctx, spec.Parent, spec.ID, spec.Address)
+		cancel()
+		if err != nil {
+			cleanup()
+			return func() {}, fmt.Errorf("%w: --vlan %s: %v", ErrNoInterfaces, spec, err)
+		}
+		if isNew {
+			created = append(created, name)
+			logf("Created VLAN interface %s (VLAN %d on %s)", name, spec.ID, spec.Parent)
+		} else {
+			logf("Using existing VLAN interface %s", name)
+		}
+	}
+	return cleanup, nil
+}
+
+// vlanInterfaces indexes the VLAN sub-interfaces among interfaces by name.
+func vlanInterfaces(interfaces []utils.InterfaceInfo) map[string]utils.InterfaceInfo {
+	vlans := map[string]utils.InterfaceInfo{}
+	for _, iface := range interfaces {
+		if iface.VLANID > 0 {
+			vlans[iface.Name] = iface
+		}
+	}
+	return vlans
+}
+
+// tagVLAN records the VLAN of the sub-interface record was found on as
+// vlan_id and vlan_parent metadata.
+func tagVLAN(record *HostRecord, vlans map[string]utils.InterfaceInfo) {
+	iface, ok := vlans[record.InterfaceName]
+	if !ok {
+		return
+	}
+	if record.Metadata == nil {
+		record.Metadata = map[string]any{}
+	}
+	record.Metadata["vlan_id"] = iface.VLANID
+	record.Metadata["vlan_parent"] = iface.Parent
+}
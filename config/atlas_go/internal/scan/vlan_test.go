@@ -0,0 +1,75 @@
+package scan
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"atlas/internal/utils"
+)
+
+func TestParseVLANSpec(t *testing.T) {
+	spec, err := ParseVLANSpec("20@eth0=10.0.20.5/24")
+	if err != nil || spec != (VLANSpec{ID: 20, Parent: "eth0", Address: "10.0.20.5/24"}) {
+		t.Fatalf("got %+v, %v", spec, err)
+	}
+	if spec, err := ParseVLANSpec(" 7@bond0=10.0.7.2/24 "); err != nil || spec.ID != 7 || spec.Parent != "bond0" || spec.String() != "7@bond0=10.0.7.2/24" {
+		t.Fatalf("got %+v, %v", spec, err)
+	}
+	// Without an address the sub-interface would need DHCP, which is not
+	// offered.
+	for _, bad := range []string{"20", "eth0.20", "0@eth0", "4095@eth0", "20@", "20@eth0", "20@eth0=10.0.20.5"} {
+		if _, err := ParseVLANSpec(bad); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+	if got := utils.VLANInterfaceName("enp0s31f6", 20); got != "enp0s31f6.20" {
+		t.Errorf("unexpected name %q", got)
+	}
+	if got := utils.VLANInterfaceName("enx00e04c680001", 20); got != "vlan20" {
+		t.Errorf("long parent should fall back to vlan<id>, got %q", got)
+	}
+
+	vlans := vlanInterfaces([]utils.InterfaceInfo{
+		{Name: "eth0", Subnet: "192.168.1.0/24"},
+		{Name: "eth0.20", Subnet: "10.0.20.0/24", VLANID: 20, Parent: "eth0"},
+	})
+	onVLAN := HostRecord{IP: "10.0.20.7", InterfaceName: "eth0.20"}
+	tagVLAN(&onVLAN, vlans)
+	if onVLAN.Metadata["vlan_id"] != 20 || onVLAN.Metadata["vlan_parent"] != "eth0" {
+		t.Fatalf("VLAN host not tagged: %v", onVLAN.Metadata)
+	}
+	untagged := HostRecord{IP: "192.168.1.7", InterfaceName: "eth0"}
+	tagVLAN(&untagged, vlans)
+	if untagged.Metadata != nil {
+		t.Fatalf("untagged host got VLAN metadata: %v", untagged.Metadata)
+	}
+}
+
+func TestSetupVLANsNeedsNetAdmin(t *testing.T) {
+	var ran []string
+	useFakeRunner(t, func(name string, args []string) ([]byte, error) {
+		ran = append(ran, name+" "+strings.Join(args, " "))
+		return nil, nil
+	})
+	prev := currentPrivileges
+	t.Cleanup(func() { currentPrivileges = prev })
+	specs := []VLANSpec{{ID: 20, Parent: "atlas-test-none0", Address: "10.0.20.5/24"}}
+
+	// Raw sockets alone do not allow creating interfaces.
+	currentPrivileges = func() scanPrivileges { return scanPrivileges{RawSockets: true} }
+	_, err := setupVLANs(specs, t.Logf)
+	if !errors.Is(err, ErrNoInterfaces) || !strings.Contains(err.Error(), "CAP_NET_ADMIN") {
+		t.Fatalf("expected a CAP_NET_ADMIN error, got %v", err)
+	}
+
+	// NET_ADMIN without NET_RAW gets as far as looking up the parent.
+	currentPrivileges = func() scanPrivileges { return scanPrivileges{NetAdmin: true} }
+	_, err = setupVLANs(specs, t.Logf)
+	if err == nil || !strings.Contains(err.Error(), "parent interface atlas-test-none0") {
+		t.Fatalf("expected the missing parent to fail setup, got %v", err)
+	}
+	if len(ran) != 0 {
+		t.Fatalf("ran %v before the parent was found", ran)
+	}
+}
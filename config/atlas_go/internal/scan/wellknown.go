@@ -0,0 +1,113 @@
+package scan
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// wellKnownPath is where a controller advertises its API layout.
+const wellKnownPath = "/.well-known/atlas"
+
+// ControllerDiscovery is the /.well-known/atlas document. Every field is
+// optional; missing values fall back to the built-in defaults.
+type ControllerDiscovery struct {
+	// APIBase replaces ControllerURL as the base for API calls.
+	APIBase string `json:"api_base"`
+	// IngestPath is a template such as "sites/{site}/agents/{agent}/ingest".
+	IngestPath    string `json:"ingest_path"`
+	SchemaVersion string `json:"schema_version"`
+	Capabilities  struct {
+		Compression []string `json:"compression"`
+		// Formats lists accepted payload encodings besides JSON, e.g. "msgpack".
+		Formats      []string `json:"formats"`
+		MaxBatchSize int      `json:"max_batch_size"`
+	} `json:"capabilities"`
+}
+
+// supportsCompression reports whether the controller accepts the encoding.
+func (d *ControllerDiscovery) supportsCompression(encoding string) bool {
+	if d == nil {
+		return false
+	}
+	for _, c := range d.Capabilities.Compression {
+		if strings.EqualFold(c, encoding) {
+			return true
+		}
+	}
+	return false
+}
+
+// supportsFormat reports whether the controller accepts the payload format.
+// JSON is always accepted.
+func (d *ControllerDiscovery) supportsFormat(format string) bool {
+	if format == "" || format == PayloadJSON {
+		return true
+	}
+	if d == nil {
+		return false
+	}
+	for _, f := range d.Capabilities.Formats {
+		if strings.EqualFold(f, format) {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *ControllerDiscovery) maxBatchSize() int {
+	if d == nil {
+		return 0
+	}
+	return d.Capabilities.MaxBatchSize
+}
+
+// Discover fetches /.well-known/atlas from the controller host and caches it
+// on rc. Controllers without the document (or unreachable ones) get an empty
+// discovery so the hardcoded ingest path is used and the lookup is not
+// repeated.
+func (rc *RemoteConfig) Discover() {
+	if rc.Discovery != nil || rc.ControllerURL == "" {
+		return
+	}
+	rc.Discovery = &ControllerDiscovery{}
+	base, err := url.Parse(rc.ControllerURL)
+	if err != nil || base.Host == "" {
+		return
+	}
+	wellKnown := url.URL{Scheme: base.Scheme, Host: base.Host, Path: wellKnownPath}
+	client := &http.Client{Timeout: 10 * time.Second}
+	if rc.HTTPClient != nil {
+		client = rc.HTTPClient
+	}
+	req, err := http.NewRequest(http.MethodGet, wellKnown.String(), nil)
+	if err != nil {
+		return
+	}
+	rc.applyHeaders(req)
+	resp, err := client.Do(req)
+	if err != nil {
+		fmt.Printf("[remote] controller discovery unavailable (%v); using default ingest path\n", err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		fmt.Printf("[remote] no %s on controller (%s); using default ingest path\n", wellKnownPath, resp.Status)
+		return
+	}
+	var doc ControllerDiscovery
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&doc); err != nil {
+		fmt.Printf("[remote] ignoring malformed %s: %v\n", wellKnownPath, err)
+		return
+	}
+	rc.Discovery = &doc
+	if apiBase, err := url.Parse(doc.APIBase); err == nil && doc.APIBase != "" && !rc.sameOrigin(apiBase) && rc.bearerToken() != "" {
+		fmt.Printf("[remote] api_base %s is not on the controller's origin; the token will not be sent there\n", doc.APIBase)
+	}
+	fmt.Printf("[remote] discovered controller api_base=%q schema=%q compression=%v max_batch=%d\n",
+		doc.APIBase, doc.SchemaVersion, doc.Capabilities.Compression, doc.Capabilities.MaxBatchSize)
+}
@@ -0,0 +1,50 @@
+package scan
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEmitUsesWellKnownDiscovery(t *testing.T) {
+	var paths []string
+	var indexes []any
+	var srvURL string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == wellKnownPath {
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"api_base":     srvURL + "/v2",
+				"ingest_path":  "ingest/{site}/{agent}",
+				"capabilities": map[string]any{"max_batch_size": 1},
+			})
+			return
+		}
+		paths = append(paths, r.URL.Path)
+		var p RemotePayload
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			t.Errorf("decode body: %v", err)
+		}
+		indexes = append(indexes, p.Metadata["batch_index"])
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+	srvURL = srv.URL
+
+	payload := samplePayload()
+	payload.Hosts = append(payload.Hosts, payload.Hosts[0])
+	// A caller's own batch_index must not hide the split's.
+	payload.Metadata = map[string]any{"batch_index": 7}
+	opts := RemotePayloadOptions{Config: RemoteConfig{ControllerURL: srv.URL + "/api", SiteID: "lab", AgentID: "edge01"}}
+	if err := opts.emit(payload); err != nil {
+		t.Fatalf("emit: %v", err)
+	}
+	if strings.Join(paths, ",") != "/v2/ingest/lab/edge01,/v2/ingest/lab/edge01" {
+		t.Fatalf("expected two batched posts to the discovered path, got %v", paths)
+	}
+	if !reflect.DeepEqual(indexes, []any{float64(0), float64(1)}) {
+		t.Fatalf("batch indexes %v, want [0 1]", indexes)
+	}
+}
@@ -0,0 +1,143 @@
+package scan
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ScanWindow limits agent runs to a daily time-of-day range, such as
+// "22:00-06:00", optionally on selected weekdays only. A range whose end is
+// not after its start wraps past midnight; its weekday is the day it starts.
+type ScanWindow struct {
+	spec     string
+	start    int // minutes after local midnight
+	end      int
+	days     [7]bool // indexed by time.Weekday; all false means every day
+	location *time.Location
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// ParseScanWindow parses "[days ]HH:MM-HH:MM", where days is a comma list of
+// weekdays or weekday ranges such as "mon-fri" or "sat,sun". The times are
+// read in tz (an IANA name such as "Europe/Berlin"), or the local zone when
+// tz is empty. An empty spec returns a nil window, which allows every run.
+func ParseScanWindow(spec, tz string) (*ScanWindow, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, nil
+	}
+	w := &ScanWindow{spec: spec, location: time.Local}
+	if tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			return nil, fmt.Errorf("--timezone: %w", err)
+		}
+		w.location = loc
+	}
+	fields := strings.Fields(spec)
+	switch len(fields) {
+	case 1:
+	case 2:
+		if err := w.parseDays(fields[0]); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("--scan-window %q: want [days ]HH:MM-HH:MM", spec)
+	}
+	from, to, ok := strings.Cut(fields[len(fields)-1], "-")
+	if !ok {
+		return nil, fmt.Errorf("--scan-window %q: want HH:MM-HH:MM", spec)
+	}
+	var err error
+	if w.start, err = parseClock(from, false); err != nil {
+		return nil, fmt.Errorf("--scan-window %q: %w", spec, err)
+	}
+	if w.end, err = parseClock(to, true); err != nil {
+		return nil, fmt.Errorf("--scan-window %q: %w", spec, err)
+	}
+	if w.start == w.end {
+		return nil, fmt.Errorf("--scan-window %q: start and end are equal (use 00:00-24:00 for the whole day)", spec)
+	}
+	return w, nil
+}
+
+func (w *ScanWindow) parseDays(s string) error {
+	for _, part := range strings.Split(strings.ToLower(s), ",") {
+		first, last, isRange := strings.Cut(part, "-")
+		from, ok := weekdayNames[first]
+		if !ok {
+			return fmt.Errorf("--scan-window: unknown weekday %q", first)
+		}
+		to := from
+		if isRange {
+			if to, ok = weekdayNames[last]; !ok {
+				return fmt.Errorf("--scan-window: unknown weekday %q", last)
+			}
+		}
+		for d := from; ; d = (d + 1) % 7 {
+			w.days[d] = true
+			if d == to {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// parseClock reads HH:MM as minutes after midnight. 24:00 is only accepted
+// as an end time.
+func parseClock(s string, end bool) (int, error) {
+	var h, m int
+	if _, err := fmt.Sscanf(s, "%d:%d", &h, &m); err != nil || len(s) != 5 {
+		return 0, fmt.Errorf("invalid time %q (want HH:MM)", s)
+	}
+	if end && h == 24 && m == 0 {
+		return 24 * 60, nil
+	}
+	if h < 0 || h > 23 || m < 0 || m > 59 {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return h*60 + m, nil
+}
+
+func (w *ScanWindow) String() string {
+	return fmt.Sprintf("%s (%s)", w.spec, w.location)
+}
+
+func (w *ScanWindow) dayAllowed(d time.Weekday) bool {
+	return w.days == [7]bool{} || w.days[d]
+}
+
+// Contains reports whether t falls inside the window. A nil window contains
+// every time.
+func (w *ScanWindow) Contains(t time.Time) bool {
+	if w == nil {
+		return true
+	}
+	t = t.In(w.location)
+	minute := t.Hour()*60 + t.Minute()
+	day := t.Weekday()
+	if w.start < w.end {
+		return w.dayAllowed(day) && minute >= w.start && minute < w.end
+	}
+	// Wrapped: the evening part belongs to today's window, the morning part
+	// to the one that started yesterday.
+	return (minute >= w.start && w.dayAllowed(day)) || (minute < w.end && w.dayAllowed((day+6)%7))
+}
+
+// Next returns the next time after t at which the window opens.
+func (w *ScanWindow) Next(t time.Time) time.Time {
+	local := t.In(w.location)
+	for i := 0; i <= 7; i++ {
+		opens := time.Date(local.Year(), local.Month(), local.Day()+i, w.start/60, w.start%60, 0, 0, w.location)
+		if opens.After(t) && w.dayAllowed(opens.Weekday()) {
+			return opens
+		}
+	}
+	return t
+}
@@ -0,0 +1,56 @@
+package scan
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScanWindow(t *testing.T) {
+	w, err := ParseScanWindow("mon-fri 22:00-06:00", "UTC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(day, clock string) time.Time {
+		ts, err := time.Parse("2006-01-02 15:04", day+" "+clock)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ts
+	}
+	// 2024-06-07 is a Friday.
+	cases := []struct {
+		day, clock string
+		want       bool
+	}{
+		{"2024-06-07", "21:59", false},
+		{"2024-06-07", "22:00", true},
+		{"2024-06-08", "05:59", true}, // Saturday morning, still Friday's window
+		{"2024-06-08", "23:00", false},
+		{"2024-06-10", "03:00", false}, // Monday morning belongs to Sunday
+		{"2024-06-10", "12:00", false},
+	}
+	for _, c := range cases {
+		if got := w.Contains(at(c.day, c.clock)); got != c.want {
+			t.Errorf("Contains(%s %s) = %v, want %v", c.day, c.clock, got, c.want)
+		}
+	}
+	if next := w.Next(at("2024-06-08", "07:00")); !next.Equal(at("2024-06-10", "22:00")) {
+		t.Fatalf("Next after Saturday = %s, want Monday 22:00", next)
+	}
+
+	berlin, err := ParseScanWindow("08:00-09:00", "Europe/Berlin")
+	if err != nil {
+		t.Skipf("no tzdata: %v", err)
+	}
+	if !berlin.Contains(at("2024-01-15", "07:30")) || berlin.Contains(at("2024-01-15", "08:30")) {
+		t.Fatal("window times should be read in the configured zone")
+	}
+	for _, bad := range []string{"22:00", "25:00-01:00", "funday 01:00-02:00", "01:00-01:00"} {
+		if _, err := ParseScanWindow(bad, ""); err == nil {
+			t.Errorf("ParseScanWindow(%q) should fail", bad)
+		}
+	}
+	if w, err := ParseScanWindow("", ""); err != nil || !w.Contains(time.Now()) {
+		t.Fatal("an empty window should allow every run")
+	}
+}
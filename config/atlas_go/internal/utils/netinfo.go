@@ -0,0 +1,58 @@
+package utils
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// InterfaceDetails describes the agent's own side of a scanned interface so
+// the controller knows the vantage point a site was scanned from.
+type InterfaceDetails struct {
+	Name   string `json:"name"`
+	IP     string `json:"ip,omitempty"`
+	Subnet string `json:"subnet,omitempty"`
+	MAC    string `json:"mac,omitempty"`
+	MTU    int    `json:"mtu,omitempty"`
+	// SpeedMbps is omitted when the kernel does not report a link speed,
+	// as for wireless, virtual, and down interfaces.
+	SpeedMbps int `json:"speed_mbps,omitempty"`
+	// VLANID and VLANParent describe 802.1Q sub-interfaces.
+	VLANID     int    `json:"vlan_id,omitempty"`
+	VLANParent string `json:"vlan_parent,omitempty"`
+}
+
+// sysClassNet is the sysfs directory link speeds are read from.
+var sysClassNet = "/sys/class/net"
+
+// DescribeInterfaces adds MAC, MTU, and link speed to each interface.
+// Details the system does not expose are left empty.
+func DescribeInterfaces(interfaces []InterfaceInfo) []InterfaceDetails {
+	details := make([]InterfaceDetails, 0, len(interfaces))
+	for _, iface := range interfaces {
+		d := InterfaceDetails{Name: iface.Name, IP: iface.IP, Subnet: iface.Subnet, SpeedMbps: linkSpeed(iface.Name), VLANID: iface.VLANID, VLANParent: iface.Parent}
+		if nif, err := net.InterfaceByName(iface.Name); err == nil {
+			d.MAC = nif.HardwareAddr.String()
+			d.MTU = nif.MTU
+		}
+		details = append(details, d)
+	}
+	return details
+}
+
+// linkSpeed reads /sys/class/net/<name>/speed in Mbit/s. Reading it fails
+// with EINVAL for links that are down, and virtual links report -1; both
+// yield 0.
+func linkSpeed(name string) int {
+	b, err := os.ReadFile(filepath.Join(sysClassNet, name, "speed"))
+	if err != nil {
+		return 0
+	}
+	speed, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil || speed < 0 {
+		return 0
+	}
+	return speed
+}
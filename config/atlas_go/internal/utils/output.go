@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"os"
+	"strings"
+)
+
+// PlainOutput swaps the emoji decorations in log lines for bracketed text
+// tags, for terminals, CI logs, and aggregators that mangle them. See
+// DetectPlainOutput for the default.
+var PlainOutput bool
+
+var plainTags = strings.NewReplacer(
+	"⚠️", "[WARN]", "⚠", "[WARN]",
+	"❌", "[ERROR]",
+	"✅", "[OK]",
+	"🚀", "[START]",
+	"🐳", "[DOCKER]",
+	"📡", "[PRESENCE]",
+	"📦", "[DB]",
+	"🩺", "[DOCTOR]",
+	"🤖", "[AGENT]",
+	"🌐", "[NET]",
+	"🔔", "[ALERT]",
+	"🎯", "[PROBE]",
+	"🧹", "[MAINTAIN]",
+	"↳", "->",
+	"→", "->",
+)
+
+// Deco returns s unchanged, or with its emoji replaced by text tags when
+// PlainOutput is set. Wrap every decorated log string with it.
+func Deco(s string) string {
+	if !PlainOutput {
+		return s
+	}
+	return plainTags.Replace(s)
+}
+
+// DetectPlainOutput picks the default for PlainOutput: ATLAS_PLAIN when set,
+// plain when NO_COLOR is set, and otherwise plain whenever stdout is not a
+// terminal (pipes, files, container logs).
+func DetectPlainOutput() bool {
+	if v, ok := os.LookupEnv("ATLAS_PLAIN"); ok {
+		v = strings.ToLower(strings.TrimSpace(v))
+		return v == "1" || v == "true" || v == "yes"
+	}
+	if _, ok := os.LookupEnv("NO_COLOR"); ok {
+		return true
+	}
+	info, err := os.Stdout.Stat()
+	return err != nil || info.Mode()&os.ModeCharDevice == 0
+}
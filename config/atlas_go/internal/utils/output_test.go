@@ -0,0 +1,26 @@
+package utils
+
+import "testing"
+
+func TestDecoPlainLeavesOnlyASCII(t *testing.T) {
+	prev := PlainOutput
+	PlainOutput = true
+	t.Cleanup(func() { PlainOutput = prev })
+
+	for _, line := range []string{
+		"⚠️ warn", "⚠ warn", "❌ failed", "✅ done", "🚀 start", "🐳 docker", "📡 presence",
+		"📦 db", "🩺 doctor", "🤖 agent", "🌐 net", "🔔 alert", "🎯 probe", "🧹 maintain",
+		"↳ hop", "1 MB → 2 MB",
+	} {
+		got := Deco(line)
+		for _, r := range got {
+			if r > 127 {
+				t.Errorf("Deco(%q) = %q keeps %q", line, got, r)
+				break
+			}
+		}
+	}
+	if got := Deco("🧹 Compacting: 3 MB → 1 MB"); got != "[MAINTAIN] Compacting: 3 MB -> 1 MB" {
+		t.Errorf("unexpected plain line %q", got)
+	}
+}
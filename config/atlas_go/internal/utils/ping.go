@@ -1,20 +1,20 @@
 package utils
 
 import (
-	"os/exec"
+	"context"
 	"strings"
 )
 
 // PingOrLocalCheck returns online if the IP is on the host or responds to ping.
 func PingHost(ip string) string {
 	// 1. Try ping
-	out, err := exec.Command("ping", "-c", "1", "-W", "1", ip).CombinedOutput()
+	out, err := RunCommand(context.Background(), "ping", "-c", "1", "-W", "1", ip)
 	if err == nil && strings.Contains(string(out), "1 received") {
 		return "online"
 	}
 
 	// 2. Try checking if IP belongs to host (for overlay/docker gateways)
-	hostIPs, err := exec.Command("hostname", "-I").Output()
+	hostIPs, err := RunCommand(context.Background(), "hostname", "-I")
 	if err == nil && strings.Contains(string(hostIPs), ip) {
 		return "online"
 	}
@@ -0,0 +1,85 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// CommandRunner spawns external tools (nmap, nbtscan, ip, docker, ...) and
+// returns their stdout. Tests swap in a fake that returns canned output so
+// parsers can be exercised without the real binaries.
+type CommandRunner interface {
+	Run(ctx context.Context, name string, args ...string) ([]byte, error)
+}
+
+// CommandError is returned when a command fails. Stderr holds what it wrote
+// there, e.g. nmap's "requires root privileges".
+type CommandError struct {
+	Name   string
+	Err    error
+	Stderr string
+}
+
+func (e *CommandError) Error() string {
+	if e.Stderr == "" {
+		return fmt.Sprintf("%s: %v", e.Name, e.Err)
+	}
+	return fmt.Sprintf("%s: %v: %s", e.Name, e.Err, e.Stderr)
+}
+
+func (e *CommandError) Unwrap() error { return e.Err }
+
+// ExecRunner runs commands with os/exec.
+type ExecRunner struct{}
+
+// Run executes name with args and returns its stdout. name is replaced by
+// its SetCommandPath override, if any. Stderr is kept out of the output so
+// warnings never reach the parsers; when the command fails it is reported
+// in the *CommandError instead.
+func (ExecRunner) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, CommandPath(name), args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return out, &CommandError{Name: name, Err: err, Stderr: strings.TrimSpace(stderr.String())}
+	}
+	return out, nil
+}
+
+// commandPaths maps a tool name to the binary run in its place.
+var commandPaths = map[string]string{}
+
+// SetCommandPath makes ExecRunner run path whenever name is requested, e.g.
+// a wrapper script or an nmap outside PATH (--nmap-path). path must be
+// executable; an empty path restores the PATH lookup.
+func SetCommandPath(name, path string) error {
+	if path == "" {
+		delete(commandPaths, name)
+		return nil
+	}
+	if _, err := exec.LookPath(path); err != nil {
+		return fmt.Errorf("%s path %q is not executable: %w", name, path, err)
+	}
+	commandPaths[name] = path
+	return nil
+}
+
+// CommandPath returns the binary ExecRunner runs for name.
+func CommandPath(name string) string {
+	if path, ok := commandPaths[name]; ok {
+		return path
+	}
+	return name
+}
+
+// Runner is the process-wide CommandRunner used by every scanner.
+var Runner CommandRunner = ExecRunner{}
+
+// RunCommand executes a command through the configured Runner.
+func RunCommand(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return Runner.Run(ctx, name, args...)
+}
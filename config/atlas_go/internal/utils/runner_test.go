@@ -0,0 +1,27 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestExecRunnerKeepsStderrOutOfOutput(t *testing.T) {
+	out, err := ExecRunner{}.Run(context.Background(), "sh", "-c", "echo result; echo warning >&2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "result\n" {
+		t.Fatalf("output %q, want only stdout", out)
+	}
+
+	_, err = ExecRunner{}.Run(context.Background(), "sh", "-c", "echo partial; echo 'requires root privileges' >&2; exit 1")
+	var cmdErr *CommandError
+	if !errors.As(err, &cmdErr) || cmdErr.Stderr != "requires root privileges" {
+		t.Fatalf("want a CommandError carrying stderr, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "requires root privileges") {
+		t.Fatalf("error %q does not mention stderr", err)
+	}
+}
@@ -1,10 +1,11 @@
 package utils
 
 import (
+	"context"
 	"fmt"
 	"net"
+	"net/netip"
 	"os"
-	"os/exec"
 	"strings"
 )
 
@@ -13,14 +14,18 @@ type InterfaceInfo struct {
 	Name   string
 	Subnet string
 	IP     string
+	// VLANID and Parent are set for 802.1Q sub-interfaces such as eth0.20.
+	VLANID int
+	Parent string
 }
 
 // GetAllInterfaces returns all non-loopback network interfaces with their subnets
 func GetAllInterfaces() ([]InterfaceInfo, error) {
 	// First, try parsing via `ip` command for portability across distros
-	out, err := exec.Command("ip", "-o", "-f", "inet", "addr", "show").Output()
+	out, err := RunCommand(context.Background(), "ip", "-o", "-f", "inet", "addr", "show")
 	var interfaces []InterfaceInfo
 	seenInterfaces := make(map[string]bool)
+	vlans := readVLANConfig()
 
 	if err == nil {
 		for _, line := range strings.Split(string(out), "\n") {
@@ -33,8 +38,9 @@ func GetAllInterfaces() ([]InterfaceInfo, error) {
 				continue
 			}
 
-			// Interface name is at index 1
-			ifName := strings.TrimSuffix(fields[1], ":")
+			// Interface name is at index 1; sub-interfaces are listed as
+			// eth0.20@eth0.
+			ifName, _, _ := strings.Cut(strings.TrimSuffix(fields[1], ":"), "@")
 
 			// Skip common virtual/bridge interfaces by name
 			if strings.HasPrefix(ifName, "docker") || strings.HasPrefix(ifName, "br-") || strings.HasPrefix(ifName, "veth") || ifName == "lo" {
@@ -73,6 +79,8 @@ func GetAllInterfaces() ([]InterfaceInfo, error) {
 							Name:   ifName,
 							Subnet: subnetBase,
 							IP:     ip,
+							VLANID: vlans[ifName].ID,
+							Parent: vlans[ifName].Parent,
 						})
 					}
 				}
@@ -122,6 +130,8 @@ func GetAllInterfaces() ([]InterfaceInfo, error) {
 						Name:   nif.Name,
 						Subnet: subnetBase,
 						IP:     ip4.String(),
+						VLANID: vlans[nif.Name].ID,
+						Parent: vlans[nif.Name].Parent,
 					})
 				}
 			}
@@ -198,3 +208,50 @@ func GetInterfaceForSubnet(subnet string) (string, error) {
 	}
 	return "", fmt.Errorf("no interface found for subnet %s", subnet)
 }
+
+// DefaultMinPrefixLen is the broadest IPv4 prefix scanned unless overridden;
+// anything wider (e.g. a /8) is almost certainly bad interface data.
+const DefaultMinPrefixLen = 16
+
+// ipv6PrefixOffset converts an IPv4 minimum prefix to the IPv6 prefix that
+// covers as many addresses, so a /16 floor allows at most a /112.
+const ipv6PrefixOffset = 128 - 32
+
+// ValidateScanSubnet checks that subnet is a parseable CIDR no broader than
+// minPrefix bits (minPrefix+96 for IPv6) and returns it normalized to its
+// network address.
+func ValidateScanSubnet(subnet string, minPrefix int) (string, error) {
+	subnet = strings.TrimSpace(subnet)
+	if subnet == "" {
+		return "", fmt.Errorf("empty subnet")
+	}
+	_, ipNet, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return "", fmt.Errorf("invalid subnet %q: %v", subnet, err)
+	}
+	ones, bits := ipNet.Mask.Size()
+	if bits == 128 {
+		minPrefix += ipv6PrefixOffset
+	}
+	if ones < minPrefix {
+		return "", fmt.Errorf("subnet %s is broader than /%d", ipNet, minPrefix)
+	}
+	return ipNet.String(), nil
+}
+
+// CompareIP orders addresses numerically, IPv4 before IPv6, returning -1, 0,
+// or +1. IPv4-mapped IPv6 addresses compare as IPv4. Strings that are not IPs
+// sort after every valid address, lexically among themselves.
+func CompareIP(a, b string) int {
+	ipA, errA := netip.ParseAddr(a)
+	ipB, errB := netip.ParseAddr(b)
+	switch {
+	case errA != nil && errB != nil:
+		return strings.Compare(a, b)
+	case errA != nil:
+		return 1
+	case errB != nil:
+		return -1
+	}
+	return ipA.Unmap().Compare(ipB.Unmap())
+}
@@ -0,0 +1,30 @@
+package utils
+
+import "testing"
+
+func TestValidateScanSubnet(t *testing.T) {
+	tests := []struct {
+		subnet    string
+		minPrefix int
+		want      string
+		wantErr   bool
+	}{
+		{subnet: "192.168.1.17/24", minPrefix: 16, want: "192.168.1.0/24"},
+		{subnet: " 10.0.0.0/16 ", minPrefix: 16, want: "10.0.0.0/16"},
+		{subnet: "10.0.0.0/8", minPrefix: 16, wantErr: true},
+		{subnet: "10.0.0.0/8", minPrefix: 8, want: "10.0.0.0/8"},
+		{subnet: "2001:db8::1/120", minPrefix: 16, want: "2001:db8::/120"},
+		{subnet: "fd00::/112", minPrefix: 16, want: "fd00::/112"},
+		{subnet: "fd00::/64", minPrefix: 16, wantErr: true},
+		{subnet: "fd00::/104", minPrefix: 8, want: "fd00::/104"},
+		{subnet: "fd00::/103", minPrefix: 8, wantErr: true},
+		{subnet: "", minPrefix: 16, wantErr: true},
+		{subnet: "192.168.1.0", minPrefix: 16, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ValidateScanSubnet(tt.subnet, tt.minPrefix)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ValidateScanSubnet(%q, %d) = %q, %v", tt.subnet, tt.minPrefix, got, err)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package utils
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// procVLANConfig lists the kernel's 802.1Q sub-interfaces as
+// "name | id | parent" rows. It exists once the 8021q module is loaded.
+var procVLANConfig = "/proc/net/vlan/config"
+
+// vlanLink is one row of procVLANConfig.
+type vlanLink struct {
+	ID     int
+	Parent string
+}
+
+// readVLANConfig maps sub-interface names to their VLAN. A missing file
+// means no VLAN interfaces exist and yields an empty map.
+func readVLANConfig() map[string]vlanLink {
+	links := map[string]vlanLink{}
+	f, err := os.Open(procVLANConfig)
+	if err != nil {
+		return links
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// The header lines ("VLAN Dev name | VLAN ID", "Name-Type: ...")
+		// do not have a numeric second column.
+		cols := strings.Split(scanner.Text(), "|")
+		if len(cols) != 3 {
+			continue
+		}
+		id, err := strconv.Atoi(strings.TrimSpace(cols[1]))
+		if err != nil {
+			continue
+		}
+		links[strings.TrimSpace(cols[0])] = vlanLink{ID: id, Parent: strings.TrimSpace(cols[2])}
+	}
+	return links
+}
+
+// VLANInterfaceName is the name CreateVLAN gives VLAN id on parent, e.g.
+// eth0.20. Names longer than the kernel's 15 bytes fall back to vlan<id>.
+func VLANInterfaceName(parent string, id int) string {
+	name := fmt.Sprintf("%s.%d", parent, id)
+	if len(name) > 15 {
+		name = fmt.Sprintf("vlan%d", id)
+	}
+	return name
+}
+
+// CreateVLAN adds a tagged sub-interface for VLAN id on parent and brings it
+// up with address (CIDR notation). There is deliberately no DHCP: a lease
+// would also install its default route and resolv.conf, taking over the
+// agent's own networking. It needs CAP_NET_ADMIN. created is false when the
+// sub-interface already existed, in which case it is used as is.
+func CreateVLAN(ctx context.Context, parent string, id int, address string) (name string, created bool, err error) {
+	name = VLANInterfaceName(parent, id)
+	if _, err := net.InterfaceByName(name); err == nil {
+		return name, false, nil
+	}
+	if address == "" {
+		return name, false, fmt.Errorf("vlan %d: an address is required", id)
+	}
+	if _, err := net.InterfaceByName(parent); err != nil {
+		return name, false, fmt.Errorf("vlan %d: parent interface %s: %w", id, parent, err)
+	}
+	if _, err := RunCommand(ctx, "ip", "link", "add", "link", parent, "name", name, "type", "vlan", "id", strconv.Itoa(id)); err != nil {
+		return name, false, fmt.Errorf("create %s: %w", name, err)
+	}
+	fail := func(step string, err error) (string, bool, error) {
+		_ = DeleteVLAN(context.Background(), name)
+		return name, false, fmt.Errorf("%s %s: %w", step, name, err)
+	}
+	if _, err := RunCommand(ctx, "ip", "link", "set", name, "up"); err != nil {
+		return fail("bring up", err)
+	}
+	if _, err := RunCommand(ctx, "ip", "addr", "add", address, "dev", name); err != nil {
+		return fail("address", err)
+	}
+	return name, true, nil
+}
+
+// DeleteVLAN removes a sub-interface made by CreateVLAN.
+func DeleteVLAN(ctx context.Context, name string) error {
+	if _, err := RunCommand(ctx, "ip", "link", "delete", name); err != nil {
+		return fmt.Errorf("delete %s: %w", name, err)
+	}
+	return nil
+}
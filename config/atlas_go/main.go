@@ -1,101 +1,398 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"net/http"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"atlas/internal/db"
 	"atlas/internal/scan"
+	"atlas/internal/utils"
 )
 
+// Exit codes let cron jobs and CI wrappers tell failures apart.
+const (
+	exitOK      = 0
+	exitUsage   = 1 // bad command or flags
+	exitScan    = 2 // the scan itself failed
+	exitRemote  = 3 // scanned, but the controller did not accept the payload
+	exitPartial = 4 // results were emitted, but --max-duration cut the run short
+)
+
+// exitCode maps an error returned by the scan package to an exit code.
+func exitCode(err error) int {
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, scan.ErrPartialScan):
+		return exitPartial
+	case errors.Is(err, scan.ErrRemoteIngest):
+		return exitRemote
+	default:
+		return exitScan
+	}
+}
+
+// fatal logs err with format and exits with code. A --help request is not an
+// error and exits 0.
+func fatal(code int, format string, err error) {
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(exitOK)
+	}
+	log.Printf(format, err)
+	os.Exit(code)
+}
+
 func main() {
-	if len(os.Args) < 2 {
+	utils.PlainOutput = utils.DetectPlainOutput()
+	argv := stripPlainFlag(os.Args[1:])
+	if len(argv) < 1 {
 		printUsage()
-		os.Exit(1)
+		os.Exit(exitUsage)
 	}
 
-	cmd := os.Args[1]
-	args := os.Args[2:]
+	cmd := argv[0]
+	args := argv[1:]
 	switch cmd {
 	case "fastscan":
-		fmt.Println("🚀 Running fast scan...")
 		opts, err := parseFastScanOptions(args)
 		if err != nil {
-			log.Fatalf("❌ Fast scan flag error: %v", err)
+			fatal(exitUsage, utils.Deco("❌ Fast scan flag error: %v"), err)
 		}
+		fmt.Println(utils.Deco("🚀 Running fast scan..."))
 		if err := scan.FastScan(opts); err != nil {
-			log.Fatalf("❌ Fast scan failed: %v", err)
+			fatal(exitCode(err), utils.Deco("❌ Fast scan failed: %v"), err)
 		}
-		fmt.Println("✅ Fast scan complete.")
+		fmt.Println(utils.Deco("✅ Fast scan complete."))
 	case "dockerscan":
-		fmt.Println("🐳 Running Docker scan...")
 		opts, err := parseDockerScanOptions(args)
 		if err != nil {
-			log.Fatalf("❌ Docker scan flag error: %v", err)
+			fatal(exitUsage, utils.Deco("❌ Docker scan flag error: %v"), err)
 		}
+		fmt.Println(utils.Deco("🐳 Running Docker scan..."))
 		if err := scan.DockerScan(opts); err != nil {
-			log.Fatalf("❌ Docker scan failed: %v", err)
+			fatal(exitCode(err), utils.Deco("❌ Docker scan failed: %v"), err)
 		}
-		fmt.Println("✅ Docker scan complete.")
+		fmt.Println(utils.Deco("✅ Docker scan complete."))
 	case "deepscan":
-		fmt.Println("🚀 Running deep scan...")
-		if err := scan.DeepScan(scan.DeepScanOptions{}); err != nil {
-			log.Fatalf("❌ Deep scan failed: %v", err)
+		opts, err := parseDeepScanOptions(args)
+		if err != nil {
+			fatal(exitUsage, utils.Deco("❌ Deep scan flag error: %v"), err)
+		}
+		fmt.Println(utils.Deco("🚀 Running deep scan..."))
+		if err := scan.DeepScan(opts); err != nil {
+			if errors.Is(err, scan.ErrPartialScan) {
+				fatal(exitPartial, utils.Deco("⚠️ Deep scan incomplete: %v"), err)
+			}
+			fatal(exitCode(err), utils.Deco("❌ Deep scan failed: %v"), err)
+		}
+		fmt.Println(utils.Deco("✅ Deep scan complete."))
+	case "probesweep":
+		opts, err := parseProbeSweepOptions(args)
+		if err != nil {
+			fatal(exitUsage, utils.Deco("❌ Probe sweep flag error: %v"), err)
+		}
+		fmt.Printf(utils.Deco("🎯 Probing port %d on every live host...\n"), opts.ProbePort)
+		if err := scan.DeepScan(opts); err != nil {
+			if errors.Is(err, scan.ErrPartialScan) {
+				fatal(exitPartial, utils.Deco("⚠️ Probe sweep incomplete: %v"), err)
+			}
+			fatal(exitCode(err), utils.Deco("❌ Probe sweep failed: %v"), err)
+		}
+		fmt.Println(utils.Deco("✅ Probe sweep complete."))
+	case "presencescan":
+		opts, err := parsePresenceScanOptions(args)
+		if err != nil {
+			fatal(exitUsage, utils.Deco("❌ Presence scan flag error: %v"), err)
+		}
+		fmt.Println(utils.Deco("📡 Running presence scan..."))
+		if err := scan.PresenceScan(opts); err != nil {
+			fatal(exitCode(err), utils.Deco("❌ Presence scan failed: %v"), err)
 		}
-		fmt.Println("✅ Deep scan complete.")
+		fmt.Println(utils.Deco("✅ Presence scan complete."))
 	case "initdb":
-		fmt.Println("📦 Initializing database...")
+		fmt.Println(utils.Deco("📦 Initializing database..."))
 		if err := db.InitDB(); err != nil {
-			log.Fatalf("❌ DB init failed: %v", err)
+			fatal(exitCode(err), utils.Deco("❌ DB init failed: %v"), err)
+		}
+		fmt.Println(utils.Deco("✅ Database initialized."))
+	case "maintain":
+		fmt.Println(utils.Deco("🧹 Compacting database (VACUUM, ANALYZE, WAL checkpoint)..."))
+		report, err := db.Maintain()
+		if err != nil {
+			fatal(exitCode(err), utils.Deco("❌ DB maintenance failed: %v"), err)
+		}
+		if report.WALBusy {
+			fmt.Println(utils.Deco("⚠️ Another process held the database open; the WAL was not fully truncated. Rerun while no scan is running."))
+		}
+		fmt.Printf(utils.Deco("✅ Database maintained in %s: %s → %s (reclaimed %s).\n"),
+			report.Duration.Round(time.Millisecond), formatBytes(report.SizeBefore), formatBytes(report.SizeAfter), formatBytes(report.Reclaimed()))
+	case "doctor":
+		remoteOpts, err := parseDoctorOptions(args)
+		if err != nil {
+			fatal(exitUsage, utils.Deco("❌ Doctor flag error: %v"), err)
+		}
+		fmt.Println(utils.Deco("🩺 Checking the scanning environment..."))
+		if err := scan.Doctor(os.Stdout, remoteOpts.Config); err != nil {
+			fatal(exitCode(err), utils.Deco("❌ Doctor: %v"), err)
+		}
+		fmt.Println(utils.Deco("✅ Environment looks good."))
+	case "compare":
+		opts, err := parseCompareOptions(args)
+		if err != nil {
+			fatal(exitUsage, utils.Deco("❌ Compare flag error: %v"), err)
+		}
+		if err := scan.Compare(os.Stdout, opts); err != nil {
+			fatal(exitUsage, utils.Deco("❌ Compare failed: %v"), err)
 		}
-		fmt.Println("✅ Database initialized.")
 	case "agent":
-		fmt.Println("🤖 Starting remote agent...")
 		cfg, err := parseAgentConfig(args)
 		if err != nil {
-			log.Fatalf("❌ Agent flag error: %v", err)
+			fatal(exitUsage, utils.Deco("❌ Agent flag error: %v"), err)
 		}
+		fmt.Println(utils.Deco("🤖 Starting remote agent..."))
 		if err := scan.RunRemoteAgent(cfg); err != nil {
-			log.Fatalf("❌ Agent failed: %v", err)
+			fatal(exitCode(err), utils.Deco("❌ Agent failed: %v"), err)
 		}
 	default:
 		printUsage()
-		os.Exit(1)
+		os.Exit(exitUsage)
+	}
+}
+
+// stripPlainFlag removes --plain / --no-color (optionally =true or =false)
+// from anywhere in args and applies it to utils.PlainOutput. It covers every
+// command, so it isn't part of any one FlagSet.
+func stripPlainFlag(args []string) []string {
+	out := args[:0:0]
+	for _, a := range args {
+		name, value, hasValue := strings.Cut(strings.TrimLeft(a, "-"), "=")
+		if strings.HasPrefix(a, "-") && (name == "plain" || name == "no-color") {
+			utils.PlainOutput = true
+			if hasValue {
+				utils.PlainOutput, _ = strconv.ParseBool(value)
+			}
+			continue
+		}
+		out = append(out, a)
 	}
+	return out
 }
 
 func printUsage() {
-	fmt.Println("Usage: atlas <command> [flags]")
-	fmt.Println("Commands: fastscan, dockerscan, deepscan, initdb, agent")
+	fmt.Println("Usage: atlas [--plain] <command> [flags]")
+	fmt.Println("Commands: fastscan, dockerscan, deepscan, probesweep, presencescan, initdb, maintain, agent, doctor, compare")
+}
+
+// formatBytes renders n in KiB or MiB with one decimal, or in bytes below
+// 1 KiB.
+func formatBytes(n int64) string {
+	abs := n
+	if abs < 0 {
+		abs = -abs
+	}
+	switch {
+	case abs >= 1<<20:
+		return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+	case abs >= 1<<10:
+		return fmt.Sprintf("%.1f KiB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
 }
 
 func parseFastScanOptions(args []string) (scan.FastScanOptions, error) {
-	fs := flag.NewFlagSet("fastscan", flag.ExitOnError)
-	skipDB := fs.Bool("skip-db", false, "skip writing hosts to SQLite (implied when --remote or --json is set)")
+	fs := flag.NewFlagSet("fastscan", flag.ContinueOnError)
+	skipDB := fs.Bool("skip-db", false, skipDBUsage)
+	var minPrefix *int
+	var offlineTTL *time.Duration
+	var namePolicy *string
+	var alertWebhook, alertWebhookFile *string
+	var includeReserved, allowPublic *bool
+	primary := &stringListFlag{}
+	envErr := withEnvDefaults(fs, func() {
+		minPrefix = fs.Int("min-prefix", utils.DefaultMinPrefixLen, "skip interface subnets broader than this IPv4 prefix length (96 bits more for IPv6)")
+		includeReserved = fs.Bool("include-reserved", false, includeReservedUsage)
+		allowPublic = fs.Bool("allow-public", false, allowPublicUsage)
+		offlineTTL = durationVar(fs, "offline-ttl", 0, "keep hosts that were not re-seen online until missing this long (e.g. 30m)")
+		namePolicy = fs.String("name-policy", string(scan.NamePreferNew), namePolicyUsage)
+		alertWebhook = fs.String("alert-webhook", "", alertWebhookUsage)
+		alertWebhookFile = fs.String("alert-webhook-file", "", webhookFileUsage)
+		fs.Var(primary, "primary-interface", primaryUsage)
+	})
 	remoteFlags := bindRemoteFlags(fs)
-	if err := fs.Parse(args); err != nil {
+	tools := bindToolFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return scan.FastScanOptions{}, err
+	}
+	if envErr != nil {
+		return scan.FastScanOptions{}, envErr
+	}
+	if err := tools.apply(); err != nil {
 		return scan.FastScanOptions{}, err
 	}
 	remoteOpts, err := remoteFlags.options()
 	if err != nil {
 		return scan.FastScanOptions{}, err
 	}
-	skip := *skipDB
-	if !skip && (remoteOpts.PrintJSON || remoteOpts.Config.Enabled()) {
-		skip = true
+	policy, err := scan.ParseNamePolicy(*namePolicy)
+	if err != nil {
+		return scan.FastScanOptions{}, err
 	}
-	return scan.FastScanOptions{SkipDB: skip, Remote: remoteOpts}, nil
+	webhook, err := secretOrFile("alert-webhook", *alertWebhook, *alertWebhookFile)
+	if err != nil {
+		return scan.FastScanOptions{}, err
+	}
+	return scan.FastScanOptions{SkipDB: *skipDB, Remote: remoteOpts, MinPrefixLen: *minPrefix, PrimaryInterfaces: *primary,
+		OfflineTTL: *offlineTTL, NamePolicy: policy, AlertWebhook: webhook, IncludeReserved: *includeReserved, AllowPublic: *allowPublic}, nil
+}
+
+func parsePresenceScanOptions(args []string) (scan.PresenceScanOptions, error) {
+	fs := flag.NewFlagSet("presencescan", flag.ContinueOnError)
+	skipDB := fs.Bool("skip-db", false, "skip updating host presence in SQLite")
+	var noResolve *bool
+	var minPrefix *int
+	var discovery *string
+	var includeReserved *bool
+	var allowPublic *bool
+	var liveness *string
+	primary := &stringListFlag{}
+	envErr := withEnvDefaults(fs, func() {
+		noResolve = fs.Bool("no-resolve", false, "skip reverse DNS during discovery (nmap -n)")
+		minPrefix = fs.Int("min-prefix", utils.DefaultMinPrefixLen, "skip interface subnets broader than this IPv4 prefix length (96 bits more for IPv6)")
+		discovery = fs.String("discovery", scan.DiscoveryNmap, "host source: nmap (ping sweep) or neighbors (kernel ARP/NDP cache, no probes; falls back to nmap when empty)")
+		includeReserved = fs.Bool("include-reserved", false, includeReservedUsage)
+		allowPublic = fs.Bool("allow-public", false, allowPublicUsage)
+		liveness = fs.String("liveness", scan.LivenessAny, "how online status is decided: any (ping or an ARP/NDP entry) or ping")
+		fs.Var(primary, "primary-interface", primaryUsage)
+	})
+	remoteFlags := bindRemoteFlags(fs)
+	tools := bindToolFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return scan.PresenceScanOptions{}, err
+	}
+	if envErr != nil {
+		return scan.PresenceScanOptions{}, envErr
+	}
+	if err := tools.apply(); err != nil {
+		return scan.PresenceScanOptions{}, err
+	}
+	if err := scan.ValidateDiscoverySource(*discovery); err != nil {
+		return scan.PresenceScanOptions{}, err
+	}
+	if err := scan.ValidateLiveness(*liveness); err != nil {
+		return scan.PresenceScanOptions{}, err
+	}
+	if *liveness == scan.LivenessPorts {
+		return scan.PresenceScanOptions{}, fmt.Errorf("--liveness ports needs a port scan; presencescan accepts any or ping")
+	}
+	remoteOpts, err := remoteFlags.options()
+	if err != nil {
+		return scan.PresenceScanOptions{}, err
+	}
+	return scan.PresenceScanOptions{
+		SkipDB:            *skipDB,
+		NoResolve:         *noResolve,
+		Remote:            remoteOpts,
+		MinPrefixLen:      *minPrefix,
+		Discovery:         *discovery,
+		PrimaryInterfaces: *primary,
+		IncludeReserved:   *includeReserved,
+		AllowPublic:       *allowPublic,
+		Liveness:          *liveness,
+	}, nil
+}
+
+func parseDoctorOptions(args []string) (scan.RemotePayloadOptions, error) {
+	fs := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	remoteFlags := bindRemoteFlags(fs)
+	tools := bindToolFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return scan.RemotePayloadOptions{}, err
+	}
+	if err := tools.apply(); err != nil {
+		return scan.RemotePayloadOptions{}, err
+	}
+	return remoteFlags.options()
+}
+
+func parseCompareOptions(args []string) (scan.CompareOptions, error) {
+	fs := flag.NewFlagSet("compare", flag.ContinueOnError)
+	before := fs.String("compare-to", "", "earlier payload or JSONL/jsonl-gzip archive to diff against")
+	asJSON := fs.Bool("json", false, "print the diff as JSON instead of a table")
+	if err := parseFlags(fs, args); err != nil {
+		return scan.CompareOptions{}, err
+	}
+	if *before == "" || fs.NArg() != 1 {
+		return scan.CompareOptions{}, fmt.Errorf("usage: atlas compare --compare-to <before> [--json] <after>")
+	}
+	return scan.CompareOptions{Before: *before, After: fs.Arg(0), JSON: *asJSON}, nil
+}
+
+func parseDeepScanOptions(args []string) (scan.DeepScanOptions, error) {
+	fs := flag.NewFlagSet("deepscan", flag.ContinueOnError)
+	skipDB := fs.Bool("skip-db", false, skipDBUsage)
+	scanFlags := bindScanFlags(fs)
+	remoteFlags := bindRemoteFlags(fs)
+	tools := bindToolFlags(fs)
+	replay := fs.String("replay", "", "run the scan pipeline on hosts from a captured nmap -oG log instead of the network")
+	if err := parseFlags(fs, args); err != nil {
+		return scan.DeepScanOptions{}, err
+	}
+	if err := tools.apply(); err != nil {
+		return scan.DeepScanOptions{}, err
+	}
+	opts, err := scanFlags.options()
+	if err != nil {
+		return opts, err
+	}
+	if opts.Remote, err = remoteFlags.options(); err != nil {
+		return opts, err
+	}
+	opts.SkipDB = *skipDB
+	opts.Replay = *replay
+	return opts, nil
+}
+
+// parseProbeSweepOptions takes the deep scan flags, so discovery and targeting
+// work the same, plus the output flags; --port replaces the profile's port
+// scan settings.
+func parseProbeSweepOptions(args []string) (scan.DeepScanOptions, error) {
+	fs := flag.NewFlagSet("probesweep", flag.ContinueOnError)
+	port := fs.Int("port", 0, "the one TCP port to probe on every live host, e.g. 445 (required)")
+	skipDB := fs.Bool("skip-db", false, skipDBUsage)
+	scanFlags := bindScanFlags(fs)
+	remoteFlags := bindRemoteFlags(fs)
+	tools := bindToolFlags(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return scan.DeepScanOptions{}, err
+	}
+	if err := tools.apply(); err != nil {
+		return scan.DeepScanOptions{}, err
+	}
+	opts, err := scanFlags.options()
+	if err != nil {
+		return opts, err
+	}
+	if opts.Remote, err = remoteFlags.options(); err != nil {
+		return opts, err
+	}
+	opts.SkipDB = *skipDB
+	return scan.ProbeSweepOptions(opts, *port)
 }
 
 func parseDockerScanOptions(args []string) (scan.DockerScanOptions, error) {
-	fs := flag.NewFlagSet("dockerscan", flag.ExitOnError)
+	fs := flag.NewFlagSet("dockerscan", flag.ContinueOnError)
 	remoteFlags := bindRemoteFlags(fs)
-	if err := fs.Parse(args); err != nil {
+	if err := parseFlags(fs, args); err != nil {
 		return scan.DockerScanOptions{}, err
 	}
 	remoteOpts, err := remoteFlags.options()
@@ -106,26 +403,323 @@ func parseDockerScanOptions(args []string) (scan.DockerScanOptions, error) {
 }
 
 func parseAgentConfig(args []string) (scan.AgentConfig, error) {
-	fs := flag.NewFlagSet("agent", flag.ExitOnError)
+	fs := flag.NewFlagSet("agent", flag.ContinueOnError)
 	remoteFlags := bindRemoteFlags(fs)
-	interval := fs.Duration("interval", envDuration("ATLAS_AGENT_INTERVAL", 15*time.Minute), "interval between scans (e.g. 15m or seconds)")
+	scanFlags := bindScanFlags(fs)
+	tools := bindToolFlags(fs)
+	interval := durationVar(fs, "interval", envDuration("ATLAS_AGENT_INTERVAL", 15*time.Minute), "interval between scans (e.g. 15m or seconds)")
 	once := fs.Bool("once", envBool("ATLAS_AGENT_ONCE", false), "run a single scan and exit")
-	if err := fs.Parse(args); err != nil {
+	breakerFailures := fs.Int("breaker-failures", envInt("ATLAS_AGENT_BREAKER_FAILURES", 3), "skip uploads after this many consecutive controller failures (0 disables)")
+	breakerCooldown := durationVar(fs, "breaker-cooldown", envDuration("ATLAS_AGENT_BREAKER_COOLDOWN", 5*time.Minute), "how long uploads are skipped once the circuit opens; doubles while the controller stays down")
+	incremental := fs.Bool("incremental", envBool("ATLAS_AGENT_INCREMENTAL", false), "after the first run, deep-scan only hosts that are new or changed MAC")
+	fullEvery := fs.Int("full-every", envInt("ATLAS_AGENT_FULL_EVERY", 12), "with --incremental, make every Nth run a full scan (0 = only the first)")
+	scanWindow := fs.String("scan-window", os.Getenv("ATLAS_AGENT_SCAN_WINDOW"), `only scan inside this daily window, e.g. "22:00-06:00" or "mon-fri 19:00-07:00"`)
+	timezone := fs.String("timezone", os.Getenv("ATLAS_AGENT_TIMEZONE"), "IANA time zone for --scan-window, e.g. Europe/Berlin (default: local time)")
+	watchIfaces := fs.Bool("watch-interfaces", envBool("ATLAS_AGENT_WATCH_INTERFACES", false), "rescan right away when an interface subnet appears or changes, e.g. a VPN connects (Linux)")
+	commandPoll := durationVar(fs, "command-poll", envDuration("ATLAS_AGENT_COMMAND_POLL", 0), "poll the controller's command queue this often for scan_now, scan_host, and update_config (0 = off)")
+	errorBackoff := fs.Float64("error-backoff", envFloat("ATLAS_AGENT_ERROR_BACKOFF", 0), "after each consecutive failed run, multiply the interval by this factor: <1 retries sooner, >1 backs off (0 = keep the interval)")
+	backoffMin := durationVar(fs, "error-backoff-min", envDuration("ATLAS_AGENT_ERROR_BACKOFF_MIN", time.Minute), "with --error-backoff, never schedule the next run sooner than this (or the interval, if shorter)")
+	backoffMax := durationVar(fs, "error-backoff-max", envDuration("ATLAS_AGENT_ERROR_BACKOFF_MAX", 6*time.Hour), "with --error-backoff, never schedule the next run later than this")
+	alertWebhook := fs.String("alert-webhook", os.Getenv("ATLAS_AGENT_ALERT_WEBHOOK"), alertWebhookUsage)
+	alertWebhookFile := fs.String("alert-webhook-file", os.Getenv("ATLAS_AGENT_ALERT_WEBHOOK_FILE"), webhookFileUsage)
+	watchDebounce := durationVar(fs, "watch-debounce", envDuration("ATLAS_AGENT_WATCH_DEBOUNCE", 10*time.Second), "with --watch-interfaces, wait for this long without further changes before rescanning")
+	if err := parseFlags(fs, args); err != nil {
+		return scan.AgentConfig{}, err
+	}
+	if err := tools.apply(); err != nil {
 		return scan.AgentConfig{}, err
 	}
 	remoteOpts, err := remoteFlags.options()
 	if err != nil {
 		return scan.AgentConfig{}, err
 	}
+	deepOpts, err := scanFlags.options()
+	if err != nil {
+		return scan.AgentConfig{}, err
+	}
+	if *fullEvery < 0 {
+		return scan.AgentConfig{}, fmt.Errorf("--full-every must not be negative")
+	}
+	window, err := scan.ParseScanWindow(*scanWindow, *timezone)
+	if err != nil {
+		return scan.AgentConfig{}, err
+	}
+	if *commandPoll < 0 {
+		return scan.AgentConfig{}, fmt.Errorf("--command-poll must not be negative")
+	}
+	if *watchDebounce < 0 {
+		return scan.AgentConfig{}, fmt.Errorf("--watch-debounce must not be negative")
+	}
+	backoff := scan.ErrorBackoff{Factor: *errorBackoff, Min: *backoffMin, Max: *backoffMax}
+	if err := backoff.Validate(); err != nil {
+		return scan.AgentConfig{}, err
+	}
+	webhook, err := secretOrFile("alert-webhook", *alertWebhook, *alertWebhookFile)
+	if err != nil {
+		return scan.AgentConfig{}, err
+	}
 	return scan.AgentConfig{
-		Remote:      remoteOpts.Config,
-		Interval:    *interval,
-		Once:        *once,
-		PrintJSON:   remoteOpts.PrintJSON,
-		ScanCommand: "deepscan",
+		Remote:          remoteOpts.Config,
+		Interval:        *interval,
+		Once:            *once,
+		PrintJSON:       remoteOpts.PrintJSON,
+		Labels:          remoteOpts.Labels,
+		EmitEmpty:       remoteOpts.EmitEmpty,
+		MACSalt:         remoteOpts.MACSalt,
+		History:         remoteOpts.History,
+		Redact:          remoteOpts.Redact,
+		PromTextfile:    remoteOpts.PromTextfile,
+		BestEffort:      !*remoteFlags.failFast,
+		ScanCommand:     "deepscan",
+		DeepScan:        deepOpts,
+		BreakerFailures: *breakerFailures,
+		BreakerCooldown: *breakerCooldown,
+		Incremental:     *incremental,
+		FullEvery:       *fullEvery,
+		Window:          window,
+		WatchInterfaces: *watchIfaces,
+		WatchDebounce:   *watchDebounce,
+		CommandPoll:     *commandPoll,
+		ErrorBackoff:    backoff,
+		AlertWebhook:    webhook,
 	}, nil
 }
 
+// scanFlagConfig holds the deep scan tuning flags. Individual flags override
+// the values supplied by the selected --profile.
+type scanFlagConfig struct {
+	fs       *flag.FlagSet
+	profile  *string
+	topPorts *int
+	ports    *string
+	timing   *int
+	trace    *bool
+	outDir   *string
+	summary  *string
+	stream   *bool
+	streamN  *int
+	streamT  *time.Duration
+	noOS     *bool
+	needRoot *bool
+	minPfx   *int
+	names    *string
+	namePol  *string
+	dnsSrv   *string
+	noNBT    *bool
+	noMDNS   *bool
+	discPing *string
+	discSrc  *string
+	reserved *bool
+	public   *bool
+	bind     *bool
+	count    *int
+	rescan   *bool
+	sshFP    *bool
+	skipSelf *bool
+	services *string
+	liveness *string
+	maxRate  *string
+	raw      *bool
+	ipv6     *bool
+	targets  *stringListFlag
+	primary  *stringListFlag
+	vlans    *stringListFlag
+	osHints  osHintFlags
+	osFile   *string
+	sample   *int
+	samplePc *float64
+	seed     *int64
+	offTTL   *time.Duration
+	maxDur   *time.Duration
+	// envErr reports an unparsable ATLAS_SCAN_* value.
+	envErr error
+}
+
+func bindScanFlags(fs *flag.FlagSet) scanFlagConfig {
+	var cfg scanFlagConfig
+	cfg.envErr = withEnvDefaults(fs, func() { cfg = newScanFlags(fs) })
+	return cfg
+}
+
+func newScanFlags(fs *flag.FlagSet) scanFlagConfig {
+	targets := &stringListFlag{}
+	fs.Var(targets, "target", "scan this IP, CIDR, or hostname instead of every interface subnet (repeatable or comma-separated)")
+	primary := &stringListFlag{}
+	fs.Var(primary, "primary-interface", primaryUsage)
+	vlans := &stringListFlag{}
+	osHints := osHintFlags{}
+	fs.Var(osHints, "os-hint", "report this OS for IP when nmap cannot identify it, e.g. 10.0.0.5=FreeBSD 13; tagged os_source=manual (repeatable)")
+	fs.Var(vlans, "vlan", "also scan VLAN ID on PARENT through a tagged sub-interface with ADDRESS, e.g. 20@eth0=10.0.20.5/24; created for the run and removed after it, needs CAP_NET_ADMIN (repeatable)")
+	topPorts := fs.Int("top-ports", 0, "scan the N most common TCP ports (overrides profile)")
+	fs.IntVar(topPorts, "ports-top", 0, "shortcut for --top-ports")
+	return scanFlagConfig{
+		fs:       fs,
+		targets:  targets,
+		primary:  primary,
+		vlans:    vlans,
+		osHints:  osHints,
+		osFile:   fs.String("os-hints-file", "", "read --os-hint ip=os pairs from this file, one per line (# comments); --os-hint wins for the same IP"),
+		profile:  fs.String("profile", scan.DefaultProfile, "scan preset: "+strings.Join(scan.ProfileNames(), ", ")),
+		topPorts: topPorts,
+		ports:    fs.String("ports", "", "explicit nmap port list, e.g. 22,80,443 or - for all (overrides profile)"),
+		timing:   fs.Int("timing", 0, "nmap timing template 1-5 (overrides profile)"),
+		trace:    fs.Bool("traceroute", false, "record each host's next hop and hop path (slower)"),
+		outDir:   fs.String("output-dir", "", "bundle each run's nmap output, payload, and summary under this directory"),
+		summary:  fs.String("summary-json", "", "write a one-object JSON run summary (counts, durations, top ports, subnets, new/removed hosts) to this file"),
+		stream:   fs.Bool("stream-ingest", false, "post hosts to the controller in batches as they finish instead of once at the end"),
+		streamN:  fs.Int("stream-every", scan.DefaultStreamEvery, "with --stream-ingest, post once this many hosts have finished"),
+		streamT:  durationVar(fs, "stream-interval", scan.DefaultStreamInterval, "with --stream-ingest, also post finished hosts at least this often (0 = only by count)"),
+		noOS:     fs.Bool("no-os-detect", false, "skip nmap OS detection (-O); faster and works without root"),
+		needRoot: fs.Bool("require-privileged", false, "fail instead of disabling OS detection, SYN scans, and traceroute when nmap would lack raw sockets"),
+		minPfx:   fs.Int("min-prefix", utils.DefaultMinPrefixLen, "skip interface subnets broader than this IPv4 prefix length (96 bits more for IPv6)"),
+		names:    fs.String("name-order", strings.Join(scan.DefaultNameOrder, ","), "hostname resolution order (nmap, dns, netbios, mdns)"),
+		namePol:  fs.String("name-policy", string(scan.NamePreferNew), namePolicyUsage),
+		dnsSrv:   fs.String("dns-server", "", "send reverse DNS and --target hostname lookups to this resolver, e.g. 10.0.0.53 or 10.0.0.53:5353 (default: system resolver)"),
+		noNBT:    fs.Bool("no-netbios", false, "disable NetBIOS hostname lookups"),
+		noMDNS:   fs.Bool("no-mdns", false, "disable mDNS hostname lookups"),
+		discPing: fs.String("discovery-ping", scan.DiscoveryPingICMP, "host discovery: icmp (fast), tcp-syn (finds ICMP-filtered hosts), none (treat every address as up; slowest)"),
+		discSrc:  fs.String("discovery", scan.DiscoveryNmap, "host source: nmap (ping sweep) or neighbors (kernel ARP/NDP cache, no probes; falls back to nmap when empty)"),
+		reserved: fs.Bool("include-reserved", false, includeReservedUsage),
+		public:   fs.Bool("allow-public", false, allowPublicUsage),
+		count:    fs.Int("count", 1, fmt.Sprintf("scan each host N times (max %d) and merge the ports seen", scan.MaxScanCount)),
+		rescan:   fs.Bool("rescan-empty", false, "rescan a host once when no ports were parsed: with -Pn, or with a slower -T when -Pn is already on"),
+		skipSelf: fs.Bool("skip-self", false, "record this agent's own host (is_self) without port-scanning it"),
+		liveness: fs.String("liveness", scan.LivenessAny, livenessUsage),
+		ipv6:     fs.Bool("ipv6", false, "also discover IPv6 neighbors (ff02::1 ping + neighbor cache) and merge them with IPv4 hosts by MAC"),
+		raw:      fs.Bool("include-raw", false, "attach each host's grepable nmap output (up to 16 KiB) and log path to its metadata"),
+		maxRate:  fs.String("max-rate-hosts", "", "start at most this many host scans per second, e.g. 5/sec or 30/min (default: no limit)"),
+		services: fs.String("only-service", "", "keep only hosts exposing one of these nmap service names, e.g. ssh,http"),
+		sshFP:    fs.Bool("ssh-fingerprint", false, "record the SSH host key fingerprint of hosts with port 22 open"),
+		bind:     fs.Bool("bind-interface", false, "scan each subnet out of its own interface and address (nmap -e/-S; -S needs root)"),
+		sample:   fs.Int("sample", 0, "deep-scan only N randomly chosen live hosts"),
+		samplePc: fs.Float64("sample-pct", 0, "deep-scan only this percentage of live hosts"),
+		seed:     fs.Int64("sample-seed", 0, "seed for --sample/--sample-pct (0 = random, logged)"),
+		maxDur:   durationVar(fs, "max-duration", 0, "stop scanning hosts after this long and report what finished (e.g. 10m; 0 = no limit)"),
+		offTTL:   durationVar(fs, "offline-ttl", 0, "keep hosts that were not re-seen online until missing this long (e.g. 30m)"),
+	}
+}
+
+func (s scanFlagConfig) options() (scan.DeepScanOptions, error) {
+	if s.envErr != nil {
+		return scan.DeepScanOptions{}, s.envErr
+	}
+	opts, err := scan.DeepScanProfile(*s.profile)
+	if err != nil {
+		return opts, err
+	}
+	set := map[string]bool{}
+	s.fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if set["top-ports"] || set["ports-top"] {
+		if *s.topPorts <= 0 {
+			return opts, fmt.Errorf("--top-ports must be positive")
+		}
+		opts.TCP.TopPorts = *s.topPorts
+		opts.TCP.Ports = ""
+	}
+	if set["ports"] {
+		opts.TCP.Ports = *s.ports
+	}
+	if set["timing"] {
+		if *s.timing < 1 || *s.timing > 5 {
+			return opts, fmt.Errorf("--timing must be between 1 and 5")
+		}
+		opts.TCP.Timing = *s.timing
+	}
+	opts.TCP.Traceroute = *s.trace
+	opts.OutputDir = *s.outDir
+	opts.SummaryJSON = *s.summary
+	if *s.streamN < 1 {
+		return opts, fmt.Errorf("--stream-every must be positive")
+	}
+	if *s.streamT < 0 {
+		return opts, fmt.Errorf("--stream-interval must not be negative")
+	}
+	opts.StreamIngest, opts.StreamEvery, opts.StreamInterval = *s.stream, *s.streamN, *s.streamT
+	if *s.noOS {
+		opts.TCP.OSDetect = false
+	}
+	opts.MinPrefixLen = *s.minPfx
+	var disabled []string
+	if *s.noNBT {
+		disabled = append(disabled, "netbios")
+	}
+	if *s.noMDNS {
+		disabled = append(disabled, "mdns")
+	}
+	if opts.NameOrder, err = scan.ParseNameOrder(*s.names, disabled...); err != nil {
+		return opts, err
+	}
+	opts.NameOrderSet = set["name-order"]
+	if opts.NamePolicy, err = scan.ParseNamePolicy(*s.namePol); err != nil {
+		return opts, err
+	}
+	if opts.DNSServer, err = scan.ParseDNSServer(*s.dnsSrv); err != nil {
+		return opts, err
+	}
+	opts.DiscoveryPing = *s.discPing
+	if err := scan.ValidateDiscoverySource(*s.discSrc); err != nil {
+		return opts, err
+	}
+	opts.Discovery = *s.discSrc
+	opts.IncludeReserved = *s.reserved
+	opts.AllowPublic = *s.public
+	opts.BindInterface = *s.bind
+	opts.RescanEmpty = *s.rescan
+	opts.SSHFingerprint = *s.sshFP
+	opts.SkipSelf = *s.skipSelf
+	opts.RequirePrivileged = *s.needRoot
+	opts.IncludeRaw = *s.raw
+	opts.IPv6 = *s.ipv6
+	opts.OnlyServices = scan.ParseServiceList(*s.services)
+	if opts.OSHints, err = s.osHintMap(); err != nil {
+		return opts, err
+	}
+	if err := scan.ValidateLiveness(*s.liveness); err != nil {
+		return opts, err
+	}
+	opts.Liveness = *s.liveness
+	if opts.MaxRateHosts, err = scan.ParseHostRate(*s.maxRate); err != nil {
+		return opts, err
+	}
+	if *s.count < 1 || *s.count > scan.MaxScanCount {
+		return opts, fmt.Errorf("--count must be between 1 and %d", scan.MaxScanCount)
+	}
+	opts.Count = *s.count
+	opts.Targets = *s.targets
+	opts.PrimaryInterfaces = *s.primary
+	for _, v := range *s.vlans {
+		spec, err := scan.ParseVLANSpec(v)
+		if err != nil {
+			return opts, err
+		}
+		opts.VLANs = append(opts.VLANs, spec)
+	}
+	if *s.offTTL < 0 {
+		return opts, fmt.Errorf("--offline-ttl must not be negative")
+	}
+	opts.OfflineTTL = *s.offTTL
+	if *s.maxDur < 0 {
+		return opts, fmt.Errorf("--max-duration must not be negative")
+	}
+	opts.MaxDuration = *s.maxDur
+	opts.Sample = scan.SampleOptions{Count: *s.sample, Percent: *s.samplePc, Seed: *s.seed}
+	if err := opts.Sample.Validate(); err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
+
+const (
+	includeReservedUsage = "keep link-local, multicast, and subnet network/broadcast addresses found by discovery"
+	allowPublicUsage     = "scan targets and discovered hosts outside private (RFC 1918/ULA/link-local) address space"
+	livenessUsage        = "how online status is decided: any (ping, an open/filtered port, or an ARP/NDP entry), ping, or ports"
+	primaryUsage         = "interface to scan and report a subnet under when several share it, e.g. bond0 (repeatable, most preferred first)"
+	skipDBUsage          = "skip writing hosts to SQLite; --json and --remote do not imply it"
+	namePolicyUsage      = "when a stored host resolves to a different name: prefer-new takes it, prefer-existing keeps the old one (a real name never yields to NoName)"
+	alertWebhookUsage    = "POST an external_ip_changed JSON event to this URL when a fast scan sees the site's public address change"
+	webhookFileUsage     = "read the --alert-webhook URL from this file (keeps a tokenized URL out of ps/env)"
+)
+
 type remoteFlagConfig struct {
 	remoteURL  *string
 	siteID     *string
@@ -133,40 +727,416 @@ type remoteFlagConfig struct {
 	agentID    *string
 	agentName  *string
 	agentToken *string
+	tokenFile  *string
+	tokenCmd   *string
+	tokenURL   *string
+	clientID   *string
+	clientKey  *string
+	keyFile    *string
 	printJSON  *bool
+	schemaPath *string
+	nmapXML    *string
+	promFile   *string
+	table      *bool
+	wide       *bool
+	emitEmpty  *bool
+	format     *string
+	hashMACs   *bool
+	macSalt    *string
+	saltFile   *string
+	output     *string
+	history    *string
+	historyMax *int
+	redact     *string
+	failFast   *bool
+	bestEffort *bool
+	headers    headerFlags
+	labels     labelFlags
 }
 
 func bindRemoteFlags(fs *flag.FlagSet) remoteFlagConfig {
+	headers := headerFlags{}
+	fs.Var(headers, "header", "extra HTTP header for controller requests as Name:Value (repeatable)")
+	labels := labelFlags{}
+	fs.Var(labels, "label", "add key=value to every host's metadata, e.g. env=prod (repeatable)")
 	return remoteFlagConfig{
+		headers:    headers,
+		labels:     labels,
 		remoteURL:  fs.String("remote", os.Getenv("ATLAS_CONTROLLER_URL"), "controller base URL (e.g. https://host/api)"),
 		siteID:     fs.String("site", os.Getenv("ATLAS_SITE_ID"), "site identifier"),
 		siteName:   fs.String("site-name", os.Getenv("ATLAS_SITE_NAME"), "site display name"),
 		agentID:    fs.String("agent", os.Getenv("ATLAS_AGENT_ID"), "agent identifier"),
 		agentName:  fs.String("agent-version", getenvDefault("ATLAS_AGENT_VERSION", scan.ScannerVersion), "agent version label"),
 		agentToken: fs.String("token", os.Getenv("ATLAS_AGENT_TOKEN"), "API token for Authorization header"),
+		tokenFile:  fs.String("token-file", os.Getenv("ATLAS_AGENT_TOKEN_FILE"), "read the API token from this file instead of --token (keeps it out of ps/env)"),
+		tokenCmd:   fs.String("token-command", os.Getenv("ATLAS_AGENT_TOKEN_COMMAND"), "shell command that prints a fresh token; run when the controller answers 401"),
+		tokenURL:   fs.String("token-url", os.Getenv("ATLAS_AGENT_TOKEN_URL"), "OAuth2 token endpoint for client-credentials refresh after a 401"),
+		clientID:   fs.String("client-id", os.Getenv("ATLAS_AGENT_CLIENT_ID"), "client ID for --token-url"),
+		clientKey:  fs.String("client-secret", os.Getenv("ATLAS_AGENT_CLIENT_SECRET"), "client secret for --token-url"),
+		keyFile:    fs.String("client-secret-file", os.Getenv("ATLAS_AGENT_CLIENT_SECRET_FILE"), "read the --client-secret from this file"),
 		printJSON:  fs.Bool("json", false, "print the ingest payload to stdout"),
+		schemaPath: fs.String("validate-schema", "", "validate the ingest payload against this JSON Schema file before emitting"),
+		nmapXML:    fs.String("nmap-xml-out", "", "also write results as nmap-compatible XML to this path"),
+		promFile:   fs.String("prom-textfile", os.Getenv("ATLAS_PROM_TEXTFILE"), "after each scan, replace this .prom file with metrics for node_exporter's textfile collector"),
+		table:      fs.Bool("table", false, "print discovered hosts as a text table"),
+		wide:       fs.Bool("wide", false, "with --table, do not truncate the ports column"),
+		format:     fs.String("payload-format", getenvDefault("ATLAS_PAYLOAD_FORMAT", scan.PayloadJSON), "ingest encoding: json or msgpack (msgpack only if the controller advertises it)"),
+		emitEmpty:  fs.Bool("emit-empty", envBool("ATLAS_EMIT_EMPTY", false), "send a payload with an empty hosts list when nothing is discovered"),
+		hashMACs:   fs.Bool("hash-macs", envBool("ATLAS_HASH_MACS", false), "replace MAC addresses in the payload with salted hashes (SQLite keeps the raw MACs)"),
+		macSalt:    fs.String("mac-salt", os.Getenv("ATLAS_MAC_SALT"), "secret salt for --hash-macs; keep it constant so hashes stay stable"),
+		saltFile:   fs.String("mac-salt-file", os.Getenv("ATLAS_MAC_SALT_FILE"), "read the --mac-salt from this file"),
+		output:     fs.String("output", os.Getenv("ATLAS_OUTPUT"), "also archive each payload: jsonl-gzip appends it to --history-path"),
+		history:    fs.String("history-path", getenvDefault("ATLAS_HISTORY_PATH", scan.DefaultHistoryPath), "archive file for --output jsonl-gzip"),
+		historyMax: fs.Int("history-max-mb", envInt("ATLAS_HISTORY_MAX_MB", 50), "rotate the archive past this size in MiB (it also rotates daily; 0 = no size limit)"),
+		redact:     fs.String("redact", "", "comma-separated fields to blank in --json output for sharing, e.g. mac,hostname (SQLite and the controller keep them)"),
+		failFast:   fs.Bool("fail-fast", envBool("ATLAS_FAIL_FAST", false), "exit nonzero when the controller rejects or cannot be reached (default for one-shot scans)"),
+		bestEffort: fs.Bool("best-effort", envBool("ATLAS_BEST_EFFORT", false), "log controller ingest failures and succeed if local results were saved (default for the agent)"),
 	}
 }
 
 func (r remoteFlagConfig) options() (scan.RemotePayloadOptions, error) {
+	token, err := secretOrFile("token", *r.agentToken, *r.tokenFile)
+	if err != nil {
+		return scan.RemotePayloadOptions{}, err
+	}
+	clientSecret, err := secretOrFile("client-secret", *r.clientKey, *r.keyFile)
+	if err != nil {
+		return scan.RemotePayloadOptions{}, err
+	}
+	macSalt, err := secretOrFile("mac-salt", *r.macSalt, *r.saltFile)
+	if err != nil {
+		return scan.RemotePayloadOptions{}, err
+	}
 	cfg := scan.RemoteConfig{
 		ControllerURL: *r.remoteURL,
 		SiteID:        *r.siteID,
 		SiteName:      *r.siteName,
 		AgentID:       *r.agentID,
 		AgentVersion:  *r.agentName,
-		Token:         *r.agentToken,
+		Token:         token,
+		PayloadFormat: *r.format,
+	}
+	if err := scan.ValidatePayloadFormat(cfg.PayloadFormat); err != nil {
+		return scan.RemotePayloadOptions{}, err
+	}
+	switch {
+	case *r.tokenCmd != "" && *r.tokenURL != "":
+		return scan.RemotePayloadOptions{}, fmt.Errorf("--token-command and --token-url are mutually exclusive")
+	case *r.tokenCmd != "":
+		cfg.Refresh = &scan.TokenRefresher{Command: *r.tokenCmd}
+	case *r.tokenURL != "":
+		if *r.clientID == "" {
+			return scan.RemotePayloadOptions{}, fmt.Errorf("--token-url requires --client-id")
+		}
+		cfg.Refresh = &scan.TokenRefresher{URL: *r.tokenURL, ClientID: *r.clientID, ClientSecret: clientSecret}
+	}
+	if len(r.headers) > 0 {
+		cfg.Headers = r.headers
 	}
 	if cfg.AgentVersion == "" {
 		cfg.AgentVersion = scan.ScannerVersion
 	}
-	opts := scan.RemotePayloadOptions{PrintJSON: *r.printJSON, Config: cfg}
+	if *r.failFast && *r.bestEffort {
+		return scan.RemotePayloadOptions{}, fmt.Errorf("--fail-fast and --best-effort are mutually exclusive")
+	}
+	opts := scan.RemotePayloadOptions{PrintJSON: *r.printJSON, SchemaPath: *r.schemaPath, NmapXMLPath: *r.nmapXML, PromTextfile: *r.promFile, Table: *r.table, Wide: *r.wide, EmitEmpty: *r.emitEmpty, BestEffort: *r.bestEffort, Config: cfg}
+	if len(r.labels) > 0 {
+		opts.Labels = r.labels
+	}
+	if err := scan.ValidateOutput(*r.output); err != nil {
+		return opts, err
+	}
+	if *r.output == scan.OutputJSONLGzip {
+		opts.History = scan.HistoryOptions{Path: *r.history, MaxBytes: int64(*r.historyMax) << 20}
+	}
+	redact, err := scan.ParseRedactFields(*r.redact)
+	if err != nil {
+		return opts, err
+	}
+	opts.Redact = redact
+	if *r.hashMACs {
+		if macSalt == "" {
+			return opts, fmt.Errorf("--hash-macs requires --mac-salt or --mac-salt-file (or ATLAS_MAC_SALT)")
+		}
+		opts.MACSalt = macSalt
+	}
 	if cfg.ControllerURL != "" && (cfg.SiteID == "" || cfg.AgentID == "") {
 		return opts, fmt.Errorf("--site and --agent are required when --remote is specified")
 	}
 	return opts, nil
 }
 
+// toolFlagConfig points the scanners at binaries outside PATH.
+type toolFlagConfig struct {
+	nmap    *string
+	nbtscan *string
+}
+
+func bindToolFlags(fs *flag.FlagSet) toolFlagConfig {
+	return toolFlagConfig{
+		nmap:    fs.String("nmap-path", os.Getenv("ATLAS_NMAP_PATH"), "run this nmap binary or wrapper instead of the one in PATH"),
+		nbtscan: fs.String("nbtscan-path", os.Getenv("ATLAS_NBTSCAN_PATH"), "run this nbtscan binary instead of the one in PATH"),
+	}
+}
+
+// apply checks the overrides are executable and installs them for every
+// scanner invocation.
+func (t toolFlagConfig) apply() error {
+	if err := utils.SetCommandPath("nmap", *t.nmap); err != nil {
+		return fmt.Errorf("--nmap-path: %w", err)
+	}
+	if err := utils.SetCommandPath("nbtscan", *t.nbtscan); err != nil {
+		return fmt.Errorf("--nbtscan-path: %w", err)
+	}
+	return nil
+}
+
+// secretFlags are masked by --print-config.
+var secretFlags = map[string]bool{"token": true, "mac-salt": true, "client-secret": true, "alert-webhook": true}
+
+// parseFlags parses args into fs, adding --print-config. With it set, the
+// effective value of every flag (defaults, then ATLAS_* variables, then the
+// command line) is printed as JSON and the process exits without scanning.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	printConfig := fs.Bool("print-config", false, "print the resolved flag values as JSON and exit (secrets masked)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if !*printConfig {
+		return nil
+	}
+	if err := writeFlagConfig(os.Stdout, fs); err != nil {
+		return err
+	}
+	os.Exit(exitOK)
+	return nil
+}
+
+// writeFlagConfig writes fs's flags as a JSON object keyed by flag name.
+func writeFlagConfig(w io.Writer, fs *flag.FlagSet) error {
+	cfg := map[string]any{}
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name == "print-config" || f.Name == "help" {
+			return
+		}
+		var value any = f.Value.String()
+		if g, ok := f.Value.(flag.Getter); ok {
+			value = g.Get()
+		}
+		switch v := value.(type) {
+		case time.Duration:
+			value = v.String()
+		case headerFlags:
+			// Header values are often API keys; keep only the names.
+			masked := make(map[string]string, len(v))
+			for name := range v {
+				masked[name] = "****"
+			}
+			value = masked
+		}
+		if secretFlags[f.Name] && f.Value.String() != "" {
+			value = "****"
+		}
+		cfg[f.Name] = value
+	})
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(map[string]any{"command": fs.Name(), "flags": cfg})
+}
+
+// stringListFlag collects repeated, comma-separated flag values.
+type stringListFlag []string
+
+func (l *stringListFlag) String() string { return strings.Join(*l, ",") }
+
+func (l *stringListFlag) Get() any { return []string(*l) }
+
+func (l *stringListFlag) Set(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l = append(*l, v)
+		}
+	}
+	return nil
+}
+
+// durationFlag is a time.Duration flag parsed with parseDuration.
+type durationFlag time.Duration
+
+func (d *durationFlag) String() string { return time.Duration(*d).String() }
+
+func (d *durationFlag) Get() any { return time.Duration(*d) }
+
+func (d *durationFlag) Set(value string) error {
+	dur, err := parseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = durationFlag(dur)
+	return nil
+}
+
+// durationVar defines a duration flag that also accepts bare seconds, like
+// fs.Duration otherwise.
+func durationVar(fs *flag.FlagSet, name string, value time.Duration, usage string) *time.Duration {
+	p := new(time.Duration)
+	*p = value
+	fs.Var((*durationFlag)(p), name, usage)
+	return p
+}
+
+// headerFlags collects repeated --header Name:Value flags.
+type headerFlags map[string]string
+
+func (h headerFlags) String() string {
+	pairs := make([]string, 0, len(h))
+	for k, v := range h {
+		pairs = append(pairs, k+":"+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (h headerFlags) Get() any { return h }
+
+func (h headerFlags) Set(value string) error {
+	name, val, ok := strings.Cut(value, ":")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" {
+		return fmt.Errorf("expected Name:Value, got %q", value)
+	}
+	h[http.CanonicalHeaderKey(name)] = strings.TrimSpace(val)
+	return nil
+}
+
+// labelFlags collects repeated --label key=value flags.
+type labelFlags map[string]string
+
+func (l labelFlags) String() string {
+	pairs := make([]string, 0, len(l))
+	for k, v := range l {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (l labelFlags) Get() any { return map[string]string(l) }
+
+func (l labelFlags) Set(value string) error {
+	key, val, err := scan.ParseLabel(value)
+	if err != nil {
+		return err
+	}
+	l[key] = val
+	return nil
+}
+
+// osHintFlags collects repeated --os-hint ip=os flags, keyed by canonical IP.
+type osHintFlags map[string]string
+
+func (h osHintFlags) String() string {
+	pairs := make([]string, 0, len(h))
+	for ip, osName := range h {
+		pairs = append(pairs, ip+"="+osName)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (h osHintFlags) Get() any { return map[string]string(h) }
+
+func (h osHintFlags) Set(value string) error {
+	ip, osName, err := scan.ParseOSHint(value)
+	if err != nil {
+		return err
+	}
+	h[ip] = osName
+	return nil
+}
+
+// osHintMap merges --os-hints-file with the --os-hint flags, which win.
+func (s scanFlagConfig) osHintMap() (map[string]string, error) {
+	hints := map[string]string{}
+	if *s.osFile != "" {
+		fromFile, err := scan.LoadOSHints(*s.osFile)
+		if err != nil {
+			return nil, fmt.Errorf("--os-hints-file: %v", err)
+		}
+		hints = fromFile
+	}
+	for ip, osName := range s.osHints {
+		hints[ip] = osName
+	}
+	if len(hints) == 0 {
+		return nil, nil
+	}
+	return hints, nil
+}
+
+// secretOrFile returns the trimmed contents of file when it is set and value
+// otherwise; it backs the --<name>-file variant of each secret flag.
+func secretOrFile(name, value, file string) (string, error) {
+	if file == "" {
+		return value, nil
+	}
+	secret, err := readSecretFile(file)
+	if err != nil {
+		return "", fmt.Errorf("--%s-file: %w", name, err)
+	}
+	return secret, nil
+}
+
+// readSecretFile returns the trimmed contents of a secret file such as a
+// mounted Docker/Kubernetes secret.
+func readSecretFile(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	secret := strings.TrimSpace(string(b))
+	if secret == "" {
+		return "", fmt.Errorf("%s is empty", path)
+	}
+	return secret, nil
+}
+
+// scanEnvPrefix names the environment variable behind each scan-tuning flag:
+// --top-ports reads ATLAS_SCAN_TOP_PORTS, --no-os-detect reads
+// ATLAS_SCAN_NO_OS_DETECT, and so on.
+const scanEnvPrefix = "ATLAS_SCAN_"
+
+func scanFlagEnv(flagName string) string {
+	return scanEnvPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// withEnvDefaults runs bind and seeds every flag it defined from the matching
+// ATLAS_SCAN_* variable, so containerized agents can be configured through
+// the environment alone. Values go through fs.Set, so they count as set
+// (overriding the --profile preset) and command-line flags still win.
+func withEnvDefaults(fs *flag.FlagSet, bind func()) error {
+	existing := map[string]bool{}
+	fs.VisitAll(func(f *flag.Flag) { existing[f.Name] = true })
+	bind()
+	var errs []error
+	fs.VisitAll(func(f *flag.Flag) {
+		if existing[f.Name] {
+			return
+		}
+		env := scanFlagEnv(f.Name)
+		f.Usage += " [$" + env + "]"
+		if v := os.Getenv(env); v != "" {
+			if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() {
+				v = strconv.FormatBool(envBool(env, false))
+			}
+			if err := fs.Set(f.Name, v); err != nil {
+				errs = append(errs, fmt.Errorf("%s=%q: %v", env, v, err))
+			}
+		}
+	})
+	return errors.Join(errs...)
+}
+
 func getenvDefault(key, fallback string) string {
 	if v := os.Getenv(key); v != "" {
 		return v
@@ -176,11 +1146,39 @@ func getenvDefault(key, fallback string) string {
 
 func envDuration(key string, fallback time.Duration) time.Duration {
 	if v := os.Getenv(key); v != "" {
-		if dur, err := time.ParseDuration(v); err == nil {
+		if dur, err := parseDuration(v); err == nil {
 			return dur
 		}
-		if secs, err := strconv.Atoi(v); err == nil {
-			return time.Duration(secs) * time.Second
+	}
+	return fallback
+}
+
+// parseDuration accepts Go durations (15m, 1h30m) and bare integers as
+// seconds (900), so flags and ATLAS_* variables take the same values.
+func parseDuration(v string) (time.Duration, error) {
+	if secs, err := strconv.Atoi(v); err == nil {
+		return time.Duration(secs) * time.Second, nil
+	}
+	dur, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q (use e.g. 90s, 15m, or seconds)", v)
+	}
+	return dur, nil
+}
+
+func envInt(key string, fallback int) int {
+	if v := os.Getenv(key); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+	}
+	return fallback
+}
+
+func envFloat(key string, fallback float64) float64 {
+	if v := os.Getenv(key); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f
 		}
 	}
 	return fallback
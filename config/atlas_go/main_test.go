@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOutputFlagsDoNotImplySkipDB(t *testing.T) {
+	remote := []string{"--json", "--remote", "https://controller.example/api", "--site", "lab", "--agent", "edge01"}
+	parsers := map[string]func(args []string) (bool, error){
+		"fastscan": func(args []string) (bool, error) {
+			opts, err := parseFastScanOptions(args)
+			return opts.SkipDB, err
+		},
+		"deepscan": func(args []string) (bool, error) {
+			opts, err := parseDeepScanOptions(args)
+			return opts.SkipDB, err
+		},
+		"probesweep": func(args []string) (bool, error) {
+			opts, err := parseProbeSweepOptions(append([]string{"--port", "445"}, args...))
+			return opts.SkipDB, err
+		},
+		"presencescan": func(args []string) (bool, error) {
+			opts, err := parsePresenceScanOptions(args)
+			return opts.SkipDB, err
+		},
+	}
+	for name, parse := range parsers {
+		for _, args := range [][]string{{"--json"}, remote} {
+			skip, err := parse(args)
+			if err != nil {
+				t.Fatalf("%s %v: %v", name, args, err)
+			}
+			if skip {
+				t.Errorf("%s %v: SkipDB set without --skip-db", name, args)
+			}
+		}
+		if skip, err := parse(append([]string{"--skip-db"}, remote...)); err != nil || !skip {
+			t.Errorf("%s --skip-db: SkipDB=%v err=%v", name, skip, err)
+		}
+	}
+}
+
+func TestReadSecretFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "secret")
+	if err := os.WriteFile(path, []byte("  s3cret\n\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := readSecretFile(path); err != nil || got != "s3cret" {
+		t.Fatalf("readSecretFile = %q, %v; want the trimmed secret", got, err)
+	}
+
+	empty := filepath.Join(dir, "empty")
+	if err := os.WriteFile(empty, []byte(" \n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readSecretFile(empty); err == nil || !strings.Contains(err.Error(), "is empty") {
+		t.Fatalf("blank file: %v", err)
+	}
+	if _, err := readSecretFile(filepath.Join(dir, "missing")); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("missing file: %v", err)
+	}
+}
+
+func TestSecretFileFlags(t *testing.T) {
+	dir := t.TempDir()
+	salt := filepath.Join(dir, "salt")
+	webhook := filepath.Join(dir, "webhook")
+	if err := os.WriteFile(salt, []byte("pepper\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(webhook, []byte("https://hooks.example/T0/secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	opts, err := parseFastScanOptions([]string{"--hash-macs", "--mac-salt-file", salt, "--alert-webhook-file", webhook})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.Remote.MACSalt != "pepper" || opts.AlertWebhook != "https://hooks.example/T0/secret" {
+		t.Fatalf("salt %q, webhook %q", opts.Remote.MACSalt, opts.AlertWebhook)
+	}
+	cfg, err := parseAgentConfig([]string{"--hash-macs", "--mac-salt-file", salt, "--alert-webhook-file", webhook})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.MACSalt != "pepper" || cfg.AlertWebhook != "https://hooks.example/T0/secret" {
+		t.Fatalf("agent salt %q, webhook %q", cfg.MACSalt, cfg.AlertWebhook)
+	}
+
+	_, err = parseFastScanOptions([]string{"--hash-macs", "--mac-salt-file", filepath.Join(dir, "missing")})
+	if err == nil || !strings.Contains(err.Error(), "--mac-salt-file") {
+		t.Fatalf("missing salt file: %v", err)
+	}
+}